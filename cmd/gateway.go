@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	log "log/slog"
 	"net/http"
@@ -12,23 +13,44 @@ import (
 	"time"
 
 	"github.com/dariusigna/object-storage/internal/app"
+	"github.com/dariusigna/object-storage/internal/audit"
+	"github.com/dariusigna/object-storage/internal/config"
 	"github.com/dariusigna/object-storage/internal/gateway"
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/dariusigna/object-storage/internal/notify"
 	"github.com/dariusigna/object-storage/internal/registrar"
 	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/dariusigna/object-storage/internal/version"
+	"github.com/dariusigna/object-storage/internal/wal"
 	"github.com/moby/moby/client"
-	"github.com/zeromicro/go-zero/core/hash"
 )
 
 func main() {
 	if err := run(); err != nil {
-		log.Error("Applications exists with error:", err)
+		log.Error("Applications exists with error", "error", err)
 		os.Exit(1)
 	}
 }
 
 func run() error {
-	log.Info("Server is starting...")
-	log.SetLogLoggerLevel(log.LevelDebug) // Set log level from env or flags in production
+	configPath := flag.String("config", "", "path of a YAML config file, overridden by the environment variables")
+	printVersion := flag.Bool("version", false, "print the build version and exit")
+	previewKey := flag.String("preview", "", "print the instances a hash key routes to, owner first, and exit, the key is the object id, bucket/id or bucket depending on the key strategy")
+	flag.Parse()
+
+	build := version.Get()
+	if *printVersion {
+		fmt.Printf("object-storage %s (commit %s, %s)\n", build.Version, build.Commit, build.GoVersion)
+		return nil
+	}
+
+	log.Info("Server is starting...", "version", build.Version, "commit", build.Commit)
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	log.SetLogLoggerLevel(cfg.LogLevel)
 
 	// Setup cancellation context
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -36,36 +58,153 @@ func run() error {
 
 	// Setup of the services
 	// registry,registrar could be a separate microservices in a prod environment
-	instanceRegistry := registry.NewRegistry(hash.NewConsistentHash())
+	instanceRegistry := registry.NewRegistry(hashring.NewSeeded(hashring.DefaultReplicas, cfg.HashSeed))
+	instanceRegistry.SetPins(cfg.Pins)
+	metrics.SetRingImbalanceSource(instanceRegistry.Imbalance)
+	metrics.RegisterBuildInfo(build.Version, build.Commit, build.GoVersion)
 	dockerCLI, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return fmt.Errorf("Could not create docker client: %v\n", err)
 	}
-	instanceRegistrar := registrar.NewRegistrar(dockerCLI, instanceRegistry)
-	storage, err := gateway.NewObjectStorage(instanceRegistry)
+	instanceRegistrar := registrar.NewRegistrar(dockerCLI, instanceRegistry, registrar.Options{
+		NamePrefix:       cfg.NamePrefix,
+		HostnameFromName: cfg.HostnameFromName,
+		SnapshotPath:     cfg.RegistrySnapshot,
+	})
+	if *previewKey != "" {
+		if err = instanceRegistrar.Refresh(ctx); err != nil {
+			return fmt.Errorf("could not load the instances: %w", err)
+		}
+		return preview(os.Stdout, instanceRegistry, cfg, *previewKey)
+	}
+	if err = instanceRegistrar.LoadSnapshot(); err != nil {
+		return err
+	}
+	var writeAheadLog *wal.Log
+	if cfg.WALDir != "" {
+		if writeAheadLog, err = wal.Open(cfg.WALDir); err != nil {
+			return err
+		}
+	}
+	var publisher notify.Publisher
+	if cfg.EventsWebhook != "" {
+		publisher = notify.NewWebhook(cfg.EventsWebhook)
+	}
+	storage, err := gateway.NewObjectStorage(instanceRegistry, gateway.Options{
+		FallbackBucket:         cfg.FallbackBucket,
+		ReplicationFactor:      cfg.ReplicationFactor,
+		WriteQuorum:            cfg.WriteQuorum,
+		BucketReplication:      cfg.BucketReplication,
+		StrictReplication:      cfg.StrictReplication,
+		ReplicationConcurrency: cfg.ReplicationConcurrency,
+		PartSize:               uint64(cfg.PartSize),
+		UploadThreads:          uint(cfg.UploadThreads),
+		Publisher:              publisher,
+		FoldCase:               cfg.FoldCase,
+		LargeObjectThreshold:   cfg.LargeObjectThreshold,
+		LargeObjectCandidates:  cfg.LargeObjectCandidates,
+		WAL:                    writeAheadLog,
+		Compression:            cfg.Compression,
+		Timeouts: gateway.Timeouts{
+			Stat:   cfg.StatTimeout,
+			Get:    cfg.GetTimeout,
+			Put:    cfg.PutTimeout,
+			Delete: cfg.DeleteTimeout,
+		},
+		KeyStrategy:      cfg.KeyStrategy,
+		DrainingWrites:   cfg.DrainingWrites,
+		Region:           cfg.Region,
+		ClientMaxRetries: cfg.ClientMaxRetries,
+		ClientAppName:    cfg.ClientAppName,
+		DialCheckTimeout: cfg.DialCheckTimeout,
+		PreviousOwners:   cfg.PreviousOwners,
+		MigrateOnRead:    cfg.MigrateOnRead,
+		RetryBudget: gateway.RetryBudget{
+			Capacity: cfg.RetryBudget,
+			Rate:     cfg.RetryBudgetRate,
+		},
+		FailoverCache: gateway.FailoverCache{
+			TTL:  cfg.FailoverTTL,
+			Size: cfg.FailoverCacheSize,
+		},
+		InstanceLimit: gateway.InstanceLimit{
+			MaxConcurrent: cfg.MaxInstanceConcurrency,
+			QueueTimeout:  cfg.InstanceQueueTimeout,
+		},
+		ReadRepair: cfg.ReadRepair,
+		Zone:       cfg.Zone,
+		// The rotated keys of an instance denying the access are picked up from docker
+		RefreshInstances: instanceRegistrar.Refresh,
+		CapacityWeighting: gateway.CapacityWeighting{
+			Interval:  cfg.CapacityWeightInterval,
+			Threshold: cfg.CapacityWeightThreshold,
+		},
+		Dedup: gateway.Dedup{
+			Enabled:       cfg.Dedup,
+			SweepInterval: cfg.DedupSweepInterval,
+		},
+		SoftDelete: gateway.SoftDelete{
+			Retention:     cfg.SoftDeleteRetention,
+			SweepInterval: cfg.SoftDeleteSweepInterval,
+		},
+	})
 	if err != nil {
 		return fmt.Errorf("Could not create object storage: %v\n", err)
 	}
-	srv := app.NewServer(storage)
+	instanceRegistry.OnDeregister(storage.EvictClient)
+	var auditLogger *audit.Logger
+	if cfg.AuditOverwrites {
+		auditOut := os.Stdout
+		if cfg.AuditLog != "" {
+			if auditOut, err = os.OpenFile(cfg.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600); err != nil {
+				return fmt.Errorf("could not open the audit log: %w", err)
+			}
+			defer auditOut.Close()
+		}
+		auditLogger = audit.NewLogger(auditOut)
+	}
+	srv := app.NewServer(cfg, storage, instanceRegistry, instanceRegistrar, auditLogger)
+	inFlight := &app.InFlight{}
 	server := &http.Server{
-		Addr:         ":3000", // Read host and port from env or flags
-		Handler:      srv,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  30 * time.Second,
+		Addr:         cfg.Addr,
+		Handler:      inFlight.Track(srv),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		// The request line counts towards the header limit, a URL past it is rejected with a 431 before the path length check
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
 	}
 
 	// In production, we will add metrics and tracing
 	// with Prometheus, OpenTelemetry further triggering alerts for cases like high latency, high error rate, errors etc.
 
+	// Complete the writes interrupted by a crash, which needs the instances to be registered first
+	if writeAheadLog != nil {
+		if err = instanceRegistrar.Refresh(ctx); err != nil {
+			log.Error("Error refreshing instances before recovery", "error", err)
+		}
+		if err = storage.Recover(ctx); err != nil {
+			log.Error("Error recovering interrupted writes", "error", err)
+		}
+	}
+
 	// Continuously listen for docker events
-	go instanceRegistrar.ListenForDockerEvents(ctx)
+	registrarDone := make(chan struct{})
+	go func() {
+		defer close(registrarDone)
+		instanceRegistrar.ListenForDockerEvents(ctx)
+	}()
+
+	// Purge the soft deleted objects past their retention
+	go storage.SweepDeleted(ctx)
+	go storage.SweepContents(ctx)
+	go storage.WeighByCapacity(ctx)
 
 	// Start the server
 	go func() {
-		log.Info("Server is ready to handle requests at :3000")
-		if err = server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Error("Could not listen on :3000: %v\n", err)
+		log.Info("Server is ready to handle requests", "addr", cfg.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("Could not listen", "addr", cfg.Addr, "error", err)
 			os.Exit(1)
 		}
 	}()
@@ -74,12 +213,31 @@ func run() error {
 	<-ctx.Done()
 	stop()
 
-	log.Info("Server is shutting down...")
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	log.Info("Server is shutting down...", "in_flight", inFlight.Count())
+	shutdownStart := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
-	if err = server.Shutdown(ctx); err != nil {
-		log.Error("Could not gracefully shutdown the server: %v\n", err)
+	summary, err := inFlight.Shutdown(ctx, server)
+	registrarStopped := true
+	select {
+	case <-registrarDone:
+	case <-ctx.Done():
+		registrarStopped = false
+	}
+
+	// The summary tells apart a shutdown waiting on slow requests from one stuck on the registrar
+	log.Info("Shutdown summary",
+		"in_flight", summary.InFlight,
+		"drained", summary.Drained,
+		"abandoned", summary.Abandoned,
+		"duration", time.Since(shutdownStart),
+		"timeout_hit", summary.TimeoutHit,
+		"registrar_stopped", registrarStopped,
+		"registrar_reconciled", instanceRegistrar.Reconciled(),
+	)
+	if err != nil {
+		log.Error("Could not gracefully shutdown the server", "error", err)
 		return err
 	}
 
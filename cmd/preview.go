@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dariusigna/object-storage/internal/config"
+	"github.com/dariusigna/object-storage/internal/registry"
+)
+
+// preview prints the instances the hash key routes to, the owner first and then its successors on the ring
+// The first instances, up to the replication factor, are the ones the object is written to
+func preview(w io.Writer, instanceRegistry *registry.Registry, cfg config.Config, key string) error {
+	if cfg.FoldCase {
+		key = cfg.KeyStrategy.FoldKey(key)
+	}
+
+	instances, err := instanceRegistry.MatchServices(key, instanceRegistry.Count())
+	if err != nil {
+		return fmt.Errorf("could not preview key %s: %w", key, err)
+	}
+
+	fmt.Fprintf(w, "key: %s (%s key strategy)\n", key, cfg.KeyStrategy)
+	fmt.Fprintf(w, "owner: %s (%s)\n", instances[0].Name, instances[0].Address())
+	fmt.Fprintln(w, "instances:")
+	for i, instance := range instances {
+		role := "successor"
+		if i < cfg.ReplicationFactor {
+			role = "replica"
+		}
+		fmt.Fprintf(w, "  %d. %s (%s) %s\n", i+1, instance.Name, instance.Address(), role)
+	}
+
+	return nil
+}
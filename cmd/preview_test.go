@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dariusigna/object-storage/internal/config"
+	"github.com/dariusigna/object-storage/internal/gateway"
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/registry"
+)
+
+// testRing returns a registry over three fixed instances
+func testRing() *registry.Registry {
+	r := registry.NewRegistry(hashring.New())
+	r.RegisterService(registry.ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1"})
+	r.RegisterService(registry.ServiceMetadata{Name: "minio2", IPAddress: "10.0.0.2"})
+	r.RegisterService(registry.ServiceMetadata{Name: "minio3", IPAddress: "10.0.0.3"})
+	return r
+}
+
+func TestPreview(t *testing.T) {
+	const want = `key: invoice-42 (id key strategy)
+owner: minio3 (10.0.0.3)
+instances:
+  1. minio3 (10.0.0.3) replica
+  2. minio1 (10.0.0.1) replica
+  3. minio2 (10.0.0.2) successor
+`
+	tests := []struct {
+		name     string
+		key      string
+		foldCase bool
+	}{
+		{name: "key", key: "invoice-42"},
+		{name: "folded key", key: "Invoice-42", foldCase: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.ReplicationFactor, cfg.FoldCase = 2, tt.foldCase
+			var out bytes.Buffer
+			if err := preview(&out, testRing(), cfg, tt.key); err != nil {
+				t.Fatalf("preview() error = %v", err)
+			}
+			if out.String() != want {
+				t.Errorf("preview() printed\n%s\nwant\n%s", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestPreviewFoldsTheIDOnly(t *testing.T) {
+	cfg := config.Default()
+	cfg.KeyStrategy, cfg.FoldCase = gateway.BucketIDKey, true
+	var out bytes.Buffer
+	if err := preview(&out, testRing(), cfg, "Invoices/Invoice-42"); err != nil {
+		t.Fatalf("preview() error = %v", err)
+	}
+	// The gateway folds the id of the object, not its bucket
+	if want := "key: Invoices/invoice-42 (bucket/id key strategy)\n"; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("preview() printed\n%s\nwant it to start with\n%s", out.String(), want)
+	}
+}
+
+func TestPreviewWithoutInstances(t *testing.T) {
+	var out bytes.Buffer
+	if err := preview(&out, registry.NewRegistry(hashring.New()), config.Default(), "invoice-42"); err == nil {
+		t.Errorf("preview() error = nil, want the lookup failure, printed %q", out.String())
+	}
+}
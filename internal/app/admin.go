@@ -0,0 +1,277 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	log "log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/dariusigna/object-storage/internal/version"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxRingReplicas bounds the virtual nodes per instance accepted when rebuilding the ring
+	maxRingReplicas = 10000
+	// adminTokenHeader carries the admin token, apart from the Authorization header checked by the auth backend
+	adminTokenHeader = "X-Admin-Token"
+)
+
+// Registry is an interface for inspecting and managing the service registry
+type Registry interface {
+	Ring() []hashring.VirtualNode
+	HashParams() hashring.Params
+	RebuildRing(hash registry.Hasher) float64
+	MigrateRing(hash registry.Hasher) float64
+	FinishMigration() bool
+	Migrating() bool
+	CheckConsistency() registry.ConsistencyReport
+	SetDraining(name string, draining bool) error
+	IsDraining(address string) bool
+	GetAllServices() []registry.ServiceMetadata
+	Count() int
+}
+
+// Registrar is an interface for managing the registration of the discovered instances
+type Registrar interface {
+	Cordoned() bool
+	SetCordoned(ctx context.Context, cordoned bool) error
+}
+
+// requireAdminToken rejects the admin requests without the admin token with a 401
+// Without a token configured the admin endpoints are disabled, and every admin request is rejected with a 403
+func requireAdminToken(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if token == "" {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte("the admin endpoints are disabled"))
+					return
+				}
+
+				if subtle.ConstantTimeCompare([]byte(r.Header.Get(adminTokenHeader)), []byte(token)) != 1 {
+					log.Warn("Admin request denied", "method", r.Method, "path", r.URL.Path, "client_ip", clientIP(r))
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+type ringResponse struct {
+	VirtualNodes []hashring.VirtualNode `json:"virtual_nodes"`
+	Load         map[string]float64     `json:"load"`
+	Imbalance    float64                `json:"imbalance"`
+}
+
+func handleGetRing(registry Registry) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ring := registry.Ring()
+			encode(w, http.StatusOK, ringResponse{
+				VirtualNodes: ring,
+				Load:         hashring.Load(ring),
+				Imbalance:    hashring.Imbalance(ring),
+			})
+		},
+	)
+}
+
+func handleGetHashing(registry Registry) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			encode(w, http.StatusOK, registry.HashParams())
+		},
+	)
+}
+
+type rebuildRingResponse struct {
+	Replicas         int     `json:"replicas"`
+	RemappedFraction float64 `json:"remapped_fraction"`
+	Migrating        bool    `json:"migrating"`
+}
+
+// handleRebuildRing rebuilds the ring with the given number of virtual nodes per instance
+// With migrate, the previous ring is kept for the reads until the migration is finished, so the objects not moved yet are found
+func handleRebuildRing(registry Registry) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			replicas, err := strconv.Atoi(r.URL.Query().Get("replicas"))
+			if err != nil || replicas < hashring.DefaultReplicas || replicas > maxRingReplicas {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("replicas must be an integer between " + strconv.Itoa(hashring.DefaultReplicas) + " and " + strconv.Itoa(maxRingReplicas)))
+				return
+			}
+
+			migrate := false
+			if value := r.URL.Query().Get("migrate"); value != "" {
+				if migrate, err = strconv.ParseBool(value); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("invalid migrate parameter"))
+					return
+				}
+			}
+
+			// The seed is kept, so the rebuild only changes the placement through the virtual nodes
+			var (
+				hash     = hashring.NewSeeded(replicas, registry.HashParams().Seed)
+				remapped float64
+			)
+			if migrate {
+				remapped = registry.MigrateRing(hash)
+			} else {
+				remapped = registry.RebuildRing(hash)
+			}
+			encode(w, http.StatusOK, rebuildRingResponse{Replicas: replicas, RemappedFraction: remapped, Migrating: migrate})
+		},
+	)
+}
+
+// handleCheckRing repairs the ring out of sync with the registered instances, and reports the divergences found
+func handleCheckRing(registry Registry) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			encode(w, http.StatusOK, registry.CheckConsistency())
+		},
+	)
+}
+
+type migrationResponse struct {
+	Migrating bool `json:"migrating"`
+}
+
+func handleGetMigration(registry Registry) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			encode(w, http.StatusOK, migrationResponse{Migrating: registry.Migrating()})
+		},
+	)
+}
+
+// handleFinishMigration drops the previous ring once the objects were moved, it responds with 409 if no migration is ongoing
+func handleFinishMigration(registry Registry) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if !registry.FinishMigration() {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("no ring migration is ongoing"))
+				return
+			}
+
+			encode(w, http.StatusOK, migrationResponse{Migrating: false})
+		},
+	)
+}
+
+// instanceResponse describes a registered instance, without its credentials
+type instanceResponse struct {
+	Name           string    `json:"name"`
+	Address        string    `json:"address"`
+	Zone           string    `json:"zone,omitempty"`
+	Weight         int       `json:"weight"`
+	CapacityWeight int       `json:"capacity_weight,omitempty"`
+	Draining       bool      `json:"draining"`
+	ReadOnly       bool      `json:"read_only"`
+	RegisteredAt   time.Time `json:"registered_at"`
+	UptimeSeconds  float64   `json:"uptime_seconds"`
+}
+
+// handleListInstances lists the registered instances with their registration time, to spot the recently flapped ones
+func handleListInstances(instanceRegistry Registry) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			services := instanceRegistry.GetAllServices()
+			instances := make([]instanceResponse, 0, len(services))
+			for _, service := range services {
+				instances = append(instances, instanceResponse{
+					Name:           service.Name,
+					Address:        service.Address(),
+					Zone:           service.Zone,
+					Weight:         service.Weight,
+					CapacityWeight: service.CapacityWeight,
+					Draining:       instanceRegistry.IsDraining(service.Address()),
+					ReadOnly:       service.ReadOnly,
+					RegisteredAt:   service.RegisteredAt,
+					UptimeSeconds:  time.Since(service.RegisteredAt).Seconds(),
+				})
+			}
+			sort.Slice(instances, func(i, j int) bool {
+				return instances[i].Name < instances[j].Name
+			})
+
+			encode(w, http.StatusOK, instances)
+		},
+	)
+}
+
+type drainResponse struct {
+	Name     string `json:"name"`
+	Draining bool   `json:"draining"`
+}
+
+func handleDrainInstance(instanceRegistry Registry, draining bool) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			name := mux.Vars(r)["name"]
+			if err := instanceRegistry.SetDraining(name, draining); err != nil {
+				log.Error("drain error", "error", err)
+				var notFoundErr registry.ServiceNotFoundError
+				if errors.As(err, &notFoundErr) {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			encode(w, http.StatusOK, drainResponse{Name: name, Draining: draining})
+		},
+	)
+}
+
+type cordonResponse struct {
+	Cordoned bool `json:"cordoned"`
+}
+
+func handleGetCordon(registrar Registrar) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			encode(w, http.StatusOK, cordonResponse{Cordoned: registrar.Cordoned()})
+		},
+	)
+}
+
+// handleSetCordon cordons the registrar, so the new instances aren't registered, or uncordons it
+// The cordon is changed even if the refresh following an uncordon fails, in which case it responds with 500
+func handleSetCordon(registrar Registrar, cordoned bool) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if err := registrar.SetCordoned(r.Context(), cordoned); err != nil {
+				log.Error("cordon error", "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			encode(w, http.StatusOK, cordonResponse{Cordoned: cordoned})
+		},
+	)
+}
+
+func handleVersion() http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			encode(w, http.StatusOK, version.Get())
+		},
+	)
+}
@@ -0,0 +1,371 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/dariusigna/object-storage/internal/version"
+)
+
+func TestAdminToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string // The configured admin token
+		sent  string // The token of the request
+		want  int
+	}{
+		{name: "valid token", token: "secret", sent: "secret", want: http.StatusOK},
+		{name: "missing token", token: "secret", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", sent: "guess", want: http.StatusUnauthorized},
+		{name: "disabled", sent: "secret", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := requireAdminToken(tt.token)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/admin/ring", nil)
+			if tt.sent != "" {
+				req.Header.Set(adminTokenHeader, tt.sent)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteNamesAreReservedBuckets(t *testing.T) {
+	for _, target := range []string{"/admin/ring", "/metrics/id", "/version/id"} {
+		// Without the reservation, the object ring of a bucket admin would be read from the ring dump
+		w := serve(t, testConfig(), newFakeStorage(), http.MethodPut, target, "data")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s status = %d, want the bucket rejected", target, w.Code)
+		}
+	}
+
+	w := serve(t, testConfig(), newFakeStorage(), http.MethodGet, "/admin", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("listing the admin bucket status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// serveAdmin sends the admin request to a server over the registry, with the admin token
+func serveAdmin(t *testing.T, registry Registry, method, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	cfg := testConfig()
+	cfg.AdminToken = "secret"
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set(adminTokenHeader, cfg.AdminToken)
+	w := httptest.NewRecorder()
+	NewServer(cfg, newFakeStorage(), registry, nil, nil).ServeHTTP(w, req)
+	return w
+}
+
+func TestGetRing(t *testing.T) {
+	r := registry.NewRegistry(hashring.New())
+	r.RegisterService(registry.ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1"})
+	r.RegisterService(registry.ServiceMetadata{Name: "minio2", IPAddress: "10.0.0.2"})
+	r.RegisterService(registry.ServiceMetadata{Name: "minio3", IPAddress: "10.0.0.3"})
+	r.DeregisterService("10.0.0.3")
+
+	w := serveAdmin(t, r, http.MethodGet, "/admin/ring")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var ring ringResponse
+	if err := json.NewDecoder(w.Body).Decode(&ring); err != nil {
+		t.Fatalf("failed to decode the ring: %v", err)
+	}
+
+	// Every registered instance owns its virtual nodes, ordered by position, and the deregistered one none
+	nodes := make(map[string]int)
+	for i, node := range ring.VirtualNodes {
+		if i > 0 && node.Position < ring.VirtualNodes[i-1].Position {
+			t.Fatalf("virtual node %d at %d is before the previous one at %d", i, node.Position, ring.VirtualNodes[i-1].Position)
+		}
+		nodes[node.Node]++
+	}
+	want := map[string]int{"10.0.0.1": hashring.DefaultReplicas, "10.0.0.2": hashring.DefaultReplicas}
+	if !maps.Equal(nodes, want) {
+		t.Errorf("virtual nodes by instance = %v, want %v", nodes, want)
+	}
+	if len(ring.Load) != 2 || ring.Imbalance < 1 {
+		t.Errorf("load = %v with imbalance %v, want the share of both instances", ring.Load, ring.Imbalance)
+	}
+}
+
+func TestGetHashing(t *testing.T) {
+	tests := []struct {
+		name string
+		hash *hashring.ConsistentHash
+		want hashring.Params
+	}{
+		{
+			name: "default",
+			hash: hashring.New(),
+			want: hashring.Params{Algorithm: "murmur3", VirtualNodes: hashring.DefaultReplicas, WeightMode: "virtual-nodes", TopWeight: hashring.TopWeight},
+		},
+		{
+			name: "custom",
+			hash: hashring.NewCustom(150, func(data []byte) uint64 { return uint64(len(data)) }),
+			want: hashring.Params{Algorithm: "custom", VirtualNodes: 150, WeightMode: "virtual-nodes", TopWeight: hashring.TopWeight},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAdmin(t, registry.NewRegistry(tt.hash), http.MethodGet, "/admin/ring/hashing")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			var got hashring.Params
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode the parameters: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parameters = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRebuildRing(t *testing.T) {
+	r := registry.NewRegistry(hashring.NewSeeded(hashring.DefaultReplicas, "staging"))
+	r.RegisterService(registry.ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1"})
+	r.RegisterService(registry.ServiceMetadata{Name: "minio2", IPAddress: "10.0.0.2"})
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{name: "rebuild", target: "/admin/ring/rebuild?replicas=300", want: http.StatusOK},
+		{name: "migrate", target: "/admin/ring/rebuild?replicas=200&migrate=true", want: http.StatusOK},
+		{name: "missing replicas", target: "/admin/ring/rebuild", want: http.StatusBadRequest},
+		{name: "too few replicas", target: "/admin/ring/rebuild?replicas=10", want: http.StatusBadRequest},
+		{name: "too many replicas", target: "/admin/ring/rebuild?replicas=10001", want: http.StatusBadRequest},
+		{name: "invalid migrate", target: "/admin/ring/rebuild?replicas=200&migrate=maybe", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := r.HashParams()
+			w := serveAdmin(t, r, http.MethodPost, tt.target)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				if r.HashParams() != params {
+					t.Errorf("the ring was rebuilt by a rejected request")
+				}
+				return
+			}
+
+			var resp rebuildRingResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode the response: %v", err)
+			}
+			if r.HashParams().VirtualNodes != resp.Replicas || r.HashParams().Seed != "staging" {
+				t.Errorf("params = %+v, want %d virtual nodes and the seed kept", r.HashParams(), resp.Replicas)
+			}
+			if resp.RemappedFraction <= 0 || resp.Migrating != r.Migrating() {
+				t.Errorf("response = %+v, want the remapped fraction and the migration state", resp)
+			}
+			if r.Count() != 2 || len(hashring.Load(r.Ring())) != 2 {
+				t.Errorf("%d instances with %d on the ring, want both kept", r.Count(), len(hashring.Load(r.Ring())))
+			}
+		})
+	}
+}
+
+func TestRingMigration(t *testing.T) {
+	r := registry.NewRegistry(hashring.New())
+	r.RegisterService(registry.ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1"})
+	r.RegisterService(registry.ServiceMetadata{Name: "minio2", IPAddress: "10.0.0.2"})
+
+	migrating := func() bool {
+		t.Helper()
+		w := serveAdmin(t, r, http.MethodGet, "/admin/ring/migration")
+		var resp migrationResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode the response: %v", err)
+		}
+		return resp.Migrating
+	}
+
+	if w := serveAdmin(t, r, http.MethodPost, "/admin/ring/migration/finish"); w.Code != http.StatusConflict {
+		t.Errorf("finish without a migration status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if migrating() {
+		t.Error("migrating before the ring was migrated")
+	}
+
+	serveAdmin(t, r, http.MethodPost, "/admin/ring/rebuild?replicas=200&migrate=true")
+	if !migrating() {
+		t.Error("not migrating after the ring was migrated")
+	}
+
+	if w := serveAdmin(t, r, http.MethodPost, "/admin/ring/migration/finish"); w.Code != http.StatusOK {
+		t.Errorf("finish status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if migrating() || r.Migrating() {
+		t.Error("still migrating after the migration was finished")
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	registeredAt := time.Now().Add(-time.Hour)
+	r := registry.NewRegistry(hashring.New())
+	r.RegisterService(registry.ServiceMetadata{Name: "minio2", IPAddress: "10.0.0.2", SecretKey: "minio123", RegisteredAt: registeredAt})
+	r.RegisterService(registry.ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1", SecretKey: "minio123", Zone: "a", Weight: 50})
+	if err := r.SetDraining("minio1", true); err != nil {
+		t.Fatalf("SetDraining() error = %v", err)
+	}
+
+	w := serveAdmin(t, r, http.MethodGet, "/admin/instances")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if strings.Contains(w.Body.String(), "minio123") {
+		t.Errorf("response = %s, want the credentials left out", w.Body)
+	}
+
+	var instances []instanceResponse
+	if err := json.NewDecoder(w.Body).Decode(&instances); err != nil {
+		t.Fatalf("failed to decode the response: %v", err)
+	}
+	if len(instances) != 2 || instances[0].Name != "minio1" || instances[1].Name != "minio2" {
+		t.Fatalf("instances = %+v, want both sorted by name", instances)
+	}
+	if got := instances[0]; got.Address != "10.0.0.1" || got.Zone != "a" || got.Weight != 50 || !got.Draining || got.RegisteredAt.IsZero() {
+		t.Errorf("instance = %+v, want the one registered", got)
+	}
+	if got := instances[1]; !got.RegisteredAt.Equal(registeredAt) || got.UptimeSeconds < time.Hour.Seconds() || got.Draining {
+		t.Errorf("instance = %+v, want registered at %v, up for an hour", got, registeredAt)
+	}
+}
+
+func TestDrainInstance(t *testing.T) {
+	r := registry.NewRegistry(hashring.New())
+	r.RegisterService(registry.ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1"})
+
+	steps := []struct {
+		target   string
+		want     int
+		draining bool
+	}{
+		{target: "/admin/instances/minio1/drain", want: http.StatusOK, draining: true},
+		{target: "/admin/instances/minio1/drain", want: http.StatusOK, draining: true},
+		{target: "/admin/instances/minio1/undrain", want: http.StatusOK},
+		{target: "/admin/instances/minio9/drain", want: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		w := serveAdmin(t, r, http.MethodPost, step.target)
+		if w.Code != step.want {
+			t.Fatalf("%s status = %d, want %d", step.target, w.Code, step.want)
+		}
+		if got := r.IsDraining("10.0.0.1"); got != step.draining {
+			t.Errorf("after %s, draining = %v, want %v", step.target, got, step.draining)
+		}
+	}
+}
+
+// fakeRegistrar records the cordon, failing to uncordon with err when set
+type fakeRegistrar struct {
+	cordoned bool
+	err      error
+}
+
+func (f *fakeRegistrar) Cordoned() bool {
+	return f.cordoned
+}
+
+func (f *fakeRegistrar) SetCordoned(_ context.Context, cordoned bool) error {
+	f.cordoned = cordoned
+	if !cordoned {
+		return f.err
+	}
+	return nil
+}
+
+func TestCordon(t *testing.T) {
+	registrar := &fakeRegistrar{}
+	cfg := testConfig()
+	cfg.AdminToken = "secret"
+	handler := NewServer(cfg, newFakeStorage(), registry.NewRegistry(hashring.New()), registrar, nil)
+	serveCordon := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set(adminTokenHeader, cfg.AdminToken)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	steps := []struct {
+		method   string
+		target   string
+		err      error // The error of the refresh following an uncordon
+		want     int
+		cordoned bool
+	}{
+		{method: http.MethodGet, target: "/admin/registrar", want: http.StatusOK},
+		{method: http.MethodPost, target: "/admin/registrar/cordon", want: http.StatusOK, cordoned: true},
+		{method: http.MethodGet, target: "/admin/registrar", want: http.StatusOK, cordoned: true},
+		{method: http.MethodPost, target: "/admin/registrar/uncordon", want: http.StatusOK},
+		{method: http.MethodPost, target: "/admin/registrar/cordon", want: http.StatusOK, cordoned: true},
+		{method: http.MethodPost, target: "/admin/registrar/uncordon", err: errors.New("docker is unreachable"), want: http.StatusInternalServerError},
+	}
+	for _, step := range steps {
+		registrar.err = step.err
+		w := serveCordon(step.method, step.target)
+		if w.Code != step.want {
+			t.Fatalf("%s %s status = %d, want %d", step.method, step.target, w.Code, step.want)
+		}
+		if registrar.cordoned != step.cordoned {
+			t.Errorf("after %s %s, cordoned = %v, want %v", step.method, step.target, registrar.cordoned, step.cordoned)
+		}
+		if step.want != http.StatusOK {
+			continue
+		}
+
+		var resp cordonResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode the response: %v", err)
+		}
+		if resp.Cordoned != step.cordoned {
+			t.Errorf("%s %s response = %+v, want cordoned %v", step.method, step.target, resp, step.cordoned)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	injectedVersion, injectedCommit := version.Version, version.Commit
+	t.Cleanup(func() { version.Version, version.Commit = injectedVersion, injectedCommit })
+	version.Version, version.Commit = "v1.2.3", "abc1234"
+
+	w := serve(t, testConfig(), newFakeStorage(), http.MethodGet, "/version", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var got version.Info
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode the version: %v", err)
+	}
+	if want := (version.Info{Version: "v1.2.3", Commit: "abc1234", GoVersion: runtime.Version()}); got != want {
+		t.Errorf("version = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"time"
+)
+
+// authTimeout bounds a check of a request against the auth backend
+const authTimeout = 2 * time.Second
+
+// authenticator checks the requests against an external auth backend, a nil authenticator lets every request through
+// The backend gets the credentials of the request, and answers 2xx to allow it or 401/403 to deny it
+type authenticator struct {
+	url    string
+	client *http.Client
+	// failOpen lets the requests through when the backend can't tell, instead of rejecting them
+	failOpen bool
+}
+
+func newAuthenticator(url string, failOpen bool) *authenticator {
+	if url == "" {
+		return nil
+	}
+
+	return &authenticator{url: url, client: &http.Client{Timeout: authTimeout}, failOpen: failOpen}
+}
+
+// check asks the backend whether the request is allowed, an error means the backend couldn't tell
+func (a *authenticator) check(r *http.Request) (bool, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, a.url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create auth request: %w", err)
+	}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	req.Header.Set("X-Original-Method", r.Method)
+	req.Header.Set("X-Original-URI", r.URL.RequestURI())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to reach the auth backend: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return true, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("auth backend responded with %s", resp.Status)
+	}
+}
+
+// authenticate rejects the requests the auth backend denies with a 401
+// When the backend fails, the request is let through in fail-open mode, and rejected with a 503 otherwise
+func (a *authenticator) authenticate(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			allowed, err := a.check(r)
+			switch {
+			case err != nil && a.failOpen:
+				log.Warn("Auth check failed, failing open", "method", r.Method, "path", r.URL.Path, "client_ip", clientIP(r), "error", err)
+			case err != nil:
+				log.Error("Auth check failed, failing closed", "method", r.Method, "path", r.URL.Path, "client_ip", clientIP(r), "error", err)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("authentication is unavailable"))
+				return
+			case !allowed:
+				log.Debug("Request denied by the auth backend", "method", r.Method, "path", r.URL.Path, "client_ip", clientIP(r))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		backend  int // The status of the auth backend, zero for an unreachable backend
+		failOpen bool
+		want     int
+	}{
+		{name: "allowed", backend: http.StatusNoContent, want: http.StatusOK},
+		{name: "unauthorized", backend: http.StatusUnauthorized, want: http.StatusUnauthorized},
+		{name: "forbidden", backend: http.StatusForbidden, failOpen: true, want: http.StatusUnauthorized},
+		{name: "backend error fails closed", backend: http.StatusInternalServerError, want: http.StatusServiceUnavailable},
+		{name: "backend error fails open", backend: http.StatusInternalServerError, failOpen: true, want: http.StatusOK},
+		{name: "unreachable backend fails closed", want: http.StatusServiceUnavailable},
+		{name: "unreachable backend fails open", failOpen: true, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAuthorization, gotURI string
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAuthorization, gotURI = r.Header.Get("Authorization"), r.Header.Get("X-Original-URI")
+				w.WriteHeader(tt.backend)
+			}))
+			if tt.backend == 0 {
+				backend.Close()
+			} else {
+				defer backend.Close()
+			}
+
+			handler := newAuthenticator(backend.URL, tt.failOpen).authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/bucket/id?metadata=1", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.backend != 0 && (gotAuthorization != "Bearer token" || gotURI != "/bucket/id?metadata=1") {
+				t.Errorf("backend got Authorization %q and URI %q, want the ones of the request", gotAuthorization, gotURI)
+			}
+		})
+	}
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	w := httptest.NewRecorder()
+	newAuthenticator("", false).authenticate(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("status = %d, want the request let through without an auth backend", w.Code)
+	}
+}
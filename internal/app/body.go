@@ -0,0 +1,112 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+)
+
+// errNoFilePart is returned when a multipart form upload has no file part
+var errNoFilePart = errors.New("multipart form has no file part")
+
+// multipartFile returns the first file part of a multipart/form-data request, read as a stream
+// It returns a nil part for the other content types, whose body is the object itself
+func multipartFile(r *http.Request) (io.Reader, string, string, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, "", "", nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid multipart form: %w", err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", "", errNoFilePart
+		}
+		if err != nil {
+			return nil, "", "", fmt.Errorf("invalid multipart form: %w", err)
+		}
+
+		// The other fields are skipped, the object is the file
+		if part.FileName() != "" {
+			return part, part.FileName(), part.Header.Get("Content-Type"), nil
+		}
+	}
+}
+
+// contentLengthError is returned when the body of a request isn't as long as its Content-Length header declares
+type contentLengthError struct {
+	declared int64
+	read     int64
+}
+
+// Error returns the error message
+func (c contentLengthError) Error() string {
+	if c.read < 0 {
+		return fmt.Sprintf("body is shorter than the declared Content-Length %d", c.declared)
+	}
+
+	return fmt.Sprintf("body length %d does not match the declared Content-Length %d", c.read, c.declared)
+}
+
+// checkContentLength returns a contentLengthError when the body read, of size bytes or failed with readErr, isn't as long as declared
+// A body cut short fails with io.ErrUnexpectedEOF rather than reporting its size, the length read is unknown then
+func checkContentLength(r *http.Request, size int64, readErr error) error {
+	switch {
+	case r.ContentLength < 0:
+		return readErr
+	case errors.Is(readErr, io.ErrUnexpectedEOF):
+		return contentLengthError{declared: r.ContentLength, read: -1}
+	case readErr == nil && size != r.ContentLength:
+		return contentLengthError{declared: r.ContentLength, read: size}
+	}
+
+	return readErr
+}
+
+// spoolBody reads the request body into a gateway.Body
+// Bodies larger than spillThreshold bytes are buffered to a temporary file instead of memory, zero keeps every body in memory
+func spoolBody(r io.Reader, spillThreshold int64) (gateway.Body, error) {
+	if spillThreshold <= 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return gateway.NewBytesBody(data), nil
+	}
+
+	// One byte past the threshold tells whether the body fits in memory
+	head, err := io.ReadAll(io.LimitReader(r, spillThreshold+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(head)) <= spillThreshold {
+		return gateway.NewBytesBody(head), nil
+	}
+
+	file, err := os.CreateTemp("", "object-storage-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload buffer file: %w", err)
+	}
+
+	size, err := io.Copy(file, io.MultiReader(bytes.NewReader(head), r))
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return gateway.NewFileBody(file, size, true), nil
+}
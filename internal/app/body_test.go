@@ -0,0 +1,81 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// tempFiles returns the names of the files in the temporary directory
+func tempFiles(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir(os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestSpoolBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		threshold int64
+		spilled   bool
+	}{
+		{name: "disabled", data: "0123456789", threshold: 0},
+		{name: "under the threshold", data: "0123456789", threshold: 16},
+		{name: "at the threshold", data: "0123456789", threshold: 10},
+		{name: "over the threshold", data: "0123456789", threshold: 4, spilled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+
+			// The reader hides its length, like a chunked upload
+			body, err := spoolBody(io.MultiReader(strings.NewReader(tt.data)), tt.threshold)
+			if err != nil {
+				t.Fatalf("spoolBody() error = %v", err)
+			}
+			if files := tempFiles(t); (len(files) == 1) != tt.spilled {
+				t.Errorf("temporary files = %v, want a spilled body: %v", files, tt.spilled)
+			}
+
+			data := make([]byte, body.Size())
+			if _, err = body.ReadAt(data, 0); err != nil && err != io.EOF {
+				t.Fatalf("ReadAt() error = %v", err)
+			}
+			if string(data) != tt.data {
+				t.Errorf("body = %q, want %q", data, tt.data)
+			}
+
+			if err = body.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if files := tempFiles(t); len(files) != 0 {
+				t.Errorf("temporary files = %v after Close, want none", files)
+			}
+		})
+	}
+}
+
+func TestSpoolBodyReadErrorRemovesTheFile(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	readErr := errors.New("connection reset")
+
+	_, err := spoolBody(io.MultiReader(strings.NewReader("0123456789"), iotest.ErrReader(readErr)), 4)
+	if !errors.Is(err, readErr) {
+		t.Fatalf("spoolBody() error = %v, want %v", err, readErr)
+	}
+	if files := tempFiles(t); len(files) != 0 {
+		t.Errorf("temporary files = %v, want the buffer file removed", files)
+	}
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dariusigna/object-storage/internal/config"
+	"github.com/dariusigna/object-storage/internal/gateway"
+)
+
+// fakeStorage keeps the objects in memory, the methods a test doesn't set up panic through the nil Storage
+type fakeStorage struct {
+	Storage
+
+	mu      sync.Mutex
+	objects map[string]gateway.Object
+	// listed are the options of the last listing
+	listed gateway.ListOptions
+	// listing is returned by the listings, empty when unset
+	listing gateway.Listing
+	// written are the options of the last write
+	written gateway.PutOptions
+	// err is returned by the reads when set
+	err error
+	// writeErr is returned by the object writes when set
+	writeErr error
+	// uploads are the incomplete uploads listed and aborted
+	uploads gateway.UploadListing
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{objects: make(map[string]gateway.Object)}
+}
+
+func (f *fakeStorage) put(bucket, id string, object gateway.Object) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[bucket+"/"+id] = object
+}
+
+func (f *fakeStorage) GetObject(_ context.Context, bucket, id string) (gateway.Object, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return gateway.Object{}, f.err
+	}
+	object, ok := f.objects[bucket+"/"+id]
+	if !ok {
+		return gateway.Object{}, gateway.NotFoundError{}
+	}
+	return object, nil
+}
+
+func (f *fakeStorage) StatObject(_ context.Context, bucket, id string) (gateway.ObjectInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return gateway.ObjectInfo{}, f.err
+	}
+	object, ok := f.objects[bucket+"/"+id]
+	if !ok {
+		return gateway.ObjectInfo{}, gateway.NotFoundError{}
+	}
+	return gateway.ObjectInfo{
+		Bucket: bucket, ID: id, Size: int64(len(object.Data)), ContentType: object.ContentType, ETag: "etag",
+		Headers: object.Headers, UserMetadata: object.UserMetadata,
+	}, nil
+}
+
+func (f *fakeStorage) UpdateObjectMetadata(_ context.Context, bucket, id string, metadata map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[bucket+"/"+id]
+	if !ok {
+		return gateway.NotFoundError{}
+	}
+
+	merged := make(map[string]string, len(object.UserMetadata)+len(metadata))
+	for k, v := range object.UserMetadata {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	object.UserMetadata = merged
+	f.objects[bucket+"/"+id] = object
+	return nil
+}
+
+func (f *fakeStorage) ObjectExists(_ context.Context, bucket, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return false, f.err
+	}
+	_, ok := f.objects[bucket+"/"+id]
+	return ok, nil
+}
+
+func (f *fakeStorage) PutObject(_ context.Context, bucket, id string, body gateway.Body, opts gateway.PutOptions) (gateway.PutResult, error) {
+	defer body.Close()
+	data, err := io.ReadAll(io.NewSectionReader(body, 0, body.Size()))
+	if err != nil {
+		return gateway.PutResult{}, err
+	}
+
+	f.mu.Lock()
+	f.written = opts
+	writeErr := f.writeErr
+	f.mu.Unlock()
+	if writeErr != nil {
+		return gateway.PutResult{}, writeErr
+	}
+	f.put(bucket, id, gateway.Object{Data: data, ContentType: opts.ContentType, Headers: opts.Headers, UserMetadata: opts.UserMetadata})
+	return gateway.PutResult{ETag: "etag", Size: int64(len(data))}, nil
+}
+
+func (f *fakeStorage) DeleteObject(_ context.Context, bucket, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	if _, ok := f.objects[bucket+"/"+id]; !ok {
+		return gateway.NotFoundError{}
+	}
+	delete(f.objects, bucket+"/"+id)
+	return nil
+}
+
+func (f *fakeStorage) MoveObject(_ context.Context, src, dst, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	object, ok := f.objects[src+"/"+id]
+	if !ok {
+		return gateway.NotFoundError{}
+	}
+	f.objects[dst+"/"+id] = object
+	delete(f.objects, src+"/"+id)
+	return nil
+}
+
+func (f *fakeStorage) BucketExists(_ context.Context, bucket string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return false, f.err
+	}
+	for key := range f.objects {
+		if strings.HasPrefix(key, bucket+"/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeStorage) ListObjects(_ context.Context, _ string, opts gateway.ListOptions) (gateway.Listing, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listed = opts
+	if f.listing.Objects == nil {
+		f.listing.Objects = []gateway.ObjectSummary{}
+	}
+	return f.listing, nil
+}
+
+func (f *fakeStorage) EnrichListing(_ context.Context, bucket string, listing gateway.Listing) gateway.Listing {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, object := range listing.Objects {
+		if stored, ok := f.objects[bucket+"/"+object.Key]; ok {
+			listing.Objects[i].ContentType, listing.Objects[i].UserMetadata = stored.ContentType, stored.UserMetadata
+		}
+	}
+	return listing
+}
+
+func (f *fakeStorage) ListIncompleteUploads(context.Context) (gateway.UploadListing, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return gateway.UploadListing{}, f.err
+	}
+	listing := f.uploads
+	listing.Uploads = append([]gateway.IncompleteUpload{}, f.uploads.Uploads...)
+	return listing, nil
+}
+
+func (f *fakeStorage) AbortUpload(_ context.Context, instance, bucket, key, uploadID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, upload := range f.uploads.Uploads {
+		if upload.Instance == instance && upload.Bucket == bucket && upload.Key == key && upload.UploadID == uploadID {
+			f.uploads.Uploads = append(f.uploads.Uploads[:i], f.uploads.Uploads[i+1:]...)
+			return nil
+		}
+	}
+	return gateway.UploadNotFoundError{UploadID: uploadID}
+}
+
+// testConfig returns the default configuration, without the request logging
+func testConfig() config.Config {
+	cfg := config.Default()
+	cfg.SlowRequestThreshold = 0
+	return cfg
+}
+
+// serve sends the request to a server over storage, and returns the recorded response
+func serve(t *testing.T, cfg config.Config, storage Storage, method, target string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := NewServer(cfg, storage, nil, nil, nil)
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, target, reader))
+	return w
+}
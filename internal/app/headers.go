@@ -0,0 +1,36 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+)
+
+// parseStoredHeaders returns the gateway.StoredHeaders of the request, to store with the object
+// In strict mode, X-Amz-* headers other than the user metadata are rejected, since they would be silently ignored
+func parseStoredHeaders(header http.Header, strict bool) (http.Header, error) {
+	if strict {
+		for name := range header {
+			if strings.HasPrefix(name, "X-Amz-") && !strings.HasPrefix(name, userMetadataPrefix) {
+				return nil, fmt.Errorf("unsupported header %s", name)
+			}
+		}
+	}
+
+	stored := make(http.Header)
+	for _, name := range gateway.StoredHeaders {
+		if value := header.Get(name); value != "" {
+			stored.Set(name, value)
+		}
+	}
+
+	if expires := stored.Get("Expires"); expires != "" {
+		if _, err := http.ParseTime(expires); err != nil {
+			return nil, fmt.Errorf("invalid Expires header: %w", err)
+		}
+	}
+
+	return stored, nil
+}
@@ -0,0 +1,33 @@
+package app
+
+import "net/http"
+
+// withHealthChecks answers the load balancer health checks, and passes the other requests to next
+// /healthz tells the gateway is up, and /readyz that it can route the requests, i.e. an instance is registered
+func withHealthChecks(registry Registry, next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			switch r.URL.Path {
+			case "/healthz":
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("ok"))
+			case "/readyz":
+				// The instances loaded from the registry snapshot make the gateway ready before docker is reconciled
+				if registry.Count() == 0 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					w.Write([]byte("no instance is registered"))
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("ready"))
+			default:
+				next.ServeHTTP(w, r)
+			}
+		},
+	)
+}
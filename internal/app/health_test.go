@@ -0,0 +1,90 @@
+package app
+
+import (
+	"bytes"
+	log "log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeRegistry serves a fixed set of instances, the methods a test doesn't set up panic through the nil Registry
+type fakeRegistry struct {
+	Registry
+	services []registry.ServiceMetadata
+}
+
+func (f *fakeRegistry) GetAllServices() []registry.ServiceMetadata {
+	return f.services
+}
+
+func (f *fakeRegistry) Count() int {
+	return len(f.services)
+}
+
+func TestHealthChecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		services []registry.ServiceMetadata
+		want     int
+	}{
+		{name: "alive", target: "/healthz", want: http.StatusOK},
+		{name: "not ready", target: "/readyz", want: http.StatusServiceUnavailable},
+		{name: "ready", target: "/readyz", services: []registry.ServiceMetadata{{IPAddress: "10.0.0.1"}}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The auth backend denies every request, the health checks carry no credentials
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			}))
+			defer backend.Close()
+			cfg := testConfig()
+			cfg.AuthURL = backend.URL
+
+			w := httptest.NewRecorder()
+			NewServer(cfg, newFakeStorage(), &fakeRegistry{services: tt.services}, nil, nil).
+				ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthChecksAreNotMonitored(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetDefault(log.Default())
+	log.SetDefault(log.New(log.NewTextHandler(&logs, nil)))
+
+	// Every request is slow, so only the unmonitored ones are left out of the log
+	cfg := testConfig()
+	cfg.SlowRequestThreshold = time.Nanosecond
+	handler := NewServer(cfg, newFakeStorage(), &fakeRegistry{}, nil, nil)
+
+	served := testutil.ToFloat64(metrics.Requests.WithLabelValues(http.MethodGet, "200"))
+	for _, target := range []string{"/healthz", "/readyz"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	if strings.Contains(logs.String(), "Slow request") {
+		t.Errorf("the health checks were logged:\n%s", logs.String())
+	}
+	if got := testutil.ToFloat64(metrics.Requests.WithLabelValues(http.MethodGet, "200")) - served; got != 0 {
+		t.Errorf("the health checks were counted %v times in the request metrics", got)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/version", nil))
+	if !strings.Contains(logs.String(), "Slow request") {
+		t.Errorf("the other requests weren't logged")
+	}
+	if got := testutil.ToFloat64(metrics.Requests.WithLabelValues(http.MethodGet, "200")) - served; got != 1 {
+		t.Errorf("the other request was counted %v times in the request metrics, want once", got)
+	}
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	log "log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyKeyHeader identifies the retries of an upload, which are answered with the result of the first attempt
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyCache keeps the results of the recent uploads by idempotency key, a nil cache keeps nothing
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]idempotentResult
+}
+
+// idempotentResult is the response of an upload, it is pending while the upload is in progress
+type idempotentResult struct {
+	pending  bool
+	status   int
+	location string
+	stored   time.Time
+}
+
+func newIdempotencyCache(ttl time.Duration, size int) *idempotencyCache {
+	if ttl <= 0 || size <= 0 {
+		return nil
+	}
+
+	return &idempotencyCache{ttl: ttl, size: size, entries: make(map[string]idempotentResult)}
+}
+
+// dedupe answers a request repeating the idempotency key of a recent successful one with its result, without calling next
+// A repeat arriving while the first request is in progress is rejected with a 409, since its outcome is unknown yet
+// The failed requests aren't recorded, so their retries are served again
+func (c *idempotencyCache) dedupe(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The key is scoped to the object, so a key reused for another object doesn't replay an unrelated result
+			cacheKey := r.Method + " " + r.URL.Path + " " + key
+			result, found := c.begin(cacheKey)
+			switch {
+			case found && result.pending:
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("a request with the same idempotency key is in progress"))
+				return
+			case found:
+				log.Debug("Replaying the result of an idempotent request", "path", r.URL.Path, "idempotency_key", key)
+				if result.location != "" {
+					w.Header().Set("Location", result.location)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(result.status)
+				return
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+			c.finish(cacheKey, recorder.status, w.Header().Get("Location"))
+		},
+	)
+}
+
+// begin returns the result recorded for the key, or marks the key pending when there is none
+func (c *idempotencyCache) begin(cacheKey string) (idempotentResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A pending entry expires too, in case its request never finished, e.g. it panicked
+	result, ok := c.entries[cacheKey]
+	if ok && time.Since(result.stored) > c.ttl {
+		delete(c.entries, cacheKey)
+		ok = false
+	}
+	if ok {
+		return result, true
+	}
+
+	if len(c.entries) >= c.size {
+		c.evictLocked()
+	}
+	c.entries[cacheKey] = idempotentResult{pending: true, stored: time.Now()}
+	return idempotentResult{}, false
+}
+
+// finish records the result of the request, only a successful one is kept
+func (c *idempotencyCache) finish(cacheKey string, status int, location string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if status < 200 || status > 299 {
+		delete(c.entries, cacheKey)
+		return
+	}
+
+	c.entries[cacheKey] = idempotentResult{status: status, location: location, stored: time.Now()}
+}
+
+// evictLocked makes room for a new entry by removing an arbitrary completed one, like the failover cache
+// The pending entries are kept, so an upload in progress is never repeated
+func (c *idempotencyCache) evictLocked() {
+	for cacheKey, result := range c.entries {
+		if !result.pending {
+			delete(c.entries, cacheKey)
+			return
+		}
+	}
+}
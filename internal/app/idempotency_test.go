@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+)
+
+// countingStorage counts the object writes of the fake storage
+type countingStorage struct {
+	*fakeStorage
+	writes atomic.Int32
+}
+
+func (c *countingStorage) PutObject(ctx context.Context, bucket, id string, body gateway.Body, opts gateway.PutOptions) (gateway.PutResult, error) {
+	c.writes.Add(1)
+	return c.fakeStorage.PutObject(ctx, bucket, id, body, opts)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     [2]string // The idempotency keys of the two uploads
+		targets  [2]string
+		failed   bool // Whether the first upload fails
+		ttl      time.Duration
+		writes   int32
+		replayed bool // Whether the second upload is answered with the result of the first one
+	}{
+		{name: "same key", keys: [2]string{"a", "a"}, targets: [2]string{"/bucket/id", "/bucket/id"}, writes: 1, replayed: true},
+		{name: "other key", keys: [2]string{"a", "b"}, targets: [2]string{"/bucket/id", "/bucket/id"}, writes: 2},
+		{name: "no key", targets: [2]string{"/bucket/id", "/bucket/id"}, writes: 2},
+		{name: "other object", keys: [2]string{"a", "a"}, targets: [2]string{"/bucket/id", "/bucket/other"}, writes: 2},
+		{name: "failed upload", keys: [2]string{"a", "a"}, targets: [2]string{"/bucket/id", "/bucket/id"}, failed: true, writes: 2},
+		{name: "expired key", keys: [2]string{"a", "a"}, targets: [2]string{"/bucket/id", "/bucket/id"}, ttl: time.Nanosecond, writes: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			if tt.ttl > 0 {
+				cfg.IdempotencyTTL = tt.ttl
+			}
+			storage := &countingStorage{fakeStorage: newFakeStorage()}
+			handler := NewServer(cfg, storage, nil, nil, nil)
+
+			var responses [2]*httptest.ResponseRecorder
+			for i := range responses {
+				storage.mu.Lock()
+				storage.writeErr = nil
+				if tt.failed && i == 0 {
+					storage.writeErr = gateway.SlowDownError{RetryAfter: time.Second}
+				}
+				storage.mu.Unlock()
+
+				req := httptest.NewRequest(http.MethodPut, tt.targets[i], strings.NewReader("data"))
+				if tt.keys[i] != "" {
+					req.Header.Set(idempotencyKeyHeader, tt.keys[i])
+				}
+				responses[i] = httptest.NewRecorder()
+				handler.ServeHTTP(responses[i], req)
+			}
+
+			if got := storage.writes.Load(); got != tt.writes {
+				t.Errorf("wrote %d times, want %d", got, tt.writes)
+			}
+			if replayed := responses[1].Header().Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+				t.Errorf("second upload replayed: %v, want %v", replayed, tt.replayed)
+			}
+			if tt.replayed && responses[1].Code != responses[0].Code {
+				t.Errorf("replayed status = %d, want the status %d of the first upload", responses[1].Code, responses[0].Code)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+	handler := newIdempotencyCache(time.Minute, 10).dedupe(next)
+
+	upload := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/bucket/id", strings.NewReader("data"))
+		req.Header.Set(idempotencyKeyHeader, "a")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- upload() }()
+	<-started
+
+	// The outcome of the first upload is unknown yet
+	if w := upload(); w.Code != http.StatusConflict {
+		t.Errorf("status = %d while the first upload is in progress, want %d", w.Code, http.StatusConflict)
+	}
+	close(release)
+	if w := <-first; w.Code != http.StatusCreated {
+		t.Errorf("first upload status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if w := upload(); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("status = %d, replayed: %q after the first upload, want its result replayed", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	log "log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+	"github.com/gorilla/mux"
+)
+
+// maxListLimit is the most objects listed at once, and the default limit of a listing
+const maxListLimit = 1000
+
+type listObjectsResponse struct {
+	Objects []gateway.ObjectSummary `json:"objects"`
+	// Partial tells the listing may be missing the objects of the skipped instances
+	Partial          bool     `json:"partial"`
+	SkippedInstances []string `json:"skipped_instances,omitempty"`
+	// Truncated tells more objects follow, they are listed with start_after set to NextStartAfter
+	Truncated      bool   `json:"truncated"`
+	NextStartAfter string `json:"next_start_after,omitempty"`
+}
+
+// handleListObjects lists the objects of the bucket, a page of up to ?limit objects at a time, after the ?start_after key
+// With ?metadata=full, the content type and user metadata of every object are included, which takes a stat of each of them
+func handleListObjects(storage Storage) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			bucket := mux.Vars(r)["bucket"]
+			if err := validateBucket(bucket); err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+
+			metadata := r.URL.Query().Get("metadata")
+			if metadata != "" && metadata != "full" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("metadata must be full"))
+				return
+			}
+
+			limit := maxListLimit
+			if value := r.URL.Query().Get("limit"); value != "" {
+				var err error
+				if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxListLimit {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("limit must be an integer between 1 and " + strconv.Itoa(maxListLimit)))
+					return
+				}
+			}
+
+			listing, err := storage.ListObjects(r.Context(), bucket, gateway.ListOptions{
+				Prefix:     r.URL.Query().Get("prefix"),
+				StartAfter: r.URL.Query().Get("start_after"),
+				Limit:      limit,
+			})
+			if err != nil {
+				log.Error("list error", "error", err)
+				if writeNotFound(w, err) {
+					return
+				}
+
+				writeInternalError(w, r, err)
+				return
+			}
+
+			if metadata == "full" {
+				listing = storage.EnrichListing(r.Context(), bucket, listing)
+			}
+
+			if listing.Partial() {
+				w.Header().Set("X-Partial-Results", "true")
+			}
+			encode(w, http.StatusOK, listObjectsResponse{
+				Objects:          listing.Objects,
+				Partial:          listing.Partial(),
+				SkippedInstances: listing.SkippedInstances,
+				Truncated:        listing.Truncated,
+				NextStartAfter:   listing.NextStartAfter,
+			})
+		},
+	)
+}
+
+func handleHeadBucket(storage Storage) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			bucket := mux.Vars(r)["bucket"]
+			if err := validateBucket(bucket); err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+
+			exists, err := storage.BucketExists(r.Context(), bucket)
+			if err != nil {
+				log.Error("bucket exists error", "error", err)
+				writeInternalError(w, r, err)
+				return
+			}
+
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+}
+
+type listBucketsResponse struct {
+	Buckets []gateway.BucketSummary `json:"buckets"`
+	// Partial tells the listing may be missing the buckets of the skipped instances
+	Partial          bool     `json:"partial"`
+	SkippedInstances []string `json:"skipped_instances,omitempty"`
+}
+
+func handleListBuckets(storage Storage) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			listing, err := storage.ListBuckets(r.Context())
+			if err != nil {
+				log.Error("list buckets error", "error", err)
+				writeInternalError(w, r, err)
+				return
+			}
+
+			if listing.Partial() {
+				w.Header().Set("X-Partial-Results", "true")
+			}
+			encode(w, http.StatusOK, listBucketsResponse{
+				Buckets:          listing.Buckets,
+				Partial:          listing.Partial(),
+				SkippedInstances: listing.SkippedInstances,
+			})
+		},
+	)
+}
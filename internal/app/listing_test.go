@@ -0,0 +1,136 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+)
+
+func TestListObjectsLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   int
+		opts   gateway.ListOptions
+	}{
+		{name: "default limit", target: "/bucket", want: http.StatusOK, opts: gateway.ListOptions{Limit: maxListLimit}},
+		{name: "page", target: "/bucket?prefix=logs/&limit=10&start_after=logs/a", want: http.StatusOK,
+			opts: gateway.ListOptions{Prefix: "logs/", StartAfter: "logs/a", Limit: 10}},
+		{name: "zero limit", target: "/bucket?limit=0", want: http.StatusBadRequest},
+		{name: "limit over the maximum", target: "/bucket?limit=1001", want: http.StatusBadRequest},
+		{name: "invalid limit", target: "/bucket?limit=all", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			w := serve(t, testConfig(), storage, http.MethodGet, tt.target, "")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if storage.listed != tt.opts {
+				t.Errorf("listed with %+v, want %+v", storage.listed, tt.opts)
+			}
+		})
+	}
+}
+
+func TestListObjectsPartial(t *testing.T) {
+	tests := []struct {
+		name    string
+		skipped []string
+	}{
+		{name: "complete"},
+		{name: "partial", skipped: []string{"10.0.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.listing = gateway.Listing{Objects: []gateway.ObjectSummary{{Key: "a", Size: 4}}, SkippedInstances: tt.skipped}
+
+			w := serve(t, testConfig(), storage, http.MethodGet, "/bucket", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			partial, header := len(tt.skipped) > 0, ""
+			if partial {
+				header = "true"
+			}
+			if got := w.Header().Get("X-Partial-Results"); got != header {
+				t.Errorf("X-Partial-Results = %q, want %q", got, header)
+			}
+
+			var resp listObjectsResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode the listing: %v", err)
+			}
+			if resp.Partial != partial || !slices.Equal(resp.SkippedInstances, tt.skipped) || len(resp.Objects) != 1 {
+				t.Errorf("listing = %+v, want the objects of the healthy instances flagged partial: %v", resp, partial)
+			}
+		})
+	}
+}
+
+func TestListObjectsMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   int
+		owner  string // The owner expected in the listed metadata
+	}{
+		{name: "without metadata", target: "/bucket", want: http.StatusOK},
+		{name: "full metadata", target: "/bucket?metadata=full", want: http.StatusOK, owner: "alice"},
+		{name: "invalid metadata", target: "/bucket?metadata=some", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.put("bucket", "a", gateway.Object{Data: []byte("data"), ContentType: "text/plain", UserMetadata: map[string]string{"Owner": "alice"}})
+			storage.listing = gateway.Listing{Objects: []gateway.ObjectSummary{{Key: "a", Size: 4}}}
+
+			w := serve(t, testConfig(), storage, http.MethodGet, tt.target, "")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var resp listObjectsResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode the listing: %v", err)
+			}
+			if len(resp.Objects) != 1 || resp.Objects[0].UserMetadata["Owner"] != tt.owner {
+				t.Errorf("objects = %+v, want owner %q", resp.Objects, tt.owner)
+			}
+		})
+	}
+}
+
+func TestHeadBucket(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		err    error
+		want   int
+	}{
+		{name: "existing", target: "/bucket", want: http.StatusOK},
+		{name: "missing", target: "/missing", want: http.StatusNotFound},
+		{name: "invalid", target: "/a", want: http.StatusBadRequest},
+		{name: "no instance checked", target: "/bucket", err: errors.New("connection refused"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.put("bucket", "id", gateway.Object{Data: []byte("data")})
+			storage.err = tt.err
+
+			if w := serve(t, testConfig(), storage, http.MethodHead, tt.target, ""); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	log "log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// maintenanceRetryAfter is suggested to the clients whose writes are rejected during maintenance
+const maintenanceRetryAfter = 30
+
+// maintenanceMode rejects the writes while the backends are under maintenance, the reads are still served
+type maintenanceMode struct {
+	enabled atomic.Bool
+}
+
+// rejectWrites responds with 503 and a Retry-After header instead of calling next while the maintenance mode is on
+func (m *maintenanceMode) rejectWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if m.enabled.Load() {
+				w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("writes are disabled during maintenance"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
+type maintenanceResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+func handleGetMaintenance(maintenance *maintenanceMode) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			encode(w, http.StatusOK, maintenanceResponse{Enabled: maintenance.enabled.Load()})
+		},
+	)
+}
+
+func handleSetMaintenance(maintenance *maintenanceMode, enabled bool) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if maintenance.enabled.Swap(enabled) != enabled {
+				log.Warn("Maintenance mode changed", "enabled", enabled, "client_ip", clientIP(r))
+			}
+			encode(w, http.StatusOK, maintenanceResponse{Enabled: enabled})
+		},
+	)
+}
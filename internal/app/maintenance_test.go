@@ -0,0 +1,73 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	cfg := testConfig()
+	cfg.AdminToken = "secret"
+	storage := newFakeStorage()
+	handler := NewServer(cfg, storage, nil, nil, nil)
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(adminTokenHeader, cfg.AdminToken)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	maintenance := func() bool {
+		t.Helper()
+		var resp maintenanceResponse
+		if err := json.NewDecoder(send(http.MethodGet, "/admin/maintenance", "").Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode the maintenance mode: %v", err)
+		}
+		return resp.Enabled
+	}
+
+	if w := send(http.MethodPut, "/bucket/id", "data"); w.Code != http.StatusCreated {
+		t.Fatalf("PUT status = %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	if w := send(http.MethodPost, "/admin/maintenance/enable", ""); w.Code != http.StatusOK || !maintenance() {
+		t.Fatalf("enable status = %d, want the maintenance mode on", w.Code)
+	}
+	for _, write := range []struct{ method, target string }{
+		{http.MethodPut, "/bucket/id"},
+		{http.MethodPatch, "/bucket/id"},
+		{http.MethodPost, "/bucket/id?moveTo=other"},
+		{http.MethodDelete, "/bucket/id"},
+	} {
+		w := send(write.method, write.target, "data")
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "30" {
+			t.Errorf("%s %s = %d with Retry-After %q, want %d with Retry-After 30", write.method, write.target, w.Code, w.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+		}
+	}
+	// The reads are still served
+	if w := send(http.MethodGet, "/bucket/id", ""); w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Errorf("GET = %d %q during maintenance, want the object", w.Code, w.Body)
+	}
+	if w := send(http.MethodHead, "/bucket/id", ""); w.Code != http.StatusOK {
+		t.Errorf("HEAD = %d during maintenance, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := send(http.MethodPost, "/admin/maintenance/disable", ""); w.Code != http.StatusOK || maintenance() {
+		t.Fatalf("disable status = %d, want the maintenance mode off", w.Code)
+	}
+	if w := send(http.MethodPut, "/bucket/id", "new data"); w.Code != http.StatusOK {
+		t.Errorf("PUT status = %d after the maintenance, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestMaintenanceModeAtStartup(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaintenanceMode = true
+	if w := serve(t, cfg, newFakeStorage(), http.MethodPut, "/bucket/id", "data"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("PUT status = %d, want %d with the maintenance mode configured", w.Code, http.StatusServiceUnavailable)
+	}
+}
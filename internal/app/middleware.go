@@ -0,0 +1,252 @@
+package app
+
+import (
+	"context"
+	"errors"
+	log "log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+	"github.com/dariusigna/object-storage/internal/metrics"
+)
+
+// allowedMethods are the methods implemented by the gateway routes
+var allowedMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPut,
+	http.MethodPost,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// allowMethods rejects the methods that aren't implemented by any route, such as TRACE or CONNECT, with a 405
+// The rejection doesn't depend on the router, so it doesn't reveal which routes exist
+// OPTIONS is answered here with the allowed methods, ahead of the authentication a CORS preflight carries no credentials for
+func allowMethods(methods []string, next http.Handler) http.Handler {
+	allowed := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		allowed[method] = struct{}{}
+	}
+	allow := strings.Join(append(methods[:len(methods):len(methods)], http.MethodOptions), ", ")
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Allow", allow)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if _, ok := allowed[r.Method]; !ok {
+				w.Header().Set("Allow", allow)
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
+// limitPathLength rejects the requests whose escaped path is longer than maxLength with a 414, before they are routed
+// A long bucket name with an encoded id can otherwise reach the backends with a URL they reject in odd ways
+func limitPathLength(maxLength int, next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if length := len(r.URL.EscapedPath()); length > maxLength {
+				log.Error("path too long", "client_ip", clientIP(r), "length", length, "max_length", maxLength)
+				w.WriteHeader(http.StatusRequestURITooLong)
+				w.Write([]byte("request path is too long"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
+// InFlight counts the requests being served, so the shutdown can report how many it drained
+type InFlight struct {
+	count atomic.Int64
+}
+
+// Count returns the number of requests being served
+func (f *InFlight) Count() int64 {
+	return f.count.Load()
+}
+
+// Track counts the requests served by next while they are in flight
+func (f *InFlight) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			f.count.Add(1)
+			defer f.count.Add(-1)
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
+// ShutdownSummary tells how many of the requests in flight a shutdown drained
+type ShutdownSummary struct {
+	// InFlight is the number of requests in flight when the shutdown started
+	InFlight int64
+	// Drained is the number of requests completed during the shutdown
+	Drained int64
+	// Abandoned is the number of requests still in flight when the shutdown returned
+	Abandoned int64
+	// TimeoutHit tells whether the shutdown gave up waiting on the requests
+	TimeoutHit bool
+}
+
+// Shutdown shuts the server down, waiting for the tracked requests until ctx is done, and summarizes how it went
+func (f *InFlight) Shutdown(ctx context.Context, server *http.Server) (ShutdownSummary, error) {
+	summary := ShutdownSummary{InFlight: f.Count()}
+	err := server.Shutdown(ctx)
+	summary.Abandoned = f.Count()
+	summary.Drained = max(summary.InFlight-summary.Abandoned, 0)
+	summary.TimeoutHit = errors.Is(err, context.DeadlineExceeded)
+
+	return summary, err
+}
+
+// withRetryCount reports the retries the gateway needed to serve the request in the X-Retry-Count header
+// It surfaces the flaky backends to the clients and the monitoring, so it is meant for debugging
+func withRetryCount(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			counter := &gateway.RetryCounter{}
+			w = &retryCountWriter{ResponseWriter: w, counter: counter}
+			next.ServeHTTP(w, r.WithContext(gateway.WithRetryCounter(r.Context(), counter)))
+		},
+	)
+}
+
+// retryCountWriter sets the X-Retry-Count header when the response header is written
+type retryCountWriter struct {
+	http.ResponseWriter
+	counter     *gateway.RetryCounter
+	wroteHeader bool
+}
+
+func (w *retryCountWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("X-Retry-Count", strconv.FormatInt(w.counter.Count(), 10))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *retryCountWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (w *retryCountWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// pathSet returns the set of the paths
+func pathSet(paths []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		set[path] = struct{}{}
+	}
+	return set
+}
+
+// withRequestMetrics counts the requests and their duration, except the ones to the unmonitored paths
+func withRequestMetrics(unmonitoredPaths []string, next http.Handler) http.Handler {
+	unmonitored := pathSet(unmonitoredPaths)
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := unmonitored[r.URL.Path]; ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			metrics.Requests.WithLabelValues(r.Method, strconv.Itoa(recorder.status)).Inc()
+			metrics.RequestDuration.WithLabelValues(r.Method).Observe(time.Since(start).Seconds())
+		},
+	)
+}
+
+// withSlowRequestLog logs a warning with the request details when it is served in longer than threshold
+// It points at the slow objects and instances without scraping the metrics
+// The requests to the unmonitored paths, such as the load balancer health checks, are never logged
+func withSlowRequestLog(threshold time.Duration, unmonitoredPaths []string, next http.Handler) http.Handler {
+	unmonitored := pathSet(unmonitoredPaths)
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := unmonitored[r.URL.Path]; ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			if elapsed := time.Since(start); elapsed > threshold {
+				log.Warn("Slow request",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"status", recorder.status,
+					"duration", elapsed,
+					"threshold", threshold,
+					"client_ip", clientIP(r),
+				)
+			}
+		},
+	)
+}
+
+// statusRecorder records the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type errorDetailsKey struct{}
+
+// withErrorDetails makes the failed requests respond with the details of their error, including the backend ones
+// It leaks the backend internals to the clients, so it is meant for debugging only
+func withErrorDetails(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), errorDetailsKey{}, true)))
+		},
+	)
+}
+
+// errorDetails reports whether the request responds with the details of its error
+func errorDetails(r *http.Request) bool {
+	enabled, _ := r.Context().Value(errorDetailsKey{}).(bool)
+	return enabled
+}
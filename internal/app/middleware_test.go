@@ -0,0 +1,201 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	log "log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+)
+
+func TestAllowMethods(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		want   int
+	}{
+		{method: http.MethodTrace, target: "/bucket/id", want: http.StatusMethodNotAllowed},
+		{method: http.MethodConnect, target: "/bucket/id", want: http.StatusMethodNotAllowed},
+		// A CORS preflight is answered with the allowed methods, whatever the route
+		{method: http.MethodOptions, target: "/bucket/id", want: http.StatusNoContent},
+		{method: http.MethodOptions, target: "*", want: http.StatusNoContent},
+		{method: "PROPFIND", target: "/bucket", want: http.StatusMethodNotAllowed},
+		// The health checks are answered behind the method check too
+		{method: http.MethodTrace, target: "/healthz", want: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, target: "/bucket/id", want: http.StatusNotFound},
+		{method: http.MethodHead, target: "/bucket/id", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			w := serve(t, testConfig(), newFakeStorage(), tt.method, tt.target, "")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+
+			allow := w.Header().Get("Allow")
+			answered := tt.want == http.StatusMethodNotAllowed || tt.method == http.MethodOptions
+			if answered && allow != "GET, HEAD, PUT, POST, PATCH, DELETE, OPTIONS" {
+				t.Errorf("Allow = %q, want the implemented methods", allow)
+			}
+			if !answered && allow != "" {
+				t.Errorf("Allow = %q on an allowed method, want none", allow)
+			}
+		})
+	}
+}
+
+func TestLimitPathLength(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxPathLength = 32
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{name: "within the limit", target: "/bucket/abc123", want: http.StatusCreated},
+		{name: "over-long bucket", target: "/" + strings.Repeat("b", 32) + "/abc123", want: http.StatusRequestURITooLong},
+		// The escaped path is measured, which is the one forwarded to the backends
+		{name: "over-long once escaped", target: "/bucket/" + strings.Repeat("%20", 10), want: http.StatusRequestURITooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &countingStorage{fakeStorage: newFakeStorage()}
+			if w := serve(t, cfg, storage, http.MethodPut, tt.target, "data"); w.Code != tt.want {
+				t.Fatalf("PUT %s = %d, want %d: %s", tt.target, w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusRequestURITooLong && storage.writes.Load() != 0 {
+				t.Error("an over-long path reached the storage")
+			}
+		})
+	}
+}
+
+func TestRetryCountHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		want    []string
+	}{
+		{name: "enabled", enabled: true, want: []string{"0"}},
+		{name: "disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.RetryCountHeader = tt.enabled
+			storage := newFakeStorage()
+			storage.put("bucket", "id", gateway.Object{Data: []byte("data")})
+
+			// The header is set on the error responses too
+			for _, target := range []string{"/bucket/id", "/bucket/missing"} {
+				w := serve(t, cfg, storage, http.MethodGet, target, "")
+				if got := w.Header().Values("X-Retry-Count"); !slices.Equal(got, tt.want) {
+					t.Errorf("GET %s X-Retry-Count = %q, want %q", target, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// slowStorage delays the reads of the fake storage
+type slowStorage struct {
+	*fakeStorage
+	delay time.Duration
+}
+
+func (s slowStorage) GetObject(ctx context.Context, bucket, id string) (gateway.Object, error) {
+	time.Sleep(s.delay)
+	return s.fakeStorage.GetObject(ctx, bucket, id)
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	tests := []struct {
+		name  string
+		delay time.Duration
+		slow  bool
+	}{
+		{name: "fast"},
+		{name: "slow", delay: 100 * time.Millisecond, slow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defer log.SetDefault(log.Default())
+			log.SetDefault(log.New(log.NewTextHandler(&logs, nil)))
+
+			cfg := testConfig()
+			cfg.SlowRequestThreshold = 50 * time.Millisecond
+			storage := slowStorage{fakeStorage: newFakeStorage(), delay: tt.delay}
+			storage.put("bucket", "id", gateway.Object{Data: []byte("data")})
+			if w := serve(t, cfg, storage, http.MethodGet, "/bucket/id", ""); w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			logged := strings.Contains(logs.String(), `msg="Slow request"`)
+			if logged != tt.slow {
+				t.Fatalf("slow request logged: %v, want %v: %s", logged, tt.slow, &logs)
+			}
+			if tt.slow && (!strings.Contains(logs.String(), "path=/bucket/id") || !strings.Contains(logs.String(), "status=200")) {
+				t.Errorf("slow request warning = %s, want the path and the status", &logs)
+			}
+		})
+	}
+}
+
+func TestInFlightShutdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		release bool // Whether the requests complete during the shutdown
+		want    ShutdownSummary
+	}{
+		{name: "drained", release: true, want: ShutdownSummary{InFlight: 2, Drained: 2}},
+		{name: "timeout hit", want: ShutdownSummary{InFlight: 2, Abandoned: 2, TimeoutHit: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			inFlight := &InFlight{}
+			server := &http.Server{Handler: inFlight.Track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+			}))}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go server.Serve(listener)
+			t.Cleanup(func() { server.Close() })
+
+			for range 2 {
+				go http.Get("http://" + listener.Addr().String())
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for inFlight.Count() < 2 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			timeout := 5 * time.Second
+			if tt.release {
+				time.AfterFunc(50*time.Millisecond, func() { close(release) })
+			} else {
+				timeout = 50 * time.Millisecond
+				defer close(release)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			summary, err := inFlight.Shutdown(ctx, server)
+			if (err != nil) != tt.want.TimeoutHit {
+				t.Errorf("Shutdown() error = %v, want an error %v", err, tt.want.TimeoutHit)
+			}
+			if summary != tt.want {
+				t.Errorf("Shutdown() = %+v, want %+v", summary, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	log "log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// clientIP returns the client address resolved by withClientIP, or the direct peer address
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+
+	return peerIP(r).String()
+}
+
+// withClientIP resolves the real client address of the requests, for the logs and any per-client limit
+// X-Forwarded-For and X-Real-IP are only trusted when the direct peer is one of the trusted proxies,
+// otherwise any client could spoof its address
+func withClientIP(trustedProxies []netip.Prefix, next http.Handler) http.Handler {
+	isTrusted := func(ip netip.Addr) bool {
+		for _, prefix := range trustedProxies {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ip := peerIP(r)
+			if ip.IsValid() && isTrusted(ip) {
+				ip = forwardedIP(r, ip, isTrusted)
+			}
+
+			log.Debug("request", "method", r.Method, "path", r.URL.Path, "client_ip", ip.String())
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip.String())))
+		},
+	)
+}
+
+// forwardedIP returns the client address forwarded by the trusted proxy at peer
+// The X-Forwarded-For chain is walked from the right, so the first untrusted hop is the client,
+// which the hops on its left can't forge
+func forwardedIP(r *http.Request, peer netip.Addr, isTrusted func(netip.Addr) bool) netip.Addr {
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break // A malformed hop can't be trusted, so the last valid one is kept
+			}
+
+			client = hop.Unmap()
+			if !isTrusted(client) {
+				break
+			}
+		}
+		return client
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap()
+	}
+
+	return peer
+}
+
+func peerIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return ip.Unmap()
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestWithClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string // The X-Forwarded-For headers
+		realIP    string
+		want      string
+	}{
+		{name: "direct client", peer: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "untrusted peer forwarding", peer: "203.0.113.7:1234", forwarded: []string{"198.51.100.1"}, realIP: "198.51.100.2", want: "203.0.113.7"},
+		{name: "trusted proxy", peer: "10.0.0.1:1234", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted proxy chain", peer: "10.0.0.1:1234", forwarded: []string{"198.51.100.1, 10.0.0.2"}, want: "198.51.100.1"},
+		{name: "spoofed hop left of the client", peer: "10.0.0.1:1234", forwarded: []string{"192.0.2.1, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "headers joined", peer: "10.0.0.1:1234", forwarded: []string{"192.0.2.1", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "malformed hop", peer: "10.0.0.1:1234", forwarded: []string{"unknown, 10.0.0.2"}, want: "10.0.0.2"},
+		{name: "real ip header", peer: "10.0.0.1:1234", realIP: "198.51.100.2", want: "198.51.100.2"},
+		{name: "trusted proxy without headers", peer: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "ipv4-mapped peer", peer: "[::ffff:10.0.0.1]:1234", realIP: "198.51.100.2", want: "198.51.100.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := withClientIP(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for _, forwarded := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,61 +2,339 @@ package app
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	log "log/slog"
+	"math"
+	"mime"
 	"net/http"
-	"regexp"
+	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/dariusigna/object-storage/internal/audit"
+	"github.com/dariusigna/object-storage/internal/config"
 	"github.com/dariusigna/object-storage/internal/gateway"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Storage is an interface for the object storage
 type Storage interface {
-	GetObject(ctx context.Context, bucket, id string) ([]byte, error)
-	PutObject(ctx context.Context, bucket, id string, object []byte) error
+	GetObject(ctx context.Context, bucket, id string) (gateway.Object, error)
+	ObjectExists(ctx context.Context, bucket, id string) (bool, error)
+	StatObject(ctx context.Context, bucket, id string) (gateway.ObjectInfo, error)
+	ListObjects(ctx context.Context, bucket string, opts gateway.ListOptions) (gateway.Listing, error)
+	EnrichListing(ctx context.Context, bucket string, listing gateway.Listing) gateway.Listing
+	ListBuckets(ctx context.Context) (gateway.BucketListing, error)
+	ListIncompleteUploads(ctx context.Context) (gateway.UploadListing, error)
+	AbortUpload(ctx context.Context, instance, bucket, key, uploadID string) error
+	BucketExists(ctx context.Context, bucket string) (bool, error)
+	PutObject(ctx context.Context, bucket, id string, body gateway.Body, opts gateway.PutOptions) (gateway.PutResult, error)
+	UpdateObjectMetadata(ctx context.Context, bucket, id string, metadata map[string]string) error
+	MoveObject(ctx context.Context, src, dst, id string) error
+	DeleteObject(ctx context.Context, bucket, id string) error
+	RestoreObject(ctx context.Context, bucket, id string) error
 }
 
+// userMetadataPrefix is the prefix of the headers carrying the user metadata of an object
+const userMetadataPrefix = "X-Amz-Meta-"
+
 // NewServer creates a new HTTP server for the object storage gateway
+// The overwrites are recorded by auditLogger when it is not nil
 func NewServer(
+	cfg config.Config,
 	storage Storage,
+	registry Registry,
+	registrar Registrar,
+	auditLogger *audit.Logger,
 ) http.Handler {
-	r := mux.NewRouter()
+	// The routes match the encoded path, so an encoded slash doesn't split an id, which parseID decodes once
+	r := mux.NewRouter().UseEncodedPath()
 	addRoutes(
 		r,
+		cfg,
 		storage,
+		registry,
+		registrar,
+		auditLogger,
 	)
 	var handler http.Handler = r
+	if cfg.RetryCountHeader {
+		handler = withRetryCount(handler)
+	}
+	if cfg.ErrorDetails {
+		log.Warn("The backend error details are returned to the clients, which leaks the backend internals")
+		handler = withErrorDetails(handler)
+	}
+	if cfg.SlowRequestThreshold > 0 {
+		handler = withSlowRequestLog(cfg.SlowRequestThreshold, cfg.UnmonitoredPaths, handler)
+	}
+	if cfg.AuthFailOpen && cfg.AuthURL != "" {
+		log.Warn("The requests are let through when the auth backend can't be reached")
+	}
+	if cfg.AdminToken == "" {
+		log.Info("The admin endpoints are disabled without an admin token")
+	}
+	handler = newAuthenticator(cfg.AuthURL, cfg.AuthFailOpen).authenticate(handler)
+	// The health checks are answered ahead of the authentication, since the load balancers carry no credentials
+	handler = withHealthChecks(registry, handler)
+	handler = allowMethods(allowedMethods, handler)
+	handler = limitPathLength(cfg.MaxPathLength, handler)
+	handler = withRequestMetrics(cfg.UnmonitoredPaths, handler)
+	handler = withClientIP(cfg.TrustedProxies, handler)
 	return handler
 }
 
 func addRoutes(
 	mux *mux.Router,
+	cfg config.Config,
 	storage Storage,
+	registry Registry,
+	registrar Registrar,
+	auditLogger *audit.Logger,
 ) {
-	mux.Handle("/{bucket}/{id}", handleGetObject(storage)).Methods(http.MethodGet)
-	mux.Handle("/{bucket}/{id}", handlePutObject(storage)).Methods(http.MethodPut)
+	maintenance := &maintenanceMode{}
+	maintenance.enabled.Store(cfg.MaintenanceMode)
+	idempotency := newIdempotencyCache(cfg.IdempotencyTTL, cfg.IdempotencyCacheSize)
+
+	// Admin routes are registered first, so they are not shadowed by the object routes, and require the admin token
+	admin := mux.PathPrefix("/admin").Subrouter()
+	admin.Use(requireAdminToken(cfg.AdminToken))
+	admin.Handle("/ring", handleGetRing(registry)).Methods(http.MethodGet)
+	admin.Handle("/ring/hashing", handleGetHashing(registry)).Methods(http.MethodGet)
+	admin.Handle("/ring/rebuild", handleRebuildRing(registry)).Methods(http.MethodPost)
+	admin.Handle("/ring/check", handleCheckRing(registry)).Methods(http.MethodPost)
+	admin.Handle("/ring/migration", handleGetMigration(registry)).Methods(http.MethodGet)
+	admin.Handle("/ring/migration/finish", handleFinishMigration(registry)).Methods(http.MethodPost)
+	admin.Handle("/instances", handleListInstances(registry)).Methods(http.MethodGet)
+	admin.Handle("/instances/{name}/drain", handleDrainInstance(registry, true)).Methods(http.MethodPost)
+	admin.Handle("/instances/{name}/undrain", handleDrainInstance(registry, false)).Methods(http.MethodPost)
+	admin.Handle("/registrar", handleGetCordon(registrar)).Methods(http.MethodGet)
+	admin.Handle("/registrar/cordon", handleSetCordon(registrar, true)).Methods(http.MethodPost)
+	admin.Handle("/registrar/uncordon", handleSetCordon(registrar, false)).Methods(http.MethodPost)
+	admin.Handle("/validation", handleGetValidation(cfg.FoldCase)).Methods(http.MethodGet)
+	admin.Handle("/maintenance", handleGetMaintenance(maintenance)).Methods(http.MethodGet)
+	admin.Handle("/maintenance/enable", handleSetMaintenance(maintenance, true)).Methods(http.MethodPost)
+	admin.Handle("/maintenance/disable", handleSetMaintenance(maintenance, false)).Methods(http.MethodPost)
+	admin.Handle("/uploads", handleListUploads(storage)).Methods(http.MethodGet)
+	admin.Handle("/uploads", handleAbortUpload(storage)).Methods(http.MethodDelete)
+	mux.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	mux.Handle("/version", handleVersion()).Methods(http.MethodGet)
+	mux.Handle("/", handleListBuckets(storage)).Methods(http.MethodGet)
+	mux.Handle("/{bucket}", handleListObjects(storage)).Methods(http.MethodGet)
+	mux.Handle("/{bucket}", handleHeadBucket(storage)).Methods(http.MethodHead)
+	mux.Handle("/{bucket}/{id}", handleGetObject(storage, cfg.SniffContentType, cfg.DefaultCacheControl)).Methods(http.MethodGet)
+	mux.Handle("/{bucket}/{id}", handleHeadObject(storage, cfg.DefaultCacheControl)).Methods(http.MethodHead)
+	mux.Handle("/{bucket}/{id}", maintenance.rejectWrites(idempotency.dedupe(handlePutObject(storage, cfg.MaxObjectSize, cfg.SpillThreshold, cfg.StrictHeaders, auditLogger)))).Methods(http.MethodPut)
+	mux.Handle("/{bucket}/{id}", maintenance.rejectWrites(handlePatchObject(storage))).Methods(http.MethodPatch)
+	mux.Handle("/{bucket}/{id}", maintenance.rejectWrites(handleMoveObject(storage))).Methods(http.MethodPost).Queries("moveTo", "{moveTo}")
+	mux.Handle("/{bucket}/{id}", maintenance.rejectWrites(handleRestoreObject(storage))).Methods(http.MethodPost).Queries("restore", "")
+	mux.Handle("/{bucket}/{id}", maintenance.rejectWrites(handleDeleteObject(storage))).Methods(http.MethodDelete)
+}
+
+// defaultContentType is the content type of the objects stored without one
+const defaultContentType = "application/octet-stream"
+
+// handleGetObject serves the object bytes, or its metadata as JSON with the metadata parameter
+// With sniff, the objects stored without a content type are served with the one detected from their first bytes
+// The objects stored without a Cache-Control header are served with defaultCacheControl, unless it is empty
+func handleGetObject(storage Storage, sniff bool, defaultCacheControl string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			bucket, id, err := parseObjectVars(mux.Vars(r))
+			if err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+
+			// Downloads are served as attachments, so browsers save them instead of displaying them
+			download := false
+			if value := r.URL.Query().Get("download"); value != "" {
+				if download, err = strconv.ParseBool(value); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("invalid download parameter"))
+					return
+				}
+			}
+
+			// Clients without HEAD support get the metadata as JSON instead of the object bytes
+			metadata := false
+			if value := r.URL.Query().Get("metadata"); value != "" {
+				if metadata, err = strconv.ParseBool(value); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("invalid metadata parameter"))
+					return
+				}
+			}
+
+			if metadata {
+				info, err := storage.StatObject(r.Context(), bucket, id)
+				if err != nil {
+					log.Error("stat error", "error", err)
+					writeReadError(w, r, err)
+					return
+				}
+
+				encode(w, http.StatusOK, info)
+				return
+			}
+
+			object, err := storage.GetObject(r.Context(), bucket, id)
+			if err != nil {
+				log.Error("get error", "error", err)
+				writeReadError(w, r, err)
+				return
+			}
+
+			for name, values := range object.Headers {
+				w.Header()[name] = values
+			}
+			// The Cache-Control stored with the object overrides the default one
+			if defaultCacheControl != "" && w.Header().Get("Cache-Control") == "" {
+				w.Header().Set("Cache-Control", defaultCacheControl)
+			}
+			contentType := object.ContentType
+			if sniff && (contentType == "" || contentType == defaultContentType) {
+				contentType = http.DetectContentType(object.Data)
+			}
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			if download {
+				w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": id}))
+			}
+			w.WriteHeader(http.StatusOK)
+			// The status is already sent, so a failed write, e.g. a client gone mid-response, can only be logged
+			if n, err := w.Write(object.Data); err != nil {
+				log.Error("write error", "client_ip", clientIP(r), "bucket", bucket, "id", id, "written", n, "size", len(object.Data), "error", err)
+			}
+		},
+	)
+}
+
+// handleHeadObject serves the headers of the object without its bytes, as GET would, and its user metadata
+// The metadata is read with a stat of the object, so the object isn't fetched
+func handleHeadObject(storage Storage, defaultCacheControl string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			bucket, id, err := parseObjectVars(mux.Vars(r))
+			if err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+
+			info, err := storage.StatObject(r.Context(), bucket, id)
+			if err != nil {
+				log.Error("stat error", "error", err)
+				writeReadError(w, r, err)
+				return
+			}
+
+			for name, values := range info.Headers {
+				w.Header()[name] = values
+			}
+			if defaultCacheControl != "" && w.Header().Get("Cache-Control") == "" {
+				w.Header().Set("Cache-Control", defaultCacheControl)
+			}
+			if info.ContentType != "" {
+				w.Header().Set("Content-Type", info.ContentType)
+			}
+			for name, value := range info.UserMetadata {
+				w.Header().Set(userMetadataPrefix+name, value)
+			}
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+			w.Header().Set("ETag", strconv.Quote(info.ETag))
+			w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusOK)
+		},
+	)
 }
 
-func handleGetObject(storage Storage) http.Handler {
+// writeReadError writes the status of a failed object read
+func writeReadError(w http.ResponseWriter, r *http.Request, err error) {
+	if writeDeleted(w, err) || writeNotFound(w, err) {
+		return
+	}
+
+	var truncatedErr gateway.TruncatedError
+	if errors.As(err, &truncatedErr) {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	if writeRegionMismatch(w, err) || writeSlowDown(w, err) {
+		return
+	}
+
+	writeInternalError(w, r, err)
+}
+
+func handlePutObject(storage Storage, maxObjectSize, spillThreshold int64, strictHeaders bool, auditLogger *audit.Logger) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			id := mux.Vars(r)["id"]
-			if err := validateID(id); err != nil {
-				log.Error("validation error", err)
+			bucket, id, err := parseObjectVars(mux.Vars(r))
+			if err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+
+			headers, err := parseStoredHeaders(r.Header, strictHeaders)
+			if err != nil {
+				log.Error("header error", "error", err)
 				w.WriteHeader(http.StatusBadRequest)
 				w.Write([]byte(err.Error()))
 				return
 			}
 
-			bucket := mux.Vars(r)["bucket"]
-			object, err := storage.GetObject(r.Context(), bucket, id)
+			log.Debug("put object", "bucket", bucket, "id", id)
+			// A request without a body, which the server never delivers but a caller may, stores an empty object
+			if r.Body == nil {
+				r.Body = http.NoBody
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxObjectSize)
+			contentType := r.Header.Get("Content-Type")
+			var src io.Reader = r.Body
+
+			// A multipart form upload stores its file part, with the file name and content type of the part
+			file, filename, fileContentType, err := multipartFile(r)
 			if err != nil {
-				log.Error("get error", err)
-				if errors.Is(err, gateway.NotFoundError{}) {
-					w.WriteHeader(http.StatusNotFound)
+				log.Error("multipart form error", "error", err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			if file != nil {
+				src, contentType = file, fileContentType
+				if headers.Get("Content-Disposition") == "" {
+					headers.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+				}
+			}
+
+			body, err := spoolBody(src, spillThreshold)
+			// The declared length is the one of the whole form for a multipart upload, so only a plain body is checked
+			if file == nil {
+				var size int64
+				if err == nil {
+					size = body.Size()
+				}
+				if err = checkContentLength(r, size, err); err != nil && body != nil {
+					body.Close()
+				}
+			}
+			if err != nil {
+				log.Error("read error", "error", err)
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					w.WriteHeader(http.StatusRequestEntityTooLarge)
+					return
+				}
+
+				var lengthErr contentLengthError
+				if errors.As(err, &lengthErr) {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(lengthErr.Error()))
 					return
 				}
 
@@ -64,36 +342,223 @@ func handleGetObject(storage Storage) http.Handler {
 				return
 			}
 
+			opts := gateway.PutOptions{SizeHint: body.Size(), ContentType: contentType, Headers: headers}
+			if hint := r.Header.Get("X-Object-Size-Hint"); hint != "" {
+				if opts.SizeHint, err = strconv.ParseInt(hint, 10, 64); err != nil || opts.SizeHint < 0 {
+					body.Close()
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("invalid X-Object-Size-Hint header"))
+					return
+				}
+			}
+
+			// The status code tells a created object from an overwritten one, a failed check doesn't block the write
+			exists, previous, err := currentVersion(r.Context(), storage, bucket, id, auditLogger != nil)
+			checked := err == nil
+			if !checked {
+				log.Warn("Failed to check whether the object exists", "bucket", bucket, "id", id, "error", err)
+			}
+
+			// The storage owns the body from here, and closes it once it is written
+			result, err := storage.PutObject(r.Context(), bucket, id, body, opts)
+			if err != nil {
+				log.Error("put error", "error", err)
+				if writeRegionMismatch(w, err) || writeSlowDown(w, err) || writeInsufficientStorage(w, err) || writeInsufficientReplicas(w, err) || writeDraining(w, err) {
+					return
+				}
+
+				writeInternalError(w, r, err)
+				return
+			}
+
+			if !exists {
+				// An object that may have existed is reported as created, its audit event leaves the replaced version unknown
+				if !checked {
+					auditLogger.Log(r.Context(), audit.Event{
+						Action:     "write",
+						Bucket:     bucket,
+						ID:         id,
+						ClientIP:   clientIP(r),
+						OldUnknown: true,
+						New:        &audit.Version{ETag: result.ETag, Size: result.Size},
+					})
+				}
+				w.Header().Set("Location", "/"+url.PathEscape(bucket)+"/"+url.PathEscape(id))
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+
+			auditLogger.Log(r.Context(), audit.Event{
+				Action:   "overwrite",
+				Bucket:   bucket,
+				ID:       id,
+				ClientIP: clientIP(r),
+				Old:      previous,
+				New:      &audit.Version{ETag: result.ETag, Size: result.Size},
+			})
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+}
+
+// currentVersion reports whether the object exists, with its version when withVersion is set
+// The version takes a stat of the object, which costs the same round trip as the existence check
+func currentVersion(ctx context.Context, storage Storage, bucket, id string, withVersion bool) (bool, *audit.Version, error) {
+	if !withVersion {
+		exists, err := storage.ObjectExists(ctx, bucket, id)
+		return exists, nil, err
+	}
+
+	info, err := storage.StatObject(ctx, bucket, id)
+	var bucketNotFoundErr gateway.BucketNotFoundError
+	switch {
+	case errors.Is(err, gateway.NotFoundError{}) || errors.As(err, &bucketNotFoundErr):
+		return false, nil, nil
+	case err != nil:
+		return false, nil, err
+	case info.Bucket != bucket:
+		return false, nil, nil // Found in the fallback bucket, which is not overwritten
+	}
+
+	return true, &audit.Version{ETag: info.ETag, Size: info.Size}, nil
+}
+
+func handlePatchObject(storage Storage) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			bucket, id, err := parseObjectVars(mux.Vars(r))
+			if err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+
+			metadata := make(map[string]string)
+			for key, values := range r.Header {
+				if name, ok := strings.CutPrefix(key, userMetadataPrefix); ok && name != "" {
+					metadata[name] = values[0]
+				}
+			}
+			if len(metadata) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("no " + userMetadataPrefix + "* header provided"))
+				return
+			}
+
+			log.Debug("patch object", "bucket", bucket, "id", id)
+			err = storage.UpdateObjectMetadata(r.Context(), bucket, id, metadata)
+			if err != nil {
+				log.Error("patch error", "error", err)
+				if writeDeleted(w, err) || writeNotFound(w, err) {
+					return
+				}
+
+				if writeRegionMismatch(w, err) || writeSlowDown(w, err) {
+					return
+				}
+
+				writeInternalError(w, r, err)
+				return
+			}
+
 			w.WriteHeader(http.StatusOK)
-			w.Write(object)
 		},
 	)
 }
 
-func handlePutObject(storage Storage) http.Handler {
+func handleMoveObject(storage Storage) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			id := mux.Vars(r)["id"]
-			if err := validateID(id); err != nil {
-				log.Error("validation error", err)
+			bucket, id, err := parseObjectVars(mux.Vars(r))
+			if err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+
+			dst := mux.Vars(r)["moveTo"]
+			if err = validateBucket(dst); err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+			if dst == bucket {
 				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte(err.Error()))
+				w.Write([]byte("the destination bucket is the source bucket"))
 				return
 			}
 
-			bucket := mux.Vars(r)["bucket"]
-			log.Debug("put object", "bucket", bucket, "id", id)
-			object, err := io.ReadAll(r.Body)
+			log.Debug("move object", "bucket", bucket, "destination_bucket", dst, "id", id)
+			err = storage.MoveObject(r.Context(), bucket, dst, id)
 			if err != nil {
-				log.Error("read error", err)
-				w.WriteHeader(http.StatusInternalServerError)
+				log.Error("move error", "error", err)
+				if writeNotFound(w, err) {
+					return
+				}
+
+				var crossInstanceErr gateway.CrossInstanceMoveError
+				if errors.As(err, &crossInstanceErr) {
+					w.WriteHeader(http.StatusConflict)
+					w.Write([]byte(crossInstanceErr.Error()))
+					return
+				}
+
+				if writeRegionMismatch(w, err) || writeSlowDown(w, err) {
+					return
+				}
+
+				writeInternalError(w, r, err)
 				return
 			}
 
-			err = storage.PutObject(r.Context(), bucket, id, object)
+			w.Header().Set("Location", "/"+url.PathEscape(dst)+"/"+url.PathEscape(id))
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+}
+
+func handleDeleteObject(storage Storage) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			bucket, id, err := parseObjectVars(mux.Vars(r))
 			if err != nil {
-				log.Error("put error", err)
-				w.WriteHeader(http.StatusInternalServerError)
+				writeValidationError(w, r, err)
+				return
+			}
+
+			log.Debug("delete object", "bucket", bucket, "id", id)
+			err = storage.DeleteObject(r.Context(), bucket, id)
+			if err != nil {
+				log.Error("delete error", "error", err)
+				if writeNotFound(w, err) || writeRegionMismatch(w, err) || writeSlowDown(w, err) {
+					return
+				}
+
+				writeInternalError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		},
+	)
+}
+
+// handleRestoreObject restores a soft deleted object, it responds with 404 if the object isn't deleted
+func handleRestoreObject(storage Storage) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			bucket, id, err := parseObjectVars(mux.Vars(r))
+			if err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+
+			log.Debug("restore object", "bucket", bucket, "id", id)
+			err = storage.RestoreObject(r.Context(), bucket, id)
+			if err != nil {
+				log.Error("restore error", "error", err)
+				if writeNotFound(w, err) || writeRegionMismatch(w, err) || writeSlowDown(w, err) {
+					return
+				}
+
+				writeInternalError(w, r, err)
 				return
 			}
 
@@ -102,15 +567,117 @@ func handlePutObject(storage Storage) http.Handler {
 	)
 }
 
-func validateID(id string) error {
-	if len(id) > 32 {
-		return fmt.Errorf("id is too long")
+// writeDeleted responds with 410 if err is a gateway.DeletedError, the object can still be restored
+func writeDeleted(w http.ResponseWriter, err error) bool {
+	var deletedErr gateway.DeletedError
+	if !errors.As(err, &deletedErr) {
+		return false
 	}
 
-	isAlphanumeric := regexp.MustCompile(`^[a-zA-Z0-9]+$`).MatchString
-	if !isAlphanumeric(id) {
-		return fmt.Errorf("id contains invalid characters")
+	w.WriteHeader(http.StatusGone)
+	w.Write([]byte(deletedErr.Error()))
+	return true
+}
+
+// writeNotFound responds with 404 if err is a gateway.NotFoundError or a gateway.BucketNotFoundError
+// The message tells the client whether the bucket or only the object is missing
+func writeNotFound(w http.ResponseWriter, err error) bool {
+	var bucketErr gateway.BucketNotFoundError
+	if !errors.Is(err, gateway.NotFoundError{}) && !errors.As(err, &bucketErr) {
+		return false
 	}
 
-	return nil
+	w.WriteHeader(http.StatusNotFound)
+	if bucketErr.Bucket != "" {
+		w.Write([]byte(bucketErr.Error()))
+		return true
+	}
+
+	w.Write([]byte(gateway.NotFoundError{}.Error()))
+	return true
+}
+
+// writeRegionMismatch responds with 502 and the regions involved if err is a gateway.RegionMismatchError
+func writeRegionMismatch(w http.ResponseWriter, err error) bool {
+	var mismatchErr gateway.RegionMismatchError
+	if !errors.As(err, &mismatchErr) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write([]byte(mismatchErr.Error()))
+	return true
+}
+
+// writeSlowDown responds with 503 and a Retry-After header if err is a gateway.SlowDownError
+func writeSlowDown(w http.ResponseWriter, err error) bool {
+	var slowDownErr gateway.SlowDownError
+	if !errors.As(err, &slowDownErr) {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(slowDownErr.RetryAfter.Seconds()))))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	return true
+}
+
+// writeInsufficientStorage responds with 507 if err is a gateway.InsufficientStorageError
+func writeInsufficientStorage(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, gateway.InsufficientStorageError{}) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusInsufficientStorage)
+	w.Write([]byte(gateway.InsufficientStorageError{}.Error()))
+	return true
+}
+
+func writeInsufficientReplicas(w http.ResponseWriter, err error) bool {
+	var replicasErr gateway.InsufficientReplicasError
+	if !errors.As(err, &replicasErr) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(replicasErr.Error()))
+	return true
+}
+
+func writeDraining(w http.ResponseWriter, err error) bool {
+	var drainingErr gateway.DrainingError
+	if !errors.As(err, &drainingErr) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(drainingErr.Error()))
+	return true
+}
+
+// errorResponse details a failed request, it is only returned with the error details enabled
+type errorResponse struct {
+	Error   string                `json:"error"`
+	Backend *gateway.BackendError `json:"backend,omitempty"`
+}
+
+// writeInternalError responds with 500, with the error and the minio error behind it when the error details are enabled
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	if !errorDetails(r) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := errorResponse{Error: err.Error()}
+	if backendErr, ok := gateway.AsBackendError(err); ok {
+		response.Backend = &backendErr
+	}
+	encode(w, http.StatusInternalServerError, response)
+}
+
+func encode[T any](w http.ResponseWriter, status int, v T) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("encode error", "error", err)
+	}
 }
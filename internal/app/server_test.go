@@ -0,0 +1,760 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/audit"
+	"github.com/dariusigna/object-storage/internal/gateway"
+	"github.com/minio/minio-go/v7"
+)
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   int
+		header http.Header // The headers expected in the response
+		body   string      // A substring of the expected body
+	}{
+		{
+			name:   "slow down",
+			err:    fmt.Errorf("%w: %w", gateway.SlowDownError{RetryAfter: 1500 * time.Millisecond}, fmt.Errorf("SlowDown")),
+			want:   http.StatusServiceUnavailable,
+			header: http.Header{"Retry-After": {"2"}},
+		},
+		{name: "backend error", err: fmt.Errorf("connection refused"), want: http.StatusInternalServerError},
+		{name: "missing object", err: gateway.NotFoundError{}, want: http.StatusNotFound, body: gateway.NotFoundError{}.Error()},
+		{name: "missing bucket", err: gateway.BucketNotFoundError{Bucket: "bucket"}, want: http.StatusNotFound, body: "bucket bucket not found"},
+		{name: "deleted object", err: gateway.DeletedError{DeletedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}, want: http.StatusGone, body: "object was deleted at 2024-01-02T03:04:05Z"},
+		{
+			name: "region mismatch",
+			err:  fmt.Errorf("%w: %w", gateway.RegionMismatchError{Bucket: "bucket", Region: "eu-west-1"}, fmt.Errorf("AuthorizationHeaderMalformed")),
+			want: http.StatusBadGateway,
+			body: "bucket bucket is in region eu-west-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.err = tt.err
+			w := serve(t, testConfig(), storage, http.MethodGet, "/bucket/id", "")
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			for name := range tt.header {
+				if got := w.Header().Get(name); got != tt.header.Get(name) {
+					t.Errorf("%s = %q, want %q", name, got, tt.header.Get(name))
+				}
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("body = %q, want it to contain %q", w.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	backendErr := minio.ErrorResponse{Code: "XMinioServerNotInitialized", Message: "Server not initialized", StatusCode: http.StatusServiceUnavailable}
+	tests := []struct {
+		name    string
+		enabled bool
+		err     error
+		want    *errorResponse // The body expected, none for an empty body
+	}{
+		{name: "disabled", err: fmt.Errorf("failed to get object: %w", backendErr)},
+		{
+			name:    "backend error",
+			enabled: true,
+			err:     fmt.Errorf("failed to get object: %w", backendErr),
+			want: &errorResponse{
+				Error:   "failed to get object: Server not initialized",
+				Backend: &gateway.BackendError{Code: "XMinioServerNotInitialized", Message: "Server not initialized", StatusCode: http.StatusServiceUnavailable},
+			},
+		},
+		{name: "gateway error", enabled: true, err: errors.New("connection refused"), want: &errorResponse{Error: "connection refused"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.ErrorDetails = tt.enabled
+			storage := newFakeStorage()
+			storage.err = tt.err
+			w := serve(t, cfg, storage, http.MethodGet, "/bucket/id", "")
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+			}
+
+			if tt.want == nil {
+				if w.Body.Len() != 0 {
+					t.Errorf("body = %q, want the error details hidden", w.Body)
+				}
+				return
+			}
+			var got errorResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode the error: %v", err)
+			}
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("error = %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchObjectThenHead(t *testing.T) {
+	storage := newFakeStorage()
+	storage.put("bucket", "id", gateway.Object{
+		Data:         []byte("data"),
+		ContentType:  "text/plain",
+		Headers:      http.Header{"Cache-Control": {"no-cache"}},
+		UserMetadata: map[string]string{"A": "1", "B": "2"},
+	})
+	handler := NewServer(testConfig(), storage, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPatch, "/bucket/id", nil)
+	req.Header.Set("X-Amz-Meta-B", "")
+	req.Header.Set("X-Amz-Meta-C", "3")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH status = %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/bucket/id", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("HEAD status = %d: %s", w.Code, w.Body)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD body = %q, want none", w.Body)
+	}
+
+	want := http.Header{
+		"X-Amz-Meta-A":   {"1"},
+		"X-Amz-Meta-C":   {"3"},
+		"Content-Type":   {"text/plain"},
+		"Content-Length": {"4"},
+		"Cache-Control":  {"no-cache"},
+	}
+	for name, values := range want {
+		if got := w.Header().Get(name); got != values[0] {
+			t.Errorf("%s = %q, want %q", name, got, values[0])
+		}
+	}
+	if got := w.Header().Values("X-Amz-Meta-B"); len(got) != 0 {
+		t.Errorf("X-Amz-Meta-B = %q, want the removed key missing", got)
+	}
+}
+
+func TestHeadObjectNotFound(t *testing.T) {
+	w := serve(t, testConfig(), newFakeStorage(), http.MethodHead, "/bucket/missing", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestPutObjectSizeHint(t *testing.T) {
+	tests := []struct {
+		name string
+		hint string
+		want int
+		size int64 // The size hint expected in the write
+	}{
+		{name: "body length", want: http.StatusCreated, size: 4},
+		{name: "hint", hint: "1048576", want: http.StatusCreated, size: 1 << 20},
+		{name: "negative hint", hint: "-1", want: http.StatusBadRequest},
+		{name: "invalid hint", hint: "large", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			req := httptest.NewRequest(http.MethodPut, "/bucket/id", strings.NewReader("data"))
+			if tt.hint != "" {
+				req.Header.Set("X-Object-Size-Hint", tt.hint)
+			}
+			w := httptest.NewRecorder()
+			NewServer(testConfig(), storage, nil, nil, nil).ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if storage.written.SizeHint != tt.size {
+				t.Errorf("SizeHint = %d, want %d", storage.written.SizeHint, tt.size)
+			}
+		})
+	}
+}
+
+func TestPutObjectAuditsOverwrites(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		checkErr error // The error of the existence check
+		status   int
+		want     string // The audit event expected, none for a created object
+	}{
+		{
+			name:   "overwrite",
+			id:     "id",
+			status: http.StatusOK,
+			want:   `{"action":"overwrite","bucket":"bucket","id":"id","client_ip":"203.0.113.7","old":{"etag":"etag","size":4},"new":{"etag":"etag","size":8}}`,
+		},
+		{name: "created", id: "new", status: http.StatusCreated},
+		{
+			name:     "failed check",
+			id:       "id",
+			checkErr: errors.New("stat failed"),
+			status:   http.StatusCreated,
+			want:     `{"action":"write","bucket":"bucket","id":"id","client_ip":"203.0.113.7","old":"unknown","new":{"etag":"etag","size":8}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.put("bucket", "id", gateway.Object{Data: []byte("data")})
+			storage.err = tt.checkErr
+			var events bytes.Buffer
+			handler := NewServer(testConfig(), storage, nil, nil, audit.NewLogger(&events))
+
+			req := httptest.NewRequest(http.MethodPut, "/bucket/"+tt.id, strings.NewReader("new data"))
+			req.RemoteAddr = "203.0.113.7:1234"
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.want == "" {
+				if events.Len() != 0 {
+					t.Errorf("audit events = %s, want none for a created object", events.String())
+				}
+				return
+			}
+
+			var event map[string]any
+			if err := json.Unmarshal(events.Bytes(), &event); err != nil {
+				t.Fatalf("failed to decode the audit event %q: %v", events.String(), err)
+			}
+			delete(event, "time")
+			delete(event, "level")
+			delete(event, "msg")
+			var want map[string]any
+			json.Unmarshal([]byte(tt.want), &want)
+			if !reflect.DeepEqual(event, want) {
+				t.Errorf("audit event = %v, want %v", event, want)
+			}
+		})
+	}
+}
+
+func TestPutObjectStoredHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		header http.Header
+		want   int
+	}{
+		{name: "stored headers", header: http.Header{"Cache-Control": {"no-cache"}, "Content-Language": {"fr"}}, want: http.StatusCreated},
+		{name: "unknown header ignored", header: http.Header{"X-Amz-Storage-Class": {"GLACIER"}}, want: http.StatusCreated},
+		{name: "unknown header in strict mode", strict: true, header: http.Header{"X-Amz-Storage-Class": {"GLACIER"}}, want: http.StatusBadRequest},
+		{name: "user metadata in strict mode", strict: true, header: http.Header{"X-Amz-Meta-Owner": {"alice"}}, want: http.StatusCreated},
+		{name: "invalid expires", header: http.Header{"Expires": {"tomorrow"}}, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.StrictHeaders = tt.strict
+			storage := newFakeStorage()
+			handler := NewServer(cfg, storage, nil, nil, nil)
+
+			req := httptest.NewRequest(http.MethodPut, "/bucket/id", strings.NewReader("data"))
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusCreated {
+				return
+			}
+
+			// Only the allow-listed headers are stored and returned by the reads
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bucket/id", nil))
+			for name, values := range tt.header {
+				want := values[0]
+				if !slices.Contains(gateway.StoredHeaders, name) {
+					want = ""
+				}
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("GET %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPutObjectSpillsAnUnknownLengthBody(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	cfg := testConfig()
+	cfg.SpillThreshold = 16
+	storage := newFakeStorage()
+
+	data := strings.Repeat("0123456789", 10)
+	req := httptest.NewRequest(http.MethodPut, "/bucket/id", io.MultiReader(strings.NewReader(data)))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	NewServer(cfg, storage, nil, nil, nil).ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if object := storage.objects["bucket/id"]; string(object.Data) != data {
+		t.Errorf("stored %d bytes, want the %d bytes of the body", len(object.Data), len(data))
+	}
+	if storage.written.SizeHint != int64(len(data)) {
+		t.Errorf("SizeHint = %d, want the size of the spilled body %d", storage.written.SizeHint, len(data))
+	}
+	if files := tempFiles(t); len(files) != 0 {
+		t.Errorf("temporary files = %v, want the buffer file removed after the write", files)
+	}
+}
+
+func TestPutObjectMultipartForm(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        bool
+		disposition string // The Content-Disposition of the request
+		want        int
+		wantHeader  string // The Content-Disposition expected in the write
+	}{
+		{name: "file part", file: true, want: http.StatusCreated, wantHeader: `attachment; filename=report.csv`},
+		{name: "request disposition kept", file: true, disposition: "inline", want: http.StatusCreated, wantHeader: "inline"},
+		{name: "no file part", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var form bytes.Buffer
+			mw := multipart.NewWriter(&form)
+			mw.WriteField("description", "skipped")
+			if tt.file {
+				header := make(textproto.MIMEHeader)
+				header.Set("Content-Disposition", `form-data; name="file"; filename="report.csv"`)
+				header.Set("Content-Type", "text/csv")
+				part, _ := mw.CreatePart(header)
+				part.Write([]byte("a,b\n1,2\n"))
+			}
+			mw.Close()
+
+			storage := newFakeStorage()
+			req := httptest.NewRequest(http.MethodPut, "/bucket/id", &form)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			if tt.disposition != "" {
+				req.Header.Set("Content-Disposition", tt.disposition)
+			}
+			w := httptest.NewRecorder()
+			NewServer(testConfig(), storage, nil, nil, nil).ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if !tt.file {
+				return
+			}
+
+			object, err := storage.GetObject(req.Context(), "bucket", "id")
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			if string(object.Data) != "a,b\n1,2\n" || object.ContentType != "text/csv" {
+				t.Errorf("stored %q of type %q, want the file part", object.Data, object.ContentType)
+			}
+			if got := object.Headers.Get("Content-Disposition"); got != tt.wantHeader {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestPutObjectWithoutABody(t *testing.T) {
+	tests := []struct {
+		name string
+		body io.ReadCloser
+	}{
+		{name: "nil body"},
+		{name: "empty body", body: http.NoBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			req := httptest.NewRequest(http.MethodPut, "/bucket/id", nil)
+			req.Body, req.ContentLength = tt.body, 0
+			w := httptest.NewRecorder()
+			NewServer(testConfig(), storage, nil, nil, nil).ServeHTTP(w, req)
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+			}
+			object, err := storage.GetObject(req.Context(), "bucket", "id")
+			if err != nil || len(object.Data) != 0 {
+				t.Errorf("GetObject() = %q, %v, want a zero-byte object", object.Data, err)
+			}
+		})
+	}
+}
+
+func TestPutObjectContentLength(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          io.Reader
+		contentLength int64
+		want          int
+		message       string // A substring of the expected body
+	}{
+		{name: "matching", body: strings.NewReader("data"), contentLength: 4, want: http.StatusCreated},
+		{name: "unknown length", body: strings.NewReader("data"), contentLength: -1, want: http.StatusCreated},
+		{
+			name: "under-length body", body: strings.NewReader("data"), contentLength: 8, want: http.StatusBadRequest,
+			message: "body length 4 does not match the declared Content-Length 8",
+		},
+		{
+			name: "over-length body", body: strings.NewReader("data"), contentLength: 2, want: http.StatusBadRequest,
+			message: "body length 4 does not match the declared Content-Length 2",
+		},
+		{
+			name: "body cut short", body: io.MultiReader(strings.NewReader("da"), iotest.ErrReader(io.ErrUnexpectedEOF)), contentLength: 4,
+			want: http.StatusBadRequest, message: "body is shorter than the declared Content-Length 4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			req := httptest.NewRequest(http.MethodPut, "/bucket/id", tt.body)
+			req.ContentLength = tt.contentLength
+			w := httptest.NewRecorder()
+			NewServer(testConfig(), storage, nil, nil, nil).ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.message) {
+				t.Errorf("body = %q, want it to contain %q", w.Body, tt.message)
+			}
+			if exists, _ := storage.ObjectExists(req.Context(), "bucket", "id"); exists != (tt.want == http.StatusCreated) {
+				t.Errorf("object stored: %v, want it stored only when the lengths match", exists)
+			}
+		})
+	}
+}
+
+func TestPutObjectErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{
+			name: "storage full",
+			err:  fmt.Errorf("failed to put object: %w: %w", gateway.InsufficientStorageError{}, fmt.Errorf("XMinioStorageFull")),
+			want: http.StatusInsufficientStorage,
+		},
+		{name: "slow down", err: gateway.SlowDownError{RetryAfter: time.Second}, want: http.StatusServiceUnavailable},
+		{name: "insufficient replicas", err: gateway.InsufficientReplicasError{Factor: 3, Available: 1}, want: http.StatusServiceUnavailable},
+		{name: "draining owner", err: gateway.DrainingError{Instance: "10.0.0.1"}, want: http.StatusServiceUnavailable},
+		{name: "backend error", err: fmt.Errorf("connection refused"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.writeErr = tt.err
+			w := serve(t, testConfig(), storage, http.MethodPut, "/bucket/id", "data")
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestPutObjectCreatedOrOverwritten(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool
+		err      error // The error of the existence check
+		want     int
+		location string
+	}{
+		{name: "created", want: http.StatusCreated, location: "/bucket/id"},
+		{name: "overwritten", existing: true, want: http.StatusOK},
+		{name: "failed existence check", err: fmt.Errorf("connection refused"), want: http.StatusCreated, location: "/bucket/id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			if tt.existing {
+				storage.put("bucket", "id", gateway.Object{Data: []byte("old")})
+			}
+			storage.err = tt.err
+
+			w := serve(t, testConfig(), storage, http.MethodPut, "/bucket/id", "new")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+			if object := storage.objects["bucket/id"]; string(object.Data) != "new" {
+				t.Errorf("stored %q, want %q", object.Data, "new")
+			}
+		})
+	}
+}
+
+func TestGetObjectDownload(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		want        int
+		disposition string
+	}{
+		{name: "inline by default", target: "/bucket/report", want: http.StatusOK},
+		{name: "download", target: "/bucket/report?download=1", want: http.StatusOK, disposition: `attachment; filename=report`},
+		{name: "download disabled", target: "/bucket/report?download=false", want: http.StatusOK},
+		{name: "invalid download", target: "/bucket/report?download=maybe", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.put("bucket", "report", gateway.Object{Data: []byte("data")})
+
+			w := serve(t, testConfig(), storage, http.MethodGet, tt.target, "")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.disposition)
+			}
+		})
+	}
+}
+
+func TestGetObjectCacheControl(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults string // The configured default Cache-Control
+		stored   string // The Cache-Control stored with the object
+		want     string
+	}{
+		{name: "none"},
+		{name: "default", defaults: "public, max-age=3600", want: "public, max-age=3600"},
+		{name: "stored overrides the default", defaults: "public, max-age=3600", stored: "no-store", want: "no-store"},
+		{name: "stored without a default", stored: "private", want: "private"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.DefaultCacheControl = tt.defaults
+			storage := newFakeStorage()
+			object := gateway.Object{Data: []byte("data"), Headers: http.Header{}}
+			if tt.stored != "" {
+				object.Headers.Set("Cache-Control", tt.stored)
+			}
+			storage.put("bucket", "id", object)
+
+			w := serve(t, cfg, storage, http.MethodGet, "/bucket/id", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// failingWriter is a ResponseWriter whose body writes fail, as when the client is gone mid-response
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (f failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestGetObjectWriteError(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetDefault(log.Default())
+	log.SetDefault(log.New(log.NewTextHandler(&logs, nil)))
+
+	storage := newFakeStorage()
+	storage.put("bucket", "id", gateway.Object{Data: []byte("data")})
+	req := httptest.NewRequest(http.MethodGet, "/bucket/id", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	NewServer(testConfig(), storage, nil, nil, nil).ServeHTTP(failingWriter{httptest.NewRecorder()}, req)
+
+	for _, want := range []string{"write error", "client_ip=203.0.113.7", "bucket=bucket", "id=id", "written=0", "size=4", "broken pipe"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs = %q, want them to contain %q", logs.String(), want)
+		}
+	}
+}
+
+func TestGetObjectMetadata(t *testing.T) {
+	storage := newFakeStorage()
+	storage.put("bucket", "id", gateway.Object{
+		Data:         []byte("data"),
+		ContentType:  "text/plain",
+		Headers:      http.Header{"Cache-Control": {"no-cache"}},
+		UserMetadata: map[string]string{"Owner": "alice"},
+	})
+
+	w := serve(t, testConfig(), storage, http.MethodGet, "/bucket/id?metadata=1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want the JSON metadata", contentType)
+	}
+
+	var got map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode the metadata: %v", err)
+	}
+	want := map[string]any{
+		"bucket":        "bucket",
+		"id":            "id",
+		"size":          float64(4),
+		"content_type":  "text/plain",
+		"etag":          "etag",
+		"last_modified": "0001-01-01T00:00:00Z",
+		"headers":       map[string]any{"Cache-Control": []any{"no-cache"}},
+		"user_metadata": map[string]any{"Owner": "alice"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata = %v, want %v", got, want)
+	}
+
+	if w = serve(t, testConfig(), storage, http.MethodGet, "/bucket/id?metadata=maybe", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d for an invalid metadata parameter, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteObject(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		err    error
+		want   int
+	}{
+		{name: "deleted", target: "/bucket/id", want: http.StatusNoContent},
+		{name: "missing object", target: "/bucket/missing", want: http.StatusNotFound},
+		{name: "invalid id", target: "/bucket/" + strings.Repeat("a", 300), want: http.StatusBadRequest},
+		{name: "backend error", target: "/bucket/id", err: fmt.Errorf("connection refused"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.put("bucket", "id", gateway.Object{Data: []byte("data")})
+			storage.err = tt.err
+
+			w := serve(t, testConfig(), storage, http.MethodDelete, tt.target, "")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusNoContent {
+				return
+			}
+
+			storage.err = nil
+			if w = serve(t, testConfig(), storage, http.MethodGet, "/bucket/id", ""); w.Code != http.StatusNotFound {
+				t.Errorf("GET after delete = %d, want %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
+
+func TestMoveObject(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		err    error
+		want   int
+	}{
+		{name: "moved", target: "/bucket/id?moveTo=other", want: http.StatusOK},
+		{name: "same bucket", target: "/bucket/id?moveTo=bucket", want: http.StatusBadRequest},
+		{name: "invalid destination", target: "/bucket/id?moveTo=a", want: http.StatusBadRequest},
+		{name: "missing object", target: "/bucket/missing?moveTo=other", want: http.StatusNotFound},
+		{name: "cross-instance move", target: "/bucket/id?moveTo=other", err: gateway.CrossInstanceMoveError{KeyStrategy: gateway.BucketKey}, want: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.put("bucket", "id", gateway.Object{Data: []byte("data")})
+			storage.err = tt.err
+
+			w := serve(t, testConfig(), storage, http.MethodPost, tt.target, "")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			if location := w.Header().Get("Location"); location != "/other/id" {
+				t.Errorf("Location = %q, want %q", location, "/other/id")
+			}
+			if w = serve(t, testConfig(), storage, http.MethodGet, "/other/id", ""); w.Code != http.StatusOK || w.Body.String() != "data" {
+				t.Errorf("GET of the destination = %d %q, want the moved object", w.Code, w.Body)
+			}
+			if w = serve(t, testConfig(), storage, http.MethodGet, "/bucket/id", ""); w.Code != http.StatusNotFound {
+				t.Errorf("GET of the source = %d, want %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
+
+func TestGetObjectSniffsTheContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name        string
+		sniff       bool
+		contentType string // The content type the object was stored with
+		want        string
+	}{
+		{name: "sniffed", sniff: true, want: "image/png"},
+		{name: "sniffed over the default", sniff: true, contentType: "application/octet-stream", want: "image/png"},
+		{name: "stored content type kept", sniff: true, contentType: "application/x-custom", want: "application/x-custom"},
+		{name: "sniffing disabled", contentType: "application/octet-stream", want: "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.SniffContentType = tt.sniff
+			storage := newFakeStorage()
+			storage.put("bucket", "id", gateway.Object{Data: png, ContentType: tt.contentType})
+
+			w := serve(t, cfg, storage, http.MethodGet, "/bucket/id", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("Content-Type = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"errors"
+	log "log/slog"
+	"net/http"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+)
+
+type listUploadsResponse struct {
+	Uploads []gateway.IncompleteUpload `json:"uploads"`
+	// Partial tells the listing may be missing the uploads of the skipped instances
+	Partial          bool     `json:"partial"`
+	SkippedInstances []string `json:"skipped_instances,omitempty"`
+}
+
+// handleListUploads lists the multipart uploads left in progress on the instances, to find the stale ones wasting space
+func handleListUploads(storage Storage) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			listing, err := storage.ListIncompleteUploads(r.Context())
+			if err != nil {
+				log.Error("list uploads error", "error", err)
+				writeInternalError(w, r, err)
+				return
+			}
+
+			if listing.Partial() {
+				w.Header().Set("X-Partial-Results", "true")
+			}
+			encode(w, http.StatusOK, listUploadsResponse{
+				Uploads:          listing.Uploads,
+				Partial:          listing.Partial(),
+				SkippedInstances: listing.SkippedInstances,
+			})
+		},
+	)
+}
+
+// handleAbortUpload aborts the multipart upload given by the instance, bucket, key and upload_id parameters
+func handleAbortUpload(storage Storage) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			instance, bucket, key, uploadID := query.Get("instance"), query.Get("bucket"), query.Get("key"), query.Get("upload_id")
+			if instance == "" || bucket == "" || key == "" || uploadID == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("instance, bucket, key and upload_id are required"))
+				return
+			}
+
+			log.Info("abort upload", "instance", instance, "bucket", bucket, "key", key, "upload_id", uploadID)
+			err := storage.AbortUpload(r.Context(), instance, bucket, key, uploadID)
+			if err != nil {
+				log.Error("abort upload error", "error", err)
+				var uploadErr gateway.UploadNotFoundError
+				var instanceErr gateway.InstanceNotFoundError
+				if errors.As(err, &uploadErr) || errors.As(err, &instanceErr) {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(err.Error()))
+					return
+				}
+
+				writeInternalError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		},
+	)
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+)
+
+func TestListUploads(t *testing.T) {
+	cfg := testConfig()
+	cfg.AdminToken = "secret"
+	initiated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	upload := gateway.IncompleteUpload{Instance: "10.0.0.1", Bucket: "bucket", Key: "key", UploadID: "upload", Initiated: initiated}
+
+	tests := []struct {
+		name    string
+		uploads gateway.UploadListing
+		err     error
+		want    int
+		partial string // The expected X-Partial-Results header
+	}{
+		{name: "uploads", uploads: gateway.UploadListing{Uploads: []gateway.IncompleteUpload{upload}}, want: http.StatusOK},
+		{name: "no uploads", want: http.StatusOK},
+		{
+			name:    "skipped instances",
+			uploads: gateway.UploadListing{Uploads: []gateway.IncompleteUpload{upload}, SkippedInstances: []string{"10.0.0.2"}},
+			want:    http.StatusOK,
+			partial: "true",
+		},
+		{name: "no instance listed", err: errors.New("no instances available"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.uploads, storage.err = tt.uploads, tt.err
+			req := httptest.NewRequest(http.MethodGet, "/admin/uploads", nil)
+			req.Header.Set(adminTokenHeader, cfg.AdminToken)
+			w := httptest.NewRecorder()
+			NewServer(cfg, storage, nil, nil, nil).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			if got := w.Header().Get("X-Partial-Results"); got != tt.partial {
+				t.Errorf("X-Partial-Results = %q, want %q", got, tt.partial)
+			}
+			var resp listUploadsResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode the uploads: %v", err)
+			}
+			if len(resp.Uploads) != len(tt.uploads.Uploads) || (len(resp.Uploads) > 0 && resp.Uploads[0] != upload) {
+				t.Errorf("uploads = %+v, want %+v", resp.Uploads, tt.uploads.Uploads)
+			}
+			if resp.Partial != (tt.partial == "true") || len(resp.SkippedInstances) != len(tt.uploads.SkippedInstances) {
+				t.Errorf("partial = %t with skipped instances %v, want %v", resp.Partial, resp.SkippedInstances, tt.uploads.SkippedInstances)
+			}
+		})
+	}
+}
+
+func TestAbortUpload(t *testing.T) {
+	cfg := testConfig()
+	cfg.AdminToken = "secret"
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{name: "in progress", target: "/admin/uploads?instance=10.0.0.1&bucket=bucket&key=key&upload_id=upload", want: http.StatusNoContent},
+		{name: "unknown upload", target: "/admin/uploads?instance=10.0.0.1&bucket=bucket&key=key&upload_id=other", want: http.StatusNotFound},
+		{name: "missing upload id", target: "/admin/uploads?instance=10.0.0.1&bucket=bucket&key=key", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.uploads.Uploads = []gateway.IncompleteUpload{{Instance: "10.0.0.1", Bucket: "bucket", Key: "key", UploadID: "upload"}}
+			req := httptest.NewRequest(http.MethodDelete, tt.target, nil)
+			req.Header.Set(adminTokenHeader, cfg.AdminToken)
+			w := httptest.NewRecorder()
+			NewServer(cfg, storage, nil, nil, nil).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("DELETE %s = %d, want %d: %s", tt.target, w.Code, tt.want, w.Body)
+			}
+			if aborted := len(storage.uploads.Uploads) == 0; aborted != (tt.want == http.StatusNoContent) {
+				t.Errorf("upload aborted = %t, want %t", aborted, tt.want == http.StatusNoContent)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"errors"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+	"github.com/dariusigna/object-storage/internal/metrics"
+)
+
+// validationError is returned for a request rejected by the validation, with the reason it is counted under
+type validationError struct {
+	reason  string
+	message string
+}
+
+// Error returns the error message
+func (v validationError) Error() string {
+	return v.message
+}
+
+var (
+	// errEmptyID is returned for ids that are empty once decoded and trimmed
+	errEmptyID = validationError{reason: "empty", message: "id is empty"}
+	// errBadEncoding is returned for ids with malformed percent-encoding
+	errBadEncoding = validationError{reason: "bad_encoding", message: "id is not properly percent-encoded"}
+	// errIDTooLong is returned for ids longer than maxIDLength characters
+	errIDTooLong = validationError{reason: "too_long", message: "id is too long"}
+	// errInvalidChars is returned for ids with non alphanumeric characters
+	errInvalidChars = validationError{reason: "invalid_chars", message: "id contains invalid characters"}
+	// errBadBucket is returned for buckets that are not valid S3 bucket names
+	errBadBucket = validationError{reason: "bad_bucket", message: "bucket name must be 3 to 63 lowercase letters, digits, dots or hyphens"}
+	// errReservedBucket is returned for the buckets internal to the gateway or shadowed by its routes
+	errReservedBucket = validationError{reason: "reserved_bucket", message: "bucket name is reserved"}
+)
+
+// reservedBuckets are the buckets internal to the gateway, and the names of its own routes, which the clients can't read or write
+// A bucket named after a route would have its listing or objects served by the route instead
+var reservedBuckets = []string{gateway.ContentBucket, "admin", "metrics", "version", "healthz", "readyz"}
+
+// maxIDLength is the maximum length of an object id
+const maxIDLength = 32
+
+var (
+	idPattern     = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+)
+
+// parseObjectVars returns the validated bucket and id of the object route
+func parseObjectVars(vars map[string]string) (bucket, id string, err error) {
+	bucket = vars["bucket"]
+	if err = validateBucket(bucket); err != nil {
+		return "", "", err
+	}
+
+	id, err = parseID(vars["id"])
+	if err != nil {
+		return "", "", err
+	}
+
+	return bucket, id, nil
+}
+
+// parseID decodes and trims the id taken from the path, then validates it
+// The router matches the encoded path, so the id is percent-decoded here and only here: an encoded slash stays in the id,
+// where it is rejected, and a double-encoded id is left encoded once, which isn't a valid id either
+func parseID(raw string) (string, error) {
+	id, err := url.PathUnescape(raw)
+	if err != nil {
+		return "", errBadEncoding
+	}
+
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return "", errEmptyID
+	}
+
+	if err = validateID(id); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func validateID(id string) error {
+	if len(id) > maxIDLength {
+		return errIDTooLong
+	}
+
+	if !idPattern.MatchString(id) {
+		return errInvalidChars
+	}
+
+	return nil
+}
+
+// validateBucket checks the bucket against the S3 bucket naming rules the backends enforce
+func validateBucket(bucket string) error {
+	if !bucketPattern.MatchString(bucket) || strings.Contains(bucket, "..") {
+		return errBadBucket
+	}
+
+	if slices.Contains(reservedBuckets, bucket) {
+		return errReservedBucket
+	}
+
+	return nil
+}
+
+// writeValidationError rejects the request with a 400, and counts the rejection by its reason
+func writeValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Error("validation error", "client_ip", clientIP(r), "error", err)
+	reason := "other"
+	var validationErr validationError
+	if errors.As(err, &validationErr) {
+		reason = validationErr.reason
+	}
+
+	metrics.ValidationRejections.WithLabelValues(reason).Inc()
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(err.Error()))
+}
+
+type idRules struct {
+	MaxLength int    `json:"max_length"`
+	Pattern   string `json:"pattern"`
+	// FoldCase tells the ids are case-insensitive, they are lowercased
+	FoldCase bool `json:"fold_case"`
+}
+
+type bucketRules struct {
+	Pattern               string `json:"pattern"`
+	ConsecutiveDotsDenied bool   `json:"consecutive_dots_denied"`
+	// Reserved are the names internal to the gateway
+	Reserved []string `json:"reserved"`
+}
+
+type validationResponse struct {
+	ID     idRules     `json:"id"`
+	Bucket bucketRules `json:"bucket"`
+}
+
+// handleGetValidation reports the validation rules of the object ids and the bucket names, taken from the validators
+// The ids are percent-decoded and trimmed before they are validated
+func handleGetValidation(foldCase bool) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			encode(w, http.StatusOK, validationResponse{
+				ID:     idRules{MaxLength: maxIDLength, Pattern: idPattern.String(), FoldCase: foldCase},
+				Bucket: bucketRules{Pattern: bucketPattern.String(), ConsecutiveDotsDenied: true, Reserved: reservedBuckets},
+			})
+		},
+	)
+}
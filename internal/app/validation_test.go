@@ -0,0 +1,156 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+		err  error
+	}{
+		{name: "plain", raw: "abc123", want: "abc123"},
+		{name: "padded with whitespace", raw: " abc123\t", want: "abc123"},
+		{name: "whitespace only", raw: "   ", err: errEmptyID},
+		{name: "empty", raw: "", err: errEmptyID},
+		{name: "percent-encoded", raw: "abc%31", want: "abc1"},
+		{name: "encoded whitespace", raw: "%20abc123%20", want: "abc123"},
+		{name: "double-encoded", raw: "abc%2531", err: errInvalidChars},
+		{name: "encoded slash", raw: "abc%2Fdef", err: errInvalidChars},
+		{name: "invalid escape", raw: "abc%zz", err: errBadEncoding},
+		{name: "truncated escape", raw: "abc%3", err: errBadEncoding},
+		{name: "too long", raw: "a123456789012345678901234567890123", err: errIDTooLong},
+		{name: "invalid characters", raw: "abc-123", err: errInvalidChars},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseID(tt.raw)
+			if err != tt.err {
+				t.Fatalf("parseID(%q) error = %v, want %v", tt.raw, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("parseID(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetObjectDecodesTheIDOnce(t *testing.T) {
+	storage := newFakeStorage()
+	storage.put("bucket", "abc1", gateway.Object{Data: []byte("data")})
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{name: "encoded", target: "/bucket/abc%31", want: http.StatusOK},
+		{name: "encoded whitespace", target: "/bucket/%20abc1%20", want: http.StatusOK},
+		{name: "encoded whitespace only", target: "/bucket/%20%20", want: http.StatusBadRequest},
+		// A double-encoded id is decoded once, what's left isn't a valid id
+		{name: "double-encoded", target: "/bucket/abc%2531", want: http.StatusBadRequest},
+		// An encoded slash is part of the id, rather than splitting the path into another route
+		{name: "encoded slash", target: "/bucket/abc%2F1", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(t, testConfig(), storage, http.MethodGet, tt.target, ""); w.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d: %s", tt.target, w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestValidationRejectionsMetric(t *testing.T) {
+	tests := []struct {
+		target string
+		reason string
+	}{
+		{target: "/bucket/%20", reason: "empty"},
+		{target: "/bucket/a123456789012345678901234567890123", reason: "too_long"},
+		{target: "/bucket/abc-123", reason: "invalid_chars"},
+		{target: "/B/abc123", reason: "bad_bucket"},
+		{target: "/admin/abc123", reason: "reserved_bucket"},
+		{target: "/object-storage-content/abc123", reason: "reserved_bucket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			counter := metrics.ValidationRejections.WithLabelValues(tt.reason)
+			before := testutil.ToFloat64(counter)
+
+			if w := serve(t, testConfig(), newFakeStorage(), http.MethodGet, tt.target, ""); w.Code != http.StatusBadRequest {
+				t.Fatalf("GET %s = %d, want %d", tt.target, w.Code, http.StatusBadRequest)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("rejections counted under %s = %v, want 1", tt.reason, got)
+			}
+		})
+	}
+
+	// A valid request isn't counted
+	total := func() float64 {
+		var sum float64
+		for _, tt := range tests {
+			sum += testutil.ToFloat64(metrics.ValidationRejections.WithLabelValues(tt.reason))
+		}
+		return sum
+	}
+	before := total()
+	serve(t, testConfig(), newFakeStorage(), http.MethodGet, "/bucket/abc123", "")
+	if got := total() - before; got != 0 {
+		t.Errorf("%v rejections counted for a valid request, want none", got)
+	}
+}
+
+func TestGetValidation(t *testing.T) {
+	for _, foldCase := range []bool{false, true} {
+		t.Run(fmt.Sprint("fold case ", foldCase), func(t *testing.T) {
+			cfg := testConfig()
+			cfg.AdminToken, cfg.FoldCase = "secret", foldCase
+			req := httptest.NewRequest(http.MethodGet, "/admin/validation", nil)
+			req.Header.Set(adminTokenHeader, cfg.AdminToken)
+			w := httptest.NewRecorder()
+			NewServer(cfg, newFakeStorage(), nil, nil, nil).ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			var rules validationResponse
+			if err := json.NewDecoder(w.Body).Decode(&rules); err != nil {
+				t.Fatalf("failed to decode the rules: %v", err)
+			}
+			if rules.ID.FoldCase != foldCase || !rules.Bucket.ConsecutiveDotsDenied {
+				t.Errorf("rules = %+v, want fold case %v and the consecutive dots denied", rules, foldCase)
+			}
+
+			// The reported rules are the ones the validators apply
+			idPattern, bucketPattern := regexp.MustCompile(rules.ID.Pattern), regexp.MustCompile(rules.Bucket.Pattern)
+			longest := strings.Repeat("a", rules.ID.MaxLength)
+			if !idPattern.MatchString(longest) || validateID(longest) != nil || validateID(longest+"a") == nil {
+				t.Errorf("max length %d doesn't match the id validator", rules.ID.MaxLength)
+			}
+			for _, id := range []string{"abc123", "ABC", "a-b", "a b"} {
+				if matched, valid := idPattern.MatchString(id), validateID(id) == nil; matched != valid {
+					t.Errorf("id %q matched by the pattern: %v, valid: %v", id, matched, valid)
+				}
+			}
+			for _, bucket := range []string{"logs", "my.bucket", "Logs", "ab", "-logs"} {
+				if matched, valid := bucketPattern.MatchString(bucket), validateBucket(bucket) == nil; matched != valid {
+					t.Errorf("bucket %q matched by the pattern: %v, valid: %v", bucket, matched, valid)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"context"
+	"io"
+	log "log/slog"
+)
+
+// Version identifies a version of an object
+type Version struct {
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+// Event is a change of an object recorded for compliance
+type Event struct {
+	Action   string
+	Bucket   string
+	ID       string
+	ClientIP string
+	// Old is the replaced version, nil when there is none or it is unknown
+	Old *Version
+	// OldUnknown is set when whether a version was replaced is unknown
+	OldUnknown bool
+	// New is the version written
+	New *Version
+}
+
+// Logger writes the audit events as JSON lines, separately from the application logs
+// A nil Logger discards the events
+type Logger struct {
+	logger *log.Logger
+}
+
+// NewLogger returns a Logger writing to w
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{logger: log.New(log.NewJSONHandler(w, nil))}
+}
+
+// Log records the event
+func (l *Logger) Log(ctx context.Context, event Event) {
+	if l == nil {
+		return
+	}
+
+	attrs := []log.Attr{
+		log.String("action", event.Action),
+		log.String("bucket", event.Bucket),
+		log.String("id", event.ID),
+		log.String("client_ip", event.ClientIP),
+	}
+	switch {
+	case event.OldUnknown:
+		attrs = append(attrs, log.String("old", "unknown"))
+	case event.Old != nil:
+		attrs = append(attrs, log.Any("old", event.Old))
+	}
+	if event.New != nil {
+		attrs = append(attrs, log.Any("new", event.New))
+	}
+	l.logger.LogAttrs(ctx, log.LevelInfo, "audit", attrs...)
+}
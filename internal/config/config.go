@@ -0,0 +1,757 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvPrefix is the prefix of all the environment variables read by the gateway
+	EnvPrefix = "GATEWAY_"
+
+	maxTimeout           = time.Hour
+	maxReplicationFactor = 16
+)
+
+// Config holds all the settings of the object storage gateway
+type Config struct {
+	// Addr is the address the HTTP server listens on
+	Addr string
+	// ReadTimeout is the maximum duration for reading the entire request, including the body
+	// It must be positive, since no timeout would let slow clients hold the connections forever
+	ReadTimeout time.Duration
+	// WriteTimeout is the maximum duration before timing out writes of the response, it must be positive
+	WriteTimeout time.Duration
+	// IdleTimeout is the maximum amount of time to wait for the next request on keep-alive connections
+	// Zero uses the read timeout, as http.Server does
+	IdleTimeout time.Duration
+	// ShutdownTimeout is the maximum amount of time to wait for in-flight requests on shutdown
+	// It must be positive, since zero would abort the in-flight requests right away
+	ShutdownTimeout time.Duration
+	// MaxHeaderBytes is the maximum size in bytes of the request headers, including the request line with its URL
+	MaxHeaderBytes int
+	// MaxPathLength is the maximum length of the escaped request path, longer ones are rejected with a 414
+	// It can't exceed MaxHeaderBytes, which bounds the request line the path is part of
+	MaxPathLength int
+	// LogLevel is the minimum level of the emitted logs
+	LogLevel log.Level
+	// NamePrefix is the prefix of the container names that are considered for registration
+	NamePrefix string
+	// HostnameFromName makes the container name the hostname of instances without a hostname label
+	HostnameFromName bool
+	// MaxObjectSize is the maximum size in bytes of an uploaded object
+	MaxObjectSize int64
+	// FallbackBucket is the bucket looked up when an object is missing from the requested one, empty to disable
+	FallbackBucket string
+	// ReplicationFactor is the number of instances each object is written to
+	ReplicationFactor int
+	// WriteQuorum is the number of replicas that must acknowledge a write, it defaults to the replication factor
+	WriteQuorum int
+	// BucketReplication overrides the replication factor of the listed buckets, as bucket=factor pairs
+	// The write quorum of a bucket is capped at its replication factor
+	BucketReplication map[string]int
+	// StrictReplication fails the writes when fewer instances than the replication factor are available
+	// Otherwise, the default, the object is written to all the available instances, with a warning
+	StrictReplication bool
+	// ReplicationConcurrency is the maximum number of replica writes of an object in flight at once, zero for all of them
+	ReplicationConcurrency int
+	// PartSize is the size in bytes of the parts the large objects are uploaded in, zero for the minio default
+	PartSize int64
+	// UploadThreads is the number of parts of an object uploaded at once to each replica, zero for the minio default
+	UploadThreads int
+	// FoldCase makes object ids case-insensitive by lowercasing them
+	FoldCase bool
+	// LargeObjectThreshold is the size hint from which objects are placed on the highest weight instances, zero to disable
+	LargeObjectThreshold int64
+	// LargeObjectCandidates is the number of ring successors, including the owner, large objects can be placed on
+	LargeObjectCandidates int
+	// WALDir is the directory of the write-ahead log of replicated writes, empty to disable
+	WALDir string
+	// RegistrySnapshot is the file the registered instances are saved to, and loaded from at startup, empty to disable
+	RegistrySnapshot string
+	// SpillThreshold is the size in bytes from which uploaded bodies are buffered to a temporary file, zero to disable
+	SpillThreshold int64
+	// Pins match the object ids, or id patterns, to dedicated instances instead of the consistent hash
+	Pins []registry.Pin
+	// Compression is the algorithm the objects are stored compressed with, gzip or zstd, empty to disable
+	Compression gateway.Compression
+	// Dedup stores the identical contents once per instance, the objects referencing them, e.g. for many copies of the same file
+	Dedup bool
+	// DedupSweepInterval is how often the deduplicated contents no object references anymore are removed
+	DedupSweepInterval time.Duration
+	// StatTimeout bounds each minio existence or metadata check, zero to disable
+	StatTimeout time.Duration
+	// GetTimeout bounds each minio object read, zero to disable
+	GetTimeout time.Duration
+	// PutTimeout bounds each minio object write to a replica, zero to disable
+	PutTimeout time.Duration
+	// DeleteTimeout bounds each minio object deletion or restoration on a replica, zero to disable
+	DeleteTimeout time.Duration
+	// KeyStrategy derives the consistent hash key of the objects from their id, bucket/id or bucket
+	KeyStrategy gateway.KeyStrategy
+	// HashSeed salts the consistent hash, another seed reshuffles the placement of every object, e.g. to test a rebalance
+	// Changing it in production moves most objects to other instances, so they must be rebalanced
+	HashSeed string
+	// DrainingWrites reroutes the writes of the objects owned by a draining instance to its successors, or rejects them
+	DrainingWrites gateway.DrainingWrites
+	// TrustedProxies are the addresses of the proxies whose X-Forwarded-For and X-Real-IP headers are trusted
+	TrustedProxies []netip.Prefix
+	// StrictHeaders rejects the uploads with X-Amz-* headers the gateway doesn't store, instead of ignoring them
+	StrictHeaders bool
+	// Region is the region the minio clients sign with, empty to discover the region of each bucket and retry on a mismatch
+	Region string
+	// ClientMaxRetries is the number of attempts of each minio client request, one disables the minio retries
+	// Zero keeps the minio default, which retries on top of the gateway's own retries
+	ClientMaxRetries int
+	// ClientAppName is reported with the gateway version in the user agent of the minio requests, empty to disable
+	ClientAppName string
+	// DialCheckTimeout bounds a TCP dial of the matched instances, which skips the unreachable ones, zero to disable
+	// It adds a dial to every request, so it is opt-in
+	DialCheckTimeout time.Duration
+	// PreviousOwners is the number of ring successors past the replicas a missing object is looked up on, zero to disable
+	PreviousOwners int
+	// MigrateOnRead writes the objects found on a previous owner to their current owners
+	MigrateOnRead bool
+	// RetryBudget is the number of instance lookup retries shared by all the requests, zero to disable the budget
+	RetryBudget int
+	// RetryBudgetRate is the number of retries added back to the budget per second
+	RetryBudgetRate float64
+	// FailoverTTL is how long the instances matched for a key are reused when none matches, zero to disable
+	FailoverTTL time.Duration
+	// FailoverCacheSize is the maximum number of keys whose last matched instances are kept
+	FailoverCacheSize int
+	// IdempotencyTTL is how long the result of an upload is replayed to the retries with the same Idempotency-Key, zero to disable
+	IdempotencyTTL time.Duration
+	// IdempotencyCacheSize is the maximum number of idempotency keys whose upload result is kept
+	IdempotencyCacheSize int
+	// MaxInstanceConcurrency is the maximum number of operations in progress on each instance, zero to disable
+	MaxInstanceConcurrency int
+	// InstanceQueueTimeout is how long an operation waits for a busy instance before failing with a 503, zero to fail fast
+	InstanceQueueTimeout time.Duration
+	// ReadRepair writes the objects read to their replicas missing them, in the background
+	ReadRepair bool
+	// AuditOverwrites records the old and new version of every overwritten object in the audit log
+	AuditOverwrites bool
+	// AuditLog is the file the audit events are appended to, empty for the standard output
+	AuditLog string
+	// MaintenanceMode rejects the writes with a 503 at startup, it can be toggled through the admin endpoints
+	MaintenanceMode bool
+	// Zone is the zone the gateway runs in, the reads prefer the replicas labelled with it, empty to disable
+	Zone string
+	// SoftDeleteRetention is how long the deleted objects can be restored before being purged, zero to delete right away
+	SoftDeleteRetention time.Duration
+	// SoftDeleteSweepInterval is how often the deleted objects past their retention are purged
+	SoftDeleteSweepInterval time.Duration
+	// CapacityWeightInterval is how often the instances are re-weighted by their free disk space, zero to use the weight labels
+	CapacityWeightInterval time.Duration
+	// CapacityWeightThreshold is the least change of weight, out of 100, which re-weights an instance, to limit the ring churn
+	CapacityWeightThreshold int
+	// SniffContentType serves the objects stored without a content type with the one detected from their first bytes
+	SniffContentType bool
+	// DefaultCacheControl is the Cache-Control header of the objects served without one stored, empty to send none
+	DefaultCacheControl string
+	// AuthURL is the URL of the auth backend every request is checked against, empty to disable the authentication
+	AuthURL string
+	// AuthFailOpen lets the requests through when the auth backend can't be reached, they are rejected by default
+	AuthFailOpen bool
+	// AdminToken is the token the admin requests carry in the X-Admin-Token header, empty to disable the admin endpoints
+	AdminToken string
+	// EventsWebhook is the URL the events of the stored and deleted objects are posted to, empty to disable
+	EventsWebhook string
+	// RetryCountHeader reports the retries needed to serve each request in an X-Retry-Count header, for debugging
+	RetryCountHeader bool
+	// ErrorDetails returns the error and the backend error behind it in the body of the 500 responses, for debugging
+	// It leaks the backend internals to the clients, so it must not be enabled in production
+	ErrorDetails bool
+	// SlowRequestThreshold logs a warning for every request served in longer than it, zero to disable
+	SlowRequestThreshold time.Duration
+	// UnmonitoredPaths are the request paths left out of the request logs and metrics, e.g. the health checks
+	// The load balancers poll them often enough to flood the logs and drown the real traffic in the metrics
+	UnmonitoredPaths []string
+}
+
+// Default returns the configuration used when no environment variable is set
+func Default() Config {
+	return Config{
+		Addr:                    ":3000",
+		ReadTimeout:             5 * time.Second,
+		WriteTimeout:            10 * time.Second,
+		IdleTimeout:             30 * time.Second,
+		ShutdownTimeout:         30 * time.Second,
+		MaxHeaderBytes:          http.DefaultMaxHeaderBytes,
+		MaxPathLength:           1024,
+		LogLevel:                log.LevelDebug,
+		NamePrefix:              "amazin-object-storage-node",
+		ClientAppName:           "object-storage",
+		MaxObjectSize:           64 << 20, // 64 MiB
+		ReplicationFactor:       1,
+		WriteQuorum:             1,
+		LargeObjectCandidates:   3,
+		StatTimeout:             2 * time.Second,
+		KeyStrategy:             gateway.IDKey,
+		DrainingWrites:          gateway.RerouteDrainingWrites,
+		RetryBudget:             100,
+		RetryBudgetRate:         10,
+		FailoverTTL:             5 * time.Second,
+		FailoverCacheSize:       10000,
+		IdempotencyTTL:          10 * time.Minute,
+		IdempotencyCacheSize:    10000,
+		SoftDeleteSweepInterval: time.Hour,
+		DedupSweepInterval:      time.Hour,
+		CapacityWeightThreshold: 10,
+		UnmonitoredPaths:        []string{"/healthz", "/readyz"},
+	}
+}
+
+// Load reads the configuration from the environment, falling back to the defaults for unset variables
+// When path is not empty, the YAML file it names is read too, and the environment variables override its values
+// The file keys are the lowercase environment variable names without the prefix, e.g. read_timeout
+// The loaded configuration is validated before being returned
+func Load(path string) (Config, error) {
+	cfg := Default()
+	l := loader{looked: make(map[string]struct{})}
+	if path != "" {
+		file, err := readFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to load config: %w", err)
+		}
+		l.file = file
+	}
+
+	cfg.Addr = l.string("ADDR", cfg.Addr)
+	cfg.ReadTimeout = l.duration("READ_TIMEOUT", cfg.ReadTimeout)
+	cfg.WriteTimeout = l.duration("WRITE_TIMEOUT", cfg.WriteTimeout)
+	cfg.IdleTimeout = l.duration("IDLE_TIMEOUT", cfg.IdleTimeout)
+	cfg.ShutdownTimeout = l.duration("SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout)
+	cfg.MaxHeaderBytes = l.int("MAX_HEADER_BYTES", cfg.MaxHeaderBytes)
+	cfg.MaxPathLength = l.int("MAX_PATH_LENGTH", cfg.MaxPathLength)
+	cfg.LogLevel = l.level("LOG_LEVEL", cfg.LogLevel)
+	cfg.NamePrefix = l.string("NAME_PREFIX", cfg.NamePrefix)
+	cfg.HostnameFromName = l.bool("HOSTNAME_FROM_NAME", cfg.HostnameFromName)
+	cfg.MaxObjectSize = l.int64("MAX_OBJECT_SIZE", cfg.MaxObjectSize)
+	cfg.FallbackBucket = l.string("FALLBACK_BUCKET", cfg.FallbackBucket)
+	cfg.ReplicationFactor = l.int("REPLICATION_FACTOR", cfg.ReplicationFactor)
+	cfg.WriteQuorum = l.int("WRITE_QUORUM", cfg.ReplicationFactor)
+	cfg.BucketReplication = l.bucketReplication("BUCKET_REPLICATION", cfg.BucketReplication)
+	cfg.StrictReplication = l.bool("STRICT_REPLICATION", cfg.StrictReplication)
+	cfg.ReplicationConcurrency = l.int("REPLICATION_CONCURRENCY", cfg.ReplicationConcurrency)
+	cfg.PartSize = l.int64("PUT_PART_SIZE", cfg.PartSize)
+	cfg.UploadThreads = l.int("PUT_UPLOAD_THREADS", cfg.UploadThreads)
+	cfg.FoldCase = l.bool("FOLD_CASE", cfg.FoldCase)
+	cfg.LargeObjectThreshold = l.int64("LARGE_OBJECT_THRESHOLD", cfg.LargeObjectThreshold)
+	cfg.LargeObjectCandidates = l.int("LARGE_OBJECT_CANDIDATES", cfg.LargeObjectCandidates)
+	cfg.WALDir = l.string("WAL_DIR", cfg.WALDir)
+	cfg.RegistrySnapshot = l.string("REGISTRY_SNAPSHOT", cfg.RegistrySnapshot)
+	cfg.SpillThreshold = l.int64("SPILL_THRESHOLD", cfg.SpillThreshold)
+	cfg.Pins = l.pins("PINS", cfg.Pins)
+	cfg.Compression = l.compression("COMPRESSION", cfg.Compression)
+	cfg.Dedup = l.bool("DEDUP", cfg.Dedup)
+	cfg.DedupSweepInterval = l.duration("DEDUP_SWEEP_INTERVAL", cfg.DedupSweepInterval)
+	cfg.StatTimeout = l.duration("STAT_TIMEOUT", cfg.StatTimeout)
+	cfg.GetTimeout = l.duration("GET_TIMEOUT", cfg.GetTimeout)
+	cfg.PutTimeout = l.duration("PUT_TIMEOUT", cfg.PutTimeout)
+	cfg.DeleteTimeout = l.duration("DELETE_TIMEOUT", cfg.DeleteTimeout)
+	cfg.KeyStrategy = l.keyStrategy("HASH_KEY", cfg.KeyStrategy)
+	cfg.HashSeed = l.string("HASH_SEED", cfg.HashSeed)
+	cfg.DrainingWrites = l.drainingWrites("DRAINING_WRITES", cfg.DrainingWrites)
+	cfg.TrustedProxies = l.prefixes("TRUSTED_PROXIES", cfg.TrustedProxies)
+	cfg.StrictHeaders = l.bool("STRICT_HEADERS", cfg.StrictHeaders)
+	cfg.Region = l.string("REGION", cfg.Region)
+	cfg.ClientMaxRetries = l.int("MINIO_MAX_RETRIES", cfg.ClientMaxRetries)
+	cfg.ClientAppName = l.string("MINIO_APP_NAME", cfg.ClientAppName)
+	cfg.DialCheckTimeout = l.duration("DIAL_CHECK_TIMEOUT", cfg.DialCheckTimeout)
+	cfg.PreviousOwners = l.int("PREVIOUS_OWNERS", cfg.PreviousOwners)
+	cfg.MigrateOnRead = l.bool("MIGRATE_ON_READ", cfg.MigrateOnRead)
+	cfg.RetryBudget = l.int("RETRY_BUDGET", cfg.RetryBudget)
+	cfg.RetryBudgetRate = l.float64("RETRY_BUDGET_RATE", cfg.RetryBudgetRate)
+	cfg.FailoverTTL = l.duration("FAILOVER_TTL", cfg.FailoverTTL)
+	cfg.FailoverCacheSize = l.int("FAILOVER_CACHE_SIZE", cfg.FailoverCacheSize)
+	cfg.IdempotencyTTL = l.duration("IDEMPOTENCY_TTL", cfg.IdempotencyTTL)
+	cfg.IdempotencyCacheSize = l.int("IDEMPOTENCY_CACHE_SIZE", cfg.IdempotencyCacheSize)
+	cfg.MaxInstanceConcurrency = l.int("MAX_INSTANCE_CONCURRENCY", cfg.MaxInstanceConcurrency)
+	cfg.InstanceQueueTimeout = l.duration("INSTANCE_QUEUE_TIMEOUT", cfg.InstanceQueueTimeout)
+	cfg.ReadRepair = l.bool("READ_REPAIR", cfg.ReadRepair)
+	cfg.AuditOverwrites = l.bool("AUDIT_OVERWRITES", cfg.AuditOverwrites)
+	cfg.AuditLog = l.string("AUDIT_LOG", cfg.AuditLog)
+	cfg.MaintenanceMode = l.bool("MAINTENANCE_MODE", cfg.MaintenanceMode)
+	cfg.Zone = l.string("ZONE", cfg.Zone)
+	cfg.SoftDeleteRetention = l.duration("SOFT_DELETE_RETENTION", cfg.SoftDeleteRetention)
+	cfg.SoftDeleteSweepInterval = l.duration("SOFT_DELETE_SWEEP_INTERVAL", cfg.SoftDeleteSweepInterval)
+	cfg.CapacityWeightInterval = l.duration("CAPACITY_WEIGHT_INTERVAL", cfg.CapacityWeightInterval)
+	cfg.CapacityWeightThreshold = l.int("CAPACITY_WEIGHT_THRESHOLD", cfg.CapacityWeightThreshold)
+	cfg.SniffContentType = l.bool("SNIFF_CONTENT_TYPE", cfg.SniffContentType)
+	cfg.DefaultCacheControl = l.string("DEFAULT_CACHE_CONTROL", cfg.DefaultCacheControl)
+	cfg.EventsWebhook = l.string("EVENTS_WEBHOOK", cfg.EventsWebhook)
+	cfg.AuthURL = l.string("AUTH_URL", cfg.AuthURL)
+	cfg.AuthFailOpen = l.bool("AUTH_FAIL_OPEN", cfg.AuthFailOpen)
+	cfg.AdminToken = l.string("ADMIN_TOKEN", cfg.AdminToken)
+	cfg.RetryCountHeader = l.bool("RETRY_COUNT_HEADER", cfg.RetryCountHeader)
+	cfg.ErrorDetails = l.bool("DEBUG_ERROR_DETAILS", cfg.ErrorDetails)
+	cfg.SlowRequestThreshold = l.duration("SLOW_REQUEST_THRESHOLD", cfg.SlowRequestThreshold)
+	cfg.UnmonitoredPaths = l.paths("UNMONITORED_PATHS", cfg.UnmonitoredPaths)
+
+	// Unknown keys are rejected, so a typo doesn't silently keep the default
+	for key := range l.file {
+		if _, ok := l.looked[key]; !ok {
+			l.errs = append(l.errs, fmt.Errorf("%s: unknown key %s", path, strings.ToLower(key)))
+		}
+	}
+
+	if err := errors.Join(l.errs...); err != nil {
+		return Config{}, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the configuration for invalid values and combinations
+// It reports all the problems found, not only the first one
+func (c Config) Validate() error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr must not be empty"))
+	}
+
+	// Zero has a meaning of its own for the optional timeouts, documented on each field, and is rejected for the others
+	timeouts := []struct {
+		name     string
+		value    time.Duration
+		optional bool
+	}{
+		{"read timeout", c.ReadTimeout, false},
+		{"write timeout", c.WriteTimeout, false},
+		{"idle timeout", c.IdleTimeout, true},
+		{"shutdown timeout", c.ShutdownTimeout, false},
+		{"stat timeout", c.StatTimeout, true},
+		{"get timeout", c.GetTimeout, true},
+		{"put timeout", c.PutTimeout, true},
+		{"delete timeout", c.DeleteTimeout, true},
+		{"failover ttl", c.FailoverTTL, true},
+		{"idempotency ttl", c.IdempotencyTTL, true},
+		{"instance queue timeout", c.InstanceQueueTimeout, true},
+		{"slow request threshold", c.SlowRequestThreshold, true},
+		{"dial check timeout", c.DialCheckTimeout, true},
+	}
+	for _, t := range timeouts {
+		if t.value == 0 && !t.optional {
+			errs = append(errs, fmt.Errorf("%s must be positive, got 0", t.name))
+			continue
+		}
+
+		if t.value < 0 || t.value > maxTimeout {
+			errs = append(errs, fmt.Errorf("%s must be between 0 and %s, got %s", t.name, maxTimeout, t.value))
+		}
+	}
+
+	if c.NamePrefix == "" {
+		errs = append(errs, errors.New("name prefix must not be empty"))
+	}
+
+	if c.MaxHeaderBytes <= 0 {
+		errs = append(errs, fmt.Errorf("max header bytes must be positive, got %d", c.MaxHeaderBytes))
+	}
+
+	if c.MaxPathLength <= 0 || c.MaxPathLength > c.MaxHeaderBytes {
+		errs = append(errs, fmt.Errorf("max path length must be between 1 and the max header bytes %d, got %d", c.MaxHeaderBytes, c.MaxPathLength))
+	}
+
+	if c.MaxObjectSize <= 0 {
+		errs = append(errs, fmt.Errorf("max object size must be positive, got %d", c.MaxObjectSize))
+	}
+
+	if c.ReplicationFactor < 1 || c.ReplicationFactor > maxReplicationFactor {
+		errs = append(errs, fmt.Errorf("replication factor must be between 1 and %d, got %d", maxReplicationFactor, c.ReplicationFactor))
+	}
+
+	if c.WriteQuorum < 1 || c.WriteQuorum > c.ReplicationFactor {
+		errs = append(errs, fmt.Errorf("write quorum must be between 1 and the replication factor %d, got %d", c.ReplicationFactor, c.WriteQuorum))
+	}
+
+	for bucket, factor := range c.BucketReplication {
+		if factor < 1 || factor > maxReplicationFactor {
+			errs = append(errs, fmt.Errorf("replication factor of bucket %s must be between 1 and %d, got %d", bucket, maxReplicationFactor, factor))
+		}
+	}
+
+	if c.ClientMaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("minio max retries must not be negative, got %d", c.ClientMaxRetries))
+	}
+
+	if c.ReplicationConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("replication concurrency must not be negative, got %d", c.ReplicationConcurrency))
+	}
+
+	if c.PartSize != 0 && (c.PartSize < gateway.MinPartSize || c.PartSize > gateway.MaxPartSize) {
+		errs = append(errs, fmt.Errorf("put part size must be 0 or between %d and %d, got %d", gateway.MinPartSize, gateway.MaxPartSize, c.PartSize))
+	}
+
+	if c.UploadThreads < 0 {
+		errs = append(errs, fmt.Errorf("put upload threads must not be negative, got %d", c.UploadThreads))
+	}
+
+	if c.LargeObjectThreshold < 0 {
+		errs = append(errs, fmt.Errorf("large object threshold must not be negative, got %d", c.LargeObjectThreshold))
+	}
+
+	if c.LargeObjectThreshold > 0 && (c.LargeObjectCandidates < c.ReplicationFactor || c.LargeObjectCandidates > maxReplicationFactor) {
+		errs = append(errs, fmt.Errorf("large object candidates must be between the replication factor %d and %d, got %d", c.ReplicationFactor, maxReplicationFactor, c.LargeObjectCandidates))
+	}
+
+	if c.PreviousOwners < 0 || c.PreviousOwners > maxReplicationFactor {
+		errs = append(errs, fmt.Errorf("previous owners must be between 0 and %d, got %d", maxReplicationFactor, c.PreviousOwners))
+	}
+
+	if c.SpillThreshold < 0 {
+		errs = append(errs, fmt.Errorf("spill threshold must not be negative, got %d", c.SpillThreshold))
+	}
+
+	if c.RetryBudget < 0 {
+		errs = append(errs, fmt.Errorf("retry budget must not be negative, got %d", c.RetryBudget))
+	}
+
+	if c.RetryBudget > 0 && c.RetryBudgetRate <= 0 {
+		errs = append(errs, fmt.Errorf("retry budget rate must be positive, got %g", c.RetryBudgetRate))
+	}
+
+	if c.FailoverTTL > 0 && c.FailoverCacheSize <= 0 {
+		errs = append(errs, fmt.Errorf("failover cache size must be positive, got %d", c.FailoverCacheSize))
+	}
+
+	if c.IdempotencyTTL > 0 && c.IdempotencyCacheSize <= 0 {
+		errs = append(errs, fmt.Errorf("idempotency cache size must be positive, got %d", c.IdempotencyCacheSize))
+	}
+
+	if c.MaxInstanceConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("max instance concurrency must not be negative, got %d", c.MaxInstanceConcurrency))
+	}
+
+	if c.AuthURL != "" {
+		if u, err := url.Parse(c.AuthURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("auth url must be an http or https URL, got %q", c.AuthURL))
+		}
+	}
+
+	if c.EventsWebhook != "" {
+		if u, err := url.Parse(c.EventsWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("events webhook must be an http or https URL, got %q", c.EventsWebhook))
+		}
+	}
+
+	if c.SoftDeleteRetention < 0 {
+		errs = append(errs, fmt.Errorf("soft delete retention must not be negative, got %s", c.SoftDeleteRetention))
+	}
+
+	if c.CapacityWeightInterval < 0 {
+		errs = append(errs, fmt.Errorf("capacity weight interval must not be negative, got %s", c.CapacityWeightInterval))
+	}
+
+	if c.CapacityWeightInterval > 0 && (c.CapacityWeightThreshold < 1 || c.CapacityWeightThreshold > hashring.TopWeight) {
+		errs = append(errs, fmt.Errorf("capacity weight threshold must be between 1 and %d, got %d", hashring.TopWeight, c.CapacityWeightThreshold))
+	}
+
+	if c.Dedup && c.DedupSweepInterval <= 0 {
+		errs = append(errs, fmt.Errorf("dedup sweep interval must be positive, got %s", c.DedupSweepInterval))
+	}
+
+	if c.SoftDeleteRetention > 0 && c.SoftDeleteSweepInterval <= 0 {
+		errs = append(errs, fmt.Errorf("soft delete sweep interval must be positive, got %s", c.SoftDeleteSweepInterval))
+	}
+
+	return errors.Join(errs...)
+}
+
+// loader reads typed values from the environment, or else the config file, and collects the parsing errors
+type loader struct {
+	errs   []error
+	file   map[string]string   // The config file values, keyed by their uppercase name
+	looked map[string]struct{} // The names looked up, to detect the unknown file keys
+}
+
+func (l *loader) lookup(name string) (string, bool) {
+	l.looked[name] = struct{}{}
+	if value, ok := os.LookupEnv(EnvPrefix + name); ok && strings.TrimSpace(value) != "" {
+		return strings.TrimSpace(value), true
+	}
+
+	if value, ok := l.file[name]; ok && strings.TrimSpace(value) != "" {
+		return strings.TrimSpace(value), true
+	}
+
+	return "", false
+}
+
+// readFile reads the scalar values of the YAML config file, keyed by their uppercase name
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err = yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	file := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch value.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("%s: %s must be a scalar value", path, key)
+		case nil:
+			continue
+		}
+		file[strings.ToUpper(key)] = fmt.Sprint(value)
+	}
+
+	return file, nil
+}
+
+func (l *loader) string(name, fallback string) string {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	return value
+}
+
+func (l *loader) bool(name string, fallback bool) bool {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return b
+}
+
+func (l *loader) duration(name string, fallback time.Duration) time.Duration {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return d
+}
+
+func (l *loader) int(name string, fallback int) int {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return i
+}
+
+func (l *loader) int64(name string, fallback int64) int64 {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return i
+}
+
+func (l *loader) float64(name string, fallback float64) float64 {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return f
+}
+
+func (l *loader) level(name string, fallback log.Level) log.Level {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	var level log.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return level
+}
+
+func (l *loader) pins(name string, fallback []registry.Pin) []registry.Pin {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	pins, err := registry.ParsePins(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return pins
+}
+
+func (l *loader) bucketReplication(name string, fallback map[string]int) map[string]int {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	factors, err := gateway.ParseBucketReplication(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return factors
+}
+
+func (l *loader) compression(name string, fallback gateway.Compression) gateway.Compression {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	c, err := gateway.ParseCompression(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return c
+}
+
+func (l *loader) keyStrategy(name string, fallback gateway.KeyStrategy) gateway.KeyStrategy {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	k, err := gateway.ParseKeyStrategy(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return k
+}
+
+func (l *loader) drainingWrites(name string, fallback gateway.DrainingWrites) gateway.DrainingWrites {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	d, err := gateway.ParseDrainingWrites(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+		return fallback
+	}
+
+	return d
+}
+
+// paths reads a comma separated list of URL paths, which must be absolute
+func (l *loader) paths(name string, fallback []string) []string {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	var paths []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(field, "/") {
+			l.errs = append(l.errs, fmt.Errorf("%s%s: path %q must start with /", EnvPrefix, name, field))
+			return fallback
+		}
+		paths = append(paths, field)
+	}
+
+	return paths
+}
+
+// prefixes reads a comma separated list of CIDRs, a bare address is a single address prefix
+func (l *loader) prefixes(name string, fallback []netip.Prefix) []netip.Prefix {
+	value, ok := l.lookup(name)
+	if !ok {
+		return fallback
+	}
+
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+				return fallback
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+			return fallback
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes
+}
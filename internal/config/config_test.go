@@ -0,0 +1,264 @@
+package config
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/gateway"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Load() = %+v, want the defaults %+v", cfg, Default())
+	}
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	t.Setenv(EnvPrefix+"ADDR", ":8080")
+	t.Setenv(EnvPrefix+"READ_TIMEOUT", "7s")
+	t.Setenv(EnvPrefix+"MAX_HEADER_BYTES", " 4096 ")
+	t.Setenv(EnvPrefix+"NAME_PREFIX", "minio")
+	// A blank variable keeps the default
+	t.Setenv(EnvPrefix+"WRITE_TIMEOUT", " ")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Addr != ":8080" || cfg.ReadTimeout != 7*time.Second || cfg.MaxHeaderBytes != 4096 || cfg.NamePrefix != "minio" {
+		t.Errorf("Load() = %+v, want the values of the environment", cfg)
+	}
+	if cfg.WriteTimeout != Default().WriteTimeout {
+		t.Errorf("write timeout = %s, want the default %s", cfg.WriteTimeout, Default().WriteTimeout)
+	}
+}
+
+func TestLoadParseErrors(t *testing.T) {
+	t.Setenv(EnvPrefix+"READ_TIMEOUT", "5 seconds")
+	t.Setenv(EnvPrefix+"MAX_HEADER_BYTES", "1MB")
+	t.Setenv(EnvPrefix+"PINS", "invoice")
+	t.Setenv(EnvPrefix+"COMPRESSION", "brotli")
+	t.Setenv(EnvPrefix+"HASH_KEY", "object")
+	t.Setenv(EnvPrefix+"TRUSTED_PROXIES", "10.0.0.1,proxy")
+	t.Setenv(EnvPrefix+"BUCKET_REPLICATION", "critical")
+	t.Setenv(EnvPrefix+"DRAINING_WRITES", "drop")
+
+	_, err := Load("")
+	if err == nil {
+		t.Fatal("Load() error = nil, want the parsing errors")
+	}
+	// Every malformed variable is reported, not only the first one
+	for _, name := range []string{"GATEWAY_READ_TIMEOUT", "GATEWAY_MAX_HEADER_BYTES", "GATEWAY_PINS", "GATEWAY_COMPRESSION", "GATEWAY_HASH_KEY", "GATEWAY_TRUSTED_PROXIES", "GATEWAY_BUCKET_REPLICATION", "GATEWAY_DRAINING_WRITES"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Load() error = %v, want it to name %s", err, name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string // The substrings of the expected errors, none for a valid config
+	}{
+		{name: "defaults", modify: func(cfg *Config) {}},
+		{name: "empty addr", modify: func(cfg *Config) { cfg.Addr = "" }, want: []string{"addr must not be empty"}},
+		{name: "zero read timeout", modify: func(cfg *Config) { cfg.ReadTimeout = 0 }, want: []string{"read timeout must be positive"}},
+		{name: "negative write timeout", modify: func(cfg *Config) { cfg.WriteTimeout = -time.Second }, want: []string{"write timeout must be between"}},
+		{name: "timeout over the maximum", modify: func(cfg *Config) { cfg.ShutdownTimeout = 2 * time.Hour }, want: []string{"shutdown timeout must be between"}},
+		{name: "empty name prefix", modify: func(cfg *Config) { cfg.NamePrefix = "" }, want: []string{"name prefix must not be empty"}},
+		{name: "zero max header bytes", modify: func(cfg *Config) { cfg.MaxHeaderBytes = 0 }, want: []string{"max header bytes must be positive"}},
+		{name: "path length over the max header bytes", modify: func(cfg *Config) { cfg.MaxHeaderBytes, cfg.MaxPathLength = 512, 1024 }, want: []string{"max path length must be between 1 and the max header bytes 512"}},
+		{name: "write quorum over the replication factor", modify: func(cfg *Config) { cfg.ReplicationFactor, cfg.WriteQuorum = 2, 3 }, want: []string{"write quorum must be between 1 and the replication factor 2"}},
+		{name: "zero write quorum", modify: func(cfg *Config) { cfg.WriteQuorum = 0 }, want: []string{"write quorum must be between"}},
+		{name: "large objects", modify: func(cfg *Config) { cfg.LargeObjectThreshold = 1 << 20 }},
+		{name: "negative large object threshold", modify: func(cfg *Config) { cfg.LargeObjectThreshold = -1 }, want: []string{"large object threshold must not be negative"}},
+		{
+			name: "large object candidates under the replication factor",
+			modify: func(cfg *Config) {
+				cfg.LargeObjectThreshold, cfg.ReplicationFactor, cfg.WriteQuorum, cfg.LargeObjectCandidates = 1<<20, 3, 3, 2
+			},
+			want: []string{"large object candidates must be between the replication factor 3"},
+		},
+		{name: "bucket replication factor over the maximum", modify: func(cfg *Config) { cfg.BucketReplication = map[string]int{"critical": 17} }, want: []string{"replication factor of bucket critical must be between 1 and 16"}},
+		{name: "zero idempotency cache size", modify: func(cfg *Config) { cfg.IdempotencyCacheSize = 0 }, want: []string{"idempotency cache size must be positive"}},
+		{name: "idempotency disabled", modify: func(cfg *Config) { cfg.IdempotencyTTL, cfg.IdempotencyCacheSize = 0, 0 }},
+		{name: "part size under the minio minimum", modify: func(cfg *Config) { cfg.PartSize = gateway.MinPartSize - 1 }, want: []string{"put part size must be 0 or between"}},
+		{name: "minimum part size", modify: func(cfg *Config) { cfg.PartSize = gateway.MinPartSize }},
+		{name: "negative upload threads", modify: func(cfg *Config) { cfg.UploadThreads = -1 }, want: []string{"put upload threads must not be negative"}},
+		{name: "negative minio max retries", modify: func(cfg *Config) { cfg.ClientMaxRetries = -1 }, want: []string{"minio max retries must not be negative"}},
+		{name: "negative replication concurrency", modify: func(cfg *Config) { cfg.ReplicationConcurrency = -1 }, want: []string{"replication concurrency must not be negative"}},
+		{name: "negative previous owners", modify: func(cfg *Config) { cfg.PreviousOwners = -1 }, want: []string{"previous owners must be between 0"}},
+		{name: "negative retry budget", modify: func(cfg *Config) { cfg.RetryBudget = -1 }, want: []string{"retry budget must not be negative"}},
+		{name: "zero retry budget rate", modify: func(cfg *Config) { cfg.RetryBudgetRate = 0 }, want: []string{"retry budget rate must be positive"}},
+		{name: "negative max instance concurrency", modify: func(cfg *Config) { cfg.MaxInstanceConcurrency = -1 }, want: []string{"max instance concurrency must not be negative"}},
+		{name: "zero dedup sweep interval", modify: func(cfg *Config) { cfg.Dedup, cfg.DedupSweepInterval = true, 0 }, want: []string{"dedup sweep interval must be positive"}},
+		{name: "negative spill threshold", modify: func(cfg *Config) { cfg.SpillThreshold = -1 }, want: []string{"spill threshold must not be negative"}},
+		{
+			name:   "all the problems are reported",
+			modify: func(cfg *Config) { cfg.Addr, cfg.NamePrefix, cfg.ReadTimeout = "", "", 0 },
+			want:   []string{"addr must not be empty", "name prefix must not be empty", "read timeout must be positive"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Validate() error = nil, want %v", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() error = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateZeroTimeouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		zero  func(cfg *Config)
+		valid bool // Whether zero has a meaning of its own, rather than being rejected
+	}{
+		{name: "read timeout", zero: func(cfg *Config) { cfg.ReadTimeout = 0 }},
+		{name: "write timeout", zero: func(cfg *Config) { cfg.WriteTimeout = 0 }},
+		{name: "shutdown timeout", zero: func(cfg *Config) { cfg.ShutdownTimeout = 0 }},
+		{name: "idle timeout", zero: func(cfg *Config) { cfg.IdleTimeout = 0 }, valid: true},
+		{name: "stat timeout", zero: func(cfg *Config) { cfg.StatTimeout = 0 }, valid: true},
+		{name: "get timeout", zero: func(cfg *Config) { cfg.GetTimeout = 0 }, valid: true},
+		{name: "put timeout", zero: func(cfg *Config) { cfg.PutTimeout = 0 }, valid: true},
+		{name: "delete timeout", zero: func(cfg *Config) { cfg.DeleteTimeout = 0 }, valid: true},
+		{name: "failover ttl", zero: func(cfg *Config) { cfg.FailoverTTL = 0 }, valid: true},
+		{name: "instance queue timeout", zero: func(cfg *Config) { cfg.InstanceQueueTimeout = 0 }, valid: true},
+		{name: "idempotency ttl", zero: func(cfg *Config) { cfg.IdempotencyTTL = 0 }, valid: true},
+		{name: "slow request threshold", zero: func(cfg *Config) { cfg.SlowRequestThreshold = 0 }, valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.zero(&cfg)
+			err := cfg.Validate()
+			if tt.valid {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want zero accepted", err)
+				}
+				return
+			}
+			if want := tt.name + " must be positive, got 0"; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("Validate() error = %v, want it to contain %q", err, want)
+			}
+		})
+	}
+}
+
+func TestLoadWriteQuorumDefaultsToTheReplicationFactor(t *testing.T) {
+	t.Setenv(EnvPrefix+"REPLICATION_FACTOR", "3")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.WriteQuorum != 3 {
+		t.Errorf("write quorum = %d, want the replication factor 3", cfg.WriteQuorum)
+	}
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	t.Setenv(EnvPrefix+"TRUSTED_PROXIES", "10.0.0.1, 192.168.1.7/16,,::ffff:10.0.0.2")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// A bare address is a single address prefix, and a CIDR is masked
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.1/32"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("10.0.0.2/32"),
+	}
+	if !reflect.DeepEqual(cfg.TrustedProxies, want) {
+		t.Errorf("trusted proxies = %v, want %v", cfg.TrustedProxies, want)
+	}
+}
+
+// writeConfigFile writes the YAML config file in a temporary directory, and returns its path
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFromFile(t *testing.T) {
+	path := writeConfigFile(t, `
+addr: ":8080"
+read_timeout: 7s
+max_header_bytes: 4096
+fold_case: true
+name_prefix: file
+fallback_bucket:
+`)
+	// The environment overrides the file
+	t.Setenv(EnvPrefix+"NAME_PREFIX", "env")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Addr != ":8080" || cfg.ReadTimeout != 7*time.Second || cfg.MaxHeaderBytes != 4096 || !cfg.FoldCase {
+		t.Errorf("Load() = %+v, want the values of the file", cfg)
+	}
+	if cfg.NamePrefix != "env" {
+		t.Errorf("name prefix = %q, want the one of the environment", cfg.NamePrefix)
+	}
+	if cfg.FallbackBucket != Default().FallbackBucket || cfg.WriteTimeout != Default().WriteTimeout {
+		t.Errorf("Load() = %+v, want the defaults for the empty and missing keys", cfg)
+	}
+}
+
+func TestLoadFromFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "unknown key", content: "adress: \":8080\"\n", want: "unknown key adress"},
+		{name: "non-scalar value", content: "addr:\n  host: localhost\n", want: "addr must be a scalar value"},
+		{name: "invalid yaml", content: "addr: [\n", want: "gateway.yaml"},
+		{name: "invalid value", content: "read_timeout: 5 seconds\n", want: "READ_TIMEOUT"},
+		{name: "invalid config", content: "replication_factor: 2\nwrite_quorum: 3\n", want: "write quorum must be between"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfigFile(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load() error = %v, want the missing file reported", err)
+	}
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Body is the content of an object being written
+// It is read at offsets, so each replica write gets its own independent reader
+type Body interface {
+	io.ReaderAt
+	Size() int64
+	Close() error
+}
+
+// NewBytesBody returns a Body holding data in memory
+func NewBytesBody(data []byte) Body {
+	return bytesBody{Reader: bytes.NewReader(data)}
+}
+
+type bytesBody struct {
+	*bytes.Reader
+}
+
+// Close does nothing, the data is released by the garbage collector
+func (b bytesBody) Close() error {
+	return nil
+}
+
+// NewFileBody returns a Body reading size bytes from file
+// The file is closed by Close, and also removed when temporary is true
+func NewFileBody(file *os.File, size int64, temporary bool) Body {
+	return &fileBody{File: file, size: size, temporary: temporary}
+}
+
+type fileBody struct {
+	*os.File
+	size      int64
+	temporary bool
+}
+
+// Size returns the number of bytes of the body
+func (f *fileBody) Size() int64 {
+	return f.size
+}
+
+// Close closes the file, and removes it if it is temporary
+func (f *fileBody) Close() error {
+	err := f.File.Close()
+	if f.temporary {
+		if removeErr := os.Remove(f.Name()); removeErr != nil && !os.IsNotExist(removeErr) {
+			return removeErr
+		}
+	}
+
+	return err
+}
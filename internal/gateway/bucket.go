@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+)
+
+// BucketExists reports whether the bucket exists on any registered instance
+// The instances are checked concurrently, and the first one reporting the bucket ends the check
+// It fails only if no instance could be checked
+func (o *ObjectStorage) BucketExists(ctx context.Context, bucket string) (bool, error) {
+	instances := o.registry.GetAllServices()
+	if len(instances) == 0 {
+		return false, errors.New("no instance is registered")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Stops the checks still running once the bucket is found
+
+	type result struct {
+		instance string
+		exists   bool
+		err      error
+	}
+	results := make(chan result, len(instances))
+	for _, instance := range instances {
+		go func() {
+			minioInstance, err := o.clients.get(instance)
+			if err != nil {
+				results <- result{instance: instance.Address(), err: err}
+				return
+			}
+
+			exists, err := withInstance(ctx, o, minioInstance, func() (bool, error) {
+				statCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Stat)
+				defer cancel()
+				return minioInstance.BucketExists(statCtx, bucket)
+			})
+			results <- result{instance: instance.Address(), exists: exists, err: err}
+		}()
+	}
+
+	var errs []error
+	for range instances {
+		r := <-results
+		if r.err != nil {
+			log.Warn("Failed to check the bucket on an instance", "instance", r.instance, "bucket", bucket, "error", r.err)
+			errs = append(errs, fmt.Errorf("failed to check bucket on %s: %w", r.instance, r.err))
+			continue
+		}
+
+		if r.exists {
+			return true, nil
+		}
+	}
+
+	if len(errs) == len(instances) {
+		return false, errors.Join(errs...)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBucketExists(t *testing.T) {
+	tests := []struct {
+		name    string
+		bucket  string
+		stopped bool // Whether the instance holding the bucket is unreachable
+		want    bool
+	}{
+		{name: "on one of the instances", bucket: "bucket", want: true},
+		{name: "missing", bucket: "missing"},
+		{name: "on the unreachable instance", bucket: "bucket", stopped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			holder := newFakeInstance(t, "bucket")
+			storage, _ := newTestStorage(t, Options{}, holder, newFakeInstance(t))
+			if tt.stopped {
+				holder.stop()
+			}
+
+			exists, err := storage.BucketExists(context.Background(), tt.bucket)
+			if err != nil || exists != tt.want {
+				t.Errorf("BucketExists() = %v, %v, want %v", exists, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketExistsEveryInstanceUnreachable(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	storage, _ := newTestStorage(t, Options{}, instance)
+	instance.stop()
+	if _, err := storage.BucketExists(context.Background(), "bucket"); err == nil {
+		t.Error("BucketExists() error = nil, want the instance failure")
+	}
+}
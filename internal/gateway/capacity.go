@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	log "log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+const (
+	// storageInfoPath is the minio admin API route reporting the disks of an instance
+	storageInfoPath = "/minio/admin/v3/storageinfo"
+	// emptyPayloadHash is the SHA-256 of an empty body, which the signed admin requests carry
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// defaultRegion is the region the admin requests are signed with when none is configured
+	defaultRegion = "us-east-1"
+	// storageInfoTimeout bounds a query of the free space of an instance, whatever the Stat timeout
+	storageInfoTimeout = 10 * time.Second
+)
+
+// storageInfoClient queries the admin API of the instances, with its own timeout since the Stat one may be disabled
+var storageInfoClient = &http.Client{Timeout: storageInfoTimeout}
+
+// CapacityWeighting derives the weights of the instances from their free disk space, instead of their weight labels
+type CapacityWeighting struct {
+	// Interval is how often the free space of the instances is queried, zero disables the weighting
+	Interval time.Duration
+	// Threshold is the least change of weight, out of hashring.TopWeight, which re-weights an instance
+	// It keeps the small changes of free space from churning the ring
+	Threshold int
+}
+
+// WeighByCapacity re-weights the instances by their free disk space every interval, until ctx is done
+// The instance with the most free space gets the top weight, the others a share proportional to their free space
+// The re-weighting moves keys to other instances, so it migrates the ring: the reads fall back to the former owners,
+// and the migration is finished with the admin API once the objects were moved, e.g. by MigrateOnRead
+// It returns right away when the weighting is disabled
+func (o *ObjectStorage) WeighByCapacity(ctx context.Context) {
+	if o.opts.CapacityWeighting.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(o.opts.CapacityWeighting.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.weighByCapacity(ctx)
+		}
+	}
+}
+
+func (o *ObjectStorage) weighByCapacity(ctx context.Context) {
+	instances := o.registry.GetAllServices()
+	free := make(map[string]uint64, len(instances))
+	var most uint64
+	for _, instance := range instances {
+		space, err := o.freeSpace(ctx, instance)
+		if err != nil {
+			// The instance keeps its weight, rather than being weighted as full
+			log.Warn("Failed to query the free space of an instance", "instance", instance.Address(), "error", err)
+			continue
+		}
+		free[instance.Address()] = space
+		most = max(most, space)
+	}
+
+	if most == 0 {
+		return
+	}
+
+	weights := make(map[string]int)
+	for _, instance := range instances {
+		space, ok := free[instance.Address()]
+		if !ok {
+			continue
+		}
+
+		weight := capacityWeight(space, most)
+		change := weight - instance.CapacityWeight
+		if instance.CapacityWeight > 0 && max(change, -change) < o.opts.CapacityWeighting.Threshold {
+			continue
+		}
+
+		weights[instance.Address()] = weight
+		log.Info("Re-weighting an instance by its free space", "instance", instance.Address(), "free_bytes", space, "weight", weight, "previous_weight", instance.CapacityWeight)
+	}
+	if len(weights) == 0 {
+		return
+	}
+
+	// The weights are applied at once on a new ring, so the keys are moved by a single migration
+	params := o.registry.HashParams()
+	remapped := o.registry.SetCapacityWeights(weights, hashring.NewSeeded(params.VirtualNodes, params.Seed))
+	log.Info("Re-weighted the instances by their free space", "instances", len(weights), "remapped_fraction", remapped)
+}
+
+// capacityWeight returns the weight of an instance with free bytes available, most being the largest free space
+func capacityWeight(free, most uint64) int {
+	return max(1, int(math.Round(float64(hashring.TopWeight)*float64(free)/float64(most))))
+}
+
+// storageInfo is the part of the minio admin storage info used to weight the instances
+type storageInfo struct {
+	Disks []struct {
+		AvailableSpace uint64 `json:"availspace"`
+	} `json:"Disks"`
+}
+
+// freeSpace returns the free bytes of the disks of the instance, from the minio admin API
+// The admin API takes the root credentials, which are the ones the instances are registered with
+func (o *ObjectStorage) freeSpace(ctx context.Context, instance registry.ServiceMetadata) (uint64, error) {
+	creds, err := instanceCredentials(instance, o.clients.creds).Get()
+	if err != nil {
+		return 0, err
+	}
+
+	reqCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Stat)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, "http://"+instanceEndpoint(instance)+storageInfoPath, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	region := o.opts.Region
+	if region == "" {
+		region = defaultRegion
+	}
+	req = signer.SignV4(*req, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, region)
+
+	resp, err := storageInfoClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query the storage info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("storage info responded with %s: %s", resp.Status, body)
+	}
+
+	var info storageInfo
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, fmt.Errorf("failed to decode the storage info: %w", err)
+	}
+
+	var free uint64
+	for _, disk := range info.Disks {
+		free += disk.AvailableSpace
+	}
+
+	return free, nil
+}
@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/registry"
+)
+
+// capacityWeights returns the capacity weights of the registered instances, by address
+func capacityWeights(r *registry.Registry) map[string]int {
+	weights := make(map[string]int)
+	for _, service := range r.GetAllServices() {
+		weights[service.Address()] = service.CapacityWeight
+	}
+	return weights
+}
+
+func TestWeighByCapacity(t *testing.T) {
+	roomy, filling := newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")
+	storage, r := newTestStorage(t, Options{CapacityWeighting: CapacityWeighting{Threshold: 10}}, roomy, filling)
+
+	steps := []struct {
+		name    string
+		roomy   uint64
+		filling uint64
+		want    int // The capacity weight expected of the filling instance
+	}{
+		{name: "first weighting", roomy: 1000, filling: 500, want: 50},
+		{name: "change under the threshold", roomy: 1000, filling: 450, want: 50},
+		{name: "change past the threshold", roomy: 1000, filling: 300, want: 30},
+		{name: "filled up", roomy: 1000, filling: 0, want: 1},
+	}
+	for _, step := range steps {
+		roomy.setFreeSpace(step.roomy)
+		filling.setFreeSpace(step.filling)
+		storage.weighByCapacity(context.Background())
+
+		weights := capacityWeights(r)
+		if weights[roomy.address] != hashring.TopWeight || weights[filling.address] != step.want {
+			t.Errorf("%s: capacity weights = %v, want %d for the roomy instance and %d for the filling one", step.name, weights, hashring.TopWeight, step.want)
+		}
+	}
+	if !r.Migrating() {
+		t.Error("the re-weighting didn't migrate the ring")
+	}
+}
+
+func TestWeighByCapacityKeepsTheWeightOfAFailingInstance(t *testing.T) {
+	roomy, failing := newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")
+	storage, r := newTestStorage(t, Options{CapacityWeighting: CapacityWeighting{Threshold: 10}}, roomy, failing)
+	roomy.setFreeSpace(1000)
+	failing.setFreeSpace(500)
+	storage.weighByCapacity(context.Background())
+
+	failing.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != storageInfoPath {
+			return false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	})
+	roomy.setFreeSpace(2000)
+	storage.weighByCapacity(context.Background())
+
+	// The failing instance isn't weighted as full
+	if weights := capacityWeights(r); weights[failing.address] != 50 {
+		t.Errorf("capacity weights = %v, want the weight 50 of the failing instance kept", weights)
+	}
+}
@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	log "log/slog"
+	"net"
+	"sync"
+
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/dariusigna/object-storage/internal/version"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// clientCache keeps a minio client per instance, so the connections are reused across requests
+type clientCache struct {
+	mu      sync.Mutex
+	clients map[string]cachedClient
+	region  string // The region the clients sign with, empty to discover the region of each bucket
+	creds   CredentialProvider
+	// maxRetries is the number of attempts of each client request, including minio's own retries, zero for minio's default
+	maxRetries int
+	// appName is reported with the gateway version in the user agent of the clients, empty to keep the minio one
+	appName string
+}
+
+type cachedClient struct {
+	instance registry.ServiceMetadata // The metadata the client was built from
+	client   *minio.Client
+	creds    *credentials.Credentials // The credentials the client signs with
+}
+
+func newClientCache(region string, creds CredentialProvider, maxRetries int, appName string) *clientCache {
+	return &clientCache{clients: make(map[string]cachedClient), region: region, creds: creds, maxRetries: maxRetries, appName: appName}
+}
+
+// get returns the cached client of the instance, building it if missing or if the instance is connected to differently
+func (c *clientCache) get(instance registry.ServiceMetadata) (*minio.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	address := instance.Address()
+	if cached, ok := c.clients[address]; ok {
+		if sameConnection(cached.instance, instance) {
+			return cached.client, nil
+		}
+
+		// The connection changed, e.g. the credentials were rotated, so the client is stale
+		c.evictLocked(address)
+	}
+
+	// A failed construction is not cached, so it is attempted again on the next request
+	creds := instanceCredentials(instance, c.creds)
+	client, err := newClient(instance, c.region, creds, c.maxRetries, c.appName)
+	if err != nil {
+		log.Error("Failed to create minio client", "name", instance.Name, "instance", address, "error", err)
+		return nil, err
+	}
+
+	c.clients[address] = cachedClient{instance: instance, client: client, creds: creds}
+	metrics.ClientCacheSize.Set(float64(len(c.clients)))
+	return client, nil
+}
+
+// sameConnection reports whether a client built for one metadata of an instance connects like one built for the other
+// The name is compared too, since the credential provider looks the credentials up by name
+// The other fields, e.g. the weights, the zone or the registration time, only matter to the routing
+func sameConnection(a, b registry.ServiceMetadata) bool {
+	return a.Name == b.Name && a.Hostname == b.Hostname && a.IPAddress == b.IPAddress && a.AccessKey == b.AccessKey && a.SecretKey == b.SecretKey
+}
+
+// rotateCredentials makes the client of the instance with the given address fetch its credentials again, when the
+// provider has other ones than the client signs with, and reports whether it does
+func (c *clientCache) rotateCredentials(ctx context.Context, address string) (bool, error) {
+	c.mu.Lock()
+	cached, ok := c.clients[address]
+	c.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	// Expired credentials are fetched again by the next request anyway, which may have been denied with the stale ones
+	if cached.creds.IsExpired() {
+		return true, nil
+	}
+
+	current, err := cached.creds.Get()
+	if err != nil {
+		return false, err
+	}
+	latest, err := c.creds.Credentials(ctx, cached.instance.Name)
+	if err != nil {
+		return false, err
+	}
+
+	if latest.AccessKey == current.AccessKeyID && latest.SecretKey == current.SecretAccessKey && latest.SessionToken == current.SessionToken {
+		return false, nil
+	}
+
+	cached.creds.Expire()
+	return true, nil
+}
+
+// evict removes the client of the instance with the given address
+func (c *clientCache) evict(address string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictLocked(address)
+}
+
+func (c *clientCache) evictLocked(address string) {
+	if _, ok := c.clients[address]; !ok {
+		return
+	}
+
+	log.Debug("Evicting minio client", "instance", address)
+	delete(c.clients, address)
+	metrics.ClientCacheEvictions.Inc()
+	metrics.ClientCacheSize.Set(float64(len(c.clients)))
+}
+
+// instanceEndpoint returns the host and port the minio API of the instance listens on
+func instanceEndpoint(instance registry.ServiceMetadata) string {
+	return net.JoinHostPort(instance.Address(), "9000")
+}
+
+func newClient(instance registry.ServiceMetadata, region string, creds *credentials.Credentials, maxRetries int, appName string) (*minio.Client, error) {
+	client, err := minio.New(instanceEndpoint(instance), &minio.Options{
+		Creds:  creds,
+		Secure: false, // In production, we would use SSL
+		// Without a region, minio-go looks up the region of each bucket, and retries with it on a mismatch
+		Region: region,
+		// The gateway retries on its own, so stacking minio's retries on top of them lengthens the tail latencies
+		MaxRetries: maxRetries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create minio client: %w", err)
+	}
+
+	// The app info is appended to the user agent, which attributes the requests to the gateway in the minio access logs
+	if appName != "" {
+		client.SetAppInfo(appName, version.Version)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/dariusigna/object-storage/internal/version"
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClientCacheMetrics(t *testing.T) {
+	c := newClientCache(testRegion, nil, 1, "")
+	first := registry.ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1", AccessKey: "minio", SecretKey: "minio123"}
+	second := registry.ServiceMetadata{Name: "minio2", IPAddress: "10.0.0.2", AccessKey: "minio", SecretKey: "minio123"}
+	evictions := testutil.ToFloat64(metrics.ClientCacheEvictions)
+
+	check := func(step string, size, evicted float64) {
+		t.Helper()
+		if got := testutil.ToFloat64(metrics.ClientCacheSize); got != size {
+			t.Errorf("%s: cache size = %v, want %v", step, got, size)
+		}
+		if got := testutil.ToFloat64(metrics.ClientCacheEvictions) - evictions; got != evicted {
+			t.Errorf("%s: evictions = %v, want %v", step, got, evicted)
+		}
+	}
+
+	client, err := c.get(first)
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if _, err = c.get(second); err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	check("registered", 2, 0)
+
+	if reused, _ := c.get(first); reused != client {
+		t.Error("get() built a new client for an unchanged instance")
+	}
+	check("reused", 2, 0)
+
+	// The routing metadata, e.g. a re-weighting by free space, keeps the client
+	reweighted := first
+	reweighted.Weight, reweighted.CapacityWeight, reweighted.Zone = 50, 20, "eu"
+	if reused, _ := c.get(reweighted); reused != client {
+		t.Error("get() built a new client for an instance whose connection is unchanged")
+	}
+	check("re-weighted", 2, 0)
+
+	// Changed metadata replaces the stale client
+	rotated := first
+	rotated.SecretKey = "rotated"
+	if _, err = c.get(rotated); err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	check("rotated", 2, 1)
+
+	c.evict(second.Address())
+	c.evict("10.0.0.3")
+	check("deregistered", 1, 2)
+}
+
+func TestClientCacheDoesntCacheFailures(t *testing.T) {
+	c := newClientCache(testRegion, nil, 1, "")
+	// The hostname label of the instance is malformed, until the registrar picks up the fixed one
+	malformed := registry.ServiceMetadata{Name: "minio1", Hostname: "minio 1", IPAddress: "10.0.0.1", AccessKey: "minio", SecretKey: "minio123"}
+
+	for range 2 {
+		if _, err := c.get(malformed); err == nil {
+			t.Fatal("get() error = nil, want the construction of the client failing")
+		}
+		if len(c.clients) != 0 {
+			t.Fatalf("the failed client was cached: %v", c.clients)
+		}
+	}
+
+	fixed := malformed
+	fixed.Hostname = "minio1"
+	client, err := c.get(fixed)
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if reused, _ := c.get(fixed); reused != client {
+		t.Error("get() didn't cache the client built once the metadata was fixed")
+	}
+}
+
+func TestClientMaxRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		want       int32 // The requests expected for a failing stat
+	}{
+		{name: "retries disabled", maxRetries: 1, want: 1},
+		{name: "retries", maxRetries: 3, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			var requests atomic.Int32
+			instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Method != http.MethodHead {
+					return false
+				}
+				requests.Add(1)
+				w.WriteHeader(http.StatusInternalServerError)
+				return true
+			})
+
+			client, err := newClientCache(testRegion, &fakeCredentialProvider{}, tt.maxRetries, "").get(instance.service())
+			if err != nil {
+				t.Fatalf("get() error = %v", err)
+			}
+			if _, err = client.StatObject(context.Background(), "bucket", "id", minio.StatObjectOptions{}); err == nil {
+				t.Fatal("StatObject() error = nil, want the instance error")
+			}
+			if got := requests.Load(); got != tt.want {
+				t.Errorf("sent %d requests, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientAppInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		appName string
+		want    string // The app info expected in the user agent, empty if none
+	}{
+		{name: "app name", appName: "object-storage", want: "object-storage/" + version.Version},
+		{name: "no app name", appName: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			var userAgent atomic.Value
+			instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+				userAgent.Store(r.UserAgent())
+				return false
+			})
+
+			client, err := newClientCache(testRegion, &fakeCredentialProvider{}, 1, tt.appName).get(instance.service())
+			if err != nil {
+				t.Fatalf("get() error = %v", err)
+			}
+			_, _ = client.StatObject(context.Background(), "bucket", "id", minio.StatObjectOptions{})
+
+			got, _ := userAgent.Load().(string)
+			if !strings.HasPrefix(got, "MinIO (") {
+				t.Fatalf("user agent = %q, want the minio one", got)
+			}
+			if tt.want == "" && !strings.HasPrefix(got[strings.LastIndex(got, " ")+1:], "minio-go/") {
+				t.Errorf("user agent = %q, want no app info", got)
+			}
+			if tt.want != "" && !strings.HasSuffix(got, " "+tt.want) {
+				t.Errorf("user agent = %q, want it to end with %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm the object bodies are compressed with before being stored
+type Compression string
+
+const (
+	NoCompression   Compression = ""
+	GzipCompression Compression = "gzip"
+	ZstdCompression Compression = "zstd"
+)
+
+const (
+	// compressionMetadataKey is the user metadata recording the algorithm an object is stored with
+	compressionMetadataKey = "Object-Storage-Compression"
+	// originalSizeMetadataKey is the user metadata recording the size of a compressed object before compression
+	originalSizeMetadataKey = "Object-Storage-Original-Size"
+)
+
+// isReservedMetadata reports whether the user metadata key is managed by the gateway, rather than by the clients
+func isReservedMetadata(key string) bool {
+	return key == compressionMetadataKey || key == originalSizeMetadataKey || key == deletedMetadataKey || key == contentRefMetadataKey
+}
+
+// ParseCompression parses the name of a compression algorithm, "none" and an empty name disable the compression
+func ParseCompression(name string) (Compression, error) {
+	switch c := Compression(strings.ToLower(name)); c {
+	case NoCompression, "none":
+		return NoCompression, nil
+	case GzipCompression, ZstdCompression:
+		return c, nil
+	default:
+		return NoCompression, fmt.Errorf("unknown compression %q", name)
+	}
+}
+
+// compressedContentTypes are the content types that don't shrink when compressed again
+var compressedContentTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zstd":             true,
+	"application/zip":              true,
+	"application/x-7z-compressed":  true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+	"application/pdf":              true,
+}
+
+// isCompressedContentType reports whether the content is already compressed
+func isCompressedContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if mediaType == "image/svg+xml" {
+		return false
+	}
+
+	return compressedContentTypes[mediaType] ||
+		strings.HasPrefix(mediaType, "image/") ||
+		strings.HasPrefix(mediaType, "video/") ||
+		strings.HasPrefix(mediaType, "audio/")
+}
+
+// compress returns the body compressed with the algorithm
+// The body itself is returned, with false, when the compression doesn't make it smaller
+func compress(body Body, algorithm Compression) (Body, bool, error) {
+	var (
+		buf  bytes.Buffer
+		file *os.File
+		dst  io.Writer = &buf
+	)
+	// A body buffered to a file is too large for memory, so it is compressed to a file too
+	if _, ok := body.(*fileBody); ok {
+		var err error
+		if file, err = os.CreateTemp("", "object-storage-compressed-*"); err != nil {
+			return nil, false, fmt.Errorf("failed to create compression buffer file: %w", err)
+		}
+		dst = file
+	}
+
+	discard := func() {
+		if file != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}
+
+	if err := compressTo(dst, io.NewSectionReader(body, 0, body.Size()), algorithm); err != nil {
+		discard()
+		return nil, false, err
+	}
+
+	size := int64(buf.Len())
+	if file != nil {
+		info, err := file.Stat()
+		if err != nil {
+			discard()
+			return nil, false, fmt.Errorf("failed to stat compression buffer file: %w", err)
+		}
+		size = info.Size()
+	}
+
+	if size >= body.Size() {
+		discard()
+		return body, false, nil
+	}
+
+	if file != nil {
+		return NewFileBody(file, size, true), true, nil
+	}
+
+	return NewBytesBody(buf.Bytes()), true, nil
+}
+
+func compressTo(dst io.Writer, src io.Reader, algorithm Compression) error {
+	var (
+		w   io.WriteCloser
+		err error
+	)
+	switch algorithm {
+	case GzipCompression:
+		w = gzip.NewWriter(dst)
+	case ZstdCompression:
+		if w, err = zstd.NewWriter(dst); err != nil {
+			return fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown compression %q", algorithm)
+	}
+
+	if _, err = io.Copy(w, src); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to compress object: %w", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("failed to compress object: %w", err)
+	}
+
+	return nil
+}
+
+// decompress returns the original data of an object stored compressed with the algorithm
+func decompress(data []byte, algorithm Compression) ([]byte, error) {
+	switch algorithm {
+	case GzipCompression:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress object: %w", err)
+		}
+		defer r.Close()
+
+		decompressed, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress object: %w", err)
+		}
+		return decompressed, nil
+	case ZstdCompression:
+		r, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		defer r.Close()
+
+		decompressed, err := r.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress object: %w", err)
+		}
+		return decompressed, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+}
@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("a compressible object body ", 100))
+	tests := []struct {
+		name        string
+		compression Compression
+		contentType string
+		compressed  bool
+	}{
+		{name: "gzip", compression: GzipCompression, contentType: "text/plain", compressed: true},
+		{name: "zstd", compression: ZstdCompression, contentType: "text/plain", compressed: true},
+		{name: "disabled", compression: NoCompression, contentType: "text/plain"},
+		{name: "compressed content type", compression: GzipCompression, contentType: "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			storage, _ := newTestStorage(t, Options{Compression: tt.compression}, instance)
+
+			if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody(data), PutOptions{ContentType: tt.contentType}); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+
+			stored := instance.object("bucket", "id")
+			if got := len(stored.data) < len(data); got != tt.compressed {
+				t.Errorf("stored %d bytes of %d, want them compressed: %v", len(stored.data), len(data), tt.compressed)
+			}
+			want := ""
+			if tt.compressed {
+				want = string(tt.compression)
+			}
+			if got := stored.header.Get("X-Amz-Meta-" + compressionMetadataKey); got != want {
+				t.Errorf("compression metadata = %q, want %q", got, want)
+			}
+
+			object, err := storage.GetObject(context.Background(), "bucket", "id")
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			if string(object.Data) != string(data) || object.ContentType != tt.contentType {
+				t.Errorf("GetObject() = %d bytes of %q, want the original %d bytes", len(object.Data), object.ContentType, len(data))
+			}
+			for key := range object.UserMetadata {
+				if isReservedMetadata(key) {
+					t.Errorf("GetObject() exposed the reserved metadata %s", key)
+				}
+			}
+
+			info, err := storage.StatObject(context.Background(), "bucket", "id")
+			if err != nil {
+				t.Fatalf("StatObject() error = %v", err)
+			}
+			if info.Size != int64(len(data)) {
+				t.Errorf("StatObject() size = %d, want the original size %d", info.Size, len(data))
+			}
+		})
+	}
+}
+
+func TestCompressionOfAnIncompressibleBody(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	storage, _ := newTestStorage(t, Options{Compression: GzipCompression}, instance)
+
+	// A body that doesn't shrink is stored as is, without the compression metadata
+	if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("x")), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	stored := instance.object("bucket", "id")
+	if string(stored.data) != "x" || stored.header.Get("X-Amz-Meta-"+compressionMetadataKey) != "" {
+		t.Errorf("stored %q with headers %v, want the body as is", stored.data, stored.header)
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Compression
+		wantErr bool
+	}{
+		{name: "", want: NoCompression},
+		{name: "none", want: NoCompression},
+		{name: "GZIP", want: GzipCompression},
+		{name: "zstd", want: ZstdCompression},
+		{name: "brotli", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCompression(tt.name)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseCompression(%q) = %q, %v, want %q and an error: %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestIsCompressedContentType(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/gzip":          true,
+		"image/png":                 true,
+		"video/mp4":                 true,
+		"image/svg+xml":             false,
+		"text/plain; charset=utf-8": false,
+		"application/json":          false,
+		"":                          false,
+	} {
+		if got := isCompressedContentType(contentType); got != want {
+			t.Errorf("isCompressedContentType(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"sync"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/zeromicro/go-zero/core/syncx"
+)
+
+const (
+	// credentialRefresh is how long the credentials without an expiration are used before being fetched again
+	credentialRefresh = 5 * time.Minute
+	// credentialTimeout bounds a credential fetch, which happens while signing a request
+	credentialTimeout = 5 * time.Second
+	// instanceRefreshInterval is the least time between two reloads of the instances caused by the denied accesses of an instance
+	instanceRefreshInterval = 10 * time.Second
+)
+
+// Credentials are the minio credentials of an instance
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Expiration is when the credentials stop being valid, zero to fetch them again periodically
+	Expiration time.Time
+}
+
+// CredentialProvider fetches the minio credentials of the instances, by instance name, e.g. from a secrets manager
+// Without a provider, the static keys of the registered instances are used
+type CredentialProvider interface {
+	Credentials(ctx context.Context, name string) (Credentials, error)
+}
+
+// providerCredentials adapts a CredentialProvider to the minio credentials, which fetch them again once expired
+type providerCredentials struct {
+	credentials.Expiry
+	provider CredentialProvider
+	name     string
+}
+
+// Retrieve fetches the credentials of the instance from the provider
+func (p *providerCredentials) Retrieve() (credentials.Value, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialTimeout)
+	defer cancel()
+
+	creds, err := p.provider.Credentials(ctx, p.name)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to fetch the credentials of instance %s: %w", p.name, err)
+	}
+
+	expiration := creds.Expiration
+	if expiration.IsZero() {
+		expiration = time.Now().Add(credentialRefresh)
+	}
+	p.SetExpiration(expiration, 0)
+
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKey,
+		SecretAccessKey: creds.SecretKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      expiration,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// registryCredentials provides the static keys of the instances, as currently registered
+// Reading them from the registry, rather than from the metadata the client was built with, picks up the rotated keys
+type registryCredentials struct {
+	registry Registry
+}
+
+// Credentials returns the keys of the registered instance with the given name
+func (r registryCredentials) Credentials(_ context.Context, name string) (Credentials, error) {
+	for _, instance := range r.registry.GetAllServices() {
+		if instance.Name == name {
+			return Credentials{AccessKey: instance.AccessKey, SecretKey: instance.SecretKey}, nil
+		}
+	}
+
+	return Credentials{}, fmt.Errorf("instance %s is not registered", name)
+}
+
+// instanceCredentials returns the credentials the client of the instance signs with
+func instanceCredentials(instance registry.ServiceMetadata, provider CredentialProvider) *credentials.Credentials {
+	return credentials.New(&providerCredentials{provider: provider, name: instance.Name})
+}
+
+// isAccessDenied reports whether err is minio rejecting the credentials of a request
+func isAccessDenied(err error) bool {
+	var minioErr minio.ErrorResponse
+	if !errors.As(err, &minioErr) {
+		return false
+	}
+
+	switch minioErr.Code {
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+		return true
+	default:
+		return false
+	}
+}
+
+// credentialRefresher throttles the refreshes of the credentials caused by the denied accesses, per instance
+// The concurrent refreshes of an instance share a single one, and the instances are reloaded at most every interval
+type credentialRefresher struct {
+	flight   syncx.SingleFlight
+	mu       sync.Mutex
+	reloaded map[string]time.Time // When the instances were last reloaded for each instance address
+}
+
+func newCredentialRefresher() *credentialRefresher {
+	return &credentialRefresher{flight: syncx.NewSingleFlight(), reloaded: make(map[string]time.Time)}
+}
+
+// shouldReload reports whether the instances weren't reloaded for the instance within the interval, and records a reload if so
+func (c *credentialRefresher) shouldReload(address string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.reloaded[address]) < instanceRefreshInterval {
+		return false
+	}
+	c.reloaded[address] = time.Now()
+	return true
+}
+
+// refreshCredentials reloads the instances, so the rotated keys are registered, and reports whether the credentials
+// of the instance changed, in which case its client fetches them again on its next request
+// A denied access with unchanged credentials isn't worth a retry, the request is rather denied for its own reasons
+func (o *ObjectStorage) refreshCredentials(ctx context.Context, address string) bool {
+	rotated, _ := o.refresher.flight.Do(address, func() (any, error) {
+		if o.opts.RefreshInstances != nil && o.refresher.shouldReload(address) {
+			if err := o.opts.RefreshInstances(ctx); err != nil {
+				log.Warn("Failed to refresh the instances after a denied access", "instance", address, "error", err)
+			}
+		}
+
+		rotated, err := o.clients.rotateCredentials(ctx, address)
+		if err != nil {
+			log.Warn("Failed to check the credentials of the instance after a denied access", "instance", address, "error", err)
+		}
+		return rotated, nil
+	})
+
+	return rotated.(bool)
+}
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/registry"
+)
+
+// denyOtherKeys makes the instance deny the requests not signed with the access key returned by key
+func denyOtherKeys(instance *fakeInstance, key func() string) {
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential="+key()+"/") {
+			writeS3Error(w, http.StatusForbidden, "InvalidAccessKeyId", "", "")
+			return true
+		}
+		return false
+	})
+}
+
+// fakeCredentialProvider returns the credentials it is set with, like a secrets manager
+type fakeCredentialProvider struct {
+	mu      sync.Mutex
+	creds   Credentials
+	fetched []string // The names of the instances the credentials were fetched for
+}
+
+func (f *fakeCredentialProvider) set(creds Credentials) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.creds = creds
+}
+
+func (f *fakeCredentialProvider) Credentials(_ context.Context, name string) (Credentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetched = append(f.fetched, name)
+	return f.creds, nil
+}
+
+func TestCredentialProvider(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("data"), nil)
+	var accepted atomic.Value
+	accepted.Store("vault-1")
+	denyOtherKeys(instance, func() string { return accepted.Load().(string) })
+
+	// The first credentials expire as they are fetched, so the next signed request fetches them again
+	provider := &fakeCredentialProvider{}
+	provider.set(Credentials{AccessKey: "vault-1", SecretKey: "secret-1", Expiration: time.Now()})
+	storage, _ := newTestStorage(t, Options{Credentials: provider}, instance)
+	if _, err := storage.GetObject(context.Background(), "bucket", "id"); err != nil {
+		t.Fatalf("GetObject() error = %v, want the object read with the credentials of the provider", err)
+	}
+
+	// The rotated credentials are fetched once the previous ones expire, and kept while they are valid
+	provider.set(Credentials{AccessKey: "vault-2", SecretKey: "secret-2"})
+	accepted.Store("vault-2")
+	provider.mu.Lock()
+	fetched := len(provider.fetched)
+	provider.mu.Unlock()
+	for range 2 {
+		if _, err := storage.ObjectExists(context.Background(), "bucket", "id"); err != nil {
+			t.Fatalf("ObjectExists() after the rotation error = %v", err)
+		}
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if len(provider.fetched) != fetched+1 {
+		t.Errorf("the credentials were fetched %d times after the rotation, want once", len(provider.fetched)-fetched)
+	}
+	for _, name := range provider.fetched {
+		if name != instance.service().Name {
+			t.Errorf("the credentials were fetched for %v, want for %s", provider.fetched, instance.service().Name)
+			break
+		}
+	}
+}
+
+func TestGetObjectRecoversFromRotatedCredentials(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("data"), nil)
+
+	var (
+		accepted atomic.Value
+		reloads  atomic.Int32
+		r        *registry.Registry
+	)
+	accepted.Store("minio")
+	denyOtherKeys(instance, func() string { return accepted.Load().(string) })
+
+	// Reloading the instances registers the rotated keys, like the registrar does from the container environment
+	storage, r := newTestStorage(t, Options{RefreshInstances: func(context.Context) error {
+		reloads.Add(1)
+		rotated := instance.service()
+		rotated.AccessKey, rotated.SecretKey = "rotated", "rotated123"
+		r.RegisterService(rotated)
+		return nil
+	}}, instance)
+
+	if _, err := storage.GetObject(context.Background(), "bucket", "id"); err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+
+	accepted.Store("rotated")
+	object, err := storage.GetObject(context.Background(), "bucket", "id")
+	if err != nil {
+		t.Fatalf("GetObject() after the rotation error = %v", err)
+	}
+	if string(object.Data) != "data" || reloads.Load() != 1 {
+		t.Errorf("GetObject() = %q after %d reloads, want the object after a single reload", object.Data, reloads.Load())
+	}
+}
+
+func TestAccessDeniedWithUnchangedCredentials(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("data"), nil)
+	denyOtherKeys(instance, func() string { return "other" })
+
+	var reloads atomic.Int32
+	storage, _ := newTestStorage(t, Options{RefreshInstances: func(context.Context) error {
+		reloads.Add(1)
+		return nil
+	}}, instance)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if exists, err := storage.ObjectExists(context.Background(), "bucket", "id"); err == nil || exists {
+				t.Errorf("ObjectExists() = %v, %v, want the access denied", exists, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The denied requests share a single reload, and none of them is retried since the credentials didn't change
+	if got := reloads.Load(); got != 1 {
+		t.Errorf("the instances were reloaded %d times, want once", got)
+	}
+	if got := instance.requests.Load(); got != 10 {
+		t.Errorf("the instance got %d requests, want the 10 requests without a retry", got)
+	}
+}
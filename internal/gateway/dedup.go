@@ -0,0 +1,223 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// contentRefMetadataKey is the user metadata of a deduplicated object, holding the hash of the content it references
+	contentRefMetadataKey = "Object-Storage-Content-Ref"
+	// ContentBucket holds the deduplicated contents of each instance, keyed by their SHA-256 hash
+	// It is internal to the gateway, so the clients can't use it
+	ContentBucket = "object-storage-content"
+	// contentGracePeriod is how long an unreferenced content is kept after it was last written
+	// A write in progress may be about to reference it, the writes renew the contents they reuse
+	contentGracePeriod = time.Hour
+)
+
+// Dedup configures the deduplication of the contents, which stores the identical contents once per instance
+type Dedup struct {
+	// Enabled stores the objects as references to their content
+	Enabled bool
+	// SweepInterval is how often the contents no object references anymore are removed
+	SweepInterval time.Duration
+}
+
+// contentHash returns the hex encoded SHA-256 hash of the body
+func contentHash(body Body) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(body, 0, body.Size())); err != nil {
+		return "", fmt.Errorf("failed to hash object: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// putContent stores the content with the hash in the content bucket of the instance, unless the instance already has it
+// A content already stored is renewed instead, so the sweep doesn't remove it before the new reference is written
+// The content written by a concurrent write of the same hash is identical, so the race is harmless
+func putContent(ctx context.Context, minioInstance *minio.Client, hash string, body Body) error {
+	_, err := minioInstance.StatObject(ctx, ContentBucket, hash, minio.StatObjectOptions{})
+	if err == nil {
+		// Copying the content onto itself renews its modification time
+		_, err = minioInstance.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: ContentBucket, Object: hash, ReplaceMetadata: true},
+			minio.CopySrcOptions{Bucket: ContentBucket, Object: hash},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to renew content: %w", err)
+		}
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("failed to stat content: %w", err)
+	}
+
+	if _, err = putObject(ctx, minioInstance, ContentBucket, hash, body, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to store content: %w", err)
+	}
+
+	return nil
+}
+
+// getContent reads the content with the hash from the content bucket of the instance
+func getContent(ctx context.Context, minioInstance *minio.Client, hash string) ([]byte, error) {
+	object, err := minioInstance.GetObject(ctx, ContentBucket, hash, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get content %s: %w", hash, err)
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		// A missing content is a broken reference rather than a missing object, so it isn't a NotFoundError
+		return nil, fmt.Errorf("failed to read content %s: %w", hash, err)
+	}
+
+	info, err := object.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat content %s: %w", hash, err)
+	}
+
+	if int64(len(data)) != info.Size {
+		return nil, TruncatedError{Expected: info.Size, Read: int64(len(data))}
+	}
+
+	return data, nil
+}
+
+// SweepContents removes the contents no object references anymore every sweep interval, until ctx is done
+// The contents of the deleted and overwritten objects are left behind by the writes, so they are only removed here
+// It returns right away when deduplication is disabled
+func (o *ObjectStorage) SweepContents(ctx context.Context) {
+	if !o.opts.Dedup.Enabled || o.opts.Dedup.SweepInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(o.opts.Dedup.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.sweepContents(ctx)
+		}
+	}
+}
+
+// sweepContents removes the unreferenced contents from every instance
+func (o *ObjectStorage) sweepContents(ctx context.Context) {
+	for _, instance := range o.registry.GetAllServices() {
+		minioInstance, err := o.clients.get(instance)
+		if err != nil {
+			continue
+		}
+
+		removed, err := sweepInstanceContents(ctx, minioInstance)
+		if err != nil {
+			log.Error("Failed to sweep the unreferenced contents", "instance", instance.Address(), "error", err)
+		}
+		if removed > 0 {
+			log.Info("Removed the unreferenced contents", "instance", instance.Address(), "removed", removed)
+		}
+	}
+}
+
+// sweepInstanceContents removes the contents of the instance referenced by none of its objects
+// The references are only local to the instance, since an object references the content stored next to it
+// Nothing is removed when an object listing fails, since a missed reference would remove a live content
+func sweepInstanceContents(ctx context.Context, minioInstance *minio.Client) (int, error) {
+	// The contents are listed before the references, so a content written meanwhile isn't considered
+	contents, err := listObjects(ctx, minioInstance, ContentBucket, "", false)
+	if err != nil {
+		if isMissing(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(contents) == 0 {
+		return 0, nil
+	}
+
+	referenced, err := contentReferences(ctx, minioInstance)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		removed int
+		errs    []error
+	)
+	for _, content := range contents {
+		if _, ok := referenced[content.Key]; ok || time.Since(content.LastModified) < contentGracePeriod {
+			continue
+		}
+
+		if err = removeContent(ctx, minioInstance, content.Key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// contentReferences returns the hashes of the contents referenced by the objects of the instance
+func contentReferences(ctx context.Context, minioInstance *minio.Client) (map[string]struct{}, error) {
+	buckets, err := minioInstance.ListBuckets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	referenced := make(map[string]struct{})
+	for _, bucket := range buckets {
+		if bucket.Name == ContentBucket {
+			continue
+		}
+
+		objects, err := listObjects(ctx, minioInstance, bucket.Name, "", true)
+		if err != nil && !isMissing(err) {
+			return nil, err
+		}
+
+		for _, object := range objects {
+			if hash, ok := metadataValue(object.UserMetadata, contentRefMetadataKey); ok {
+				referenced[hash] = struct{}{}
+			}
+		}
+	}
+
+	return referenced, nil
+}
+
+// removeContent removes the content, checking first that no write renewed it since it was listed
+func removeContent(ctx context.Context, minioInstance *minio.Client, hash string) error {
+	info, err := minioInstance.StatObject(ctx, ContentBucket, hash, minio.StatObjectOptions{})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat content: %w", err)
+	}
+
+	if time.Since(info.LastModified) < contentGracePeriod {
+		return nil
+	}
+
+	if err = minioInstance.RemoveObject(ctx, ContentBucket, hash, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove content: %w", err)
+	}
+
+	return nil
+}
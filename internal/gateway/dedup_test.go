@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	storage, _ := newTestStorage(t, Options{Dedup: Dedup{Enabled: true}}, instance)
+	for _, id := range []string{"first", "second"} {
+		if _, err := storage.PutObject(context.Background(), "bucket", id, NewBytesBody([]byte("identical content")), PutOptions{}); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+	}
+
+	// The content is stored once, both objects reference it
+	hash, err := contentHash(NewBytesBody([]byte("identical content")))
+	if err != nil {
+		t.Fatalf("contentHash() error = %v", err)
+	}
+	if keys := instance.keys(ContentBucket); len(keys) != 1 || keys[0] != hash {
+		t.Fatalf("contents = %v, want the single content %s", keys, hash)
+	}
+	for _, id := range []string{"first", "second"} {
+		if stored := instance.object("bucket", id); stored == nil || string(stored.data) != hash {
+			t.Errorf("object %s stored as %q, want a reference to the content", id, stored.data)
+		}
+
+		object, err := storage.GetObject(context.Background(), "bucket", id)
+		if err != nil || string(object.Data) != "identical content" {
+			t.Errorf("GetObject(%s) = %q, %v, want the referenced content", id, object.Data, err)
+		}
+	}
+
+	// The listing reports the size of the content rather than the one of the reference
+	listing, err := storage.ListObjects(context.Background(), "bucket", ListOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	for _, object := range listing.Objects {
+		if object.Size != int64(len("identical content")) {
+			t.Errorf("listed size of %s = %d, want %d", object.Key, object.Size, len("identical content"))
+		}
+	}
+}
+
+func TestPutContentRenewsAStoredContent(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	storage, _ := newTestStorage(t, Options{Dedup: Dedup{Enabled: true}}, instance)
+	if _, err := storage.PutObject(context.Background(), "bucket", "first", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	hash := instance.keys(ContentBucket)[0]
+	instance.object(ContentBucket, hash).modified = time.Now().Add(-2 * contentGracePeriod)
+
+	// A write reusing the content keeps the sweep from removing it before its reference is written
+	if _, err := storage.PutObject(context.Background(), "bucket", "second", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if modified := instance.object(ContentBucket, hash).modified; time.Since(modified) > time.Minute {
+		t.Errorf("content last modified %v ago, want it renewed by the write", time.Since(modified))
+	}
+}
+
+func TestSweepContents(t *testing.T) {
+	tests := []struct {
+		name    string
+		age     time.Duration // How long ago the unreferenced content was last written
+		removed bool
+	}{
+		{name: "past the grace period", age: 2 * contentGracePeriod, removed: true},
+		{name: "within the grace period", age: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			storage, _ := newTestStorage(t, Options{Dedup: Dedup{Enabled: true}}, instance)
+			for _, id := range []string{"kept", "overwritten"} {
+				if _, err := storage.PutObject(context.Background(), "bucket", id, NewBytesBody([]byte(id)), PutOptions{}); err != nil {
+					t.Fatalf("PutObject() error = %v", err)
+				}
+			}
+			keptHash, _ := contentHash(NewBytesBody([]byte("kept")))
+			staleHash, _ := contentHash(NewBytesBody([]byte("overwritten")))
+
+			// The overwrite leaves the previous content unreferenced
+			if _, err := storage.PutObject(context.Background(), "bucket", "overwritten", NewBytesBody([]byte("new")), PutOptions{}); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			for _, hash := range []string{keptHash, staleHash} {
+				instance.object(ContentBucket, hash).modified = time.Now().Add(-tt.age)
+			}
+
+			storage.sweepContents(context.Background())
+			if removed := instance.object(ContentBucket, staleHash) == nil; removed != tt.removed {
+				t.Errorf("unreferenced content removed = %t, want %t", removed, tt.removed)
+			}
+			if instance.object(ContentBucket, keptHash) == nil {
+				t.Error("a referenced content was removed")
+			}
+			object, err := storage.GetObject(context.Background(), "bucket", "kept")
+			if err != nil || string(object.Data) != "kept" {
+				t.Errorf("GetObject() = %q, %v after the sweep, want the object", object.Data, err)
+			}
+		})
+	}
+}
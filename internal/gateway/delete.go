@@ -0,0 +1,277 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/notify"
+	"github.com/minio/minio-go/v7"
+)
+
+// deletedMetadataKey is the user metadata marking a soft deleted object, holding its deletion time
+const deletedMetadataKey = "Object-Storage-Deleted-At"
+
+// SoftDelete configures the soft delete of the objects, which keeps the deleted objects recoverable for a while
+type SoftDelete struct {
+	// Retention is how long a deleted object is kept before being purged, zero deletes the objects right away
+	Retention time.Duration
+	// SweepInterval is how often the deleted objects past their retention are purged
+	SweepInterval time.Duration
+}
+
+// DeletedError is returned when the object was soft deleted, it can still be restored until purged
+type DeletedError struct {
+	DeletedAt time.Time
+}
+
+// Error returns the error message
+func (d DeletedError) Error() string {
+	return fmt.Sprintf("object was deleted at %s", d.DeletedAt.Format(time.RFC3339))
+}
+
+// metadataValue returns the value of the user metadata key
+// The listings with metadata report the user metadata with their header prefix, which is accepted too
+func metadataValue(metadata map[string]string, key string) (string, bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(strings.TrimPrefix(http.CanonicalHeaderKey(k), "X-Amz-Meta-"), key) {
+			return v, true
+		}
+	}
+
+	return "", false
+}
+
+// deletedAt returns the deletion time of a soft deleted object from its metadata
+func deletedAt(metadata map[string]string) (time.Time, bool) {
+	v, ok := metadataValue(metadata, deletedMetadataKey)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		log.Warn("Ignoring invalid deletion time", "value", v, "error", err)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// DeleteObject deletes the object from all its replicas
+// With soft delete, the object is marked deleted instead, so it can be restored until it is purged
+// A delete event is published once the object is deleted
+func (o *ObjectStorage) DeleteObject(ctx context.Context, bucket, id string) error {
+	var size int64
+	err := o.updateReplicas(ctx, bucket, id, func(ctx context.Context, minioInstance *minio.Client, info minio.ObjectInfo) error {
+		size = info.Size
+		if originalSize, err := strconv.ParseInt(info.UserMetadata[originalSizeMetadataKey], 10, 64); err == nil {
+			size = originalSize // The stored size of a compressed object isn't the one of the object
+		}
+		if o.opts.SoftDelete.Retention <= 0 {
+			if err := minioInstance.RemoveObject(ctx, bucket, id, minio.RemoveObjectOptions{}); err != nil {
+				return fmt.Errorf("failed to remove object: %w", err)
+			}
+			return nil
+		}
+
+		if _, deleted := deletedAt(info.UserMetadata); deleted {
+			return NotFoundError{} // Deleting it again would extend its retention
+		}
+
+		return setDeleted(ctx, minioInstance, bucket, id, info, time.Now().UTC().Format(time.RFC3339))
+	})
+	if err != nil {
+		return err
+	}
+
+	o.publish(ctx, notify.Event{Bucket: bucket, ID: o.normalizeID(id), Operation: notify.Delete, Size: size})
+	return nil
+}
+
+// RestoreObject restores a soft deleted object which was not purged yet
+func (o *ObjectStorage) RestoreObject(ctx context.Context, bucket, id string) error {
+	return o.updateReplicas(ctx, bucket, id, func(ctx context.Context, minioInstance *minio.Client, info minio.ObjectInfo) error {
+		if _, deleted := deletedAt(info.UserMetadata); !deleted {
+			return NotFoundError{}
+		}
+
+		return setDeleted(ctx, minioInstance, bucket, id, info, "")
+	})
+}
+
+// updateReplicas calls update on every replica having the object, with its current info
+// It returns a NotFoundError when no replica has the object, or when update reports so for every replica
+func (o *ObjectStorage) updateReplicas(ctx context.Context, bucket, id string, update func(ctx context.Context, minioInstance *minio.Client, info minio.ObjectInfo) error) error {
+	id = o.normalizeID(id)
+	instances, err := o.matchInstances(ctx, o.routingKey(bucket, id), o.readCandidates(bucket))
+	if err != nil {
+		return err
+	}
+
+	minioInstances, err := o.getClients(ctx, id, instances)
+	if err != nil {
+		return err
+	}
+
+	var (
+		updated int
+		errs    []error
+	)
+	for _, minioInstance := range minioInstances {
+		_, err := withInstance(ctx, o, minioInstance, func() (struct{}, error) {
+			updateCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Delete)
+			defer cancel()
+
+			info, err := minioInstance.StatObject(updateCtx, bucket, id, minio.StatObjectOptions{})
+			if err != nil {
+				if isNotFound(err) {
+					return struct{}{}, notFoundError(err, bucket)
+				}
+				return struct{}{}, fmt.Errorf("failed to stat object: %w", err)
+			}
+			return struct{}{}, update(updateCtx, minioInstance, info)
+		})
+		switch {
+		case err == nil:
+			updated++
+		case isMissing(err):
+			continue
+		default:
+			log.Error("Replica update failed", "instance", minioInstance.EndpointURL().Host, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if updated == 0 {
+		return NotFoundError{}
+	}
+
+	return nil
+}
+
+// setDeleted sets the deletion time of the object, an empty time restores it
+// The metadata is replaced by copying the object onto itself
+func setDeleted(ctx context.Context, minioInstance *minio.Client, bucket, id string, info minio.ObjectInfo, at string) error {
+	metadata := make(map[string]string, len(info.UserMetadata)+1)
+	for k, v := range info.UserMetadata {
+		metadata[k] = v
+	}
+	delete(metadata, deletedMetadataKey)
+	if at != "" {
+		metadata[deletedMetadataKey] = at
+	}
+
+	dst := minio.CopyDestOptions{Bucket: bucket, Object: id, UserMetadata: copyMetadata(metadata, info), ReplaceMetadata: true}
+	if _, err := minioInstance.CopyObject(ctx, dst, minio.CopySrcOptions{Bucket: bucket, Object: id}); err != nil {
+		return fmt.Errorf("failed to mark object deleted: %w", err)
+	}
+
+	return nil
+}
+
+// SweepDeleted purges the deleted objects past their retention every sweep interval, until ctx is done
+// It returns right away when soft delete is disabled
+func (o *ObjectStorage) SweepDeleted(ctx context.Context) {
+	if o.opts.SoftDelete.Retention <= 0 || o.opts.SoftDelete.SweepInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(o.opts.SoftDelete.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.purgeDeleted(ctx)
+		}
+	}
+}
+
+// purgeDeleted removes the deleted objects past their retention from every instance
+func (o *ObjectStorage) purgeDeleted(ctx context.Context) {
+	for _, instance := range o.registry.GetAllServices() {
+		minioInstance, err := o.clients.get(instance)
+		if err != nil {
+			continue
+		}
+
+		purged, err := o.purgeInstance(ctx, minioInstance)
+		if err != nil {
+			log.Error("Failed to purge the deleted objects", "instance", instance.Address(), "error", err)
+		}
+		if purged > 0 {
+			log.Info("Purged the deleted objects past their retention", "instance", instance.Address(), "purged", purged)
+		}
+	}
+}
+
+func (o *ObjectStorage) purgeInstance(ctx context.Context, minioInstance *minio.Client) (int, error) {
+	buckets, err := minioInstance.ListBuckets(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	var (
+		purged int
+		errs   []error
+	)
+	for _, bucket := range buckets {
+		if bucket.Name == ContentBucket {
+			continue // The contents are never soft deleted
+		}
+
+		objects, err := listObjects(ctx, minioInstance, bucket.Name, "", true)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, object := range objects {
+			if at, deleted := deletedAt(object.UserMetadata); !deleted || time.Since(at) < o.opts.SoftDelete.Retention {
+				continue
+			}
+
+			if err = o.purgeObject(ctx, minioInstance, bucket.Name, object.Key); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			purged++
+		}
+	}
+
+	return purged, errors.Join(errs...)
+}
+
+// purgeObject removes the deleted object, checking first that it wasn't restored since it was listed
+func (o *ObjectStorage) purgeObject(ctx context.Context, minioInstance *minio.Client, bucket, id string) error {
+	purgeCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Delete)
+	defer cancel()
+
+	info, err := minioInstance.StatObject(purgeCtx, bucket, id, minio.StatObjectOptions{})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	if at, deleted := deletedAt(info.UserMetadata); !deleted || time.Since(at) < o.opts.SoftDelete.Retention {
+		return nil
+	}
+
+	if err = minioInstance.RemoveObject(purgeCtx, bucket, id, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove object: %w", err)
+	}
+
+	return nil
+}
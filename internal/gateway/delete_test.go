@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeleteObject(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	storage, _ := newTestStorage(t, Options{}, instance)
+	if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	if err := storage.DeleteObject(context.Background(), "bucket", "id"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	if instance.object("bucket", "id") != nil {
+		t.Errorf("the object was kept without soft delete")
+	}
+	if err := storage.DeleteObject(context.Background(), "bucket", "id"); !errors.Is(err, NotFoundError{}) {
+		t.Errorf("DeleteObject() of a deleted object error = %v, want a NotFoundError", err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	storage, _ := newTestStorage(t, Options{SoftDelete: SoftDelete{Retention: time.Hour}}, instance)
+	for _, id := range []string{"deleted", "kept"} {
+		if _, err := storage.PutObject(context.Background(), "bucket", id, NewBytesBody([]byte(id)), PutOptions{}); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+	}
+
+	if err := storage.DeleteObject(context.Background(), "bucket", "deleted"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	if instance.object("bucket", "deleted") == nil {
+		t.Fatalf("the soft deleted object was removed before its retention")
+	}
+	if _, err := storage.GetObject(context.Background(), "bucket", "deleted"); !errorIs[DeletedError](err) {
+		t.Errorf("GetObject() error = %v, want a DeletedError", err)
+	}
+	if _, err := storage.StatObject(context.Background(), "bucket", "deleted"); !errorIs[DeletedError](err) {
+		t.Errorf("StatObject() error = %v, want a DeletedError", err)
+	}
+	listing, err := storage.ListObjects(context.Background(), "bucket", ListOptions{})
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	if len(listing.Objects) != 1 || listing.Objects[0].Key != "kept" {
+		t.Errorf("ListObjects() = %+v, want only the object which wasn't deleted", listing.Objects)
+	}
+	// Deleting it again would extend its retention
+	if err = storage.DeleteObject(context.Background(), "bucket", "deleted"); !errors.Is(err, NotFoundError{}) {
+		t.Errorf("DeleteObject() of a deleted object error = %v, want a NotFoundError", err)
+	}
+
+	if err = storage.RestoreObject(context.Background(), "bucket", "deleted"); err != nil {
+		t.Fatalf("RestoreObject() error = %v", err)
+	}
+	object, err := storage.GetObject(context.Background(), "bucket", "deleted")
+	if err != nil || string(object.Data) != "deleted" {
+		t.Errorf("GetObject() after restore = %q, %v, want the restored object", object.Data, err)
+	}
+	if err = storage.RestoreObject(context.Background(), "bucket", "kept"); !errors.Is(err, NotFoundError{}) {
+		t.Errorf("RestoreObject() of an object which isn't deleted error = %v, want a NotFoundError", err)
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	tests := []struct {
+		name      string
+		deletedAt time.Time
+		purged    bool
+	}{
+		{name: "past the retention", deletedAt: time.Now().Add(-2 * time.Hour), purged: true},
+		{name: "within the retention", deletedAt: time.Now().Add(-time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			storage, _ := newTestStorage(t, Options{SoftDelete: SoftDelete{Retention: time.Hour}}, instance)
+			for _, id := range []string{"deleted", "kept"} {
+				if _, err := storage.PutObject(context.Background(), "bucket", id, NewBytesBody([]byte(id)), PutOptions{}); err != nil {
+					t.Fatalf("PutObject() error = %v", err)
+				}
+			}
+			if err := storage.DeleteObject(context.Background(), "bucket", "deleted"); err != nil {
+				t.Fatalf("DeleteObject() error = %v", err)
+			}
+			instance.object("bucket", "deleted").header.Set("X-Amz-Meta-"+deletedMetadataKey, tt.deletedAt.UTC().Format(time.RFC3339))
+
+			storage.purgeDeleted(context.Background())
+			if purged := instance.object("bucket", "deleted") == nil; purged != tt.purged {
+				t.Errorf("purged = %t, want %t", purged, tt.purged)
+			}
+			if instance.object("bucket", "kept") == nil {
+				t.Errorf("an object which wasn't deleted was purged")
+			}
+		})
+	}
+}
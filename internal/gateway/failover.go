@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/registry"
+)
+
+// FailoverCache configures the last known instances of the keys, which the reads fall back on when the ring is briefly empty
+type FailoverCache struct {
+	// TTL is how long the instances matched for a key are used as a fallback, zero disables the cache
+	TTL time.Duration
+	// Size is the maximum number of keys cached
+	Size int
+}
+
+// instanceCache keeps the last instances matched for the keys, a nil cache stores nothing
+type instanceCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]cachedInstances
+}
+
+type cachedInstances struct {
+	instances []registry.ServiceMetadata
+	stored    time.Time
+}
+
+func newInstanceCache(cache FailoverCache) *instanceCache {
+	if cache.TTL <= 0 || cache.Size <= 0 {
+		return nil
+	}
+
+	return &instanceCache{ttl: cache.TTL, size: cache.Size, entries: make(map[string]cachedInstances)}
+}
+
+// store records the instances matched for n replicas of the key
+func (c *instanceCache) store(key string, n int, instances []registry.ServiceMetadata) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cacheKey := instanceCacheKey(key, n)
+	if _, ok := c.entries[cacheKey]; !ok && len(c.entries) >= c.size {
+		c.evictLocked()
+	}
+	c.entries[cacheKey] = cachedInstances{instances: append([]registry.ServiceMetadata(nil), instances...), stored: time.Now()}
+}
+
+// load returns the instances last matched for n replicas of the key, unless they expired
+func (c *instanceCache) load(key string, n int) ([]registry.ServiceMetadata, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cacheKey := instanceCacheKey(key, n)
+	cached, ok := c.entries[cacheKey]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Since(cached.stored) > c.ttl {
+		delete(c.entries, cacheKey)
+		return nil, false
+	}
+
+	return cached.instances, true
+}
+
+// evictLocked makes room for a new entry by removing an arbitrary one, which is cheaper than tracking the oldest
+// The expired entries are removed when loaded, and the others are only useful for as long as their TTL anyway
+func (c *instanceCache) evictLocked() {
+	for cacheKey := range c.entries {
+		delete(c.entries, cacheKey)
+		return
+	}
+}
+
+func instanceCacheKey(key string, n int) string {
+	return strconv.Itoa(n) + "/" + key
+}
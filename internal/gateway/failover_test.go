@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstanceCache(t *testing.T) {
+	instances := []registry.ServiceMetadata{{IPAddress: "10.0.0.1"}}
+
+	cache := newInstanceCache(FailoverCache{TTL: time.Hour, Size: 1})
+	cache.store("key", 1, instances)
+	if cached, ok := cache.load("key", 1); !ok || len(cached) != 1 || cached[0].IPAddress != "10.0.0.1" {
+		t.Errorf("load() = %v, %v, want the stored instances", cached, ok)
+	}
+	if _, ok := cache.load("key", 2); ok {
+		t.Error("load() found the instances stored for another replica count")
+	}
+
+	// The cache is bounded, so storing another key evicts one
+	cache.store("other", 1, instances)
+	if _, ok := cache.load("key", 1); ok {
+		t.Error("load() found an entry past the size of the cache")
+	}
+
+	expiring := newInstanceCache(FailoverCache{TTL: time.Millisecond, Size: 1})
+	expiring.store("key", 1, instances)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := expiring.load("key", 1); ok {
+		t.Error("load() found an expired entry")
+	}
+
+	if newInstanceCache(FailoverCache{}) != nil {
+		t.Error("newInstanceCache() built a cache without a TTL")
+	}
+}
+
+func TestGetObjectFailsOverToTheLastKnownInstances(t *testing.T) {
+	tests := []struct {
+		name    string
+		cache   FailoverCache
+		wantErr bool
+	}{
+		{name: "cached", cache: FailoverCache{TTL: time.Minute, Size: 10}},
+		{name: "disabled", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			instance.put("bucket", "id", []byte("data"), nil)
+			storage, r := newTestStorage(t, Options{FailoverCache: tt.cache}, instance)
+			if _, err := storage.GetObject(context.Background(), "bucket", "id"); err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+
+			// The ring briefly empties, while the instance is still up
+			r.DeregisterService(instance.service().Address())
+			hits := testutil.ToFloat64(metrics.FailoverCacheHits)
+			object, err := storage.GetObject(context.Background(), "bucket", "id")
+			if tt.wantErr {
+				if err == nil {
+					t.Error("GetObject() error = nil, want the lookup failure without a failover cache")
+				}
+				return
+			}
+			if err != nil || string(object.Data) != "data" {
+				t.Fatalf("GetObject() = %q, %v, want the object read from the last known instance", object.Data, err)
+			}
+			if got := testutil.ToFloat64(metrics.FailoverCacheHits) - hits; got != 1 {
+				t.Errorf("failover cache hits = %v, want 1", got)
+			}
+
+			// The writes never go to an instance that may have left the ring
+			if _, err = storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("new")), PutOptions{}); err == nil {
+				t.Error("PutObject() error = nil, want the writes kept off the last known instances")
+			}
+		})
+	}
+}
@@ -1,23 +1,34 @@
 package gateway
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	log "log/slog"
+	"maps"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/avast/retry-go"
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/dariusigna/object-storage/internal/notify"
 	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/dariusigna/object-storage/internal/wal"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/zeromicro/go-zero/core/syncx"
 )
 
 type Registry interface {
-	MatchService(key string) (registry.ServiceMetadata, error)
+	MatchServices(key string, n int) ([]registry.ServiceMetadata, error)
+	MatchWritableServices(key string, n int) ([]registry.ServiceMetadata, error)
+	MatchPreviousServices(key string, n int) ([]registry.ServiceMetadata, error)
+	GetAllServices() []registry.ServiceMetadata
+	IsDraining(address string) bool
+	HashParams() hashring.Params
+	SetCapacityWeights(weights map[string]int, hash registry.Hasher) float64
 }
 
 // NotFoundError is returned when the object is not found in the object storage
@@ -29,96 +40,1136 @@ func (n NotFoundError) Error() string {
 	return "object not found"
 }
 
+// BucketNotFoundError is returned when the bucket of the object is not found in the object storage
+type BucketNotFoundError struct {
+	Bucket string
+}
+
+// Error returns the error message
+func (b BucketNotFoundError) Error() string {
+	return fmt.Sprintf("bucket %s not found", b.Bucket)
+}
+
+// Options configures the ObjectStorage
+type Options struct {
+	// FallbackBucket is the bucket an object is looked up in, on the same instance, when it is missing from the requested bucket
+	// An empty value disables the fallback
+	FallbackBucket string
+	// ReplicationFactor is the number of instances each object is written to
+	ReplicationFactor int
+	// BucketReplication overrides the replication factor of the listed buckets
+	BucketReplication map[string]int
+	// Publisher is sent an event for every object stored or deleted, nil to disable
+	Publisher notify.Publisher
+	// StrictReplication fails the writes when fewer instances than the replication factor are available
+	// Otherwise, the object is written to all the available instances, with a warning
+	StrictReplication bool
+	// ReplicationConcurrency is the maximum number of replica writes of an object in flight at once, zero for all of them
+	ReplicationConcurrency int
+	// PartSize is the size of the parts the large objects are uploaded in, zero for the minio default
+	// It must be between MinPartSize and MaxPartSize
+	PartSize uint64
+	// UploadThreads is the number of parts of an object uploaded at once to each replica, zero for the minio default
+	UploadThreads uint
+	// CapacityWeighting derives the weights of the instances from their free disk space, it is disabled by default
+	CapacityWeighting CapacityWeighting
+	// Credentials fetches the minio credentials of the instances, nil to use the static keys of the registered instances
+	Credentials CredentialProvider
+	// RefreshInstances reloads the registered instances, nil to skip
+	// It is called when an instance denies the access, at most once per instance every instanceRefreshInterval
+	RefreshInstances func(ctx context.Context) error
+	// WriteQuorum is the number of replicas that must acknowledge a write before it succeeds
+	WriteQuorum int
+	// FoldCase lowercases the object ids before routing and storing them, making them case-insensitive
+	FoldCase bool
+	// LargeObjectThreshold is the size hint from which objects are placed on the highest weight instances
+	// Zero disables the size-aware placement
+	LargeObjectThreshold int64
+	// LargeObjectCandidates is the number of ring successors, including the owner, large objects can be placed on
+	LargeObjectCandidates int
+	// WAL records the replicated writes in progress, so they can be recovered after a crash
+	// A nil value disables the write-ahead log
+	WAL *wal.Log
+	// Compression is the algorithm the objects are compressed with before being stored, the reads decompress them transparently
+	Compression Compression
+	// Timeouts bound each minio operation attempt, separately from the request deadline
+	Timeouts Timeouts
+	// KeyStrategy derives the consistent hash key of an object, it defaults to the object id
+	KeyStrategy KeyStrategy
+	// DrainingWrites is what happens to the writes of an object owned by a draining instance, it defaults to rerouting them
+	DrainingWrites DrainingWrites
+	// Region is the region the minio clients sign their requests with
+	// An empty value discovers the region of each bucket, and retries the requests sent to the wrong region
+	Region string
+	// ClientMaxRetries is the number of attempts of each request of the minio clients, including their own retries
+	// One disables the minio retries, leaving the retries to the gateway, zero keeps the minio default
+	ClientMaxRetries int
+	// ClientAppName is reported with the gateway version in the user agent of the minio clients, empty to keep the minio one
+	ClientAppName string
+	// DialCheckTimeout bounds a TCP dial of the matched instances, which skips the unreachable ones, zero to disable
+	// It catches the dead instances before a minio operation times out on them, at the cost of a dial per request
+	DialCheckTimeout time.Duration
+	// PreviousOwners is the number of ring successors past the replicas a missing object is looked up on, zero to disable
+	// After an instance joins, the objects it took over are still on these previous owners until migrated
+	PreviousOwners int
+	// MigrateOnRead writes the objects found on a previous owner to their current owners
+	MigrateOnRead bool
+	// RetryBudget bounds the instance lookup retries shared by all the requests, a zero capacity disables it
+	RetryBudget RetryBudget
+	// FailoverCache keeps the last instances matched for the keys, which the reads fall back on when none matches
+	FailoverCache FailoverCache
+	// InstanceLimit caps the concurrent operations sent to each instance, a zero maximum disables it
+	InstanceLimit InstanceLimit
+	// ReadRepair checks the replicas of every object read, and writes the object to the ones missing it in the background
+	ReadRepair bool
+	// Zone is the zone of the gateway, the reads try the replicas in this zone first, empty to keep the ring order
+	Zone string
+	// SoftDelete keeps the deleted objects recoverable for a retention period, a zero retention deletes them right away
+	SoftDelete SoftDelete
+	// Dedup stores the identical contents once per instance, the objects holding a reference to their content
+	// The contents left unreferenced by the deletes and overwrites are removed by SweepContents
+	Dedup Dedup
+}
+
+// Timeouts are the per-operation timeouts of the minio calls, zero leaves an operation bounded by the request only
+type Timeouts struct {
+	// Stat bounds the existence and metadata checks, which should be fast
+	Stat time.Duration
+	// Get bounds an object read, including its transfer
+	Get time.Duration
+	// Put bounds an object write to a replica, including its transfer
+	Put time.Duration
+	// Delete bounds an object deletion or restoration on a replica
+	Delete time.Duration
+}
+
+// PutOptions are the per-request options of PutObject
+type PutOptions struct {
+	// SizeHint is the expected size of the object, used for the size-aware placement
+	SizeHint int64
+	// ContentType is the media type of the object, already compressed content is stored as is
+	ContentType string
+	// Headers are the StoredHeaders to store with the object, the other headers are ignored
+	Headers http.Header
+	// UserMetadata is the user metadata to store with the object
+	UserMetadata map[string]string
+}
+
+// Object is an object read from the object storage
+type Object struct {
+	Data        []byte
+	ContentType string
+	// Headers are the StoredHeaders stored with the object
+	Headers http.Header
+	// UserMetadata is the user metadata stored with the object
+	UserMetadata map[string]string
+}
+
+// clone returns a copy of the object whose headers and user metadata can be changed by the caller
+// The data is shared, it is never written to once read
+func (o Object) clone() Object {
+	o.Headers = o.Headers.Clone()
+	o.UserMetadata = maps.Clone(o.UserMetadata)
+	return o
+}
+
+// PutResult describes the object stored by PutObject
+type PutResult struct {
+	// ETag is the entity tag of the object, as reported by the first replica written
+	ETag string
+	// Size is the size of the object as uploaded, before any compression
+	Size int64
+}
+
+// TruncatedError is returned when the data read from the object storage doesn't match the object size
+type TruncatedError struct {
+	Expected int64
+	Read     int64
+}
+
+// Error returns the error message
+func (t TruncatedError) Error() string {
+	return fmt.Sprintf("object truncated: read %d of %d bytes", t.Read, t.Expected)
+}
+
+// RegionMismatchError is returned when a bucket is in another region than the one the gateway is configured with
+type RegionMismatchError struct {
+	Bucket string
+	Region string // The region of the bucket, empty if the backend didn't tell
+}
+
+// Error returns the error message
+func (r RegionMismatchError) Error() string {
+	if r.Region == "" {
+		return fmt.Sprintf("bucket %s is not in the configured region", r.Bucket)
+	}
+
+	return fmt.Sprintf("bucket %s is in region %s, not in the configured region", r.Bucket, r.Region)
+}
+
+// SlowDownError is returned when the object storage keeps asking to slow down after retrying
+type SlowDownError struct {
+	RetryAfter time.Duration
+}
+
+// Error returns the error message
+func (s SlowDownError) Error() string {
+	return fmt.Sprintf("object storage is overloaded, retry after %s", s.RetryAfter)
+}
+
+// InsufficientReplicasError is returned by a strict replication when fewer instances than the replication factor are available
+type InsufficientReplicasError struct {
+	Factor    int
+	Available int
+}
+
+// Error returns the error message
+func (i InsufficientReplicasError) Error() string {
+	return fmt.Sprintf("replication factor of %d can't be met with %d available instances", i.Factor, i.Available)
+}
+
+// InsufficientStorageError is returned when a write fails because the object storage is out of space or over its quota
+type InsufficientStorageError struct{}
+
+// Error returns the error message
+func (i InsufficientStorageError) Error() string {
+	return "object storage is out of space"
+}
+
+// storageFullCodes are the minio error codes of the writes rejected for lack of space
+var storageFullCodes = map[string]bool{
+	"XMinioStorageFull":              true,
+	"XMinioAdminBucketQuotaExceeded": true,
+	"QuotaExceeded":                  true,
+}
+
+// sharedFetchTimeout bounds a shared fetch started by a request without a deadline
+const sharedFetchTimeout = 5 * time.Minute
+
+const (
+	slowDownAttempts = 3
+	slowDownDelay    = 500 * time.Millisecond
+	// slowDownRetryAfter is suggested to clients, since minio-go doesn't expose the backend's Retry-After header
+	slowDownRetryAfter = 2 * time.Second
+)
+
 // ObjectStorage is a gateway to the object storage
 type ObjectStorage struct {
-	registry Registry
+	registry  Registry
+	clients   *clientCache
+	retries   *retryBudget
+	lastKnown *instanceCache
+	limiter   *instanceLimiter
+	reads     syncx.SingleFlight // Coalesces the concurrent reads of the same object into a single backend fetch
+	refresher *credentialRefresher
+	opts      Options
 }
 
 // NewObjectStorage creates a new ObjectStorage instance
-func NewObjectStorage(registry Registry) (*ObjectStorage, error) {
-	return &ObjectStorage{registry: registry}, nil
+func NewObjectStorage(registry Registry, opts Options) (*ObjectStorage, error) {
+	if opts.Publisher == nil {
+		opts.Publisher = notify.Nop{}
+	}
+	if opts.Credentials == nil {
+		opts.Credentials = registryCredentials{registry: registry}
+	}
+
+	return &ObjectStorage{
+		registry:  registry,
+		clients:   newClientCache(opts.Region, opts.Credentials, opts.ClientMaxRetries, opts.ClientAppName),
+		retries:   newRetryBudget(opts.RetryBudget),
+		lastKnown: newInstanceCache(opts.FailoverCache),
+		limiter:   newInstanceLimiter(opts.InstanceLimit),
+		reads:     syncx.NewSingleFlight(),
+		refresher: newCredentialRefresher(),
+		opts:      opts,
+	}, nil
 }
 
 // GetObject retrieves the object from the object storage
-func (o *ObjectStorage) GetObject(ctx context.Context, bucket, id string) ([]byte, error) {
-	minioInstance, err := o.getMatchingInstance(id)
+// The replicas of the object are tried in order, the ones in the local zone first, until one of them has the object
+// When none has it, the previous owners of the object are tried too, if enabled or while the ring is migrated
+// When read repair is enabled, the replicas missing an object that was read are repaired in the background
+// The concurrent reads of the same object share a single backend fetch, and its result
+func (o *ObjectStorage) GetObject(ctx context.Context, bucket, id string) (Object, error) {
+	id = o.normalizeID(id)
+	done := make(chan fetchResult, 1)
+	go func() {
+		result, fresh, err := o.reads.DoEx(bucket+"/"+id, func() (any, error) {
+			// The fetch isn't canceled with the request that started it, since the other requests may still wait for it
+			// It keeps the deadline of the request though, or gets one, so a fetch nobody waits for anymore still ends
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				deadline = time.Now().Add(sharedFetchTimeout)
+			}
+			fetchCtx, cancel := context.WithDeadline(context.WithoutCancel(ctx), deadline)
+			defer cancel()
+			return o.fetchObject(fetchCtx, bucket, id)
+		})
+		if !fresh {
+			metrics.CoalescedReads.Inc()
+		}
+		object, _ := result.(Object)
+		done <- fetchResult{object: object, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return Object{}, ctx.Err()
+	case result := <-done:
+		// Every request gets its own headers and metadata, since the object is shared by the coalesced reads
+		return result.object.clone(), result.err
+	}
+}
+
+// fetchResult is the outcome of a backend fetch shared by concurrent reads
+type fetchResult struct {
+	object Object
+	err    error
+}
+
+// fetchObject reads the object from its replicas, or else from its previous owners
+func (o *ObjectStorage) fetchObject(ctx context.Context, bucket, id string) (Object, error) {
+	key := o.routingKey(bucket, id)
+	instances, err := o.matchInstances(ctx, key, o.readCandidates(bucket))
+	if err != nil {
+		return Object{}, err
+	}
+
+	object, err := o.readReplicas(ctx, o.preferLocal(instances), bucket, id)
+	if err == nil && o.opts.ReadRepair && o.replicationFactor(bucket) > 1 {
+		go o.repair(context.WithoutCancel(ctx), bucket, id, object)
+	}
+	if !isMissing(err) {
+		return object, err
+	}
+
+	previous, prevErr := o.previousOwners(ctx, key, instances)
+	if prevErr != nil || len(previous) == 0 {
+		return Object{}, err
+	}
+
+	object, prevErr = o.readReplicas(ctx, previous, bucket, id)
+	if prevErr != nil {
+		if !isMissing(prevErr) {
+			log.Warn("Failed to read the object from its previous owners", "bucket", bucket, "id", id, "error", prevErr)
+		}
+		return Object{}, err
+	}
+
+	log.Info("Served the object from a previous owner", "bucket", bucket, "id", id)
+	if o.opts.MigrateOnRead {
+		go o.migrate(context.WithoutCancel(ctx), bucket, id, object)
+	}
+	return object, nil
+}
+
+// readReplicas reads the object from the first of the instances that has it
+func (o *ObjectStorage) readReplicas(ctx context.Context, instances []registry.ServiceMetadata, bucket, id string) (Object, error) {
+	minioInstances, err := o.getClients(ctx, id, instances)
+	if err != nil {
+		return Object{}, err
+	}
+
+	var (
+		errs          []error
+		deleted       error
+		bucketMissing = true // Whether every replica reported the bucket missing, rather than only the object
+	)
+	for _, minioInstance := range minioInstances {
+		object, err := o.getObjectWithFallback(ctx, minioInstance, bucket, id)
+		if err == nil {
+			return object, nil
+		}
+
+		// Another replica may not have the deletion yet, but the deletion is the latest change
+		if isDeleted(err) {
+			deleted = err
+			continue
+		}
+
+		if errors.Is(err, NotFoundError{}) {
+			bucketMissing = false
+			continue
+		}
+
+		if !isMissing(err) {
+			log.Error("Replica read failed", "instance", minioInstance.EndpointURL().Host, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	if deleted != nil {
+		return Object{}, deleted
+	}
+
+	if len(errs) > 0 {
+		return Object{}, errors.Join(errs...)
+	}
+
+	if bucketMissing {
+		return Object{}, BucketNotFoundError{Bucket: bucket}
+	}
+
+	return Object{}, NotFoundError{}
+}
+
+// previousOwners returns the ring successors following the current owners of the key, and its owners on the previous ring
+// When an instance joins the ring, it takes over keys from its successors, which is where they are still stored until migrated
+// Likewise, while the ring is migrated, the objects are still stored on their owners on the previous ring until moved
+func (o *ObjectStorage) previousOwners(ctx context.Context, key string, owners []registry.ServiceMetadata) ([]registry.ServiceMetadata, error) {
+	var instances []registry.ServiceMetadata
+	if o.opts.PreviousOwners > 0 {
+		successors, err := o.matchInstances(ctx, key, len(owners)+o.opts.PreviousOwners)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, successors...) // A copy, the matched instances may be shared with the failover cache
+	}
+
+	previousRing, err := o.registry.MatchPreviousServices(key, len(owners))
 	if err != nil {
 		return nil, err
 	}
+	instances = append(instances, previousRing...)
+
+	seen := make(map[string]struct{}, len(owners)+len(instances))
+	for _, owner := range owners {
+		seen[owner.Address()] = struct{}{}
+	}
+
+	var previous []registry.ServiceMetadata
+	for _, instance := range instances {
+		if _, ok := seen[instance.Address()]; !ok {
+			seen[instance.Address()] = struct{}{}
+			previous = append(previous, instance)
+		}
+	}
+
+	return previous, nil
+}
+
+// migrate writes the object found on a previous owner to its current owners
+// The previous copy is kept, it is removed by the usual cleanup of the previous owner
+func (o *ObjectStorage) migrate(ctx context.Context, bucket, id string, object Object) {
+	_, err := o.writeObject(ctx, bucket, id, NewBytesBody(object.Data), PutOptions{
+		SizeHint:     int64(len(object.Data)),
+		ContentType:  object.ContentType,
+		Headers:      object.Headers,
+		UserMetadata: object.UserMetadata,
+	})
+	if err != nil {
+		log.Error("Failed to migrate the object to its current owners", "bucket", bucket, "id", id, "error", err)
+		return
+	}
+
+	log.Info("Migrated the object to its current owners", "bucket", bucket, "id", id)
+}
+
+// ObjectExists reports whether any replica of the object has it
+// The fallback bucket is not consulted, since writes never go there
+func (o *ObjectStorage) ObjectExists(ctx context.Context, bucket, id string) (bool, error) {
+	id = o.normalizeID(id)
+	instances, err := o.matchInstances(ctx, o.routingKey(bucket, id), o.readCandidates(bucket))
+	if err != nil {
+		return false, err
+	}
+
+	minioInstances, err := o.getClients(ctx, id, instances)
+	if err != nil {
+		return false, err
+	}
+
+	var errs []error
+	for _, minioInstance := range minioInstances {
+		info, err := withInstance(ctx, o, minioInstance, func() (minio.ObjectInfo, error) {
+			statCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Stat)
+			defer cancel()
+			return minioInstance.StatObject(statCtx, bucket, id, minio.StatObjectOptions{})
+		})
+		if err == nil {
+			if _, deleted := deletedAt(info.UserMetadata); deleted {
+				continue
+			}
+			return true, nil
+		}
+
+		if !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to stat object: %w", err))
+		}
+	}
+
+	// A replica that couldn't be checked might have the object
+	if len(errs) > 0 {
+		return false, errors.Join(errs...)
+	}
+
+	return false, nil
+}
+
+func (o *ObjectStorage) getObjectWithFallback(ctx context.Context, minioInstance *minio.Client, bucket, id string) (Object, error) {
+	object, err := withInstance(ctx, o, minioInstance, func() (Object, error) {
+		getCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Get)
+		defer cancel()
+		return getObject(getCtx, minioInstance, bucket, id)
+	})
+	if isMissing(err) && o.opts.FallbackBucket != "" && o.opts.FallbackBucket != bucket {
+		log.Debug("Object not found, trying the fallback bucket", "bucket", bucket, "fallback_bucket", o.opts.FallbackBucket, "id", id)
+		return withInstance(ctx, o, minioInstance, func() (Object, error) {
+			getCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Get)
+			defer cancel()
+			return getObject(getCtx, minioInstance, o.opts.FallbackBucket, id)
+		})
+	}
 
+	return object, err
+}
+
+func getObject(ctx context.Context, minioInstance *minio.Client, bucket, id string) (Object, error) {
 	object, err := minioInstance.GetObject(ctx, bucket, id, minio.GetObjectOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get object: %w", err)
+		return Object{}, fmt.Errorf("failed to get object: %w", err)
 	}
 	defer object.Close()
 
 	data, err := io.ReadAll(object)
 	if err != nil {
-		var minioErr minio.ErrorResponse
-		if errors.As(err, &minioErr) && minioErr.StatusCode == http.StatusNotFound {
-			return nil, NotFoundError{}
+		if isNotFound(err) {
+			return Object{}, notFoundError(err, bucket)
+		}
+		return Object{}, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	// A dropped backend connection can end the read early without an error, so the read size is checked
+	info, err := object.Stat()
+	if err != nil {
+		return Object{}, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	if int64(len(data)) != info.Size {
+		return Object{}, TruncatedError{Expected: info.Size, Read: int64(len(data))}
+	}
+
+	if at, deleted := deletedAt(info.UserMetadata); deleted {
+		return Object{}, DeletedError{DeletedAt: at}
+	}
+
+	if hash := info.UserMetadata[contentRefMetadataKey]; hash != "" {
+		if data, err = getContent(ctx, minioInstance, hash); err != nil {
+			return Object{}, err
+		}
+	}
+
+	if algorithm := Compression(info.UserMetadata[compressionMetadataKey]); algorithm != NoCompression {
+		if data, err = decompress(data, algorithm); err != nil {
+			return Object{}, err
 		}
-		return nil, fmt.Errorf("failed to read object: %w", err)
 	}
 
-	return data, nil
+	userMetadata := make(map[string]string, len(info.UserMetadata))
+	for k, v := range info.UserMetadata {
+		if !isReservedMetadata(k) {
+			userMetadata[k] = v
+		}
+	}
+
+	return Object{Data: data, ContentType: info.ContentType, Headers: storedHeaders(info), UserMetadata: userMetadata}, nil
 }
 
 // PutObject stores the object in the object storage
-func (o *ObjectStorage) PutObject(ctx context.Context, bucket, id string, data []byte) error {
-	minioInstance, err := o.getMatchingInstance(id)
+// The object is written to all its replicas concurrently, and the call returns as soon as the write quorum is reached
+// The remaining writes complete in the background
+// PutObject takes ownership of the body, and closes it once every replica write completed
+// A put event is published once the object is stored
+func (o *ObjectStorage) PutObject(ctx context.Context, bucket, id string, body Body, opts PutOptions) (PutResult, error) {
+	result, err := o.writeObject(ctx, bucket, id, body, opts)
+	if err != nil {
+		return result, err
+	}
+
+	o.publish(ctx, notify.Event{Bucket: bucket, ID: o.normalizeID(id), Operation: notify.Put, Size: result.Size})
+	return result, nil
+}
+
+// writeObject writes the object to its replicas, see PutObject
+func (o *ObjectStorage) writeObject(ctx context.Context, bucket, id string, body Body, opts PutOptions) (PutResult, error) {
+	closeBody := true // Until the replica writes take over the body
+	defer func() {
+		if closeBody {
+			body.Close()
+		}
+	}()
+
+	id = o.normalizeID(id)
+	if err := o.checkDrainingOwner(ctx, o.routingKey(bucket, id)); err != nil {
+		return PutResult{}, err
+	}
+
+	minioInstances, err := o.getWriteClients(ctx, bucket, id, opts.SizeHint)
+	if err != nil {
+		return PutResult{}, err
+	}
+
+	// With fewer instances than the replication factor, the write either fails or goes to all of them
+	quorum := o.writeQuorum(bucket)
+	if factor := o.replicationFactor(bucket); len(minioInstances) < factor {
+		if o.opts.StrictReplication {
+			return PutResult{}, InsufficientReplicasError{Factor: factor, Available: len(minioInstances)}
+		}
+
+		log.Warn("Fewer instances than the replication factor, writing to all of them", "bucket", bucket, "id", id, "replication_factor", factor, "instances", len(minioInstances))
+		quorum = min(quorum, len(minioInstances))
+	}
+
+	size := body.Size()
+	stored, putOpts, err := o.prepareWrite(body, opts)
+	if err != nil {
+		return PutResult{}, err
+	}
+	body = stored
+
+	if o.opts.WAL == nil {
+		closeBody = false
+		etag, err := o.replicate(ctx, minioInstances, bucket, id, body, putOpts, quorum, func(int) {
+			body.Close()
+		})
+		return PutResult{ETag: etag, Size: size}, err
+	}
+
+	entry, err := o.opts.WAL.Begin(wal.Entry{
+		Bucket:      bucket,
+		Object:      id,
+		ContentType: putOpts.ContentType,
+		Metadata:    putOpts.UserMetadata,
+		Headers:     headerMap(opts.Headers),
+	}, body, body.Size())
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to log the write: %w", err)
+	}
+
+	closeBody = false
+	etag, err := o.replicate(ctx, minioInstances, bucket, id, body, putOpts, quorum, func(failed int) {
+		body.Close()
+		if failed > 0 {
+			log.Warn("Keeping the write in the write-ahead log for recovery", "bucket", bucket, "id", id, "failed_replicas", failed)
+			return
+		}
+
+		if err := o.opts.WAL.Commit(entry); err != nil {
+			log.Error("Failed to commit the write-ahead log entry", "entry", entry.ID, "error", err)
+		}
+	})
+	return PutResult{ETag: etag, Size: size}, err
+}
+
+// Recover completes the replicated writes interrupted by a crash, as recorded in the write-ahead log
+// The writes are replayed to all the replicas, and the ones failing again are kept for the next recovery
+// Only the latest write of each object is replayed, the earlier ones would overwrite it with stale data
+func (o *ObjectStorage) Recover(ctx context.Context) error {
+	if o.opts.WAL == nil {
+		return nil
+	}
+
+	entries, err := o.opts.WAL.Pending()
+	if err != nil {
+		return err
+	}
+
+	// The entries are sorted by generation, so the last one of each object is its latest write
+	latest := make(map[string]wal.Entry, len(entries))
+	for _, entry := range entries {
+		latest[entry.Key()] = entry
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if latest[entry.Key()].Supersedes(entry) {
+			log.Info("Dropping a superseded interrupted write", "bucket", entry.Bucket, "id", entry.Object, "entry", entry.ID)
+			if err = o.opts.WAL.Commit(entry); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if err = o.recoverEntry(ctx, entry); err != nil {
+			log.Error("Failed to recover interrupted write", "bucket", entry.Bucket, "id", entry.Object, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	log.Info("Recovered interrupted writes", "recovered", len(entries)-len(errs), "failed", len(errs))
+	return errors.Join(errs...)
+}
+
+func (o *ObjectStorage) recoverEntry(ctx context.Context, entry wal.Entry) error {
+	file, size, err := o.opts.WAL.Data(entry)
+	if err != nil {
+		return err
+	}
+
+	body := NewFileBody(file, size, false)
+	defer body.Close()
+
+	minioInstances, err := o.getWriteClients(ctx, entry.Bucket, entry.Object, size)
 	if err != nil {
 		return err
 	}
 
+	putOpts := minio.PutObjectOptions{ContentType: entry.ContentType, UserMetadata: entry.Metadata}
+	headers := make(http.Header)
+	for name, value := range entry.Headers {
+		headers.Set(name, value)
+	}
+	applyStoredHeaders(&putOpts, headers)
+	if _, err = o.replicate(ctx, minioInstances, entry.Bucket, entry.Object, body, putOpts, len(minioInstances), nil); err != nil {
+		return err
+	}
+
+	return o.opts.WAL.Commit(entry)
+}
+
+// prepareWrite returns the body to store and the minio options of an object, compressing the body if enabled
+// When the body is compressed, the original one is closed and the compressed one is returned instead
+// With deduplication, the hash of the stored body is recorded in the metadata, for the replica writes to reference it
+func (o *ObjectStorage) prepareWrite(body Body, opts PutOptions) (Body, minio.PutObjectOptions, error) {
+	putOpts := minio.PutObjectOptions{ContentType: opts.ContentType, UserMetadata: make(map[string]string, len(opts.UserMetadata)+3)}
+	for k, v := range opts.UserMetadata {
+		putOpts.UserMetadata[k] = v
+	}
+	applyStoredHeaders(&putOpts, opts.Headers)
+	// Content with its own encoding is stored as is, since its readers expect that encoding
+	if o.opts.Compression != NoCompression && !isCompressedContentType(opts.ContentType) && putOpts.ContentEncoding == "" {
+		compressed, ok, err := compress(body, o.opts.Compression)
+		if err != nil {
+			return nil, minio.PutObjectOptions{}, err
+		}
+
+		if ok {
+			putOpts.UserMetadata[originalSizeMetadataKey] = strconv.FormatInt(body.Size(), 10)
+			body.Close()
+			body = compressed
+			putOpts.UserMetadata[compressionMetadataKey] = string(o.opts.Compression)
+		}
+	}
+
+	if o.opts.Dedup.Enabled {
+		hash, err := contentHash(body)
+		if err != nil {
+			return nil, minio.PutObjectOptions{}, err
+		}
+
+		// The stored size of a reference is the one of the hash, so the size of the object is recorded like a compressed one
+		if _, ok := putOpts.UserMetadata[originalSizeMetadataKey]; !ok {
+			putOpts.UserMetadata[originalSizeMetadataKey] = strconv.FormatInt(body.Size(), 10)
+		}
+		putOpts.UserMetadata[contentRefMetadataKey] = hash
+	}
+
+	return body, putOpts, nil
+}
+
+// replicate writes the object to the instances concurrently, at most ReplicationConcurrency at a time if set
+// It returns once quorum writes succeeded, or as soon as the quorum can no longer be reached
+// When ctx is done before the quorum is reached, e.g. the client disconnected, the writes in flight are cancelled
+// The ETag of the first successful write is returned
+// If not nil, done is called with the number of failed writes once every write completed
+func (o *ObjectStorage) replicate(ctx context.Context, minioInstances []*minio.Client, bucket, id string, body Body, opts minio.PutObjectOptions, quorum int, done func(failed int)) (string, error) {
+	type writeResult struct {
+		etag string
+		err  error
+	}
+
+	opts.PartSize, opts.NumThreads = o.opts.PartSize, o.opts.UploadThreads
+
+	// The writes outliving the quorum must not be cancelled when the request completes, but they don't outlive its deadline
+	writeCtx, cancelWrites := withRequestDeadline(ctx)
+	var slots chan struct{}
+	if o.opts.ReplicationConcurrency > 0 {
+		slots = make(chan struct{}, o.opts.ReplicationConcurrency)
+	}
+	results := make(chan writeResult, len(minioInstances))
+	for _, minioInstance := range minioInstances {
+		go func() {
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-writeCtx.Done():
+					results <- writeResult{err: writeCtx.Err()}
+					return
+				}
+			}
+
+			etag, err := withInstance(writeCtx, o, minioInstance, func() (string, error) {
+				putCtx, cancel := withTimeout(writeCtx, o.opts.Timeouts.Put)
+				defer cancel()
+				return putObject(putCtx, minioInstance, bucket, id, body, opts)
+			})
+			if err != nil {
+				log.Error("Replica write failed", "instance", minioInstance.EndpointURL().Host, "error", err)
+			}
+			results <- writeResult{etag: etag, err: err}
+		}()
+	}
+
+	var (
+		received  int
+		succeeded int
+		etag      string
+		errs      []error
+		err       error
+	)
+collect:
+	for received < len(minioInstances) {
+		var result writeResult
+		select {
+		case result = <-results:
+		case <-ctx.Done():
+			cancelWrites()
+			err = fmt.Errorf("write quorum of %d not reached: %w", quorum, ctx.Err())
+			break collect
+		}
+		received++
+		if result.err != nil {
+			errs = append(errs, result.err)
+			if len(errs) > len(minioInstances)-quorum {
+				err = fmt.Errorf("write quorum of %d not reached: %w", quorum, errors.Join(errs...))
+				break
+			}
+			continue
+		}
+
+		succeeded++
+		if etag == "" {
+			etag = result.etag
+		}
+		if succeeded == quorum {
+			break
+		}
+	}
+
+	remaining, failed := len(minioInstances)-received, len(errs)
+	go func() {
+		defer cancelWrites()
+		for ; remaining > 0; remaining-- {
+			if result := <-results; result.err != nil {
+				failed++
+			}
+		}
+		if done != nil {
+			done(failed)
+		}
+	}()
+
+	return etag, err
+}
+
+const (
+	// MinPartSize is the smallest part size minio accepts for a multipart upload
+	MinPartSize = 5 << 20
+	// MaxPartSize is the largest part size minio accepts for a multipart upload
+	MaxPartSize = 5 << 30
+)
+
+// putObject writes the object to the instance, creating the bucket if needed, and returns its ETag
+// A failure to create the bucket only fails the write to this instance, the other replicas are written regardless
+func putObject(ctx context.Context, minioInstance *minio.Client, bucket, id string, body Body, opts minio.PutObjectOptions) (string, error) {
 	exists, err := minioInstance.BucketExists(ctx, bucket)
 	if err != nil {
-		return fmt.Errorf("failed to check bucket existence: %w", err)
+		return "", fmt.Errorf("failed to check bucket existence: %w", err)
 	}
 
+	// Concurrent writes to a new bucket race to create it, the losers find it already created, which is fine
 	if !exists {
-		if err = minioInstance.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
-			return fmt.Errorf("failed to create bucket: %w", err)
+		if err = minioInstance.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil && !isBucketCreated(err) {
+			return "", fmt.Errorf("failed to create bucket: %w", err)
 		}
 	}
-	_, err = minioInstance.PutObject(ctx, bucket, id, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+
+	// A deduplicated object is stored once per instance, the object itself only holds the hash referencing it
+	// Its ETag is then the one of the hash, so the objects with the same content share it, as their ETags would
+	if hash := opts.UserMetadata[contentRefMetadataKey]; hash != "" {
+		if err = putContent(ctx, minioInstance, hash, body); err != nil {
+			return "", err
+		}
+		body = NewBytesBody([]byte(hash))
+	}
+	info, err := minioInstance.PutObject(ctx, bucket, id, io.NewSectionReader(body, 0, body.Size()), body.Size(), opts)
 	if err != nil {
-		return fmt.Errorf("failed to put object: %w", err)
+		if isStorageFull(err) {
+			return "", fmt.Errorf("failed to put object: %w: %w", InsufficientStorageError{}, err)
+		}
+		return "", fmt.Errorf("failed to put object: %w", err)
 	}
 
-	return nil
+	return info.ETag, nil
+}
+
+// normalizeID returns the id the object is routed and stored under
+func (o *ObjectStorage) normalizeID(id string) string {
+	if o.opts.FoldCase {
+		return foldID(id)
+	}
+
+	return id
 }
 
-func (o *ObjectStorage) getMatchingInstance(id string) (*minio.Client, error) {
+// UpdateObjectMetadata merges the given user metadata into the metadata of the object, without rewriting its body
+// A key with an empty value is removed from the metadata
+// Every replica holding the object is updated, a soft deleted object isn't, like it isn't read
+func (o *ObjectStorage) UpdateObjectMetadata(ctx context.Context, bucket, id string, metadata map[string]string) error {
+	id = o.normalizeID(id)
+	instances, err := o.matchInstances(ctx, o.routingKey(bucket, id), o.readCandidates(bucket))
+	if err != nil {
+		return err
+	}
+
+	minioInstances, err := o.getClients(ctx, id, instances)
+	if err != nil {
+		return err
+	}
+
 	var (
-		instance registry.ServiceMetadata
-		err      error
+		updated int
+		deleted error
+		errs    []error
 	)
+	for _, minioInstance := range minioInstances {
+		_, err := withInstance(ctx, o, minioInstance, func() (struct{}, error) {
+			// The metadata is replaced by a server-side copy, so the update is bounded like a write
+			updateCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Put)
+			defer cancel()
+			return struct{}{}, updateObjectMetadata(updateCtx, minioInstance, bucket, id, metadata)
+		})
+		switch {
+		case err == nil:
+			updated++
+		case isDeleted(err):
+			deleted = err
+		case isMissing(err):
+			continue
+		default:
+			log.Error("Replica metadata update failed", "instance", minioInstance.EndpointURL().Host, "error", err)
+			errs = append(errs, err)
+		}
+	}
 
-	// Retry mechanism to make it resilient to transient failures
-	err = retry.Do(
-		func() error {
-			instance, err = o.registry.MatchService(id)
-			if err != nil {
-				return fmt.Errorf("failed to get minio instance for object id: %w", err)
-			}
-			return nil
-		},
-		retry.Attempts(3),
-		retry.Delay(300*time.Millisecond))
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if updated == 0 && deleted != nil {
+		return deleted
+	}
+
+	if updated == 0 {
+		return NotFoundError{}
+	}
+
+	return nil
+}
+
+func updateObjectMetadata(ctx context.Context, minioInstance *minio.Client, bucket, id string, metadata map[string]string) error {
+	info, err := minioInstance.StatObject(ctx, bucket, id, minio.StatObjectOptions{})
 	if err != nil {
-		return nil, err
+		if isNotFound(err) {
+			return notFoundError(err, bucket)
+		}
+		return fmt.Errorf("failed to stat object: %w", err)
 	}
 
-	endpoint := fmt.Sprintf("%s:9000", instance.IPAddress)
-	minioInstance, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(instance.AccessKey, instance.SecretKey, ""),
-		Secure: false, // In production, we would use SSL
-	})
+	// A deleted object reads as deleted, so it isn't updated either, restoring it is the way back
+	if at, deleted := deletedAt(info.UserMetadata); deleted {
+		return DeletedError{DeletedAt: at}
+	}
+
+	merged := make(map[string]string, len(info.UserMetadata)+len(metadata))
+	for k, v := range info.UserMetadata {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		if isReservedMetadata(k) {
+			continue // The stored bytes depend on it, so it can't be changed
+		}
+
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+
+	// Copying the object onto itself replaces its metadata server-side, the stored headers are kept
+	_, err = minioInstance.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: bucket, Object: id, UserMetadata: copyMetadata(merged, info), ReplaceMetadata: true},
+		minio.CopySrcOptions{Bucket: bucket, Object: id},
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create minio client: %w", err)
+		return fmt.Errorf("failed to update object metadata: %w", err)
+	}
+
+	return nil
+}
+
+// withTimeout bounds ctx by the timeout, zero only derives a cancelable context
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// withRequestDeadline returns a context which is not cancelled with ctx, but keeps its deadline if it has one
+// The per-operation timeouts derived from it are capped by the remaining deadline of the request
+func withRequestDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached, deadline)
+	}
+
+	return context.WithCancel(detached)
+}
+
+// withSlowDownRetry retries op with a backoff while the object storage asks to slow down
+// A SlowDownError is returned when the object storage is still overloaded after the last attempt
+// A RegionMismatchError is returned when the bucket is in another region than the configured one
+func withSlowDownRetry[T any](ctx context.Context, op func() (T, error)) (T, error) {
+	var result T
+	err := retry.Do(
+		func() error {
+			var err error
+			result, err = op()
+			return err
+		},
+		retry.RetryIf(isSlowDown),
+		retry.OnRetry(func(n uint, _ error) {
+			// It is called after the last attempt too, which isn't retried
+			if n < slowDownAttempts-1 {
+				countRetry(ctx)
+			}
+		}),
+		retry.Attempts(slowDownAttempts),
+		retry.Delay(slowDownDelay),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.Context(ctx))
+	if err != nil && isSlowDown(err) {
+		log.Warn("Object storage is still overloaded after retrying", "error", err)
+		return result, fmt.Errorf("%w: %w", SlowDownError{RetryAfter: slowDownRetryAfter}, err)
+	}
+
+	if mismatchErr, ok := regionMismatch(err); ok {
+		log.Error("Bucket is in another region than the configured one", "bucket", mismatchErr.Bucket, "region", mismatchErr.Region, "error", err)
+		return result, fmt.Errorf("%w: %w", mismatchErr, err)
+	}
+
+	return result, err
+}
+
+// regionMismatch returns the RegionMismatchError matching err, if err is a minio region mismatch
+// minio-go only surfaces the mismatches when the clients have a fixed region, otherwise it retries in the right region
+func regionMismatch(err error) (RegionMismatchError, bool) {
+	var minioErr minio.ErrorResponse
+	if !errors.As(err, &minioErr) {
+		return RegionMismatchError{}, false
+	}
+
+	switch minioErr.Code {
+	case "AuthorizationHeaderMalformed", "InvalidRegion", "IllegalLocationConstraintException":
+		return RegionMismatchError{Bucket: minioErr.BucketName, Region: minioErr.Region}, true
+	default:
+		return RegionMismatchError{}, false
 	}
+}
+
+func isNotFound(err error) bool {
+	var minioErr minio.ErrorResponse
+	return errors.As(err, &minioErr) && minioErr.StatusCode == http.StatusNotFound
+}
+
+// notFoundError maps a minio not found error to the gateway error telling whether the bucket or the object is missing
+func notFoundError(err error, bucket string) error {
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) && minioErr.Code == "NoSuchBucket" {
+		return BucketNotFoundError{Bucket: bucket}
+	}
+
+	return NotFoundError{}
+}
+
+// isDeleted reports whether err is a DeletedError
+func isDeleted(err error) bool {
+	var deletedErr DeletedError
+	return errors.As(err, &deletedErr)
+}
+
+// isMissing reports whether err is a NotFoundError or a BucketNotFoundError
+func isMissing(err error) bool {
+	var bucketErr BucketNotFoundError
+	return errors.Is(err, NotFoundError{}) || errors.As(err, &bucketErr)
+}
+
+// BackendError is the error returned by a minio instance
+type BackendError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"status_code"`
+}
+
+// AsBackendError returns the minio error behind err, if any
+func AsBackendError(err error) (BackendError, bool) {
+	var minioErr minio.ErrorResponse
+	if !errors.As(err, &minioErr) {
+		return BackendError{}, false
+	}
+
+	return BackendError{Code: minioErr.Code, Message: minioErr.Message, StatusCode: minioErr.StatusCode}, true
+}
+
+// isBucketCreated reports whether err is minio refusing to create a bucket which already exists
+func isBucketCreated(err error) bool {
+	var minioErr minio.ErrorResponse
+	if !errors.As(err, &minioErr) {
+		return false
+	}
+
+	return minioErr.Code == "BucketAlreadyOwnedByYou" || minioErr.Code == "BucketAlreadyExists"
+}
+
+func isSlowDown(err error) bool {
+	var minioErr minio.ErrorResponse
+	if !errors.As(err, &minioErr) {
+		return false
+	}
+
+	return minioErr.Code == "SlowDown" || minioErr.StatusCode == http.StatusServiceUnavailable
+}
+
+// publish sends the event in the background, a failure is logged since the operation already succeeded
+func (o *ObjectStorage) publish(ctx context.Context, event notify.Event) {
+	event.Timestamp = time.Now().UTC()
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := o.opts.Publisher.Publish(ctx, event); err != nil {
+			log.Error("Failed to publish the object event", "bucket", event.Bucket, "id", event.ID, "operation", event.Operation, "error", err)
+		}
+	}()
+}
+
+// isStorageFull reports whether err is minio rejecting a write for lack of space
+func isStorageFull(err error) bool {
+	var minioErr minio.ErrorResponse
+	if !errors.As(err, &minioErr) {
+		return false
+	}
+
+	return storageFullCodes[minioErr.Code] || minioErr.StatusCode == http.StatusInsufficientStorage
+}
 
-	log.Debug("Matched instance", "object_id", id, "instance", instance.IPAddress)
-	return minioInstance, nil
+// EvictClient drops the cached minio client, and the concurrency limit, of the instance with the given address
+// It is meant to be called when the instance is deregistered
+func (o *ObjectStorage) EvictClient(address string) {
+	o.clients.evict(address)
+	o.limiter.forget(address)
 }
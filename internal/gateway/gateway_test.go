@@ -0,0 +1,1019 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/dariusigna/object-storage/internal/notify"
+	"github.com/dariusigna/object-storage/internal/wal"
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGetObjectCoalescesConcurrentReads(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("data"), nil)
+	storage, _ := newTestStorage(t, Options{}, instance)
+	joined := watchReads(storage)
+
+	// The backend read is held until every read is waiting on it
+	var fetches atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodGet && strings.Count(r.URL.Path, "/") >= 2 {
+			if fetches.Add(1) == 1 {
+				close(started)
+			}
+			<-release
+		}
+		return false
+	})
+
+	coalesced := testutil.ToFloat64(metrics.CoalescedReads)
+	const readers = 10
+	var wg sync.WaitGroup
+	for i := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			object, err := storage.GetObject(context.Background(), "bucket", "id")
+			if err != nil || string(object.Data) != "data" {
+				t.Errorf("GetObject() = %q, %v, want %q", object.Data, err, "data")
+			}
+		}()
+		<-joined
+		if i == 0 {
+			<-started
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	if fetches.Load() != 1 {
+		t.Errorf("the backend was read %d times, want once", fetches.Load())
+	}
+	if got := testutil.ToFloat64(metrics.CoalescedReads) - coalesced; got != readers-1 {
+		t.Errorf("coalesced reads metric increased by %v, want %d", got, readers-1)
+	}
+}
+
+func TestGetObjectCoalescedReadsDontShareMetadata(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("data"), http.Header{"X-Amz-Meta-Owner": {"alice"}, "Cache-Control": {"no-cache"}})
+	storage, _ := newTestStorage(t, Options{}, instance)
+	joined := watchReads(storage)
+
+	// The backend read is held until both reads are waiting on it
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodGet {
+			once.Do(func() { close(started) })
+			<-release
+		}
+		return false
+	})
+
+	objects := make([]Object, 2)
+	var wg sync.WaitGroup
+	for i := range objects {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			object, err := storage.GetObject(context.Background(), "bucket", "id")
+			if err != nil {
+				t.Errorf("GetObject() error = %v", err)
+			}
+			objects[i] = object
+		}()
+		<-joined
+		if i == 0 {
+			<-started
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	objects[0].UserMetadata["Owner"] = "mallory"
+	objects[0].Headers.Set("Cache-Control", "public")
+	if got := objects[1].UserMetadata["Owner"]; got != "alice" {
+		t.Errorf("UserMetadata[Owner] = %q, want the metadata of a read unchanged by another one", got)
+	}
+	if got := objects[1].Headers.Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want the headers of a read unchanged by another one", got)
+	}
+}
+
+func TestGetObjectSharedFetchKeepsTheDeadline(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("data"), nil)
+	storage, _ := newTestStorage(t, Options{}, instance)
+
+	canceled := make(chan struct{})
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodGet {
+			<-r.Context().Done()
+			close(canceled)
+			return true
+		}
+		return false
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := storage.GetObject(ctx, "bucket", "id"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetObject() error = %v, want the deadline exceeded", err)
+	}
+
+	// The backend read of the abandoned fetch ends with the deadline of the request, rather than never
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the shared fetch outlived the deadline of the request")
+	}
+}
+
+func TestGetObjectFallbackBucket(t *testing.T) {
+	instance := newFakeInstance(t, "bucket", "legacy")
+	instance.put("legacy", "old", []byte("legacy data"), nil)
+	instance.put("bucket", "new", []byte("new data"), nil)
+	instance.put("legacy", "new", []byte("stale data"), nil)
+
+	tests := []struct {
+		name     string
+		fallback string
+		id       string
+		want     string
+		wantErr  bool
+	}{
+		{name: "only in the fallback bucket", fallback: "legacy", id: "old", want: "legacy data"},
+		{name: "in both buckets", fallback: "legacy", id: "new", want: "new data"},
+		{name: "in neither bucket", fallback: "legacy", id: "missing", wantErr: true},
+		{name: "fallback disabled", id: "old", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, _ := newTestStorage(t, Options{FallbackBucket: tt.fallback}, instance)
+			object, err := storage.GetObject(context.Background(), "bucket", tt.id)
+			if tt.wantErr {
+				if !errorIs[NotFoundError](err) {
+					t.Errorf("GetObject() error = %v, want a NotFoundError", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			if string(object.Data) != tt.want {
+				t.Errorf("GetObject() = %q, want %q", object.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetObjectShortRead(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("0123456789"), nil)
+	storage, _ := newTestStorage(t, Options{}, instance)
+
+	// The connection drops after the first 4 of the 10 bytes of the object
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet {
+			return false
+		}
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack the connection: %v", err)
+			return true
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 10\r\nContent-Type: application/octet-stream\r\n" +
+			"ETag: \"etag\"\r\nLast-Modified: " + time.Now().UTC().Format(http.TimeFormat) + "\r\n\r\n0123")
+		buf.Flush()
+		return true
+	})
+
+	object, err := storage.GetObject(context.Background(), "bucket", "id")
+	if err == nil {
+		t.Fatalf("GetObject() = %q, want an error rather than a truncated object", object.Data)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) && !errorIs[TruncatedError](err) {
+		t.Errorf("GetObject() error = %v, want the short read reported", err)
+	}
+}
+
+func TestPutObjectWriteQuorum(t *testing.T) {
+	tests := []struct {
+		name    string
+		quorum  int
+		failing int // The number of replicas failing the write
+		wantErr bool
+	}{
+		{name: "all the replicas", quorum: 2},
+		{name: "quorum reached", quorum: 2, failing: 1},
+		{name: "quorum missed", quorum: 2, failing: 2, wantErr: true},
+		{name: "every replica required", quorum: 3, failing: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+			for _, instance := range instances[:tt.failing] {
+				instance.failPuts(http.StatusInternalServerError, "InternalError")
+			}
+			storage, _ := newTestStorage(t, Options{ReplicationFactor: 3, WriteQuorum: tt.quorum}, instances...)
+
+			_, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PutObject() error = %v, want an error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// The writes past the quorum complete in the background
+			deadline := time.Now().Add(5 * time.Second)
+			for _, instance := range instances[tt.failing:] {
+				for instance.object("bucket", "id") == nil && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				if instance.object("bucket", "id") == nil {
+					t.Errorf("the healthy replica %s is missing the object", instance.address)
+				}
+			}
+		})
+	}
+}
+
+func TestReplicationConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		failing     int // The number of replicas failing the write
+		want        int32
+	}{
+		{name: "unbounded", want: 3},
+		{name: "bounded", concurrency: 2, want: 2},
+		{name: "sequential", concurrency: 1, want: 1},
+		{name: "quorum reached with a failing replica", concurrency: 1, failing: 1, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var active, highest atomic.Int32
+			// The writes are held until as many as expected are in flight at once, so they overlap whatever their timing
+			full := make(chan struct{})
+			var once sync.Once
+			instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+			for i, instance := range instances {
+				failing := i < tt.failing
+				instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+					if r.Method != http.MethodPut {
+						return false
+					}
+					n := active.Add(1)
+					defer active.Add(-1)
+					for {
+						if h := highest.Load(); n <= h || highest.CompareAndSwap(h, n) {
+							break
+						}
+					}
+					if n >= tt.want {
+						once.Do(func() { close(full) })
+					}
+					select {
+					case <-full:
+					case <-time.After(5 * time.Second):
+						t.Errorf("%d replica writes in flight, want %d", n, tt.want)
+					}
+					if failing {
+						writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket", "id")
+						return true
+					}
+					return false
+				})
+			}
+			opts := Options{ReplicationFactor: 3, WriteQuorum: 3 - tt.failing, ReplicationConcurrency: tt.concurrency}
+			storage, _ := newTestStorage(t, opts, instances...)
+
+			if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			if highest.Load() != tt.want {
+				t.Errorf("%d replica writes in flight at once, want %d", highest.Load(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPutObjectCancelledBeforeQuorum(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	cancelled := make(chan struct{}, len(instances))
+	for _, instance := range instances {
+		instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPut {
+				return false
+			}
+			// The connection is only watched for a disconnect once the body was read
+			io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+			cancelled <- struct{}{}
+			return true
+		})
+	}
+	storage, _ := newTestStorage(t, Options{ReplicationFactor: 2, WriteQuorum: 2}, instances...)
+
+	// The client disconnects while the replica writes hang
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := storage.PutObject(ctx, "bucket", "id", NewBytesBody([]byte("data")), PutOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("PutObject() error = %v, want the deadline exceeded", err)
+	}
+
+	for range instances {
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("a replica write in flight wasn't cancelled")
+		}
+	}
+}
+
+func TestReplicaWritesKeepTheRequestDeadline(t *testing.T) {
+	fast, slow := newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")
+	cancelled := make(chan time.Time, 1)
+	slow.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodPut {
+			return false
+		}
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+		cancelled <- time.Now()
+		return true
+	})
+	storage, _ := newTestStorage(t, Options{ReplicationFactor: 2, WriteQuorum: 1}, fast, slow)
+
+	// The write reaches its quorum on the fast replica, the slow one outlives the request
+	deadline := time.Now().Add(300 * time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if _, err := storage.PutObject(ctx, "bucket", "id", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	cancel()
+
+	select {
+	case at := <-cancelled:
+		if at.Before(deadline) {
+			t.Errorf("the replica write was cancelled %v before the request deadline", deadline.Sub(at))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the replica write outlived the request deadline")
+	}
+}
+
+// recordParts makes the instance accept the multipart uploads, and returns the sizes of the uploaded parts
+func recordParts(instance *fakeInstance) func() []int {
+	var (
+		mu    sync.Mutex
+		parts []int
+	)
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		query := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPost && query.Has("uploads"):
+			writeXML(w, http.StatusOK, struct {
+				XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+				Bucket   string
+				Key      string
+				UploadID string `xml:"UploadId"`
+			}{Bucket: bucket, Key: key, UploadID: "upload"})
+		case r.Method == http.MethodPut && query.Has("partNumber"):
+			data, _ := readBody(r)
+			mu.Lock()
+			parts = append(parts, len(data))
+			mu.Unlock()
+			w.Header().Set("ETag", `"part`+query.Get("partNumber")+`"`)
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPost && query.Has("uploadId"):
+			io.Copy(io.Discard, r.Body)
+			writeXML(w, http.StatusOK, struct {
+				XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+				Bucket  string
+				Key     string
+				ETag    string
+			}{Bucket: bucket, Key: key, ETag: `"complete"`})
+		default:
+			return false
+		}
+		return true
+	})
+
+	return func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		sorted := slices.Clone(parts)
+		slices.Sort(sorted)
+		return sorted
+	}
+}
+
+func TestPutObjectPartSize(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	parts := recordParts(instance)
+	storage, _ := newTestStorage(t, Options{PartSize: MinPartSize, UploadThreads: 2}, instance)
+
+	data := bytes.Repeat([]byte("a"), 2*MinPartSize+1024)
+	if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody(data), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if got, want := parts(), []int{1024, MinPartSize, MinPartSize}; !slices.Equal(got, want) {
+		t.Errorf("uploaded parts of %v bytes, want %v", got, want)
+	}
+}
+
+func TestPutObjectStorageFull(t *testing.T) {
+	tests := []struct {
+		code   string
+		status int
+		full   bool
+	}{
+		{code: "XMinioStorageFull", status: http.StatusInsufficientStorage, full: true},
+		{code: "XMinioAdminBucketQuotaExceeded", status: http.StatusBadRequest, full: true},
+		{code: "QuotaExceeded", status: http.StatusForbidden, full: true},
+		{code: "InternalError", status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			storage, _ := newTestStorage(t, Options{}, instance)
+			instance.failPuts(tt.status, tt.code)
+
+			_, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{})
+			if err == nil {
+				t.Fatal("PutObject() error = nil, want the write rejected")
+			}
+			if full := errors.Is(err, InsufficientStorageError{}); full != tt.full {
+				t.Errorf("PutObject() error = %v, want an InsufficientStorageError: %v", err, tt.full)
+			}
+		})
+	}
+}
+
+// fakePublisher sends the published events on a channel, failing with err when set
+type fakePublisher struct {
+	events chan notify.Event
+	err    error
+}
+
+func (f *fakePublisher) Publish(_ context.Context, event notify.Event) error {
+	f.events <- event
+	return f.err
+}
+
+// nextEvent returns the next event published, failing the test if none is published in time
+func (f *fakePublisher) nextEvent(t *testing.T) notify.Event {
+	t.Helper()
+	select {
+	case event := <-f.events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was published")
+		return notify.Event{}
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error // The error of the publisher
+	}{
+		{name: "published"},
+		{name: "failed publish", err: errors.New("webhook is unreachable")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := &fakePublisher{events: make(chan notify.Event, 2), err: tt.err}
+			storage, _ := newTestStorage(t, Options{Publisher: publisher}, newFakeInstance(t, "bucket"))
+
+			// A failed publish doesn't fail the operation, which already succeeded
+			if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			event := publisher.nextEvent(t)
+			if event.Bucket != "bucket" || event.ID != "id" || event.Operation != notify.Put || event.Size != 4 || event.Timestamp.IsZero() {
+				t.Errorf("event = %+v, want the put of the object", event)
+			}
+
+			if err := storage.DeleteObject(context.Background(), "bucket", "id"); err != nil {
+				t.Fatalf("DeleteObject() error = %v", err)
+			}
+			if event = publisher.nextEvent(t); event.Operation != notify.Delete || event.Size != 4 {
+				t.Errorf("event = %+v, want the delete of the object", event)
+			}
+		})
+	}
+}
+
+func TestStoredHeadersRoundTrip(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	storage, _ := newTestStorage(t, Options{}, instance)
+	expires := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	headers := http.Header{
+		"Cache-Control":       {"max-age=60"},
+		"Content-Disposition": {`attachment; filename="report.pdf"`},
+		"Content-Encoding":    {"identity"},
+		"Content-Language":    {"fr"},
+		"Expires":             {expires},
+	}
+
+	opts := PutOptions{ContentType: "application/pdf", Headers: headers}
+	if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), opts); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	object, err := storage.GetObject(context.Background(), "bucket", "id")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	if object.ContentType != "application/pdf" {
+		t.Errorf("content type = %q, want %q", object.ContentType, "application/pdf")
+	}
+	for name := range headers {
+		if got := object.Headers.Get(name); got != headers.Get(name) {
+			t.Errorf("%s = %q, want the stored %q", name, got, headers.Get(name))
+		}
+	}
+}
+
+// slowDown makes the instance ask the first failures object reads to slow down, or all of them when failures is negative
+func slowDown(instance *fakeInstance, failures int) *atomic.Int32 {
+	var reads atomic.Int32
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet || strings.Count(r.URL.Path, "/") < 2 {
+			return false
+		}
+		if n := reads.Add(1); failures >= 0 && int(n) > failures {
+			return false
+		}
+		writeS3Error(w, http.StatusServiceUnavailable, "SlowDown", "bucket", "id")
+		return true
+	})
+	return &reads
+}
+
+func TestGetObjectSlowDown(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("data"), nil)
+	storage, _ := newTestStorage(t, Options{}, instance)
+
+	reads := slowDown(instance, 1)
+	object, err := storage.GetObject(context.Background(), "bucket", "id")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v, want the read retried", err)
+	}
+	if string(object.Data) != "data" || reads.Load() != 2 {
+		t.Errorf("GetObject() = %q after %d reads, want %q after a retry", object.Data, reads.Load(), "data")
+	}
+
+	// The backend stays overloaded, so the retries give up with a SlowDownError
+	reads = slowDown(instance, -1)
+	_, err = storage.GetObject(context.Background(), "bucket", "id")
+	if !errorIs[SlowDownError](err) {
+		t.Errorf("GetObject() error = %v, want a SlowDownError", err)
+	}
+	if reads.Load() != slowDownAttempts {
+		t.Errorf("the object was read %d times, want %d", reads.Load(), slowDownAttempts)
+	}
+}
+
+func TestRegionMismatch(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("data"), nil)
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet || strings.Count(r.URL.Path, "/") < 2 {
+			return false
+		}
+		w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+		writeS3Error(w, http.StatusBadRequest, "AuthorizationHeaderMalformed", "bucket", "id")
+		return true
+	})
+	storage, _ := newTestStorage(t, Options{}, instance)
+
+	_, err := storage.GetObject(context.Background(), "bucket", "id")
+	var mismatchErr RegionMismatchError
+	if !errors.As(err, &mismatchErr) {
+		t.Fatalf("GetObject() error = %v, want a RegionMismatchError", err)
+	}
+	if mismatchErr.Bucket != "bucket" || mismatchErr.Region != "eu-west-1" {
+		t.Errorf("RegionMismatchError = %+v, want the bucket and the region reported by the backend", mismatchErr)
+	}
+}
+
+func TestGetObjectFromAPreviousOwner(t *testing.T) {
+	tests := []struct {
+		name           string
+		previousOwners int
+		holder         int // The position on the ring of the instance holding the object, the first is the owner
+		migrate        bool
+		wantErr        bool
+	}{
+		{name: "disabled", holder: 1, wantErr: true},
+		{name: "previous owner", previousOwners: 1, holder: 1},
+		{name: "previous owner migrated", previousOwners: 1, holder: 1, migrate: true},
+		{name: "past the previous owners", previousOwners: 1, holder: 2, wantErr: true},
+		{name: "second previous owner", previousOwners: 2, holder: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+			storage, r := newTestStorage(t, Options{PreviousOwners: tt.previousOwners, MigrateOnRead: tt.migrate}, instances...)
+			owners := ownersOf(t, r, "id", instances)
+			owners[tt.holder].put("bucket", "id", []byte("data"), http.Header{"Content-Type": {"text/plain"}, "X-Amz-Meta-Owner": {"alice"}})
+
+			object, err := storage.GetObject(context.Background(), "bucket", "id")
+			if tt.wantErr {
+				if !errors.Is(err, NotFoundError{}) {
+					t.Errorf("GetObject() error = %v, want a NotFoundError", err)
+				}
+				return
+			}
+			if err != nil || string(object.Data) != "data" {
+				t.Fatalf("GetObject() = %q, %v, want the object of the previous owner", object.Data, err)
+			}
+
+			// The migration writes the object to its owner in the background, keeping the previous copy
+			deadline := time.Now().Add(5 * time.Second)
+			for tt.migrate && owners[0].object("bucket", "id") == nil && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			migrated := owners[0].object("bucket", "id")
+			if !tt.migrate {
+				if migrated != nil {
+					t.Error("the object was migrated without MigrateOnRead")
+				}
+				return
+			}
+			if migrated == nil || string(migrated.data) != "data" {
+				t.Fatalf("owner holds %v, want the migrated object", migrated)
+			}
+			if migrated.header.Get("Content-Type") != "text/plain" || migrated.header.Get("X-Amz-Meta-Owner") != "alice" {
+				t.Errorf("migrated headers = %v, want the metadata of the previous copy", migrated.header)
+			}
+			if owners[tt.holder].object("bucket", "id") == nil {
+				t.Error("the previous copy was removed")
+			}
+		})
+	}
+}
+
+func TestGetObjectDuringARingMigration(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	storage, r := newTestStorage(t, Options{}, instances...)
+	ids := make([]string, 0, 20)
+	for i := range 20 {
+		id := fmt.Sprint("id", i)
+		if _, err := storage.PutObject(context.Background(), "bucket", id, NewBytesBody([]byte(id)), PutOptions{}); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	r.MigrateRing(hashring.NewCustom(500, nil))
+	var moved []string
+	for _, id := range ids {
+		if ownersOf(t, r, id, instances)[0].object("bucket", id) == nil {
+			moved = append(moved, id)
+		}
+	}
+	if len(moved) == 0 {
+		t.Fatal("the migration moved no object to another owner")
+	}
+
+	// The objects not moved yet are read from their owner on the previous ring
+	for _, id := range moved {
+		if object, err := storage.GetObject(context.Background(), "bucket", id); err != nil || string(object.Data) != id {
+			t.Errorf("GetObject(%s) during the migration = %q, %v, want it read from the previous ring", id, object.Data, err)
+		}
+	}
+
+	r.FinishMigration()
+	if _, err := storage.GetObject(context.Background(), "bucket", moved[0]); !errors.Is(err, NotFoundError{}) {
+		t.Errorf("GetObject() after the migration error = %v, want a NotFoundError", err)
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	tests := []struct {
+		name     string
+		foldCase bool
+		want     []string // The keys stored
+	}{
+		{name: "enabled", foldCase: true, want: []string{"photo"}},
+		{name: "disabled", want: []string{"Photo", "photo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			storage, _ := newTestStorage(t, Options{FoldCase: tt.foldCase}, instance)
+
+			for _, id := range []string{"Photo", "photo"} {
+				if _, err := storage.PutObject(context.Background(), "bucket", id, NewBytesBody([]byte(id)), PutOptions{}); err != nil {
+					t.Fatalf("PutObject(%s) error = %v", id, err)
+				}
+			}
+
+			// With case folding, the second write overwrites the first one
+			object, err := storage.GetObject(context.Background(), "bucket", "Photo")
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			if want := map[bool]string{true: "photo", false: "Photo"}[tt.foldCase]; string(object.Data) != want {
+				t.Errorf("GetObject(Photo) = %q, want %q", object.Data, want)
+			}
+			if keys := instance.keys("bucket"); !slices.Equal(keys, tt.want) {
+				t.Errorf("stored keys = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateObjectMetadata(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	instance.put("bucket", "id", []byte("data"), http.Header{
+		"Content-Type":  {"text/plain"},
+		"Cache-Control": {"no-cache"},
+		"X-Amz-Meta-A":  {"1"},
+		"X-Amz-Meta-B":  {"2"},
+	})
+	storage, _ := newTestStorage(t, Options{}, instance)
+
+	if err := storage.UpdateObjectMetadata(context.Background(), "bucket", "id", map[string]string{"B": "", "C": "3"}); err != nil {
+		t.Fatalf("UpdateObjectMetadata() error = %v", err)
+	}
+
+	info, err := storage.StatObject(context.Background(), "bucket", "id")
+	if err != nil {
+		t.Fatalf("StatObject() error = %v", err)
+	}
+	if info.UserMetadata["A"] != "1" || info.UserMetadata["C"] != "3" || len(info.UserMetadata) != 2 {
+		t.Errorf("UserMetadata = %v, want A=1 and C=3", info.UserMetadata)
+	}
+	if info.ContentType != "text/plain" || info.Headers.Get("Cache-Control") != "no-cache" {
+		t.Errorf("Content-Type %q and Cache-Control %q, want the ones of the object kept", info.ContentType, info.Headers.Get("Cache-Control"))
+	}
+	if info.Size != 4 {
+		t.Errorf("Size = %d, want the body unchanged", info.Size)
+	}
+}
+
+func TestUpdateObjectMetadataNotFound(t *testing.T) {
+	storage, _ := newTestStorage(t, Options{}, newFakeInstance(t, "bucket"))
+	err := storage.UpdateObjectMetadata(context.Background(), "bucket", "missing", map[string]string{"A": "1"})
+	if !errors.Is(err, NotFoundError{}) {
+		t.Errorf("UpdateObjectMetadata() error = %v, want a NotFoundError", err)
+	}
+}
+
+func TestNotFoundError(t *testing.T) {
+	tests := []struct {
+		code          string
+		bucketMissing bool
+	}{
+		{code: "NoSuchBucket", bucketMissing: true},
+		{code: "NoSuchKey"},
+		{code: "NoSuchVersion"},
+		{code: "NotFound"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			err := notFoundError(minio.ErrorResponse{Code: tt.code, StatusCode: http.StatusNotFound}, "bucket")
+			if got := errorIs[BucketNotFoundError](err); got != tt.bucketMissing {
+				t.Errorf("notFoundError() = %v, want a BucketNotFoundError: %v", err, tt.bucketMissing)
+			}
+			if got := errors.Is(err, NotFoundError{}); got == tt.bucketMissing {
+				t.Errorf("notFoundError() = %v, want a NotFoundError: %v", err, !tt.bucketMissing)
+			}
+			if !isMissing(err) {
+				t.Errorf("isMissing(%v) = false, want true", err)
+			}
+		})
+	}
+}
+
+func TestGetObjectMissingBucketOrObject(t *testing.T) {
+	tests := []struct {
+		name          string
+		buckets       [][]string // The buckets of each instance
+		bucketMissing bool
+	}{
+		{name: "missing object", buckets: [][]string{{"bucket"}, {"bucket"}}},
+		{name: "missing bucket", buckets: [][]string{{}, {}}, bucketMissing: true},
+		{name: "bucket missing on a replica only", buckets: [][]string{{}, {"bucket"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var instances []*fakeInstance
+			for _, buckets := range tt.buckets {
+				instances = append(instances, newFakeInstance(t, buckets...))
+			}
+			storage, _ := newTestStorage(t, Options{ReplicationFactor: 2}, instances...)
+
+			_, err := storage.GetObject(context.Background(), "bucket", "id")
+			if got := errorIs[BucketNotFoundError](err); got != tt.bucketMissing {
+				t.Errorf("GetObject() error = %v, want a BucketNotFoundError: %v", err, tt.bucketMissing)
+			}
+			if got := errors.Is(err, NotFoundError{}); got == tt.bucketMissing {
+				t.Errorf("GetObject() error = %v, want a NotFoundError: %v", err, !tt.bucketMissing)
+			}
+		})
+	}
+}
+
+// newTestWAL opens a write-ahead l in a temporary directory
+func newTestWAL(t *testing.T) *wal.Log {
+	t.Helper()
+	l, err := wal.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("wal.Open() error = %v", err)
+	}
+	return l
+}
+
+// waitPending waits until the log holds n pending entries, since the replicas past the quorum finish in the background
+func waitPending(t *testing.T, l *wal.Log, n int) []wal.Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, err := l.Pending()
+		if err != nil {
+			t.Fatalf("Pending() error = %v", err)
+		}
+		if len(entries) == n || time.Now().After(deadline) {
+			if len(entries) != n {
+				t.Fatalf("%d pending entries, want %d", len(entries), n)
+			}
+			return entries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// entryData returns the logged data of the entry
+func entryData(t *testing.T, l *wal.Log, entry wal.Entry) []byte {
+	t.Helper()
+	file, size, err := l.Data(entry)
+	if err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	defer file.Close()
+
+	data := make([]byte, size)
+	if _, err = file.ReadAt(data, 0); err != nil {
+		t.Fatalf("failed to read the entry data: %v", err)
+	}
+	return data
+}
+
+func TestRecoverCompletesAnInterruptedWrite(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	l := newTestWAL(t)
+	storage, _ := newTestStorage(t, Options{ReplicationFactor: 2, WriteQuorum: 2, WAL: l}, instances...)
+
+	// The gateway crashed after logging the write, before any replica was written
+	data := []byte("data")
+	if _, err := l.Begin(wal.Entry{Bucket: "bucket", Object: "id", ContentType: "text/plain", Metadata: map[string]string{"Owner": "alice"}},
+		bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	if err := storage.Recover(context.Background()); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	for i, instance := range instances {
+		object := instance.object("bucket", "id")
+		if object == nil || string(object.data) != "data" {
+			t.Fatalf("replica %d = %v, want the logged write", i, object)
+		}
+		if object.header.Get("Content-Type") != "text/plain" || object.header.Get("X-Amz-Meta-Owner") != "alice" {
+			t.Errorf("replica %d headers = %v, want the logged content type and metadata", i, object.header)
+		}
+	}
+	waitPending(t, l, 0)
+}
+
+func TestRecoverReplaysTheLatestWriteToAFailedReplica(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	l := newTestWAL(t)
+	storage, r := newTestStorage(t, Options{ReplicationFactor: 2, WriteQuorum: 1, WAL: l}, instances...)
+
+	// Both writes reach the quorum but fail on the same replica, so both are kept in the log
+	failed := ownersOf(t, r, "id", instances)[1]
+	failed.failPuts(http.StatusInternalServerError, "InternalError")
+	for _, data := range []string{"stale", "latest"} {
+		if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte(data)), PutOptions{}); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+	}
+	waitPending(t, l, 2)
+
+	// The replica fails again, so the latest write stays pending for the next recovery
+	if err := storage.Recover(context.Background()); err == nil {
+		t.Fatal("Recover() error = nil, want the failed replay")
+	}
+	if entries := waitPending(t, l, 1); string(entryData(t, l, entries[0])) != "latest" {
+		t.Errorf("pending entry holds %q, want the latest write, the superseded one dropped", entryData(t, l, entries[0]))
+	}
+
+	failed.setIntercept(nil)
+	if err := storage.Recover(context.Background()); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if object := failed.object("bucket", "id"); object == nil || string(object.data) != "latest" {
+		t.Errorf("failed replica = %v, want the latest write replayed", object)
+	}
+	waitPending(t, l, 0)
+}
+
+func TestRecoverWithoutWAL(t *testing.T) {
+	storage, _ := newTestStorage(t, Options{}, newFakeInstance(t, "bucket"))
+	if err := storage.Recover(context.Background()); err != nil {
+		t.Errorf("Recover() error = %v, want nil without a write-ahead log", err)
+	}
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	const short, long = 50 * time.Millisecond, 5 * time.Second
+	ops := []struct {
+		name string
+		call func(storage *ObjectStorage) error
+	}{
+		{name: "stat", call: func(storage *ObjectStorage) error {
+			_, err := storage.StatObject(context.Background(), "bucket", "id")
+			return err
+		}},
+		{name: "get", call: func(storage *ObjectStorage) error {
+			_, err := storage.GetObject(context.Background(), "bucket", "id")
+			return err
+		}},
+		{name: "put", call: func(storage *ObjectStorage) error {
+			_, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{})
+			return err
+		}},
+		{name: "delete", call: func(storage *ObjectStorage) error {
+			return storage.DeleteObject(context.Background(), "bucket", "id")
+		}},
+	}
+	tests := []struct {
+		name     string
+		timeouts Timeouts
+	}{
+		{name: "stat", timeouts: Timeouts{Stat: short, Get: long, Put: long, Delete: long}},
+		{name: "get", timeouts: Timeouts{Stat: long, Get: short, Put: long, Delete: long}},
+		{name: "put", timeouts: Timeouts{Stat: long, Get: long, Put: short, Delete: long}},
+		{name: "delete", timeouts: Timeouts{Stat: long, Get: long, Put: long, Delete: short}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			instance := newFakeInstance(t, "bucket")
+			instance.put("bucket", "id", []byte("data"), nil)
+			storage, _ := newTestStorage(t, Options{Timeouts: tt.timeouts}, instance)
+
+			// Every request of the object is slower than the short timeout, and well within the long ones
+			instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+				if strings.HasSuffix(r.URL.Path, "/id") {
+					select {
+					case <-time.After(3 * short):
+					case <-r.Context().Done():
+					}
+				}
+				return false
+			})
+
+			// Only the operation with the short timeout fails, the others are bound by their own timeout
+			for _, op := range ops {
+				err := op.call(storage)
+				if op.name == tt.name && !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("%s error = %v, want its timeout exceeded", op.name, err)
+				}
+				if op.name != tt.name && err != nil {
+					t.Errorf("%s error = %v, want nil", op.name, err)
+				}
+			}
+		})
+	}
+}
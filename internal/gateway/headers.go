@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// StoredHeaders are the HTTP headers stored with an object when it is written, and returned when it is read
+var StoredHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Expires",
+}
+
+// applyStoredHeaders sets the minio options of the StoredHeaders found in headers
+// An unparsable Expires header is ignored
+func applyStoredHeaders(opts *minio.PutObjectOptions, headers http.Header) {
+	opts.CacheControl = headers.Get("Cache-Control")
+	opts.ContentDisposition = headers.Get("Content-Disposition")
+	opts.ContentEncoding = headers.Get("Content-Encoding")
+	opts.ContentLanguage = headers.Get("Content-Language")
+	if expires, err := http.ParseTime(headers.Get("Expires")); err == nil {
+		opts.Expires = expires
+	}
+}
+
+// storedHeaders returns the StoredHeaders of an object
+// minio parses Expires out of the metadata, so it is formatted back from the object info
+func storedHeaders(info minio.ObjectInfo) http.Header {
+	headers := make(http.Header)
+	for _, name := range StoredHeaders {
+		if value := info.Metadata.Get(name); value != "" {
+			headers.Set(name, value)
+		}
+	}
+	if !info.Expires.IsZero() {
+		headers.Set("Expires", info.Expires.UTC().Format(http.TimeFormat))
+	}
+
+	return headers
+}
+
+// copyMetadata adds the StoredHeaders and the content type of the object to the user metadata of a copy replacing its metadata
+// The copy replaces the standard headers along with the user metadata, so they would be lost otherwise
+func copyMetadata(userMetadata map[string]string, info minio.ObjectInfo) map[string]string {
+	for k, v := range headerMap(storedHeaders(info)) {
+		userMetadata[k] = v
+	}
+	if info.ContentType != "" {
+		userMetadata["Content-Type"] = info.ContentType
+	}
+
+	return userMetadata
+}
+
+// headerMap flattens the StoredHeaders found in headers, for the write-ahead log
+func headerMap(headers http.Header) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	flat := make(map[string]string)
+	for _, name := range StoredHeaders {
+		if value := headers.Get(name); value != "" {
+			flat[name] = value
+		}
+	}
+
+	return flat
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	log "log/slog"
+	"sync"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/minio/minio-go/v7"
+)
+
+// InstanceLimit caps the concurrent operations sent to each instance, so a hot instance isn't overwhelmed
+type InstanceLimit struct {
+	// MaxConcurrent is the maximum number of operations in progress on an instance, zero disables the limit
+	MaxConcurrent int
+	// QueueTimeout is how long an operation waits for a slot of a busy instance, zero to fail fast
+	QueueTimeout time.Duration
+}
+
+// InstanceBusyError is returned when an instance already has the maximum number of operations in progress
+// It is wrapped in a SlowDownError, so the clients are asked to retry later
+type InstanceBusyError struct {
+	Instance string
+}
+
+// Error returns the error message
+func (i InstanceBusyError) Error() string {
+	return fmt.Sprintf("instance %s has too many operations in progress", i.Instance)
+}
+
+// instanceBusyRetryAfter is suggested to clients rejected by a busy instance
+const instanceBusyRetryAfter = time.Second
+
+// instanceLimiter holds a semaphore per instance, a nil limiter doesn't limit anything
+type instanceLimiter struct {
+	mu           sync.Mutex
+	max          int
+	queueTimeout time.Duration
+	slots        map[string]chan struct{} // The semaphores, keyed by the instance address
+}
+
+func newInstanceLimiter(limit InstanceLimit) *instanceLimiter {
+	if limit.MaxConcurrent <= 0 {
+		return nil
+	}
+
+	return &instanceLimiter{max: limit.MaxConcurrent, queueTimeout: limit.QueueTimeout, slots: make(map[string]chan struct{})}
+}
+
+// acquire takes a slot of the instance, waiting up to the queue timeout, and returns the func releasing it
+func (l *instanceLimiter) acquire(ctx context.Context, address string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	slots := l.semaphore(address)
+	release := func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		select {
+		case slots <- struct{}{}:
+			return release, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	metrics.InstanceLimitRejections.WithLabelValues(address).Inc()
+	return nil, fmt.Errorf("%w: %w", SlowDownError{RetryAfter: instanceBusyRetryAfter}, InstanceBusyError{Instance: address})
+}
+
+func (l *instanceLimiter) semaphore(address string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots, ok := l.slots[address]
+	if !ok {
+		slots = make(chan struct{}, l.max)
+		l.slots[address] = slots
+	}
+	return slots
+}
+
+// forget drops the semaphore of a deregistered instance, the operations still holding its slots release them as usual
+func (l *instanceLimiter) forget(address string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.slots, address)
+	metrics.InstanceLimitRejections.DeleteLabelValues(address)
+}
+
+// withInstance runs op on the instance within one of its slots, retrying it while the object storage asks to slow down
+// The slot is released between the attempts, so a backing off operation doesn't hold it
+// When the instance denies the access, its credentials are refreshed, and op is retried once if they were rotated
+func withInstance[T any](ctx context.Context, o *ObjectStorage, minioInstance *minio.Client, op func() (T, error)) (T, error) {
+	result, err := withInstanceSlot(ctx, o, minioInstance, op)
+	if err == nil || !isAccessDenied(err) {
+		return result, err
+	}
+
+	address := minioInstance.EndpointURL().Hostname()
+	log.Warn("Access denied, refreshing the credentials of the instance", "instance", address, "error", err)
+	if !o.refreshCredentials(ctx, address) {
+		return result, err
+	}
+
+	log.Info("The credentials of the instance were rotated, retrying", "instance", address)
+	return withInstanceSlot(ctx, o, minioInstance, op)
+}
+
+func withInstanceSlot[T any](ctx context.Context, o *ObjectStorage, minioInstance *minio.Client, op func() (T, error)) (T, error) {
+	return withSlowDownRetry(ctx, func() (T, error) {
+		release, err := o.limiter.acquire(ctx, minioInstance.EndpointURL().Hostname())
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		defer release()
+
+		return op()
+	})
+}
@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// trackConcurrency makes the object reads of the instance slow, and returns the highest number of reads in progress at once
+func trackConcurrency(instance *fakeInstance) *atomic.Int32 {
+	var active, highest atomic.Int32
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodGet && strings.Count(r.URL.Path, "/") >= 2 {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for {
+				if h := highest.Load(); n <= h || highest.CompareAndSwap(h, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	})
+	return &highest
+}
+
+func TestInstanceLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		queueTimeout time.Duration
+		wantBusy     bool // Whether some reads are expected to be rejected
+	}{
+		{name: "queued", queueTimeout: 5 * time.Second},
+		{name: "fail fast", wantBusy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			// Distinct objects, so the reads aren't coalesced into one
+			for i := range 10 {
+				instance.put("bucket", fmt.Sprint("id", i), []byte("data"), nil)
+			}
+			highest := trackConcurrency(instance)
+			storage, _ := newTestStorage(t, Options{InstanceLimit: InstanceLimit{MaxConcurrent: 2, QueueTimeout: tt.queueTimeout}}, instance)
+
+			var (
+				wg   sync.WaitGroup
+				busy atomic.Int32
+			)
+			for i := range 10 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := storage.GetObject(context.Background(), "bucket", fmt.Sprint("id", i))
+					switch {
+					case errorIs[InstanceBusyError](err) && errorIs[SlowDownError](err):
+						busy.Add(1)
+					case err != nil:
+						t.Errorf("GetObject() error = %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if highest.Load() > 2 {
+				t.Errorf("%d reads in progress at once, want at most 2", highest.Load())
+			}
+			if got := busy.Load() > 0; got != tt.wantBusy {
+				t.Errorf("%d reads rejected as busy, want some rejected %v", busy.Load(), tt.wantBusy)
+			}
+		})
+	}
+}
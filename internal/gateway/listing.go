@@ -0,0 +1,356 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// enrichConcurrency bounds the objects of a listing stated at once by EnrichListing
+const enrichConcurrency = 8
+
+// ObjectSummary is an object of a listing
+type ObjectSummary struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"` // The size as uploaded, even when the object is stored compressed or deduplicated
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag"`
+	// ContentType and UserMetadata are only set by EnrichListing
+	ContentType  string            `json:"content_type,omitempty"`
+	UserMetadata map[string]string `json:"user_metadata,omitempty"`
+}
+
+// ListOptions selects the objects of a bucket listing
+type ListOptions struct {
+	// Prefix lists the objects whose key starts with it
+	Prefix string
+	// StartAfter lists the objects after this key, the NextStartAfter of the previous page
+	StartAfter string
+	// Limit is the maximum number of objects listed, zero for no limit
+	Limit int
+}
+
+// Listing is the merged listing of a bucket across the instances
+type Listing struct {
+	Objects []ObjectSummary
+	// SkippedInstances are the instances that couldn't be listed, so the listing may be missing objects
+	SkippedInstances []string
+	// Truncated tells more objects follow the limit, they are listed from NextStartAfter
+	Truncated      bool
+	NextStartAfter string
+}
+
+// Partial reports whether some instances couldn't be listed
+func (l Listing) Partial() bool {
+	return len(l.SkippedInstances) > 0
+}
+
+// BucketSummary is a bucket of the cluster
+type BucketSummary struct {
+	Name         string    `json:"name"`
+	CreationDate time.Time `json:"creation_date"` // The earliest creation on any instance
+}
+
+// BucketListing is the merged listing of the buckets across the instances
+type BucketListing struct {
+	Buckets []BucketSummary
+	// SkippedInstances are the instances that couldn't be listed, so the listing may be missing buckets
+	SkippedInstances []string
+}
+
+// Partial reports whether some instances couldn't be listed
+func (l BucketListing) Partial() bool {
+	return len(l.SkippedInstances) > 0
+}
+
+// ListObjects lists the objects of the bucket across all the instances, up to the limit of the options
+// The replicas of an object are merged into its most recently modified one, and the objects are sorted by key
+// The instances list their objects sorted by key, so their listings are streamed and merged without buffering them,
+// and the merge stops at the limit, so the memory is bounded by the limit rather than by the number of objects
+// Unreachable instances are skipped and reported in the listing, it fails only if no instance could be listed
+// With soft delete, the objects whose most recent replica is deleted are left out
+func (o *ObjectStorage) ListObjects(ctx context.Context, bucket string, opts ListOptions) (Listing, error) {
+	instances := o.registry.GetAllServices()
+	if len(instances) == 0 {
+		return Listing{}, errors.New("no instance is registered")
+	}
+
+	// The listings still running once the merge is done, e.g. after it failed, are stopped
+	ctx, cancel := withTimeout(ctx, o.opts.Timeouts.Get)
+	defer cancel()
+
+	// The deletion markers and the sizes of the objects not stored as is are only in the metadata
+	withMetadata := o.opts.SoftDelete.Retention > 0 || o.opts.Compression != NoCompression || o.opts.Dedup.Enabled
+	listOptions := minio.ListObjectsOptions{Prefix: opts.Prefix, StartAfter: opts.StartAfter, WithMetadata: withMetadata}
+	if opts.Limit > 0 {
+		// An instance holds at most the objects of the page and the one telling it's truncated
+		listOptions.MaxKeys = opts.Limit + 1
+	}
+	sources := make([]*sortedSource[minio.ObjectInfo], len(instances))
+	for i, instance := range instances {
+		minioInstance, err := o.clients.get(instance)
+		if err != nil {
+			sources[i] = failedSource[minio.ObjectInfo](instance.Address(), err)
+			continue
+		}
+
+		release, err := o.limiter.acquire(ctx, instance.Address())
+		if err != nil {
+			sources[i] = failedSource[minio.ObjectInfo](instance.Address(), err)
+			continue
+		}
+		defer release()
+
+		sources[i] = &sortedSource[minio.ObjectInfo]{
+			instance: instance.Address(),
+			next:     objectIterator(ctx, minioInstance, bucket, listOptions),
+		}
+	}
+
+	listing := Listing{Objects: []ObjectSummary{}}
+	mergeSorted(sources, func(info minio.ObjectInfo) string { return info.Key }, func(replicas []minio.ObjectInfo) bool {
+		latest := replicas[0]
+		for _, info := range replicas[1:] {
+			if info.LastModified.After(latest.LastModified) {
+				latest = info
+			}
+		}
+
+		if _, deleted := deletedAt(latest.UserMetadata); deleted {
+			return true
+		}
+
+		// An object past the limit tells the listing is truncated, it is the first one of the next page
+		if opts.Limit > 0 && len(listing.Objects) == opts.Limit {
+			listing.Truncated, listing.NextStartAfter = true, listing.Objects[len(listing.Objects)-1].Key
+			return false
+		}
+		listing.Objects = append(listing.Objects, ObjectSummary{Key: latest.Key, Size: listedSize(latest), LastModified: latest.LastModified, ETag: latest.ETag})
+		return true
+	})
+
+	var (
+		errs          []error
+		bucketMissing = true // Whether every listed instance reported the bucket missing
+	)
+	for _, source := range sources {
+		switch {
+		case source.err == nil:
+			bucketMissing = false
+		case !isMissing(source.err):
+			log.Warn("Skipping unreachable instance", "instance", source.instance, "error", source.err)
+			listing.SkippedInstances = append(listing.SkippedInstances, source.instance)
+			errs = append(errs, source.err)
+		}
+	}
+
+	if len(errs) == len(instances) {
+		return Listing{}, errors.Join(errs...)
+	}
+
+	if bucketMissing && !listing.Partial() {
+		return Listing{}, BucketNotFoundError{Bucket: bucket}
+	}
+	sort.Strings(listing.SkippedInstances)
+
+	return listing, nil
+}
+
+// listedSize returns the size of the listed object as uploaded, from its metadata when it isn't stored as is
+func listedSize(info minio.ObjectInfo) int64 {
+	if value, ok := metadataValue(info.UserMetadata, originalSizeMetadataKey); ok {
+		if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return size
+		}
+	}
+
+	return info.Size
+}
+
+// EnrichListing sets the content type and user metadata of the listed objects, which takes a stat of every object
+// This is expensive for large listings, so the stats are bounded to a few at once to limit the load on the instances
+// The objects that couldn't be stated are left as listed
+func (o *ObjectStorage) EnrichListing(ctx context.Context, bucket string, listing Listing) Listing {
+	var (
+		wg    sync.WaitGroup
+		slots = make(chan struct{}, enrichConcurrency)
+	)
+	for i := range listing.Objects {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return listing
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			object := &listing.Objects[i]
+			info, err := o.StatObject(ctx, bucket, object.Key)
+			if err != nil {
+				log.Warn("Failed to stat a listed object", "bucket", bucket, "id", object.Key, "error", err)
+				return
+			}
+			object.ContentType, object.UserMetadata = info.ContentType, info.UserMetadata
+		}()
+	}
+	wg.Wait()
+
+	return listing
+}
+
+// listObjects lists the objects of the bucket on the instance, with their user metadata if withMetadata is set
+func listObjects(ctx context.Context, minioInstance *minio.Client, bucket, prefix string, withMetadata bool) ([]minio.ObjectInfo, error) {
+	var (
+		objects []minio.ObjectInfo
+		next    = objectIterator(ctx, minioInstance, bucket, minio.ListObjectsOptions{Prefix: prefix, WithMetadata: withMetadata})
+	)
+	for {
+		info, ok, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return objects, nil
+		}
+		objects = append(objects, info)
+	}
+}
+
+// objectIterator returns the objects of the bucket on the instance selected by opts one at a time, sorted by key
+// It reports false once the objects are exhausted, the listing goes on in the background until ctx is done
+func objectIterator(ctx context.Context, minioInstance *minio.Client, bucket string, opts minio.ListObjectsOptions) func() (minio.ObjectInfo, bool, error) {
+	opts.Recursive = true
+	objects := minioInstance.ListObjects(ctx, bucket, opts)
+	return func() (minio.ObjectInfo, bool, error) {
+		info, ok := <-objects
+		switch {
+		case !ok:
+			return minio.ObjectInfo{}, false, nil
+		case info.Err == nil:
+			return info, true, nil
+		case isNotFound(info.Err):
+			return minio.ObjectInfo{}, false, notFoundError(info.Err, bucket)
+		default:
+			return minio.ObjectInfo{}, false, fmt.Errorf("failed to list objects: %w", info.Err)
+		}
+	}
+}
+
+// ListBuckets lists the buckets across all the instances
+// A bucket is created on every instance an object of it is written to, so the names are de-duplicated
+// Unreachable instances are skipped and reported in the listing, it fails only if no instance could be listed
+func (o *ObjectStorage) ListBuckets(ctx context.Context) (BucketListing, error) {
+	results, err := fanOut(ctx, o, func(ctx context.Context, minioInstance *minio.Client) ([]minio.BucketInfo, error) {
+		buckets, err := minioInstance.ListBuckets(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list buckets: %w", err)
+		}
+		return buckets, nil
+	})
+	if err != nil {
+		return BucketListing{}, err
+	}
+
+	listing := BucketListing{Buckets: []BucketSummary{}}
+	sources := make([]*sortedSource[minio.BucketInfo], 0, len(results))
+	for _, r := range results {
+		if r.err != nil {
+			listing.SkippedInstances = append(listing.SkippedInstances, r.instance)
+			continue
+		}
+
+		// The deduplicated contents are an internal bucket, not one of the clients
+		r.value = slices.DeleteFunc(r.value, func(info minio.BucketInfo) bool {
+			return info.Name == ContentBucket
+		})
+
+		sort.Slice(r.value, func(i, j int) bool {
+			return r.value[i].Name < r.value[j].Name
+		})
+		sources = append(sources, sliceSource(r.instance, r.value))
+	}
+
+	mergeSorted(sources, func(info minio.BucketInfo) string { return info.Name }, func(buckets []minio.BucketInfo) bool {
+		earliest := buckets[0]
+		for _, info := range buckets[1:] {
+			if info.CreationDate.Before(earliest.CreationDate) {
+				earliest = info
+			}
+		}
+		listing.Buckets = append(listing.Buckets, BucketSummary{Name: earliest.Name, CreationDate: earliest.CreationDate})
+		return true
+	})
+	sort.Strings(listing.SkippedInstances)
+
+	return listing, nil
+}
+
+// fanOutResult is the outcome of a fanned out call on an instance
+type fanOutResult[T any] struct {
+	instance string
+	value    T
+	err      error
+}
+
+// fanOut calls fn concurrently on every registered instance, each call bounded by the get timeout
+// The failures of the instances, other than missing buckets or objects, are logged
+// It fails only if every instance failed for another reason than a missing bucket or object
+func fanOut[T any](ctx context.Context, o *ObjectStorage, fn func(ctx context.Context, minioInstance *minio.Client) (T, error)) ([]fanOutResult[T], error) {
+	instances := o.registry.GetAllServices()
+	if len(instances) == 0 {
+		return nil, errors.New("no instance is registered")
+	}
+
+	results := make([]fanOutResult[T], len(instances))
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].instance = instance.Address()
+			minioInstance, err := o.clients.get(instance)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+
+			release, err := o.limiter.acquire(ctx, instance.Address())
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			defer release()
+
+			callCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Get)
+			defer cancel()
+			results[i].value, results[i].err = fn(callCtx, minioInstance)
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.err != nil && !isMissing(r.err) {
+			log.Warn("Skipping unreachable instance", "instance", r.instance, "error", r.err)
+			errs = append(errs, r.err)
+		}
+	}
+
+	if len(errs) == len(instances) {
+		return nil, errors.Join(errs...)
+	}
+
+	return results, nil
+}
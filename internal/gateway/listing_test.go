@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countListings counts the listing requests of the instance
+func countListings(instance *fakeInstance) *atomic.Int32 {
+	var listings atomic.Int32
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("list-type") == "2" {
+			listings.Add(1)
+		}
+		return false
+	})
+	return &listings
+}
+
+func TestListObjectsPages(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	const objects = 2500
+	for i := 0; i < objects; i++ {
+		// Every object has two replicas, on different instances
+		key := fmt.Sprintf("object%05d", i)
+		instances[i%3].put("bucket", key, []byte("data"), nil)
+		instances[(i+1)%3].put("bucket", key, []byte("data"), nil)
+	}
+	storage, _ := newTestStorage(t, Options{}, instances...)
+
+	var (
+		listed     []string
+		startAfter string
+	)
+	for pages := 1; ; pages++ {
+		listing, err := storage.ListObjects(context.Background(), "bucket", ListOptions{StartAfter: startAfter, Limit: 1000})
+		if err != nil {
+			t.Fatalf("ListObjects() error = %v", err)
+		}
+		if len(listing.Objects) > 1000 {
+			t.Fatalf("page %d has %d objects, over the limit", pages, len(listing.Objects))
+		}
+		for _, object := range listing.Objects {
+			listed = append(listed, object.Key)
+		}
+
+		if !listing.Truncated {
+			if pages != 3 {
+				t.Errorf("listed %d pages, want 3", pages)
+			}
+			break
+		}
+		startAfter = listing.NextStartAfter
+	}
+
+	if len(listed) != objects {
+		t.Fatalf("listed %d objects, want each of the %d objects once", len(listed), objects)
+	}
+	for i, key := range listed {
+		if want := fmt.Sprintf("object%05d", i); key != want {
+			t.Fatalf("object %d is %s, want %s", i, key, want)
+		}
+	}
+}
+
+func TestListObjectsStopsAtTheLimit(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	for i := 0; i < 5000; i++ {
+		instance.put("bucket", fmt.Sprintf("object%05d", i), []byte("data"), nil)
+	}
+	listings := countListings(instance)
+	storage, _ := newTestStorage(t, Options{}, instance)
+
+	listing, err := storage.ListObjects(context.Background(), "bucket", ListOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	if len(listing.Objects) != 10 || !listing.Truncated || listing.NextStartAfter != "object00009" {
+		t.Errorf("ListObjects() = %d objects, truncated %v after %q, want 10 objects truncated after object00009",
+			len(listing.Objects), listing.Truncated, listing.NextStartAfter)
+	}
+
+	// The merge stops at the limit, so the instance is listed a page of the limit ahead at most, not the whole bucket
+	if got := listings.Load(); got > 2 {
+		t.Errorf("the instance was listed %d times, want the first page and the one read ahead at most", got)
+	}
+}
+
+func BenchmarkListObjects(b *testing.B) {
+	instances := []*fakeInstance{newFakeInstance(b, "bucket"), newFakeInstance(b, "bucket")}
+	for i := 0; i < 20000; i++ {
+		instances[i%2].put("bucket", fmt.Sprintf("object%05d", i), []byte("data"), nil)
+	}
+	storage, _ := newTestStorage(b, Options{}, instances...)
+
+	// The allocations of a page stay the same however many objects the bucket holds
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := storage.ListObjects(context.Background(), "bucket", ListOptions{Limit: 100}); err != nil {
+			b.Fatalf("ListObjects() error = %v", err)
+		}
+	}
+}
+
+func TestListObjectsSkipsUnreachableInstances(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	instances[0].put("bucket", "a", []byte("stale"), nil)
+	instances[0].object("bucket", "a").modified = time.Now().Add(-time.Hour)
+	instances[1].put("bucket", "a", []byte("latest"), nil)
+	instances[1].put("bucket", "b", []byte("data"), nil)
+	instances[2].put("bucket", "c", []byte("data"), nil)
+	storage, _ := newTestStorage(t, Options{}, instances...)
+	instances[2].stop()
+
+	listing, err := storage.ListObjects(context.Background(), "bucket", ListOptions{})
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	if !listing.Partial() || len(listing.SkippedInstances) != 1 || listing.SkippedInstances[0] != instances[2].address {
+		t.Errorf("skipped instances = %v, want the stopped one %s", listing.SkippedInstances, instances[2].address)
+	}
+
+	// The replicas of the healthy instances are merged into the most recent one
+	var keys []string
+	for _, object := range listing.Objects {
+		keys = append(keys, object.Key)
+	}
+	if fmt.Sprint(keys) != "[a b]" {
+		t.Errorf("listed %v, want the objects of the healthy instances [a b]", keys)
+	}
+	if len(listing.Objects) > 0 && listing.Objects[0].Size != int64(len("latest")) {
+		t.Errorf("object a has size %d, want the one of its most recent replica", listing.Objects[0].Size)
+	}
+}
+
+func TestListObjectsErrors(t *testing.T) {
+	t.Run("every instance unreachable", func(t *testing.T) {
+		instance := newFakeInstance(t, "bucket")
+		storage, _ := newTestStorage(t, Options{}, instance)
+		instance.stop()
+		if _, err := storage.ListObjects(context.Background(), "bucket", ListOptions{}); err == nil || isMissing(err) {
+			t.Errorf("ListObjects() error = %v, want the instance failure", err)
+		}
+	})
+
+	t.Run("bucket missing everywhere", func(t *testing.T) {
+		storage, _ := newTestStorage(t, Options{}, newFakeInstance(t), newFakeInstance(t))
+		if _, err := storage.ListObjects(context.Background(), "bucket", ListOptions{}); !errorIs[BucketNotFoundError](err) {
+			t.Errorf("ListObjects() error = %v, want a BucketNotFoundError", err)
+		}
+	})
+
+	t.Run("bucket missing on the reachable instances", func(t *testing.T) {
+		// The bucket may be on the unreachable instance, so the listing is partial rather than missing
+		unreachable := newFakeInstance(t, "bucket")
+		storage, _ := newTestStorage(t, Options{}, newFakeInstance(t), unreachable)
+		unreachable.stop()
+		listing, err := storage.ListObjects(context.Background(), "bucket", ListOptions{})
+		if err != nil || !listing.Partial() {
+			t.Errorf("ListObjects() = %+v, %v, want an empty partial listing", listing, err)
+		}
+	})
+}
+
+func TestEnrichListing(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	var active, highest atomic.Int32
+	instance.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodHead {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for {
+				if h := highest.Load(); n <= h || highest.CompareAndSwap(h, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	})
+	storage, _ := newTestStorage(t, Options{}, instance)
+
+	const objects = 3 * enrichConcurrency
+	for i := range objects {
+		opts := PutOptions{ContentType: "text/plain", UserMetadata: map[string]string{"owner": fmt.Sprint("user", i)}}
+		if _, err := storage.PutObject(context.Background(), "bucket", fmt.Sprint("object", i), NewBytesBody([]byte("data")), opts); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+	}
+	listing, err := storage.ListObjects(context.Background(), "bucket", ListOptions{Limit: 1000})
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	// An object removed since it was listed is kept without its metadata
+	listing.Objects = append(listing.Objects, ObjectSummary{Key: "removed"})
+
+	listing = storage.EnrichListing(context.Background(), "bucket", listing)
+	for _, object := range listing.Objects {
+		if object.Key == "removed" {
+			if object.ContentType != "" || object.UserMetadata != nil {
+				t.Errorf("removed object enriched with %q and %v, want it left as listed", object.ContentType, object.UserMetadata)
+			}
+			continue
+		}
+		want := "user" + strings.TrimPrefix(object.Key, "object")
+		if object.ContentType != "text/plain" || object.UserMetadata["Owner"] != want {
+			t.Errorf("%s enriched with %q and %v, want text/plain and owner %s", object.Key, object.ContentType, object.UserMetadata, want)
+		}
+	}
+	if n := highest.Load(); n > enrichConcurrency {
+		t.Errorf("%d objects stated at once, want at most %d", n, enrichConcurrency)
+	}
+}
+
+func TestListBuckets(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "a", "b"), newFakeInstance(t, "b", "c", ContentBucket), newFakeInstance(t, "d")}
+	earliest := time.Now().Add(-time.Hour).UTC()
+	instances[1].created["b"] = earliest
+	storage, _ := newTestStorage(t, Options{}, instances...)
+	instances[2].stop()
+
+	listing, err := storage.ListBuckets(context.Background())
+	if err != nil {
+		t.Fatalf("ListBuckets() error = %v", err)
+	}
+	if !listing.Partial() || len(listing.SkippedInstances) != 1 || listing.SkippedInstances[0] != instances[2].address {
+		t.Errorf("skipped instances = %v, want the stopped one %s", listing.SkippedInstances, instances[2].address)
+	}
+
+	// The bucket on both instances is listed once, and the internal content bucket isn't listed
+	var names []string
+	for _, bucket := range listing.Buckets {
+		names = append(names, bucket.Name)
+	}
+	if fmt.Sprint(names) != "[a b c]" {
+		t.Fatalf("listed %v, want the union of the healthy instances [a b c]", names)
+	}
+	if !listing.Buckets[1].CreationDate.Equal(earliest) {
+		t.Errorf("bucket b created at %s, want the earliest creation %s", listing.Buckets[1].CreationDate, earliest)
+	}
+}
+
+func TestListBucketsEveryInstanceUnreachable(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	storage, _ := newTestStorage(t, Options{}, instance)
+	instance.stop()
+	if _, err := storage.ListBuckets(context.Background()); err == nil {
+		t.Error("ListBuckets() error = nil, want the instance failure")
+	}
+}
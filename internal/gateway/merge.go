@@ -0,0 +1,113 @@
+package gateway
+
+import "container/heap"
+
+// sortedSource yields the items listed by an instance in increasing key order
+type sortedSource[T any] struct {
+	instance string
+	next     func() (T, bool, error) // It reports false once the listing is exhausted
+	head     T
+	err      error // The error the listing failed with, the source yields nothing more
+}
+
+// advance moves to the next item of the source, it reports false once the source is exhausted or failed
+func (s *sortedSource[T]) advance() bool {
+	item, ok, err := s.next()
+	if err != nil {
+		s.err = err
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	s.head = item
+	return true
+}
+
+// mergeHeap orders the sources by the key of their current item
+type mergeHeap[T any] struct {
+	sources []*sortedSource[T]
+	key     func(T) string
+}
+
+func (h *mergeHeap[T]) Len() int {
+	return len(h.sources)
+}
+
+func (h *mergeHeap[T]) Less(i, j int) bool {
+	return h.key(h.sources[i].head) < h.key(h.sources[j].head)
+}
+
+func (h *mergeHeap[T]) Swap(i, j int) {
+	h.sources[i], h.sources[j] = h.sources[j], h.sources[i]
+}
+
+func (h *mergeHeap[T]) Push(x any) {
+	h.sources = append(h.sources, x.(*sortedSource[T]))
+}
+
+func (h *mergeHeap[T]) Pop() any {
+	last := h.sources[len(h.sources)-1]
+	h.sources = h.sources[:len(h.sources)-1]
+	return last
+}
+
+// mergeSorted merges the sorted sources, calling emit in key order with the items of every source listing the key
+// Only the current item of each source is held, so the memory doesn't grow with the size of the listings
+// A failed source is dropped from the merge, with its error left in the source, the items it yielded before are kept
+// The items passed to emit are reused by the next call, the merge stops early once emit returns false
+func mergeSorted[T any](sources []*sortedSource[T], key func(T) string, emit func(items []T) bool) {
+	h := &mergeHeap[T]{key: key}
+	for _, source := range sources {
+		if source.advance() {
+			h.sources = append(h.sources, source)
+		}
+	}
+	heap.Init(h)
+
+	var items []T
+	for h.Len() > 0 {
+		current := key(h.sources[0].head)
+		items = items[:0]
+		for h.Len() > 0 && key(h.sources[0].head) == current {
+			source := h.sources[0]
+			items = append(items, source.head)
+			if source.advance() {
+				heap.Fix(h, 0)
+			} else {
+				heap.Pop(h)
+			}
+		}
+		if !emit(items) {
+			return
+		}
+	}
+}
+
+// sliceSource returns a source yielding the items, which must be sorted by key
+func sliceSource[T any](instance string, items []T) *sortedSource[T] {
+	return &sortedSource[T]{
+		instance: instance,
+		next: func() (T, bool, error) {
+			var item T
+			if len(items) == 0 {
+				return item, false, nil
+			}
+
+			item, items = items[0], items[1:]
+			return item, true, nil
+		},
+	}
+}
+
+// failedSource returns a source failing with err
+func failedSource[T any](instance string, err error) *sortedSource[T] {
+	return &sortedSource[T]{
+		instance: instance,
+		next: func() (T, bool, error) {
+			var item T
+			return item, false, err
+		},
+	}
+}
@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// CrossInstanceMoveError is returned when the destination bucket routes the object to other instances than the source
+// It only happens with the bucket based key strategies, the object id alone routes the same in every bucket
+type CrossInstanceMoveError struct {
+	KeyStrategy KeyStrategy
+}
+
+// Error returns the error message
+func (c CrossInstanceMoveError) Error() string {
+	return fmt.Sprintf("the %s key strategy routes the object to other instances in the destination bucket", c.KeyStrategy)
+}
+
+// MoveObject moves the object from the src bucket to the dst bucket, keeping its id and metadata
+// Every replica holding the object is copied server-side, then removed from the source bucket
+func (o *ObjectStorage) MoveObject(ctx context.Context, src, dst, id string) error {
+	id = o.normalizeID(id)
+	if o.routingKey(src, id) != o.routingKey(dst, id) {
+		return CrossInstanceMoveError{KeyStrategy: o.opts.KeyStrategy}
+	}
+
+	instances, err := o.matchInstances(ctx, o.routingKey(src, id), o.readCandidates(src))
+	if err != nil {
+		return err
+	}
+
+	minioInstances, err := o.getClients(ctx, id, instances)
+	if err != nil {
+		return err
+	}
+
+	var (
+		moved         int
+		errs          []error
+		bucketMissing = true // Whether every replica reported the source bucket missing, rather than only the object
+	)
+	for _, minioInstance := range minioInstances {
+		_, err := withInstance(ctx, o, minioInstance, func() (struct{}, error) {
+			moveCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Put)
+			defer cancel()
+			return struct{}{}, moveObject(moveCtx, minioInstance, src, dst, id)
+		})
+		switch {
+		case err == nil:
+			moved++
+		case errors.Is(err, NotFoundError{}):
+			bucketMissing = false
+		case isMissing(err):
+			continue
+		default:
+			log.Error("Replica move failed", "instance", minioInstance.EndpointURL().Host, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if moved == 0 && bucketMissing {
+		return BucketNotFoundError{Bucket: src}
+	}
+
+	if moved == 0 {
+		return NotFoundError{}
+	}
+
+	return nil
+}
+
+func moveObject(ctx context.Context, minioInstance *minio.Client, src, dst, id string) error {
+	if _, err := minioInstance.StatObject(ctx, src, id, minio.StatObjectOptions{}); err != nil {
+		if isNotFound(err) {
+			return notFoundError(err, src)
+		}
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	exists, err := minioInstance.BucketExists(ctx, dst)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket existence: %w", err)
+	}
+	if !exists {
+		if err = minioInstance.MakeBucket(ctx, dst, minio.MakeBucketOptions{}); err != nil {
+			return fmt.Errorf("failed to create bucket: %w", err)
+		}
+	}
+
+	// The copy keeps the metadata of the source, and the source is only removed once it is copied
+	_, err = minioInstance.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: dst, Object: id},
+		minio.CopySrcOptions{Bucket: src, Object: id},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	if err = minioInstance.RemoveObject(ctx, src, id, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove the moved object: %w", err)
+	}
+
+	return nil
+}
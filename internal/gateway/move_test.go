@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestMoveObject(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "src"), newFakeInstance(t, "src"), newFakeInstance(t, "src")}
+	storage, r := newTestStorage(t, Options{ReplicationFactor: 2, WriteQuorum: 2}, instances...)
+	owners := ownersOf(t, r, "id", instances)
+	for _, owner := range owners[:2] {
+		owner.put("src", "id", []byte("data"), http.Header{"Content-Type": {"text/plain"}, "X-Amz-Meta-Owner": {"alice"}})
+	}
+
+	if err := storage.MoveObject(context.Background(), "src", "dst", "id"); err != nil {
+		t.Fatalf("MoveObject() error = %v", err)
+	}
+
+	// Every replica is moved in place, to the destination bucket created on the fly
+	for i, owner := range owners[:2] {
+		if owner.object("src", "id") != nil {
+			t.Errorf("replica %d is still in the source bucket", i)
+		}
+		moved := owner.object("dst", "id")
+		if moved == nil || string(moved.data) != "data" {
+			t.Fatalf("replica %d = %v in the destination bucket, want the moved object", i, moved)
+		}
+		if moved.header.Get("Content-Type") != "text/plain" || moved.header.Get("X-Amz-Meta-Owner") != "alice" {
+			t.Errorf("replica %d headers = %v, want the metadata of the source kept", i, moved.header)
+		}
+	}
+	if owners[2].object("dst", "id") != nil {
+		t.Error("the object was moved to an instance that didn't hold it")
+	}
+
+	object, err := storage.GetObject(context.Background(), "dst", "id")
+	if err != nil || string(object.Data) != "data" {
+		t.Errorf("GetObject() = %q, %v, want the moved object", object.Data, err)
+	}
+}
+
+func TestMoveObjectErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		buckets  []string
+		strategy KeyStrategy
+		want     func(err error) bool
+	}{
+		{name: "missing object", buckets: []string{"src"}, want: func(err error) bool { return errors.Is(err, NotFoundError{}) }},
+		{name: "missing bucket", want: errorIs[BucketNotFoundError]},
+		{name: "bucket key strategy", buckets: []string{"src"}, strategy: BucketKey, want: errorIs[CrossInstanceMoveError]},
+		{name: "bucket/id key strategy", buckets: []string{"src"}, strategy: BucketIDKey, want: errorIs[CrossInstanceMoveError]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, _ := newTestStorage(t, Options{KeyStrategy: tt.strategy}, newFakeInstance(t, tt.buckets...))
+			if err := storage.MoveObject(context.Background(), "src", "dst", "id"); !tt.want(err) {
+				t.Errorf("MoveObject() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"context"
+	log "log/slog"
+
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/minio/minio-go/v7"
+)
+
+// repair writes the object read from one of its replicas to the replicas missing it
+// The replicas are the instances a write of the object would be placed on, which are checked with a stat
+// Nothing is repaired unless a replica has the object, since it may have been read from the fallback bucket
+func (o *ObjectStorage) repair(ctx context.Context, bucket, id string, object Object) {
+	size := int64(len(object.Data))
+	minioInstances, err := o.getWriteClients(ctx, bucket, id, size)
+	if err != nil {
+		log.Error("Failed to get the replicas to repair", "bucket", bucket, "id", id, "error", err)
+		return
+	}
+
+	var (
+		missing []*minio.Client
+		found   int
+	)
+	for _, minioInstance := range minioInstances {
+		_, err := withInstance(ctx, o, minioInstance, func() (minio.ObjectInfo, error) {
+			statCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Stat)
+			defer cancel()
+			return minioInstance.StatObject(statCtx, bucket, id, minio.StatObjectOptions{})
+		})
+		switch {
+		case err == nil:
+			found++
+		case isNotFound(err):
+			missing = append(missing, minioInstance)
+		default:
+			// A replica that couldn't be checked is left alone, rather than overwritten blindly
+			log.Warn("Failed to check a replica for read repair", "instance", minioInstance.EndpointURL().Host, "error", err)
+		}
+	}
+
+	if found == 0 || len(missing) == 0 {
+		return
+	}
+
+	body, putOpts, err := o.prepareWrite(NewBytesBody(object.Data), PutOptions{
+		SizeHint:     size,
+		ContentType:  object.ContentType,
+		Headers:      object.Headers,
+		UserMetadata: object.UserMetadata,
+	})
+	if err != nil {
+		log.Error("Failed to prepare the read repair", "bucket", bucket, "id", id, "error", err)
+		return
+	}
+
+	log.Info("Repairing the replicas missing the object", "bucket", bucket, "id", id, "missing", len(missing))
+	_, err = o.replicate(ctx, missing, bucket, id, body, putOpts, len(missing), func(failed int) {
+		body.Close()
+		metrics.ReadRepairs.Add(float64(len(missing) - failed))
+	})
+	if err != nil {
+		log.Error("Failed to repair the replicas missing the object", "bucket", bucket, "id", id, "error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReadRepair(t *testing.T) {
+	tests := []struct {
+		name       string
+		readRepair bool
+	}{
+		{name: "enabled", readRepair: true},
+		{name: "disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+			storage, r := newTestStorage(t, Options{ReplicationFactor: 3, WriteQuorum: 3, ReadRepair: tt.readRepair}, instances...)
+			owners := ownersOf(t, r, "id", instances)
+			for _, owner := range owners[:2] {
+				owner.put("bucket", "id", []byte("data"), http.Header{"Content-Type": {"text/plain"}, "X-Amz-Meta-Owner": {"alice"}})
+			}
+			repairs := testutil.ToFloat64(metrics.ReadRepairs)
+
+			object, err := storage.GetObject(context.Background(), "bucket", "id")
+			if err != nil || string(object.Data) != "data" {
+				t.Fatalf("GetObject() = %q, %v, want the object", object.Data, err)
+			}
+
+			// The repair runs in the background, so the replica missing the object is polled
+			deadline := time.Now().Add(time.Second)
+			if !tt.readRepair {
+				deadline = time.Now().Add(100 * time.Millisecond)
+			}
+			for owners[2].object("bucket", "id") == nil && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			repaired := owners[2].object("bucket", "id")
+			if !tt.readRepair {
+				if repaired != nil {
+					t.Error("the replica was repaired without read repair")
+				}
+				return
+			}
+			if repaired == nil || string(repaired.data) != "data" {
+				t.Fatalf("replica holds %v, want the repaired object", repaired)
+			}
+			if repaired.header.Get("Content-Type") != "text/plain" || repaired.header.Get("X-Amz-Meta-Owner") != "alice" {
+				t.Errorf("repaired headers = %v, want the metadata of the object read", repaired.header)
+			}
+			for time.Now().Before(deadline) && testutil.ToFloat64(metrics.ReadRepairs)-repairs < 1 {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := testutil.ToFloat64(metrics.ReadRepairs) - repairs; got != 1 {
+				t.Errorf("read repairs = %v, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/metrics"
+)
+
+// RetryBudget bounds the instance lookup retries across all the requests, so an outage doesn't turn into a retry storm
+// Each retry takes a token from a bucket of Capacity tokens, refilled at Rate tokens per second
+// Once the bucket is empty, the requests fail on their first error instead of retrying
+type RetryBudget struct {
+	// Capacity is the maximum number of tokens, zero disables the budget
+	Capacity int
+	// Rate is the number of tokens added back per second
+	Rate float64
+}
+
+// retryBudget is the token bucket of a RetryBudget, a nil budget allows every retry
+type retryBudget struct {
+	mu       sync.Mutex
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+func newRetryBudget(budget RetryBudget) *retryBudget {
+	if budget.Capacity <= 0 {
+		return nil
+	}
+
+	b := &retryBudget{
+		capacity: float64(budget.Capacity),
+		rate:     budget.Rate,
+		tokens:   float64(budget.Capacity),
+		last:     time.Now(),
+	}
+	metrics.RetryBudgetTokens.Set(b.tokens)
+	return b
+}
+
+// take takes a token for a retry, it reports false when the budget is exhausted
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	defer func() { metrics.RetryBudgetTokens.Set(b.tokens) }()
+
+	if b.tokens < 1 {
+		metrics.RetryBudgetExhausted.Inc()
+		return false
+	}
+
+	b.tokens--
+	return true
+}
@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(RetryBudget{Capacity: 2, Rate: 10})
+	exhausted := testutil.ToFloat64(metrics.RetryBudgetExhausted)
+	if !budget.take() || !budget.take() {
+		t.Fatal("take() = false, want the retries allowed within the capacity")
+	}
+	if budget.take() {
+		t.Error("take() = true, want the retry denied once the budget is exhausted")
+	}
+	if got := testutil.ToFloat64(metrics.RetryBudgetExhausted) - exhausted; got != 1 {
+		t.Errorf("exhausted retries = %v, want 1", got)
+	}
+
+	// The tokens are refilled at the rate, up to the capacity
+	budget.mu.Lock()
+	budget.last = budget.last.Add(-time.Hour)
+	budget.mu.Unlock()
+	if !budget.take() {
+		t.Fatal("take() = false, want the budget refilled")
+	}
+	if tokens := testutil.ToFloat64(metrics.RetryBudgetTokens); tokens != 1 {
+		t.Errorf("tokens = %v, want the capacity minus the retry taken", tokens)
+	}
+}
+
+func TestRetryBudgetDisabled(t *testing.T) {
+	budget := newRetryBudget(RetryBudget{})
+	for range 10 {
+		if !budget.take() {
+			t.Fatal("take() = false, want every retry allowed without a budget")
+		}
+	}
+}
+
+func TestRetryBudgetExhaustedFailsFast(t *testing.T) {
+	// Without any instance, every lookup fails and is retried while the budget allows it
+	storage, _ := newTestStorage(t, Options{RetryBudget: RetryBudget{Capacity: 2}})
+
+	start := time.Now()
+	if _, err := storage.GetObject(context.Background(), "bucket", "id"); err == nil {
+		t.Fatal("GetObject() error = nil, want the lookup failure")
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("the first lookup failed after %s, want it retried", elapsed)
+	}
+
+	start = time.Now()
+	if _, err := storage.GetObject(context.Background(), "bucket", "id"); err == nil {
+		t.Fatal("GetObject() error = nil, want the lookup failure")
+	}
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Errorf("the lookup failed after %s, want it to fail fast with the budget exhausted", elapsed)
+	}
+}
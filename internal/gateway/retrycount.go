@@ -0,0 +1,30 @@
+package gateway
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// RetryCounter counts the retries of the operations done on behalf of a request
+type RetryCounter struct {
+	count atomic.Int64
+}
+
+// Count returns the number of retries counted so far
+func (c *RetryCounter) Count() int64 {
+	return c.count.Load()
+}
+
+type retryCounterKey struct{}
+
+// WithRetryCounter returns a context whose operations count their retries in counter
+func WithRetryCounter(ctx context.Context, counter *RetryCounter) context.Context {
+	return context.WithValue(ctx, retryCounterKey{}, counter)
+}
+
+// countRetry counts a retry in the counter of ctx, if any
+func countRetry(ctx context.Context) {
+	if counter, ok := ctx.Value(retryCounterKey{}).(*RetryCounter); ok {
+		counter.count.Add(1)
+	}
+}
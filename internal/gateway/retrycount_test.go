@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRetryCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int // The reads asked to slow down before the backend recovers
+		want     int64
+	}{
+		{name: "steady backend", want: 0},
+		{name: "flaky backend", failures: 1, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			instance.put("bucket", "id", []byte("data"), nil)
+			storage, _ := newTestStorage(t, Options{}, instance)
+			slowDown(instance, tt.failures)
+
+			counter := &RetryCounter{}
+			if _, err := storage.GetObject(WithRetryCounter(context.Background(), counter), "bucket", "id"); err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			if counter.Count() != tt.want {
+				t.Errorf("Count() = %d, want %d", counter.Count(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/minio/minio-go/v7"
+)
+
+// KeyStrategy is how the consistent hash key of an object is derived from its bucket and id
+type KeyStrategy string
+
+const (
+	// IDKey routes by the object id, so an id lives on the same instances in every bucket
+	IDKey KeyStrategy = "id"
+	// BucketIDKey routes by the bucket and the object id, spreading the objects of every bucket
+	BucketIDKey KeyStrategy = "bucket/id"
+	// BucketKey routes by the bucket only, so all the objects of a bucket are on the same instances
+	BucketKey KeyStrategy = "bucket"
+)
+
+// ParseKeyStrategy parses the name of a key strategy, an empty name is the default IDKey
+func ParseKeyStrategy(name string) (KeyStrategy, error) {
+	switch k := KeyStrategy(strings.ToLower(name)); k {
+	case "":
+		return IDKey, nil
+	case IDKey, BucketIDKey, BucketKey:
+		return k, nil
+	default:
+		return "", fmt.Errorf("unknown key strategy %q", name)
+	}
+}
+
+// FoldKey lowercases the id in the consistent hash key derived by the strategy, as FoldCase does before routing
+// The bucket is kept as is, only the ids are case-insensitive
+func (k KeyStrategy) FoldKey(key string) string {
+	switch k {
+	case BucketIDKey:
+		bucket, id, _ := strings.Cut(key, "/")
+		return bucket + "/" + foldID(id)
+	case BucketKey:
+		return key
+	default:
+		return foldID(key)
+	}
+}
+
+// foldID returns the case-insensitive form of the id
+func foldID(id string) string {
+	return strings.ToLower(id)
+}
+
+// DrainingWrites is what happens to the writes of an object owned by a draining instance
+type DrainingWrites string
+
+const (
+	// RerouteDrainingWrites writes the object to the next successors on the ring which aren't draining
+	RerouteDrainingWrites DrainingWrites = "reroute"
+	// RejectDrainingWrites rejects the write with a DrainingError, until the instance is undrained or deregistered
+	RejectDrainingWrites DrainingWrites = "reject"
+)
+
+// ParseDrainingWrites parses the name of a draining writes behavior, an empty name is the default RerouteDrainingWrites
+func ParseDrainingWrites(name string) (DrainingWrites, error) {
+	switch d := DrainingWrites(strings.ToLower(name)); d {
+	case "":
+		return RerouteDrainingWrites, nil
+	case RerouteDrainingWrites, RejectDrainingWrites:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown draining writes behavior %q", name)
+	}
+}
+
+// DrainingError is returned when the owner of the object written is draining, and such writes are rejected
+type DrainingError struct {
+	Instance string
+}
+
+// Error returns the error message
+func (d DrainingError) Error() string {
+	return fmt.Sprintf("the owner %s of the object is draining", d.Instance)
+}
+
+// checkDrainingOwner returns a DrainingError when the owner of the key is draining and the writes are rejected
+// The reads are matched as usual, the owner keeps serving them while it is draining
+func (o *ObjectStorage) checkDrainingOwner(ctx context.Context, key string) error {
+	if o.opts.DrainingWrites != RejectDrainingWrites {
+		return nil
+	}
+
+	owners, err := o.matchInstances(ctx, key, 1)
+	if err != nil {
+		return err
+	}
+
+	if o.registry.IsDraining(owners[0].Address()) {
+		return DrainingError{Instance: owners[0].Address()}
+	}
+
+	return nil
+}
+
+// routingKey returns the consistent hash key of the object, which the pins are matched against too
+func (o *ObjectStorage) routingKey(bucket, id string) string {
+	switch o.opts.KeyStrategy {
+	case BucketIDKey:
+		return bucket + "/" + id
+	case BucketKey:
+		return bucket
+	default:
+		return id
+	}
+}
+
+// matchAttempts is the number of attempts of an instance lookup, when the retry budget allows it
+const matchAttempts = 3
+
+// matchInstances returns up to n instances for reading the object, the owner first and then its successors on the ring
+// When no instance matches, e.g. while the ring is briefly empty, the instances last matched for the key are used if recent
+func (o *ObjectStorage) matchInstances(ctx context.Context, id string, n int) ([]registry.ServiceMetadata, error) {
+	instances, err := o.match(ctx, id, n, o.registry.MatchServices)
+	if err != nil {
+		if cached, ok := o.lastKnown.load(id, n); ok {
+			log.Warn("No instance matched, using the last known instances", "key", id, "error", err)
+			metrics.FailoverCacheHits.Inc()
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	o.lastKnown.store(id, n, instances)
+	return instances, nil
+}
+
+// matchWritableInstances returns up to n instances for writing the object, skipping the draining ones
+func (o *ObjectStorage) matchWritableInstances(ctx context.Context, id string, n int) ([]registry.ServiceMetadata, error) {
+	return o.match(ctx, id, n, o.registry.MatchWritableServices)
+}
+
+func (o *ObjectStorage) match(ctx context.Context, id string, n int, matchServices func(key string, n int) ([]registry.ServiceMetadata, error)) ([]registry.ServiceMetadata, error) {
+	var (
+		instances []registry.ServiceMetadata
+		attempts  uint
+		err       error
+	)
+
+	// Retry mechanism to make it resilient to transient failures
+	// The retries draw from the shared budget, so the requests fail fast once too many are retrying
+	err = retry.Do(
+		func() error {
+			attempts++
+			instances, err = matchServices(id, n)
+			if err != nil {
+				return fmt.Errorf("failed to get minio instance for object id: %w", err)
+			}
+			return nil
+		},
+		retry.RetryIf(func(error) bool {
+			return attempts < matchAttempts && o.retries.take()
+		}),
+		retry.OnRetry(func(uint, error) {
+			countRetry(ctx)
+		}),
+		retry.Attempts(matchAttempts),
+		retry.Delay(300*time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+
+	return instances, nil
+}
+
+// preferLocal returns the instances in the local zone first, each group keeping its ring order
+// The writes don't use it, since they go to every replica anyway
+func (o *ObjectStorage) preferLocal(instances []registry.ServiceMetadata) []registry.ServiceMetadata {
+	if o.opts.Zone == "" {
+		return instances
+	}
+
+	// A new slice is built, since the instances may be shared with the failover cache
+	ordered := make([]registry.ServiceMetadata, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Zone == o.opts.Zone {
+			ordered = append(ordered, instance)
+		}
+	}
+	for _, instance := range instances {
+		if instance.Zone != o.opts.Zone {
+			ordered = append(ordered, instance)
+		}
+	}
+
+	return ordered
+}
+
+// getClients returns the minio clients of the given instances
+// The instances whose client can't be created are skipped, it fails only if none of them is usable
+// With the dial check, the unreachable instances are skipped too, so the next candidates are used without waiting on a dead one
+func (o *ObjectStorage) getClients(ctx context.Context, id string, instances []registry.ServiceMetadata) ([]*minio.Client, error) {
+	reachable, err := o.reachable(ctx, id, instances, len(instances))
+	if err != nil {
+		return nil, err
+	}
+
+	return o.clientsOf(id, reachable)
+}
+
+// getWriteClients returns the minio clients of the instances the object is written to
+// With the dial check, spare ring successors are matched on top of the candidates and the unreachable candidates are
+// dropped before the replicas are placed, so a dead instance is replaced by the next successor instead of shrinking the write
+func (o *ObjectStorage) getWriteClients(ctx context.Context, bucket, id string, sizeHint int64) ([]*minio.Client, error) {
+	candidates := o.writeCandidates(bucket, sizeHint)
+	spares := 0
+	if o.opts.DialCheckTimeout > 0 {
+		spares = o.replicationFactor(bucket)
+	}
+
+	instances, err := o.matchWritableInstances(ctx, o.routingKey(bucket, id), candidates+spares)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first reachable candidates in ring order are kept, so the placement is unchanged while every instance is up
+	reachable, err := o.reachable(ctx, id, instances, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.clientsOf(id, o.placeReplicas(bucket, reachable, sizeHint))
+}
+
+// reachable returns up to n of the instances which pass the dial check, in their order
+// It fails only if none of them is reachable
+func (o *ObjectStorage) reachable(ctx context.Context, id string, instances []registry.ServiceMetadata, n int) ([]registry.ServiceMetadata, error) {
+	unreachable := o.dialCheck(ctx, instances)
+	var errs []error
+	reachable := make([]registry.ServiceMetadata, 0, min(n, len(instances)))
+	for i, instance := range instances {
+		if len(reachable) == n {
+			break
+		}
+
+		if unreachable[i] != nil {
+			log.Warn("Skipping unreachable instance", "object_id", id, "instance", instance.Address(), "error", unreachable[i])
+			errs = append(errs, unreachable[i])
+			continue
+		}
+		reachable = append(reachable, instance)
+	}
+
+	if len(reachable) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return reachable, nil
+}
+
+// clientsOf returns the minio clients of the given instances, skipping the ones whose client can't be created
+func (o *ObjectStorage) clientsOf(id string, instances []registry.ServiceMetadata) ([]*minio.Client, error) {
+	var errs []error
+	minioInstances := make([]*minio.Client, 0, len(instances))
+	for _, instance := range instances {
+		minioInstance, err := o.clients.get(instance)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		log.Debug("Matched instance", "object_id", id, "instance", instance.Address())
+		minioInstances = append(minioInstances, minioInstance)
+	}
+
+	if len(minioInstances) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return minioInstances, nil
+}
+
+// dialCheck dials the instances concurrently, and returns the error of each unreachable one
+// Every instance is reachable when the dial check is disabled
+func (o *ObjectStorage) dialCheck(ctx context.Context, instances []registry.ServiceMetadata) []error {
+	errs := make([]error, len(instances))
+	if o.opts.DialCheckTimeout <= 0 {
+		return errs
+	}
+
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dialer := net.Dialer{Timeout: o.opts.DialCheckTimeout}
+			conn, err := dialer.DialContext(ctx, "tcp", instanceEndpoint(instance))
+			if err != nil {
+				errs[i] = fmt.Errorf("instance %s is unreachable: %w", instance.Address(), err)
+				return
+			}
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// ParseBucketReplication parses a comma separated list of bucket=factor replication factors
+func ParseBucketReplication(value string) (map[string]int, error) {
+	factors := make(map[string]int)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		bucket, factor, ok := strings.Cut(field, "=")
+		bucket, factor = strings.TrimSpace(bucket), strings.TrimSpace(factor)
+		if !ok || bucket == "" || factor == "" {
+			return nil, fmt.Errorf("invalid bucket replication factor %q, expected bucket=factor", field)
+		}
+
+		n, err := strconv.Atoi(factor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replication factor of bucket %s: %w", bucket, err)
+		}
+		factors[bucket] = n
+	}
+
+	return factors, nil
+}
+
+// replicationFactor returns the number of instances the objects of the bucket are written to
+func (o *ObjectStorage) replicationFactor(bucket string) int {
+	if n, ok := o.opts.BucketReplication[bucket]; ok {
+		return n
+	}
+
+	return o.opts.ReplicationFactor
+}
+
+// writeQuorum returns the number of replicas that must acknowledge a write to the bucket, at most its replication factor
+func (o *ObjectStorage) writeQuorum(bucket string) int {
+	return min(o.opts.WriteQuorum, o.replicationFactor(bucket))
+}
+
+// readCandidates returns the number of instances an object of the bucket may have been placed on
+func (o *ObjectStorage) readCandidates(bucket string) int {
+	if o.opts.LargeObjectThreshold > 0 {
+		return max(o.replicationFactor(bucket), o.opts.LargeObjectCandidates)
+	}
+
+	return o.replicationFactor(bucket)
+}
+
+// writeCandidates returns the number of instances considered for placing an object of the given size in the bucket
+func (o *ObjectStorage) writeCandidates(bucket string, sizeHint int64) int {
+	if o.isLarge(sizeHint) {
+		return o.readCandidates(bucket)
+	}
+
+	return o.replicationFactor(bucket)
+}
+
+// placeReplicas chooses the instances the object is written to among the candidates
+// Large objects prefer the candidates of the highest weight on the ring, the others keep the ring order
+func (o *ObjectStorage) placeReplicas(bucket string, candidates []registry.ServiceMetadata, sizeHint int64) []registry.ServiceMetadata {
+	if o.isLarge(sizeHint) {
+		// The stable sort keeps the ring order between candidates of equal weight, so placement stays deterministic
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].EffectiveWeight() > candidates[j].EffectiveWeight()
+		})
+	}
+
+	return candidates[:min(len(candidates), o.replicationFactor(bucket))]
+}
+
+func (o *ObjectStorage) isLarge(sizeHint int64) bool {
+	return o.opts.LargeObjectThreshold > 0 && sizeHint >= o.opts.LargeObjectThreshold
+}
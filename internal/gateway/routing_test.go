@@ -0,0 +1,535 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/registry"
+)
+
+func TestPutObjectReplacesUnreachableReplicas(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	storage, r := newTestStorage(t, Options{ReplicationFactor: 2, WriteQuorum: 2, StrictReplication: true, DialCheckTimeout: time.Second}, instances...)
+
+	owners := ownersOf(t, r, "id", instances)
+	owners[0].stop()
+
+	if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	// The dead owner is replaced by the next successor, instead of the write falling short of the replication factor
+	for i, owner := range owners {
+		stored := owner.object("bucket", "id") != nil
+		if want := i == 1 || i == 2; stored != want {
+			t.Errorf("successor %d stored the object: %v, want %v", i, stored, want)
+		}
+	}
+}
+
+func TestFewerInstancesThanTheReplicationFactor(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+	}{
+		{name: "degraded"},
+		{name: "strict", strict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			storage, _ := newTestStorage(t, Options{ReplicationFactor: 3, WriteQuorum: 3, StrictReplication: tt.strict}, instance)
+
+			_, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{})
+			if tt.strict {
+				if !errorIs[InsufficientReplicasError](err) {
+					t.Errorf("PutObject() error = %v, want an InsufficientReplicasError", err)
+				}
+				return
+			}
+
+			// The write goes to the single instance, with the quorum lowered to it
+			if err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			if object, err := storage.GetObject(context.Background(), "bucket", "id"); err != nil || string(object.Data) != "data" {
+				t.Errorf("GetObject() = %q, %v, want %q", object.Data, err, "data")
+			}
+		})
+	}
+}
+
+func TestPutObjectToANewBucket(t *testing.T) {
+	created, racing, failing := newFakeInstance(t), newFakeInstance(t), newFakeInstance(t)
+	// Another write created the bucket on the racing instance between its check and its creation
+	racing.put("bucket", "other", []byte("data"), nil)
+	racing.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodHead && strings.Trim(r.URL.Path, "/") == "bucket" {
+			w.WriteHeader(http.StatusNotFound)
+			return true
+		}
+		return false
+	})
+	failing.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.Trim(r.URL.Path, "/") == "bucket" {
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket", "")
+			return true
+		}
+		return false
+	})
+	instances := []*fakeInstance{created, racing, failing}
+	storage, _ := newTestStorage(t, Options{ReplicationFactor: 3, WriteQuorum: 2}, instances...)
+
+	if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v, want the write to reach its quorum", err)
+	}
+	if got := holders(instances, "bucket", "id"); len(got) != 2 || got[0] != created || got[1] != racing {
+		t.Errorf("object is on %d instances, want it on the instances that created the bucket or found it created", len(got))
+	}
+}
+
+func TestConcurrentWritesToANewBucket(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t), newFakeInstance(t), newFakeInstance(t)}
+	storage, _ := newTestStorage(t, Options{ReplicationFactor: 3, WriteQuorum: 3}, instances...)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := storage.PutObject(context.Background(), "bucket", fmt.Sprint("id", i), NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("PutObject() error = %v, want the concurrent bucket creations tolerated", err)
+	}
+}
+
+func TestPutObjectKeepsThePlacementOfReachableReplicas(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	storage, r := newTestStorage(t, Options{ReplicationFactor: 2, WriteQuorum: 2, DialCheckTimeout: time.Second}, instances...)
+
+	if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	owners := ownersOf(t, r, "id", instances)
+	if owners[0].object("bucket", "id") == nil || owners[1].object("bucket", "id") == nil || owners[2].object("bucket", "id") != nil {
+		t.Error("the object wasn't written to its two owners only, although they are reachable")
+	}
+}
+
+func TestReadOnlyInstance(t *testing.T) {
+	archive, writable := newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")
+	archive.readOnly = true
+	storage, r := newTestStorage(t, Options{}, archive, writable)
+
+	for i := range 10 {
+		id := fmt.Sprint("id", i)
+		if _, err := storage.PutObject(context.Background(), "bucket", id, NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+		if archive.object("bucket", id) != nil || writable.object("bucket", id) == nil {
+			t.Errorf("object %s wasn't written to the writable instance only", id)
+		}
+	}
+
+	// The reads match the read-only instance where it owns the key
+	id := "archived"
+	for i := 0; ownersOf(t, r, id, []*fakeInstance{archive, writable})[0] != archive; i++ {
+		id = fmt.Sprint("archived", i)
+	}
+	archive.put("bucket", id, []byte("archived"), nil)
+	if object, err := storage.GetObject(context.Background(), "bucket", id); err != nil || string(object.Data) != "archived" {
+		t.Errorf("GetObject() = %q, %v, want the object read from the read-only instance", object.Data, err)
+	}
+}
+
+func TestRoutingToAHostname(t *testing.T) {
+	instance := newFakeInstanceAt(t, "127.0.0.1", "bucket")
+	instance.put("bucket", "id", []byte("data"), nil)
+
+	// The IP address is unreachable, so the object can only be read through the hostname
+	r := registry.NewRegistry(hashring.New())
+	r.RegisterService(registry.ServiceMetadata{Name: "minio", Hostname: "localhost", IPAddress: "192.0.2.1", AccessKey: "minio", SecretKey: "minio123"})
+	storage, err := NewObjectStorage(r, Options{Region: testRegion, ClientMaxRetries: 1, ReplicationFactor: 1, WriteQuorum: 1})
+	if err != nil {
+		t.Fatalf("NewObjectStorage() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	object, err := storage.GetObject(ctx, "bucket", "id")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	if string(object.Data) != "data" {
+		t.Errorf("GetObject() = %q, want %q", object.Data, "data")
+	}
+}
+
+func TestPutObjectSizeAwarePlacement(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	r := registry.NewRegistry(hashring.New())
+	for i, instance := range instances {
+		service := instance.service()
+		service.Weight = 20
+		if i == 0 {
+			service.Weight = hashring.TopWeight
+		}
+		r.RegisterService(service)
+	}
+	storage, err := NewObjectStorage(r, Options{
+		Region: testRegion, ClientMaxRetries: 1, ReplicationFactor: 1, WriteQuorum: 1,
+		LargeObjectThreshold: 1024, LargeObjectCandidates: 3,
+	})
+	if err != nil {
+		t.Fatalf("NewObjectStorage() error = %v", err)
+	}
+
+	heavy := instances[0]
+	for i := range 20 {
+		small, large := fmt.Sprintf("small%d", i), fmt.Sprintf("large%d", i)
+		if _, err = storage.PutObject(context.Background(), "bucket", small, NewBytesBody([]byte("data")), PutOptions{SizeHint: 4}); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+		if _, err = storage.PutObject(context.Background(), "bucket", large, NewBytesBody([]byte("data")), PutOptions{SizeHint: 1 << 20}); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+
+		// The small objects keep the ring placement, the large ones go to the highest weight instance
+		if owner := ownersOf(t, r, small, instances)[0]; owner.object("bucket", small) == nil {
+			t.Errorf("small object %s wasn't written to its ring owner %s", small, owner.address)
+		}
+		if heavy.object("bucket", large) == nil {
+			t.Errorf("large object %s wasn't written to the highest weight instance", large)
+		}
+
+		// The large objects are still found by the reads, wherever the ring places them
+		if _, err = storage.GetObject(context.Background(), "bucket", large); err != nil {
+			t.Errorf("GetObject(%s) error = %v", large, err)
+		}
+	}
+}
+
+func TestPutObjectPlainHashingByDefault(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	storage, r := newTestStorage(t, Options{}, instances...)
+
+	for i := range 10 {
+		id := fmt.Sprintf("large%d", i)
+		if _, err := storage.PutObject(context.Background(), "bucket", id, NewBytesBody([]byte("data")), PutOptions{SizeHint: 1 << 30}); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+		if owner := ownersOf(t, r, id, instances)[0]; owner.object("bucket", id) == nil {
+			t.Errorf("object %s wasn't written to its ring owner without a large object threshold", id)
+		}
+	}
+}
+
+func TestPlaceReplicasByEffectiveWeight(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []registry.ServiceMetadata
+		want       string
+	}{
+		{
+			name:       "unset weight",
+			candidates: []registry.ServiceMetadata{{IPAddress: "weighted", Weight: 50}, {IPAddress: "unset"}},
+			want:       "unset",
+		},
+		{
+			name:       "capacity weight",
+			candidates: []registry.ServiceMetadata{{IPAddress: "full", Weight: hashring.TopWeight, CapacityWeight: 10}, {IPAddress: "weighted", Weight: 50}},
+			want:       "weighted",
+		},
+		{
+			name:       "equal weights",
+			candidates: []registry.ServiceMetadata{{IPAddress: "first", CapacityWeight: 50}, {IPAddress: "second", Weight: 50}},
+			want:       "first",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &ObjectStorage{opts: Options{ReplicationFactor: 1, LargeObjectThreshold: 1024}}
+			placed := o.placeReplicas("bucket", tt.candidates, 1<<20)
+			if len(placed) != 1 || placed[0].Address() != tt.want {
+				t.Errorf("placeReplicas() = %v, want %s", placed, tt.want)
+			}
+		})
+	}
+}
+
+func TestDrainingInstance(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	storage, r := newTestStorage(t, Options{}, instances...)
+	owners := ownersOf(t, r, "id", instances)
+	if err := r.SetDraining(owners[0].service().Name, true); err != nil {
+		t.Fatalf("SetDraining() error = %v", err)
+	}
+
+	// The new writes go to the successor, where the reads find them
+	if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if owners[0].object("bucket", "id") != nil || owners[1].object("bucket", "id") == nil {
+		t.Error("the write wasn't moved from the draining owner to its successor")
+	}
+	if _, err := storage.GetObject(context.Background(), "bucket", "id"); err != nil {
+		t.Errorf("GetObject() error = %v, want the object found on the successor", err)
+	}
+}
+
+func TestDrainingWrites(t *testing.T) {
+	tests := []struct {
+		writes   DrainingWrites
+		rejected bool
+	}{
+		{writes: RerouteDrainingWrites},
+		{writes: RejectDrainingWrites, rejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.writes), func(t *testing.T) {
+			instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+			storage, r := newTestStorage(t, Options{DrainingWrites: tt.writes}, instances...)
+			owners := ownersOf(t, r, "stored", instances)
+			if _, err := storage.PutObject(context.Background(), "bucket", "stored", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			if err := r.SetDraining(owners[0].service().Name, true); err != nil {
+				t.Fatalf("SetDraining() error = %v", err)
+			}
+
+			// The draining owner keeps serving the objects it holds
+			if _, err := storage.GetObject(context.Background(), "bucket", "stored"); err != nil {
+				t.Errorf("GetObject() error = %v, want the object read from the draining owner", err)
+			}
+
+			_, err := storage.PutObject(context.Background(), "bucket", "stored", NewBytesBody([]byte("new data")), PutOptions{})
+			if tt.rejected {
+				var drainingErr DrainingError
+				if !errors.As(err, &drainingErr) || drainingErr.Instance != owners[0].address {
+					t.Errorf("PutObject() error = %v, want a DrainingError of %s", err, owners[0].address)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PutObject() error = %v, want the write rerouted", err)
+			}
+			if object := owners[1].object("bucket", "stored"); object == nil || string(object.data) != "new data" {
+				t.Error("the write wasn't rerouted to the successor of the draining owner")
+			}
+		})
+	}
+}
+
+func TestParseDrainingWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    DrainingWrites
+		wantErr bool
+	}{
+		{name: "", want: RerouteDrainingWrites},
+		{name: "reroute", want: RerouteDrainingWrites},
+		{name: "Reject", want: RejectDrainingWrites},
+		{name: "drop", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDrainingWrites(tt.name)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("ParseDrainingWrites(%q) = %q, %v, want %q and an error: %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFoldKey(t *testing.T) {
+	tests := []struct {
+		strategy KeyStrategy
+		key      string
+		want     string
+	}{
+		{strategy: IDKey, key: "Invoice42", want: "invoice42"},
+		{strategy: BucketIDKey, key: "Invoices/Invoice42", want: "Invoices/invoice42"},
+		{strategy: BucketKey, key: "Invoices", want: "Invoices"},
+	}
+	for _, tt := range tests {
+		if got := tt.strategy.FoldKey(tt.key); got != tt.want {
+			t.Errorf("%s FoldKey(%q) = %q, want %q", tt.strategy, tt.key, got, tt.want)
+		}
+	}
+}
+
+// holders returns the instances holding the object
+func holders(instances []*fakeInstance, bucket, key string) []*fakeInstance {
+	var found []*fakeInstance
+	for _, instance := range instances {
+		if instance.object(bucket, key) != nil {
+			found = append(found, instance)
+		}
+	}
+	return found
+}
+
+func TestKeyStrategy(t *testing.T) {
+	tests := []struct {
+		strategy KeyStrategy
+		// colocated tells whether all the objects of a bucket are on one instance
+		colocated bool
+		// sameID tells whether an id is on the same instance in every bucket
+		sameID bool
+	}{
+		{strategy: IDKey, sameID: true},
+		{strategy: BucketIDKey},
+		{strategy: BucketKey, colocated: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			// The addresses are fixed, so the two buckets always hash to different instances
+			var instances []*fakeInstance
+			for i := range 4 {
+				instances = append(instances, newFakeInstanceAt(t, fmt.Sprintf("127.1.0.%d", i+1), "first", "second"))
+			}
+			storage, _ := newTestStorage(t, Options{KeyStrategy: tt.strategy}, instances...)
+
+			used := make(map[*fakeInstance]bool)
+			sameID := true
+			for i := range 40 {
+				id := fmt.Sprintf("id%d", i)
+				for _, bucket := range []string{"first", "second"} {
+					if _, err := storage.PutObject(context.Background(), bucket, id, NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+						t.Fatalf("PutObject() error = %v", err)
+					}
+					if _, err := storage.GetObject(context.Background(), bucket, id); err != nil {
+						t.Fatalf("GetObject() error = %v", err)
+					}
+				}
+
+				first, second := holders(instances, "first", id), holders(instances, "second", id)
+				if len(first) != 1 || len(second) != 1 {
+					t.Fatalf("object %s is on %d and %d instances, want one", id, len(first), len(second))
+				}
+				used[first[0]] = true
+				sameID = sameID && first[0] == second[0]
+			}
+
+			if colocated := len(used) == 1; colocated != tt.colocated {
+				t.Errorf("the objects of the bucket are on %d instances, want them colocated: %v", len(used), tt.colocated)
+			}
+			if sameID != tt.sameID {
+				t.Errorf("every id is on the same instance in both buckets: %v, want %v", sameID, tt.sameID)
+			}
+		})
+	}
+}
+
+func TestParseKeyStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    KeyStrategy
+		wantErr bool
+	}{
+		{name: "", want: IDKey},
+		{name: "id", want: IDKey},
+		{name: "Bucket/ID", want: BucketIDKey},
+		{name: "bucket", want: BucketKey},
+		{name: "object", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeyStrategy(tt.name)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("ParseKeyStrategy(%q) = %q, %v, want %q and an error: %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBucketReplication(t *testing.T) {
+	instances := []*fakeInstance{
+		newFakeInstance(t, "critical", "scratch"), newFakeInstance(t, "critical", "scratch"), newFakeInstance(t, "critical", "scratch"),
+	}
+	// The write quorum of the scratch bucket is capped at its replication factor
+	opts := Options{ReplicationFactor: 3, WriteQuorum: 3, BucketReplication: map[string]int{"scratch": 1}}
+	storage, _ := newTestStorage(t, opts, instances...)
+
+	for _, tt := range []struct {
+		bucket string
+		want   int
+	}{{bucket: "critical", want: 3}, {bucket: "scratch", want: 1}} {
+		if _, err := storage.PutObject(context.Background(), tt.bucket, "id", NewBytesBody([]byte("data")), PutOptions{}); err != nil {
+			t.Fatalf("PutObject(%s) error = %v", tt.bucket, err)
+		}
+		if got := len(holders(instances, tt.bucket, "id")); got != tt.want {
+			t.Errorf("the object of bucket %s is on %d instances, want %d", tt.bucket, got, tt.want)
+		}
+		if object, err := storage.GetObject(context.Background(), tt.bucket, "id"); err != nil || string(object.Data) != "data" {
+			t.Errorf("GetObject(%s) = %q, %v, want %q", tt.bucket, object.Data, err, "data")
+		}
+	}
+}
+
+func TestParseBucketReplication(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]int
+		wantErr bool
+	}{
+		{value: "", want: map[string]int{}},
+		{value: "critical=3, scratch = 1,", want: map[string]int{"critical": 3, "scratch": 1}},
+		{value: "critical", wantErr: true},
+		{value: "=3", wantErr: true},
+		{value: "critical=three", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBucketReplication(tt.value)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !maps.Equal(got, tt.want)) {
+			t.Errorf("ParseBucketReplication(%q) = %v, %v, want %v and an error: %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetObjectPrefersTheLocalZone(t *testing.T) {
+	tests := []struct {
+		name  string
+		zones []string // The zones of the replicas, in ring order
+		want  int      // The replica expected to serve the read
+	}{
+		{name: "local replica last", zones: []string{"a", "a", "b"}, want: 2},
+		{name: "local replica first", zones: []string{"b", "a", "b"}, want: 0},
+		{name: "no local replica", zones: []string{"a", "c", "a"}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instances := []*fakeInstance{newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+			storage, r := newTestStorage(t, Options{ReplicationFactor: 3, WriteQuorum: 3, Zone: "b"}, instances...)
+			owners := ownersOf(t, r, "id", instances)
+			for i, owner := range owners {
+				// The zone doesn't move the instance on the ring, so it is registered again with it
+				owner.zone = tt.zones[i]
+				r.RegisterService(owner.service())
+				owner.put("bucket", "id", []byte("data"), nil)
+			}
+
+			if _, err := storage.GetObject(context.Background(), "bucket", "id"); err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			for i, owner := range owners {
+				if served := owner.requests.Load() > 0; served != (i == tt.want) {
+					t.Errorf("replica %d in zone %s served the read: %v, want replica %d to serve it", i, tt.zones[i], served, tt.want)
+				}
+			}
+		})
+	}
+}
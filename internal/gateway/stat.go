@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ObjectInfo is the metadata of an object, without its data
+type ObjectInfo struct {
+	Bucket       string            `json:"bucket"`
+	ID           string            `json:"id"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"content_type,omitempty"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"last_modified"`
+	Headers      http.Header       `json:"headers,omitempty"`
+	UserMetadata map[string]string `json:"user_metadata,omitempty"`
+}
+
+// StatObject returns the metadata of the object from the first of its replicas that has it
+// The size is the one of the object as uploaded, even when it is stored compressed
+func (o *ObjectStorage) StatObject(ctx context.Context, bucket, id string) (ObjectInfo, error) {
+	id = o.normalizeID(id)
+	instances, err := o.matchInstances(ctx, o.routingKey(bucket, id), o.readCandidates(bucket))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	minioInstances, err := o.getClients(ctx, id, o.preferLocal(instances))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	var (
+		errs          []error
+		deleted       error
+		bucketMissing = true // Whether every replica reported the bucket missing, rather than only the object
+	)
+	for _, minioInstance := range minioInstances {
+		info, err := o.statObjectWithFallback(ctx, minioInstance, bucket, id)
+		if err == nil {
+			return info, nil
+		}
+
+		if isDeleted(err) {
+			deleted = err
+			continue
+		}
+
+		if errors.Is(err, NotFoundError{}) {
+			bucketMissing = false
+			continue
+		}
+
+		if !isMissing(err) {
+			log.Error("Replica stat failed", "instance", minioInstance.EndpointURL().Host, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	if deleted != nil {
+		return ObjectInfo{}, deleted
+	}
+
+	if len(errs) > 0 {
+		return ObjectInfo{}, errors.Join(errs...)
+	}
+
+	if bucketMissing {
+		return ObjectInfo{}, BucketNotFoundError{Bucket: bucket}
+	}
+
+	return ObjectInfo{}, NotFoundError{}
+}
+
+func (o *ObjectStorage) statObjectWithFallback(ctx context.Context, minioInstance *minio.Client, bucket, id string) (ObjectInfo, error) {
+	info, err := withInstance(ctx, o, minioInstance, func() (ObjectInfo, error) {
+		statCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Stat)
+		defer cancel()
+		return statObject(statCtx, minioInstance, bucket, id)
+	})
+	if isMissing(err) && o.opts.FallbackBucket != "" && o.opts.FallbackBucket != bucket {
+		log.Debug("Object not found, trying the fallback bucket", "bucket", bucket, "fallback_bucket", o.opts.FallbackBucket, "id", id)
+		return withInstance(ctx, o, minioInstance, func() (ObjectInfo, error) {
+			statCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Stat)
+			defer cancel()
+			return statObject(statCtx, minioInstance, o.opts.FallbackBucket, id)
+		})
+	}
+
+	return info, err
+}
+
+func statObject(ctx context.Context, minioInstance *minio.Client, bucket, id string) (ObjectInfo, error) {
+	info, err := minioInstance.StatObject(ctx, bucket, id, minio.StatObjectOptions{})
+	if err != nil {
+		if isNotFound(err) {
+			return ObjectInfo{}, notFoundError(err, bucket)
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	if at, deleted := deletedAt(info.UserMetadata); deleted {
+		return ObjectInfo{}, DeletedError{DeletedAt: at}
+	}
+
+	size := info.Size
+	if originalSize, ok := info.UserMetadata[originalSizeMetadataKey]; ok {
+		if size, err = strconv.ParseInt(originalSize, 10, 64); err != nil {
+			return ObjectInfo{}, fmt.Errorf("invalid original size metadata %q: %w", originalSize, err)
+		}
+	}
+
+	userMetadata := make(map[string]string, len(info.UserMetadata))
+	for k, v := range info.UserMetadata {
+		if !isReservedMetadata(k) {
+			userMetadata[k] = v
+		}
+	}
+
+	return ObjectInfo{
+		Bucket:       bucket,
+		ID:           id,
+		Size:         size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		Headers:      storedHeaders(info),
+		UserMetadata: userMetadata,
+	}, nil
+}
@@ -0,0 +1,50 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestStatObject(t *testing.T) {
+	instance := newFakeInstance(t, "bucket")
+	storage, _ := newTestStorage(t, Options{Compression: GzipCompression}, instance)
+	data := []byte("a compressible body, a compressible body, a compressible body")
+	opts := PutOptions{
+		ContentType:  "text/plain",
+		Headers:      http.Header{"Cache-Control": {"no-cache"}},
+		UserMetadata: map[string]string{"Owner": "alice"},
+	}
+	if _, err := storage.PutObject(context.Background(), "bucket", "id", NewBytesBody(data), opts); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	info, err := storage.StatObject(context.Background(), "bucket", "id")
+	if err != nil {
+		t.Fatalf("StatObject() error = %v", err)
+	}
+	if info.Bucket != "bucket" || info.ID != "id" || info.Size != int64(len(data)) || info.ContentType != "text/plain" {
+		t.Errorf("StatObject() = %+v, want the object as uploaded", info)
+	}
+	if info.ETag == "" || info.LastModified.IsZero() {
+		t.Errorf("StatObject() = %+v, want its ETag and modification time", info)
+	}
+	if info.Headers.Get("Cache-Control") != "no-cache" {
+		t.Errorf("headers = %v, want the stored Cache-Control", info.Headers)
+	}
+	// The compression is internal to the gateway, so it isn't part of the user metadata
+	if len(info.UserMetadata) != 1 || info.UserMetadata["Owner"] != "alice" {
+		t.Errorf("user metadata = %v, want only the one of the client", info.UserMetadata)
+	}
+}
+
+func TestStatObjectNotFound(t *testing.T) {
+	storage, _ := newTestStorage(t, Options{}, newFakeInstance(t, "bucket"))
+	if _, err := storage.StatObject(context.Background(), "bucket", "id"); !errors.Is(err, NotFoundError{}) {
+		t.Errorf("StatObject() error = %v, want a NotFoundError", err)
+	}
+	if _, err := storage.StatObject(context.Background(), "missing", "id"); !errorIs[BucketNotFoundError](err) {
+		t.Errorf("StatObject() error = %v, want a BucketNotFoundError", err)
+	}
+}
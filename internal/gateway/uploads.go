@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// IncompleteUpload is a multipart upload left in progress on an instance, e.g. by a replica write that failed midway
+// Its parts take space on the instance until it is aborted
+type IncompleteUpload struct {
+	Instance  string    `json:"instance"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	UploadID  string    `json:"upload_id"`
+	Initiated time.Time `json:"initiated"`
+}
+
+// UploadListing is the listing of the incomplete uploads across the instances
+type UploadListing struct {
+	Uploads []IncompleteUpload
+	// SkippedInstances are the instances that couldn't be listed, so the listing may be missing uploads
+	SkippedInstances []string
+}
+
+// Partial reports whether some instances couldn't be listed
+func (l UploadListing) Partial() bool {
+	return len(l.SkippedInstances) > 0
+}
+
+// UploadNotFoundError is returned when the upload to abort isn't in progress on the instance
+type UploadNotFoundError struct {
+	UploadID string
+}
+
+// Error returns the error message
+func (u UploadNotFoundError) Error() string {
+	return fmt.Sprintf("upload %s not found", u.UploadID)
+}
+
+// InstanceNotFoundError is returned when no registered instance has the given address
+type InstanceNotFoundError struct {
+	Address string
+}
+
+// Error returns the error message
+func (i InstanceNotFoundError) Error() string {
+	return fmt.Sprintf("no instance is registered with address %s", i.Address)
+}
+
+// ListIncompleteUploads lists the multipart uploads in progress in every bucket of every instance, oldest first
+// Unreachable instances are skipped and reported in the listing, it fails only if no instance could be listed
+func (o *ObjectStorage) ListIncompleteUploads(ctx context.Context) (UploadListing, error) {
+	results, err := fanOut(ctx, o, listIncompleteUploads)
+	if err != nil {
+		return UploadListing{}, err
+	}
+
+	listing := UploadListing{Uploads: []IncompleteUpload{}}
+	for _, r := range results {
+		if r.err != nil {
+			listing.SkippedInstances = append(listing.SkippedInstances, r.instance)
+			continue
+		}
+
+		for _, upload := range r.value {
+			upload.Instance = r.instance
+			listing.Uploads = append(listing.Uploads, upload)
+		}
+	}
+
+	sort.Slice(listing.Uploads, func(i, j int) bool {
+		return listing.Uploads[i].Initiated.Before(listing.Uploads[j].Initiated)
+	})
+	sort.Strings(listing.SkippedInstances)
+
+	return listing, nil
+}
+
+func listIncompleteUploads(ctx context.Context, minioInstance *minio.Client) ([]IncompleteUpload, error) {
+	buckets, err := minioInstance.ListBuckets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	var uploads []IncompleteUpload
+	for _, bucket := range buckets {
+		for info := range minioInstance.ListIncompleteUploads(ctx, bucket.Name, "", true) {
+			if info.Err != nil {
+				return nil, fmt.Errorf("failed to list the incomplete uploads of bucket %s: %w", bucket.Name, info.Err)
+			}
+
+			uploads = append(uploads, IncompleteUpload{
+				Bucket:    bucket.Name,
+				Key:       info.Key,
+				UploadID:  info.UploadID,
+				Initiated: info.Initiated,
+			})
+		}
+	}
+
+	return uploads, nil
+}
+
+// AbortUpload aborts the multipart upload of the key in progress on the instance with the given address
+// The parts uploaded so far are removed
+func (o *ObjectStorage) AbortUpload(ctx context.Context, address, bucket, key, uploadID string) error {
+	var minioInstance *minio.Client
+	for _, instance := range o.registry.GetAllServices() {
+		if instance.Address() != address {
+			continue
+		}
+
+		client, err := o.clients.get(instance)
+		if err != nil {
+			return err
+		}
+		minioInstance = client
+		break
+	}
+	if minioInstance == nil {
+		return InstanceNotFoundError{Address: address}
+	}
+
+	_, err := withInstance(ctx, o, minioInstance, func() (struct{}, error) {
+		abortCtx, cancel := withTimeout(ctx, o.opts.Timeouts.Delete)
+		defer cancel()
+
+		// minio reports a missing upload with its code only, without the status code
+		err := minio.Core{Client: minioInstance}.AbortMultipartUpload(abortCtx, bucket, key, uploadID)
+		var minioErr minio.ErrorResponse
+		if errors.As(err, &minioErr) && minioErr.Code == "NoSuchUpload" {
+			return struct{}{}, UploadNotFoundError{UploadID: uploadID}
+		}
+		if err != nil {
+			return struct{}{}, fmt.Errorf("failed to abort upload: %w", err)
+		}
+		return struct{}{}, nil
+	})
+	return err
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestListIncompleteUploads(t *testing.T) {
+	instances := []*fakeInstance{newFakeInstance(t, "bucket", "other"), newFakeInstance(t, "bucket"), newFakeInstance(t, "bucket")}
+	now := time.Now().UTC().Truncate(time.Second)
+	instances[0].addUpload("bucket", "recent", "upload1", now)
+	instances[0].addUpload("other", "stale", "upload2", now.Add(-2*time.Hour))
+	instances[1].addUpload("bucket", "older", "upload3", now.Add(-time.Hour))
+	storage, _ := newTestStorage(t, Options{}, instances...)
+	instances[2].stop()
+
+	listing, err := storage.ListIncompleteUploads(context.Background())
+	if err != nil {
+		t.Fatalf("ListIncompleteUploads() error = %v", err)
+	}
+
+	// The uploads of every bucket of the reachable instances are listed, oldest first
+	want := []IncompleteUpload{
+		{Instance: instances[0].address, Bucket: "other", Key: "stale", UploadID: "upload2", Initiated: now.Add(-2 * time.Hour)},
+		{Instance: instances[1].address, Bucket: "bucket", Key: "older", UploadID: "upload3", Initiated: now.Add(-time.Hour)},
+		{Instance: instances[0].address, Bucket: "bucket", Key: "recent", UploadID: "upload1", Initiated: now},
+	}
+	if len(listing.Uploads) != len(want) {
+		t.Fatalf("uploads = %v, want %v", listing.Uploads, want)
+	}
+	for i, upload := range listing.Uploads {
+		if upload.Instance != want[i].Instance || upload.Bucket != want[i].Bucket || upload.Key != want[i].Key ||
+			upload.UploadID != want[i].UploadID || !upload.Initiated.Equal(want[i].Initiated) {
+			t.Errorf("upload %d = %+v, want %+v", i, upload, want[i])
+		}
+	}
+	if !listing.Partial() || len(listing.SkippedInstances) != 1 || listing.SkippedInstances[0] != instances[2].address {
+		t.Errorf("skipped instances = %v, want the stopped one %s", listing.SkippedInstances, instances[2].address)
+	}
+}
+
+func TestAbortUpload(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string // The address of the instance to abort on, the fake one when empty
+		uploadID string
+		wantErr  func(error) bool
+	}{
+		{name: "in progress", uploadID: "upload"},
+		{name: "unknown upload", uploadID: "other", wantErr: errorIs[UploadNotFoundError]},
+		{name: "unknown instance", address: "10.255.255.1", uploadID: "upload", wantErr: errorIs[InstanceNotFoundError]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newFakeInstance(t, "bucket")
+			instance.addUpload("bucket", "key", "upload", time.Now())
+			storage, _ := newTestStorage(t, Options{}, instance)
+			address := tt.address
+			if address == "" {
+				address = instance.address
+			}
+
+			err := storage.AbortUpload(context.Background(), address, "bucket", "key", tt.uploadID)
+			if tt.wantErr != nil {
+				if !tt.wantErr(err) {
+					t.Fatalf("AbortUpload() error = %v, want a %s error", err, tt.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AbortUpload() error = %v", err)
+			}
+
+			listing, err := storage.ListIncompleteUploads(context.Background())
+			if err != nil || len(listing.Uploads) != 0 {
+				t.Errorf("ListIncompleteUploads() = %v, %v after the abort, want none", listing.Uploads, err)
+			}
+		})
+	}
+}
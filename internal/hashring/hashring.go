@@ -0,0 +1,363 @@
+package hashring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/zeromicro/go-zero/core/hash"
+	"github.com/zeromicro/go-zero/core/lang"
+)
+
+const (
+	// TopWeight is the top weight that one node might set
+	TopWeight = 100
+	// DefaultReplicas is the default number of virtual nodes of a node
+	DefaultReplicas = 100
+
+	prime = 16777619
+
+	// DefaultAlgorithm is the name of the hash func used when none is given
+	DefaultAlgorithm = "murmur3"
+	// CustomAlgorithm is the name reported for a hash func given to NewCustom
+	CustomAlgorithm = "custom"
+	// WeightMode is how the node weights are applied, as a share of the virtual nodes of a node
+	WeightMode = "virtual-nodes"
+)
+
+// Func defines the hash method
+type Func func(data []byte) uint64
+
+// VirtualNode is a position on the ring owned by a node
+type VirtualNode struct {
+	Position uint64 `json:"position"`
+	Node     string `json:"node"`
+}
+
+// Params are the parameters of a ConsistentHash, which decide the placement of the keys
+type Params struct {
+	Algorithm    string `json:"algorithm"`
+	VirtualNodes int    `json:"virtual_nodes"`
+	WeightMode   string `json:"weight_mode"`
+	TopWeight    int    `json:"top_weight"`
+	Seed         string `json:"seed,omitempty"`
+}
+
+// ConsistentHash is a ring hash implementation
+// It follows the same placement as go-zero's hash.ConsistentHash, so keys keep their owners,
+// but it also exposes the ring layout for inspection
+type ConsistentHash struct {
+	hashFunc  Func
+	algorithm string
+	seed      string
+	replicas  int
+	keys      []uint64
+	ring      map[uint64][]any
+	nodes     map[string]struct{}
+	lock      sync.RWMutex
+}
+
+// New returns a ConsistentHash with the default number of replicas and hash func
+func New() *ConsistentHash {
+	return NewCustom(DefaultReplicas, nil)
+}
+
+// NewCustom returns a ConsistentHash with the given replicas and hash func
+func NewCustom(replicas int, fn Func) *ConsistentHash {
+	if replicas < DefaultReplicas {
+		replicas = DefaultReplicas
+	}
+
+	algorithm := CustomAlgorithm
+	if fn == nil {
+		fn, algorithm = hash.Hash, DefaultAlgorithm
+	}
+
+	return &ConsistentHash{
+		hashFunc:  fn,
+		algorithm: algorithm,
+		replicas:  replicas,
+		ring:      make(map[uint64][]any),
+		nodes:     make(map[string]struct{}),
+	}
+}
+
+// NewSeeded returns a ConsistentHash with the given replicas and the default hash func salted with seed
+// Another seed reshuffles the placement of every key, without changing the nodes, an empty seed is the default placement
+func NewSeeded(replicas int, seed string) *ConsistentHash {
+	if seed == "" {
+		return NewCustom(replicas, nil)
+	}
+
+	h := NewCustom(replicas, func(data []byte) uint64 {
+		return hash.Hash(append([]byte(seed), data...))
+	})
+	h.algorithm, h.seed = DefaultAlgorithm, seed
+	return h
+}
+
+// Params returns the parameters of the hash
+func (h *ConsistentHash) Params() Params {
+	return Params{Algorithm: h.algorithm, VirtualNodes: h.replicas, WeightMode: WeightMode, TopWeight: TopWeight, Seed: h.seed}
+}
+
+// Add adds the node with the number of h.replicas
+// The later call will overwrite the replicas of the former calls
+func (h *ConsistentHash) Add(node any) {
+	h.AddWithReplicas(node, h.replicas)
+}
+
+// AddWithReplicas adds the node with the number of replicas
+// replicas will be truncated to h.replicas if it's larger than h.replicas
+// The later call will overwrite the replicas of the former calls
+// The former replicas are replaced under the same lock, so the node is never missing from the ring meanwhile
+func (h *ConsistentHash) AddWithReplicas(node any, replicas int) {
+	if replicas > h.replicas {
+		replicas = h.replicas
+	}
+
+	nodeRepr := repr(node)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.remove(nodeRepr)
+	h.nodes[nodeRepr] = struct{}{}
+
+	for i := 0; i < replicas; i++ {
+		hash := h.hashFunc([]byte(nodeRepr + strconv.Itoa(i)))
+		h.keys = append(h.keys, hash)
+		h.ring[hash] = append(h.ring[hash], node)
+	}
+
+	sort.Slice(h.keys, func(i, j int) bool {
+		return h.keys[i] < h.keys[j]
+	})
+}
+
+// AddWithWeight adds the node with weight, the weight can be 1 to 100, indicates the percent
+// The later call will overwrite the replicas of the former calls
+// The node gets at least one virtual node, so a low weight doesn't leave it, or a single node ring, unmatched
+func (h *ConsistentHash) AddWithWeight(node any, weight int) {
+	replicas := max(h.replicas*weight/TopWeight, 1)
+	h.AddWithReplicas(node, replicas)
+}
+
+// Get returns the corresponding node from h based on the given v
+func (h *ConsistentHash) Get(v any) (any, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if len(h.ring) == 0 {
+		return nil, false
+	}
+
+	return h.pick(v, h.locate(v))
+}
+
+// GetN returns up to n distinct nodes for v
+// The first node is the one returned by Get, the others are its successors on the ring
+// Fewer nodes are returned when the ring has less than n, e.g. a single one for a single node ring
+func (h *ConsistentHash) GetN(v any, n int) []any {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if len(h.ring) == 0 || n <= 0 {
+		return nil
+	}
+
+	index := h.locate(v)
+	first, ok := h.pick(v, index)
+	if !ok {
+		return nil
+	}
+
+	// The lap stops as soon as every node was found, rather than going over all the virtual nodes of the last ones
+	n = min(n, len(h.nodes))
+	nodes := []any{first}
+	seen := map[string]struct{}{repr(first): {}}
+	// A single lap of the ring is enough to visit every node
+	for i := 1; i < len(h.keys) && len(nodes) < n; i++ {
+		for _, node := range h.ring[h.keys[(index+i)%len(h.keys)]] {
+			nodeRepr := repr(node)
+			if _, ok := seen[nodeRepr]; ok || len(nodes) == n {
+				continue
+			}
+
+			seen[nodeRepr] = struct{}{}
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes
+}
+
+// Remove removes the given node from h
+func (h *ConsistentHash) Remove(node any) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.remove(repr(node))
+}
+
+// remove removes the node with the given representation, the caller must hold the lock
+func (h *ConsistentHash) remove(nodeRepr string) {
+	if _, ok := h.nodes[nodeRepr]; !ok {
+		return
+	}
+
+	for i := 0; i < h.replicas; i++ {
+		hash := h.hashFunc([]byte(nodeRepr + strconv.Itoa(i)))
+		index := sort.Search(len(h.keys), func(i int) bool {
+			return h.keys[i] >= hash
+		})
+		if index < len(h.keys) && h.keys[index] == hash {
+			h.keys = append(h.keys[:index], h.keys[index+1:]...)
+		}
+		h.removeRingNode(hash, nodeRepr)
+	}
+
+	delete(h.nodes, nodeRepr)
+}
+
+// Ring returns the virtual nodes of the ring ordered by their position
+func (h *ConsistentHash) Ring() []VirtualNode {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	virtualNodes := make([]VirtualNode, 0, len(h.keys))
+	for i, key := range h.keys {
+		if i > 0 && h.keys[i-1] == key {
+			continue // Colliding virtual nodes share a position, and they are all listed below
+		}
+
+		for _, node := range h.ring[key] {
+			virtualNodes = append(virtualNodes, VirtualNode{Position: key, Node: repr(node)})
+		}
+	}
+
+	return virtualNodes
+}
+
+// locate returns the index of the virtual node owning v, the caller must hold the lock
+func (h *ConsistentHash) locate(v any) int {
+	hash := h.hashFunc([]byte(repr(v)))
+	return sort.Search(len(h.keys), func(i int) bool {
+		return h.keys[i] >= hash
+	}) % len(h.keys)
+}
+
+// pick returns the node for v among the nodes sharing the virtual node at index, the caller must hold the lock
+func (h *ConsistentHash) pick(v any, index int) (any, bool) {
+	nodes := h.ring[h.keys[index]]
+	switch len(nodes) {
+	case 0:
+		return nil, false
+	case 1:
+		return nodes[0], true
+	default:
+		innerIndex := h.hashFunc([]byte(innerRepr(v)))
+		pos := int(innerIndex % uint64(len(nodes)))
+		return nodes[pos], true
+	}
+}
+
+func (h *ConsistentHash) removeRingNode(hash uint64, nodeRepr string) {
+	if nodes, ok := h.ring[hash]; ok {
+		newNodes := nodes[:0]
+		for _, x := range nodes {
+			if repr(x) != nodeRepr {
+				newNodes = append(newNodes, x)
+			}
+		}
+		if len(newNodes) > 0 {
+			h.ring[hash] = newNodes
+		} else {
+			delete(h.ring, hash)
+		}
+	}
+}
+
+func innerRepr(node any) string {
+	return fmt.Sprintf("%d:%v", prime, node)
+}
+
+func repr(node any) string {
+	return lang.Repr(node)
+}
+
+// Remapped returns the fraction of the key space, between 0 and 1, owned by different nodes in the two rings
+func Remapped(from, to []VirtualNode) float64 {
+	if len(from) == 0 || len(to) == 0 {
+		if len(from) == len(to) {
+			return 0
+		}
+		return 1
+	}
+
+	positions := make([]uint64, 0, len(from)+len(to))
+	for _, vn := range from {
+		positions = append(positions, vn.Position)
+	}
+	for _, vn := range to {
+		positions = append(positions, vn.Position)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+
+	// Each arc between two consecutive positions is owned by the first virtual node at or after its end
+	var remapped float64
+	for i, end := range positions {
+		if i > 0 && positions[i-1] == end {
+			continue
+		}
+
+		start := positions[(i+len(positions)-1)%len(positions)]
+		if owner(from, end) != owner(to, end) {
+			remapped += float64(end - start) // The first arc wraps around the ring, which the uint64 arithmetic handles
+		}
+	}
+
+	return remapped / math.Pow(2, 64)
+}
+
+func owner(ring []VirtualNode, position uint64) string {
+	index := sort.Search(len(ring), func(i int) bool {
+		return ring[i].Position >= position
+	}) % len(ring)
+
+	return ring[index].Node
+}
+
+// Load returns the fraction of the key space, between 0 and 1, owned by each node of the ring
+func Load(ring []VirtualNode) map[string]float64 {
+	load := make(map[string]float64)
+	for i, vn := range ring {
+		if i > 0 && ring[i-1].Position == vn.Position {
+			continue // Colliding virtual nodes share a position, which is owned by the first one
+		}
+
+		prev := ring[(i+len(ring)-1)%len(ring)].Position
+		span := float64(vn.Position - prev) // The first span wraps around the ring, which the uint64 arithmetic handles
+		if vn.Position == prev {
+			span = math.Pow(2, 64) // A single position owns the whole ring
+		}
+		load[vn.Node] += span / math.Pow(2, 64)
+	}
+
+	return load
+}
+
+// Imbalance returns the ratio between the key space owned by the most and the least loaded nodes of the ring
+// A perfectly balanced ring has an imbalance of 1, an empty ring too
+func Imbalance(ring []VirtualNode) float64 {
+	load := Load(ring)
+	if len(load) == 0 {
+		return 1
+	}
+
+	lowest, highest := math.Inf(1), 0.0
+	for _, l := range load {
+		lowest, highest = min(lowest, l), max(highest, l)
+	}
+
+	return highest / lowest
+}
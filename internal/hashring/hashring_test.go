@@ -0,0 +1,128 @@
+package hashring
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAddWithReplicasKeepsTheNodeMatched(t *testing.T) {
+	h := New()
+	h.Add("node")
+
+	// Re-adding the node, e.g. with a new weight, must not leave a window where the ring is empty
+	var done atomic.Bool
+	go func() {
+		defer done.Store(true)
+		for i := 0; i < 2000; i++ {
+			h.AddWithWeight("node", 1+i%TopWeight)
+		}
+	}()
+
+	for i := 0; !done.Load(); i++ {
+		if _, ok := h.Get(i); !ok {
+			t.Fatal("Get() found no node while the node was re-added")
+		}
+	}
+}
+
+func TestGetNWithFewerNodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes int
+		n     int
+		want  int
+	}{
+		{name: "single node", nodes: 1, n: 3, want: 1},
+		{name: "fewer nodes than requested", nodes: 2, n: 3, want: 2},
+		{name: "as many nodes as requested", nodes: 3, n: 3, want: 3},
+		{name: "empty ring", n: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			for i := range tt.nodes {
+				h.Add(fmt.Sprint("node", i))
+			}
+
+			for i := range 100 {
+				nodes := h.GetN(i, tt.n)
+				if len(nodes) != tt.want {
+					t.Fatalf("GetN(%d, %d) = %v, want %d distinct nodes", i, tt.n, nodes, tt.want)
+				}
+				if first, ok := h.Get(i); ok && nodes[0] != first {
+					t.Fatalf("GetN(%d, %d) = %v, want the node of Get() %v first", i, tt.n, nodes, first)
+				}
+			}
+		})
+	}
+}
+
+func TestAddWithWeightKeepsAVirtualNode(t *testing.T) {
+	h := New()
+	h.AddWithWeight("node", 0)
+	for i := range 100 {
+		if node, ok := h.Get(i); !ok || node != "node" {
+			t.Fatalf("Get(%d) = %v, %v, want the single node however low its weight", i, node, ok)
+		}
+	}
+}
+
+func TestNewSeeded(t *testing.T) {
+	route := func(h *ConsistentHash) []any {
+		for i := range 5 {
+			h.Add(fmt.Sprint("node", i))
+		}
+		var nodes []any
+		for i := range 200 {
+			node, _ := h.Get(fmt.Sprint("id", i))
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+
+	unseeded, same, other := route(New()), route(NewSeeded(DefaultReplicas, "staging")), route(NewSeeded(DefaultReplicas, "rebalance"))
+	moved := 0
+	for i := range same {
+		if same[i] != other[i] {
+			moved++
+		}
+	}
+	if moved < len(same)/2 {
+		t.Errorf("%d of %d ids moved to another node with another seed, want most of them", moved, len(same))
+	}
+	if again := route(NewSeeded(DefaultReplicas, "staging")); fmt.Sprint(again) != fmt.Sprint(same) {
+		t.Error("the same seed routed the ids differently")
+	}
+	if empty := route(NewSeeded(DefaultReplicas, "")); fmt.Sprint(empty) != fmt.Sprint(unseeded) {
+		t.Error("an empty seed didn't keep the default placement")
+	}
+	if params := NewSeeded(DefaultReplicas, "staging").Params(); params.Seed != "staging" || params.Algorithm != DefaultAlgorithm {
+		t.Errorf("Params() = %+v, want the seed and the default algorithm", params)
+	}
+}
+
+func TestImbalance(t *testing.T) {
+	if got := Imbalance(nil); got != 1 {
+		t.Errorf("Imbalance() of an empty ring = %v, want 1", got)
+	}
+
+	h := New()
+	h.Add("a")
+	if got := Imbalance(h.Ring()); got != 1 {
+		t.Errorf("Imbalance() of a single node = %v, want 1", got)
+	}
+
+	h.Add("b")
+	h.Add("c")
+	balanced := Imbalance(h.Ring())
+	if balanced < 1 || balanced > 1.5 {
+		t.Errorf("Imbalance() of equal weights = %v, want close to 1", balanced)
+	}
+
+	// A node with a tenth of the weight owns about a tenth of the key space of the others
+	h.AddWithWeight("c", TopWeight/10)
+	if skewed := Imbalance(h.Ring()); skewed < 5 {
+		t.Errorf("Imbalance() of skewed weights = %v, want well above the balanced %v", skewed, balanced)
+	}
+}
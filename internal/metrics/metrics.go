@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "object_storage"
+
+var (
+	// ClientCacheSize is the number of cached minio clients
+	ClientCacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "minio_client_cache_size",
+		Help:      "Number of cached minio clients.",
+	})
+	// ClientCacheEvictions is the number of minio clients evicted from the cache
+	ClientCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "minio_client_cache_evictions_total",
+		Help:      "Number of minio clients evicted from the cache.",
+	})
+	// ValidationRejections is the number of requests rejected by the validation of their bucket or id
+	ValidationRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "validation_rejections_total",
+		Help:      "Number of requests rejected by the validation of their bucket or id, by reason.",
+	}, []string{"reason"})
+	// RetryBudgetTokens is the number of instance lookup retries left in the shared retry budget
+	RetryBudgetTokens = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "retry_budget_tokens",
+		Help:      "Number of instance lookup retries left in the shared retry budget.",
+	})
+	// RetryBudgetExhausted is the number of retries denied because the shared retry budget was empty
+	RetryBudgetExhausted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "retry_budget_exhausted_total",
+		Help:      "Number of instance lookup retries denied because the shared retry budget was empty.",
+	})
+	// FailoverCacheHits is the number of reads served by the last known instances of their key because none matched
+	FailoverCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "failover_cache_hits_total",
+		Help:      "Number of reads routed to the last known instances of their key because none matched.",
+	})
+	// InstanceLimitRejections is the number of operations rejected because their instance had too many in progress
+	InstanceLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "instance_limit_rejections_total",
+		Help:      "Number of operations rejected because their instance had too many in progress, by instance.",
+	}, []string{"instance"})
+	// ReadRepairs is the number of replicas the read repair wrote a missing object to
+	ReadRepairs = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "read_repairs_total",
+		Help:      "Number of replicas the read repair wrote a missing object to.",
+	})
+	// CoalescedReads is the number of object reads served by the backend fetch of a concurrent identical read
+	CoalescedReads = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "coalesced_reads_total",
+		Help:      "Number of object reads served by the backend fetch of a concurrent identical read.",
+	})
+	// ReconciledInstances is the number of instances added and removed by the reconciliations with docker
+	ReconciledInstances = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "registrar",
+		Name:      "reconciled_instances_total",
+		Help:      "Number of instances added and removed by the reconciliations with docker.",
+	}, []string{"change"})
+	// Requests is the number of requests served by the gateway, by method and status code
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "http_requests_total",
+		Help:      "Number of requests served by the gateway, by method and status code.",
+	}, []string{"method", "code"})
+	// RequestDuration is the time taken to serve the requests, by method
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "gateway",
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve the requests, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	// SnapshotInstances is the number of instances loaded from the registry snapshot at startup
+	SnapshotInstances = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "registrar",
+		Name:      "snapshot_instances",
+		Help:      "Number of instances loaded from the registry snapshot at startup.",
+	})
+)
+
+// ringImbalanceSource computes the ring imbalance at scrape time, it is nil until SetRingImbalanceSource is called
+var ringImbalanceSource atomic.Pointer[func() float64]
+
+// RingImbalance is the ring imbalance returned by the source set with SetRingImbalanceSource, zero without one
+var RingImbalance = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Subsystem: "gateway",
+	Name:      "ring_imbalance_ratio",
+	Help:      "Ratio between the key space owned by the most and the least loaded instances of the ring.",
+}, func() float64 {
+	if fn := ringImbalanceSource.Load(); fn != nil {
+		return (*fn)()
+	}
+	return 0
+})
+
+// SetRingImbalanceSource exposes the ring imbalance returned by fn, replacing the previous source
+// The gauge is registered once, so it can be called again, e.g. with the registry of a test
+func SetRingImbalanceSource(fn func() float64) {
+	ringImbalanceSource.Store(&fn)
+}
+
+// RegisterBuildInfo exposes the running build as a gauge set to 1, labelled by its version, commit and Go version
+func RegisterBuildInfo(version, commit, goVersion string) {
+	promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Build of the running gateway, labelled by version, commit and Go version.",
+	}, []string{"version", "commit", "go_version"}).WithLabelValues(version, commit, goVersion).Set(1)
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSetRingImbalanceSource(t *testing.T) {
+	// The source is package state, it is cleared so the test can run again, e.g. with -count
+	t.Cleanup(func() { ringImbalanceSource.Store(nil) })
+	if got := testutil.ToFloat64(RingImbalance); got != 0 {
+		t.Errorf("imbalance without a source = %v, want 0", got)
+	}
+
+	// Setting the source again replaces it, instead of registering the gauge twice
+	SetRingImbalanceSource(func() float64 { return 1.5 })
+	SetRingImbalanceSource(func() float64 { return 2 })
+	if got := testutil.ToFloat64(RingImbalance); got != 2 {
+		t.Errorf("imbalance = %v, want the one of the last source 2", got)
+	}
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Operations of the published events
+const (
+	Put    = "put"
+	Delete = "delete"
+)
+
+// webhookTimeout bounds the delivery of an event to the webhook
+const webhookTimeout = 5 * time.Second
+
+// Event is a change of an object, published for the downstream processing such as indexing
+type Event struct {
+	Bucket    string    `json:"bucket"`
+	ID        string    `json:"id"`
+	Operation string    `json:"operation"`
+	Size      int64     `json:"size"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Publisher publishes the object events to a sink, e.g. a message queue or a webhook
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Nop discards the events
+type Nop struct{}
+
+// Publish discards the event
+func (Nop) Publish(context.Context, Event) error {
+	return nil
+}
+
+// Webhook posts the events as JSON to a URL
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns a Webhook posting to url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Publish posts the event, a response other than 2xx is an error
+func (w *Webhook) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
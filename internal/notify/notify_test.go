@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "delivered", status: http.StatusNoContent},
+		{name: "rejected", status: http.StatusBadGateway, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received Event
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("got a %s request of %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+				}
+				if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+					t.Errorf("failed to decode the event: %v", err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			event := Event{Bucket: "bucket", ID: "id", Operation: Put, Size: 4, Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+			err := NewWebhook(server.URL).Publish(context.Background(), event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v, want an error %v", err, tt.wantErr)
+			}
+			if received != event {
+				t.Errorf("webhook received %+v, want %+v", received, event)
+			}
+		})
+	}
+}
+
+func TestWebhookUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	if err := NewWebhook(server.URL).Publish(context.Background(), Event{}); err == nil {
+		t.Error("Publish() error = nil, want the unreachable webhook reported")
+	}
+}
@@ -3,8 +3,13 @@ package registrar
 import (
 	"context"
 	log "log/slog"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/metrics"
 	"github.com/dariusigna/object-storage/internal/registry"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -13,12 +18,23 @@ import (
 )
 
 const (
-	// NamePrefix is the prefix of the container names that are considered for registration
-	NamePrefix = "amazin-object-storage-node"
 	// MinioAccessKeyVarName is the name of the environment variable that contains the MinIO access key
 	MinioAccessKeyVarName = "MINIO_ACCESS_KEY"
 	// MinioSecretKeyVarName is the name of the environment variable that contains the MinIO secret key
 	MinioSecretKeyVarName = "MINIO_SECRET_KEY"
+	// HostnameLabel is the container label holding the hostname the MinIO instance is reached at
+	HostnameLabel = "object-storage.hostname"
+	// WeightLabel is the container label holding the weight of the MinIO instance, from 1 to 100
+	WeightLabel = "object-storage.weight"
+	// ZoneLabel is the container label holding the zone of the MinIO instance
+	ZoneLabel = "object-storage.zone"
+	// ReadOnlyLabel is the container label marking the MinIO instance read-only, e.g. an archival one, when set to true
+	ReadOnlyLabel = "object-storage.read-only"
+
+	// retryDelay is the delay before retrying a failed initial refresh or reconnecting to docker, doubled on every failure
+	retryDelay = 500 * time.Millisecond
+	// maxRetryDelay caps the delay between the retries
+	maxRetryDelay = 30 * time.Second
 )
 
 // DockerClient is an interface for the Docker client
@@ -31,32 +47,53 @@ type DockerClient interface {
 // Registry is an interface for the service registry
 type Registry interface {
 	RegisterService(service registry.ServiceMetadata)
-	DeregisterService(ipAddress string)
+	DeregisterService(address string)
 	GetAllServices() []registry.ServiceMetadata
+	Count() int
+	SetDraining(name string, draining bool) error
+	IsDraining(address string) bool
+	SaveSnapshot(path string) error
+	LoadSnapshot(path string) (int, error)
+}
+
+// Options configures the Registrar
+type Options struct {
+	// NamePrefix is the prefix of the container names that are considered for registration
+	NamePrefix string
+	// HostnameFromName makes the container name the hostname of instances without the hostname label
+	// The name is resolved by the docker embedded DNS on user-defined networks
+	HostnameFromName bool
+	// SnapshotPath is the file the registry is saved to after every reconciliation, and loaded from at startup
+	// An empty path disables the snapshot, the registry then starts empty until the first reconciliation
+	SnapshotPath string
 }
 
 // Registrar listens for docker events and registers/deregisters instances in the registry
 type Registrar struct {
 	dockerClient DockerClient
 	registry     Registry
+	opts         Options
+	reconciled   atomic.Bool // Whether the registry was reconciled with docker at least once
+	cordoned     atomic.Bool // Whether the newly discovered instances are left unregistered
 }
 
 // NewRegistrar creates a new Registrar instance
-func NewRegistrar(dockerClient DockerClient, registry *registry.Registry) *Registrar {
-	return &Registrar{dockerClient: dockerClient, registry: registry}
+func NewRegistrar(dockerClient DockerClient, registry *registry.Registry, opts Options) *Registrar {
+	return &Registrar{dockerClient: dockerClient, registry: registry, opts: opts}
 }
 
 // ListenForDockerEvents listens for docker events and registers/deregisters instances in the registry
 func (r *Registrar) ListenForDockerEvents(ctx context.Context) {
-	// initial refresh
-	err := r.refreshInstances(ctx)
-	if err != nil {
-		log.Error("Error refreshing instances", err)
+	// The ring stays empty until the initial refresh succeeds, so it is retried rather than waiting for an event
+	if !r.initialRefresh(ctx) {
+		log.Debug("Shutting down docker event listener")
+		return
 	}
 
 	filter := filters.NewArgs()
-	filter.Add("name", NamePrefix)
+	filter.Add("name", r.opts.NamePrefix)
 	filter.Add("type", "container")
+	delay := retryDelay
 	for {
 		messageChan, errChan := r.dockerClient.Events(ctx, events.ListOptions{Filters: filter})
 	secondLoop: // Use it when we need to break out of the listening loop and retry the connection to the docker daemon
@@ -65,17 +102,106 @@ func (r *Registrar) ListenForDockerEvents(ctx context.Context) {
 			case <-ctx.Done():
 				log.Debug("Shutting down docker event listener")
 				return
-			case event := <-messageChan:
+			case event, ok := <-messageChan:
+				if !ok {
+					// A closed channel would otherwise yield zero-value events forever
+					log.Warn("Docker events channel closed, reconnecting")
+					break secondLoop
+				}
+				// The connection works again, so the next reconnection starts over from the shortest delay
+				delay = retryDelay
 				log.Debug("Received docker event", "action", event.Action, "event", event.Type)
-				if err = r.handleDockerEvent(ctx, event); err != nil {
-					log.Error("Error handling docker event", err)
+				if err := r.handleDockerEvent(ctx, event); err != nil {
+					log.Error("Error handling docker event", "error", err)
+				}
+			case e, ok := <-errChan:
+				if !ok {
+					log.Warn("Docker events error channel closed, reconnecting")
+					break secondLoop
 				}
-			case e := <-errChan:
-				log.Error("Error while listening for docker events", e)
+				log.Error("Error while listening for docker events", "error", e)
 				break secondLoop
 			}
 		}
+
+		// A daemon that keeps closing the connection would otherwise be reconnected to in a tight loop
+		log.Debug("Reconnecting to docker", "delay", delay)
+		select {
+		case <-ctx.Done():
+			log.Debug("Shutting down docker event listener")
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+// initialRefresh refreshes the instances, retrying with a backoff until it succeeds
+// It reports false if ctx is done first
+func (r *Registrar) initialRefresh(ctx context.Context) bool {
+	delay := retryDelay
+	for {
+		err := r.refreshInstances(ctx)
+		if err == nil {
+			return true
+		}
+
+		log.Error("Error refreshing instances, retrying", "error", err, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+// LoadSnapshot registers the instances saved by the last run, so the ring is warm before docker is reconciled
+// The first reconciliation then adds and removes the instances that changed meanwhile
+func (r *Registrar) LoadSnapshot() error {
+	if r.opts.SnapshotPath == "" {
+		return nil
+	}
+
+	loaded, err := r.registry.LoadSnapshot(r.opts.SnapshotPath)
+	if err != nil {
+		return err
+	}
+
+	metrics.SnapshotInstances.Set(float64(loaded))
+	log.Info("Loaded the registry snapshot", "path", r.opts.SnapshotPath, "instances", loaded)
+	return nil
+}
+
+// Reconciled reports whether the registry was reconciled with docker at least once
+func (r *Registrar) Reconciled() bool {
+	return r.reconciled.Load()
+}
+
+// Cordoned reports whether the registrar leaves the newly discovered instances unregistered
+func (r *Registrar) Cordoned() bool {
+	return r.cordoned.Load()
+}
+
+// SetCordoned stops registering the newly discovered instances, or resumes it, to freeze the topology during sensitive operations
+// The registered instances are still updated and deregistered when gone
+// Uncordoning refreshes the instances, so the ones discovered meanwhile are registered right away
+func (r *Registrar) SetCordoned(ctx context.Context, cordoned bool) error {
+	if r.cordoned.Swap(cordoned) == cordoned {
+		return nil
 	}
+
+	log.Warn("Registrar cordon changed", "cordoned", cordoned)
+	if cordoned {
+		return nil
+	}
+
+	return r.refreshInstances(ctx)
+}
+
+// Refresh registers the running instances and deregisters the ones that are gone
+func (r *Registrar) Refresh(ctx context.Context) error {
+	return r.refreshInstances(ctx)
 }
 
 func (r *Registrar) handleDockerEvent(ctx context.Context, event events.Message) error {
@@ -99,7 +225,7 @@ func shouldRefresh(event events.Message) bool {
 
 func (r *Registrar) refreshInstances(ctx context.Context) error {
 	containerFilters := filters.NewArgs()
-	containerFilters.Add("name", NamePrefix)
+	containerFilters.Add("name", r.opts.NamePrefix)
 	containers, err := r.dockerClient.ContainerList(ctx, container.ListOptions{Filters: containerFilters})
 	if err != nil {
 		return err
@@ -117,20 +243,36 @@ func (r *Registrar) refreshInstances(ctx context.Context) error {
 			continue
 		}
 
-		serviceMetadata := getServiceMetadataFromContainer(info)
-		if !isValidServiceMetadata(serviceMetadata) {
-			log.Debug("Skipping instance", "name", info.Name, "reason", "missing metadata")
+		serviceMetadata := getServiceMetadataFromContainer(info, r.opts.HostnameFromName)
+		if reasons := invalidMetadataReasons(serviceMetadata, info.Config.Env); len(reasons) > 0 {
+			log.Debug("Skipping instance", "name", info.Name, "reason", strings.Join(reasons, ", "))
 			continue
 		}
 
 		availableInstances = append(availableInstances, serviceMetadata)
 	}
 
-	r.diffAndUpdateInstances(availableInstances)
+	preexisting := r.registry.Count()
+	added, removed := r.diffAndUpdateInstances(availableInstances)
+	metrics.ReconciledInstances.WithLabelValues("added").Add(float64(added))
+	metrics.ReconciledInstances.WithLabelValues("removed").Add(float64(removed))
+	// The first reconciliation tells whether the instances loaded from the snapshot matched the running ones
+	if !r.reconciled.Swap(true) {
+		log.Info("Initial reconciliation with docker", "preexisting", preexisting, "kept", preexisting-removed, "added", added, "removed", removed)
+	} else if added > 0 || removed > 0 {
+		log.Debug("Reconciled with docker", "added", added, "removed", removed)
+	}
+
+	// The snapshot is saved even when nothing was added or removed, since the metadata of the instances may have changed
+	if r.opts.SnapshotPath != "" {
+		if err = r.registry.SaveSnapshot(r.opts.SnapshotPath); err != nil {
+			log.Error("Failed to save the registry snapshot", "path", r.opts.SnapshotPath, "error", err)
+		}
+	}
 	return nil
 }
 
-func getServiceMetadataFromContainer(c types.ContainerJSON) registry.ServiceMetadata {
+func getServiceMetadataFromContainer(c types.ContainerJSON, hostnameFromName bool) registry.ServiceMetadata {
 	var accessKey, secretKey string
 	for _, env := range c.Config.Env {
 		split := strings.SplitN(env, "=", 2)
@@ -152,29 +294,105 @@ func getServiceMetadataFromContainer(c types.ContainerJSON) registry.ServiceMeta
 		break
 	}
 
+	hostname := c.Config.Labels[HostnameLabel]
+	if hostname == "" && hostnameFromName {
+		hostname = strings.TrimPrefix(c.Name, "/")
+	}
+
 	return registry.ServiceMetadata{
-		Name:      c.Name,
-		IPAddress: ipAddress,
-		AccessKey: accessKey,
-		SecretKey: secretKey,
+		Name:        c.Name,
+		ContainerID: c.ID,
+		IPAddress:   ipAddress,
+		Hostname:    hostname,
+		AccessKey:   accessKey,
+		SecretKey:   secretKey,
+		Weight:      getWeight(c),
+		Zone:        c.Config.Labels[ZoneLabel],
+		ReadOnly:    getReadOnly(c),
 	}
 }
 
-func (r *Registrar) diffAndUpdateInstances(newInstances []registry.ServiceMetadata) {
+func getReadOnly(c types.ContainerJSON) bool {
+	label, ok := c.Config.Labels[ReadOnlyLabel]
+	if !ok {
+		return false
+	}
+
+	readOnly, err := strconv.ParseBool(label)
+	if err != nil {
+		log.Warn("Ignoring invalid read-only label", "name", c.Name, "read_only", label)
+		return false
+	}
+
+	return readOnly
+}
+
+func getWeight(c types.ContainerJSON) int {
+	label, ok := c.Config.Labels[WeightLabel]
+	if !ok {
+		return hashring.TopWeight
+	}
+
+	weight, err := strconv.Atoi(label)
+	if err != nil || weight < 1 || weight > hashring.TopWeight {
+		log.Warn("Ignoring invalid weight label", "name", c.Name, "weight", label)
+		return hashring.TopWeight
+	}
+
+	return weight
+}
+
+// diffAndUpdateInstances registers the new instances and deregisters the missing ones, and returns how many of each
+// The instances whose metadata changed, e.g. with rotated keys, are registered again to update them
+// A container whose address changed, e.g. after a network reconfiguration, is moved to its new address, even while cordoned,
+// with its registration time and its draining state
+func (r *Registrar) diffAndUpdateInstances(newInstances []registry.ServiceMetadata) (added, removed int) {
+	var drainingMoves []string // The names of the draining instances moved to a new address
 	currentInstances := r.registry.GetAllServices()
-	currentSet := make(map[string]struct{})
+	currentSet := make(map[string]registry.ServiceMetadata)
 	newSet := make(map[string]registry.ServiceMetadata)
+	byContainer := make(map[string]registry.ServiceMetadata) // The current instances by container ID
 	for _, i := range currentInstances {
-		currentSet[i.IPAddress] = struct{}{}
+		currentSet[i.Address()] = i
+		if i.ContainerID != "" {
+			byContainer[i.ContainerID] = i
+		}
 	}
 
 	for _, i := range newInstances {
-		newSet[i.IPAddress] = i
+		newSet[i.Address()] = i
 	}
 
 	// Identify instances to be added
-	for ip, instance := range newSet {
-		if _, exists := currentSet[ip]; !exists {
+	for address, instance := range newSet {
+		current, exists := currentSet[address]
+		if moved, ok := byContainer[instance.ContainerID]; !exists && ok && instance.ContainerID != "" {
+			// The old address is deregistered below, since no running instance has it anymore
+			log.Warn("Instance address changed, moving it", "name", instance.Name, "container_id", instance.ContainerID, "from", moved.Address(), "to", address)
+			instance.RegisteredAt = moved.RegisteredAt
+			r.registry.RegisterService(instance)
+			if r.registry.IsDraining(moved.Address()) {
+				drainingMoves = append(drainingMoves, instance.Name)
+			}
+			added++
+			continue
+		}
+
+		if !exists {
+			if r.cordoned.Load() {
+				log.Info("Not registering the new instance while cordoned", "instance", address)
+				continue
+			}
+
+			r.registry.RegisterService(instance)
+			added++
+			continue
+		}
+
+		// The registration time and capacity weight are set by the registry, so they aren't a change
+		current.RegisteredAt, current.CapacityWeight = instance.RegisteredAt, instance.CapacityWeight
+		if current != instance {
+			log.Info("Updating the changed instance metadata", "instance", address)
 			r.registry.RegisterService(instance)
 		}
 	}
@@ -183,10 +401,58 @@ func (r *Registrar) diffAndUpdateInstances(newInstances []registry.ServiceMetada
 	for i := range currentSet {
 		if _, exists := newSet[i]; !exists {
 			r.registry.DeregisterService(i)
+			removed++
+		}
+	}
+
+	// The draining state is kept by address, so it is set on the new address once the old one is deregistered,
+	// when the name only matches the moved instance
+	for _, name := range drainingMoves {
+		if err := r.registry.SetDraining(name, true); err != nil {
+			log.Error("Failed to keep the moved instance draining", "name", name, "error", err)
 		}
 	}
+
+	return added, removed
 }
 
-func isValidServiceMetadata(serviceMetadata registry.ServiceMetadata) bool {
-	return serviceMetadata.IPAddress != "" && serviceMetadata.AccessKey != "" && serviceMetadata.SecretKey != ""
+// invalidMetadataReasons returns why the service metadata read from a container can't be registered, none if it can
+// The credentials tell an environment variable set empty from a missing one, to point at the misconfiguration
+func invalidMetadataReasons(serviceMetadata registry.ServiceMetadata, env []string) []string {
+	var reasons []string
+	if serviceMetadata.Address() == "" {
+		reasons = append(reasons, "no IP address or hostname")
+	}
+
+	credentials := []struct {
+		name  string
+		value string
+	}{
+		{MinioAccessKeyVarName, serviceMetadata.AccessKey},
+		{MinioSecretKeyVarName, serviceMetadata.SecretKey},
+	}
+	for _, c := range credentials {
+		if c.value != "" {
+			continue
+		}
+
+		if hasEnv(env, c.name) {
+			reasons = append(reasons, c.name+" is empty")
+		} else {
+			reasons = append(reasons, c.name+" is missing")
+		}
+	}
+
+	return reasons
+}
+
+// hasEnv reports whether the variable is set in env, even to an empty value
+func hasEnv(env []string, name string) bool {
+	for _, e := range env {
+		if strings.HasPrefix(e, name+"=") {
+			return true
+		}
+	}
+
+	return false
 }
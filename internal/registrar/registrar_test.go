@@ -0,0 +1,469 @@
+package registrar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	log "log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/dariusigna/object-storage/internal/registry"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeDocker serves a fixed set of running containers
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers []types.ContainerJSON
+	events     func(ctx context.Context) (<-chan events.Message, <-chan error)
+	eventCalls int
+	listErrs   []error // The errors of the next container listings, one per listing
+}
+
+func (f *fakeDocker) ContainerList(_ context.Context, _ container.ListOptions) ([]types.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.listErrs) > 0 {
+		err := f.listErrs[0]
+		f.listErrs = f.listErrs[1:]
+		return nil, err
+	}
+	list := make([]types.Container, 0, len(f.containers))
+	for _, c := range f.containers {
+		list = append(list, types.Container{ID: c.ID, Names: []string{c.Name}})
+	}
+	return list, nil
+}
+
+func (f *fakeDocker) ContainerInspect(_ context.Context, id string) (types.ContainerJSON, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.containers {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return types.ContainerJSON{}, nil
+}
+
+func (f *fakeDocker) Events(ctx context.Context, _ events.ListOptions) (<-chan events.Message, <-chan error) {
+	f.mu.Lock()
+	f.eventCalls++
+	listen := f.events
+	f.mu.Unlock()
+	if listen != nil {
+		return listen(ctx)
+	}
+	return make(chan events.Message), make(chan error)
+}
+
+func (f *fakeDocker) setContainers(containers ...types.ContainerJSON) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.containers = containers
+}
+
+// minioContainer returns a running minio container reached at ip
+func minioContainer(name, ip string) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    "id-" + name,
+			Name:  "/" + name,
+			State: &types.ContainerState{Status: "running"},
+		},
+		Config: &container.Config{
+			Env:    []string{MinioAccessKeyVarName + "=minio", MinioSecretKeyVarName + "=minio123"},
+			Labels: map[string]string{},
+		},
+		NetworkSettings: &types.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{"default": {IPAddress: ip}},
+		},
+	}
+}
+
+func addresses(r *registry.Registry) []string {
+	var list []string
+	for _, service := range r.GetAllServices() {
+		list = append(list, service.Address())
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestSnapshotWarmStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	opts := Options{NamePrefix: "minio", SnapshotPath: path}
+
+	// The previous run knew minio1 and minio2, and saved them on its reconciliation
+	previous := registry.NewRegistry(hashring.New())
+	docker := &fakeDocker{}
+	docker.setContainers(minioContainer("minio1", "10.0.0.1"), minioContainer("minio2", "10.0.0.2"))
+	if err := NewRegistrar(docker, previous, opts).Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	// Meanwhile minio2 is gone and minio3 started
+	docker.setContainers(minioContainer("minio1", "10.0.0.1"), minioContainer("minio3", "10.0.0.3"))
+	warm := registry.NewRegistry(hashring.New())
+	registrar := NewRegistrar(docker, warm, opts)
+	if err := registrar.LoadSnapshot(); err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if got := addresses(warm); len(got) != 2 || got[0] != "10.0.0.1" || got[1] != "10.0.0.2" {
+		t.Fatalf("instances loaded from the snapshot = %v, want [10.0.0.1 10.0.0.2]", got)
+	}
+	if got := testutil.ToFloat64(metrics.SnapshotInstances); got != 2 {
+		t.Errorf("snapshot instances metric = %v, want 2", got)
+	}
+
+	added := testutil.ToFloat64(metrics.ReconciledInstances.WithLabelValues("added"))
+	removed := testutil.ToFloat64(metrics.ReconciledInstances.WithLabelValues("removed"))
+	if err := registrar.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	// Only the differences between the snapshot and the running instances are reconciled
+	if got := testutil.ToFloat64(metrics.ReconciledInstances.WithLabelValues("added")) - added; got != 1 {
+		t.Errorf("added instances = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.ReconciledInstances.WithLabelValues("removed")) - removed; got != 1 {
+		t.Errorf("removed instances = %v, want 1", got)
+	}
+	if got := addresses(warm); len(got) != 2 || got[0] != "10.0.0.1" || got[1] != "10.0.0.3" {
+		t.Errorf("instances after the reconciliation = %v, want [10.0.0.1 10.0.0.3]", got)
+	}
+
+	// The snapshot follows the reconciled instances
+	reloaded := registry.NewRegistry(hashring.New())
+	if n, err := reloaded.LoadSnapshot(path); err != nil || n != 2 {
+		t.Errorf("LoadSnapshot() = %d, %v, want the 2 reconciled instances", n, err)
+	}
+}
+
+func TestCordon(t *testing.T) {
+	r := registry.NewRegistry(hashring.New())
+	docker := &fakeDocker{}
+	docker.setContainers(minioContainer("minio1", "10.0.0.1"), minioContainer("minio2", "10.0.0.2"))
+	registrar := NewRegistrar(docker, r, Options{NamePrefix: "minio"})
+	if err := registrar.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	if err := registrar.SetCordoned(context.Background(), true); err != nil || !registrar.Cordoned() {
+		t.Fatalf("SetCordoned() error = %v, cordoned: %v, want the registrar cordoned", err, registrar.Cordoned())
+	}
+
+	// While cordoned, minio3 is left unregistered, and the registered instances are still deregistered when gone
+	docker.setContainers(minioContainer("minio1", "10.0.0.1"), minioContainer("minio3", "10.0.0.3"))
+	if err := registrar.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got := addresses(r); len(got) != 1 || got[0] != "10.0.0.1" {
+		t.Errorf("instances while cordoned = %v, want [10.0.0.1]", got)
+	}
+
+	// Uncordoning registers the instances discovered meanwhile right away
+	if err := registrar.SetCordoned(context.Background(), false); err != nil || registrar.Cordoned() {
+		t.Fatalf("SetCordoned() error = %v, cordoned: %v, want the registrar uncordoned", err, registrar.Cordoned())
+	}
+	if got := addresses(r); len(got) != 2 || got[0] != "10.0.0.1" || got[1] != "10.0.0.3" {
+		t.Errorf("instances after the uncordon = %v, want [10.0.0.1 10.0.0.3]", got)
+	}
+}
+
+func TestLoadSnapshotMissing(t *testing.T) {
+	r := registry.NewRegistry(hashring.New())
+	registrar := NewRegistrar(&fakeDocker{}, r, Options{SnapshotPath: filepath.Join(t.TempDir(), "missing.json")})
+	if err := registrar.LoadSnapshot(); err != nil {
+		t.Errorf("LoadSnapshot() error = %v, want a cold start without a snapshot", err)
+	}
+	if r.Count() != 0 {
+		t.Errorf("Count() = %d, want 0", r.Count())
+	}
+}
+
+func TestListenForDockerEventsBacksOffReconnecting(t *testing.T) {
+	// The daemon closes every connection right away
+	docker := &fakeDocker{events: func(ctx context.Context) (<-chan events.Message, <-chan error) {
+		messages := make(chan events.Message)
+		close(messages)
+		return messages, make(chan error)
+	}}
+	registrar := NewRegistrar(docker, registry.NewRegistry(hashring.New()), Options{NamePrefix: "minio"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), retryDelay+retryDelay/2)
+	defer cancel()
+	registrar.ListenForDockerEvents(ctx)
+
+	// The reconnections wait 500ms then 1s, instead of spinning on the closed channel
+	docker.mu.Lock()
+	defer docker.mu.Unlock()
+	if docker.eventCalls != 2 {
+		t.Errorf("connected %d times, want the first connection and a single reconnection", docker.eventCalls)
+	}
+}
+
+func TestHostname(t *testing.T) {
+	labelled := minioContainer("minio1", "10.0.0.1")
+	labelled.Config.Labels[HostnameLabel] = "minio1.storage.internal"
+
+	tests := []struct {
+		name             string
+		container        types.ContainerJSON
+		hostnameFromName bool
+		want             string
+	}{
+		{name: "ip address", container: minioContainer("minio1", "10.0.0.1"), want: "10.0.0.1"},
+		{name: "container name", container: minioContainer("minio1", "10.0.0.1"), hostnameFromName: true, want: "minio1"},
+		{name: "label", container: labelled, want: "minio1.storage.internal"},
+		{name: "label over the container name", container: labelled, hostnameFromName: true, want: "minio1.storage.internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := getServiceMetadataFromContainer(tt.container, tt.hostnameFromName)
+			if service.Address() != tt.want || service.IPAddress != "10.0.0.1" {
+				t.Errorf("address = %s with IP %s, want %s with IP 10.0.0.1", service.Address(), service.IPAddress, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeight(t *testing.T) {
+	tests := []struct {
+		name  string
+		label string // The weight label, none when empty
+		want  int
+	}{
+		{name: "no label", want: hashring.TopWeight},
+		{name: "label", label: "25", want: 25},
+		{name: "top weight", label: "100", want: hashring.TopWeight},
+		{name: "zero", label: "0", want: hashring.TopWeight},
+		{name: "over the top weight", label: "101", want: hashring.TopWeight},
+		{name: "invalid", label: "heavy", want: hashring.TopWeight},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := minioContainer("minio1", "10.0.0.1")
+			if tt.label != "" {
+				c.Config.Labels[WeightLabel] = tt.label
+			}
+			if got := getServiceMetadataFromContainer(c, false).Weight; got != tt.want {
+				t.Errorf("Weight = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZone(t *testing.T) {
+	c := minioContainer("minio1", "10.0.0.1")
+	if zone := getServiceMetadataFromContainer(c, false).Zone; zone != "" {
+		t.Errorf("Zone = %q without a label, want none", zone)
+	}
+	c.Config.Labels[ZoneLabel] = "eu-west-1a"
+	if zone := getServiceMetadataFromContainer(c, false).Zone; zone != "eu-west-1a" {
+		t.Errorf("Zone = %q, want the one of the label", zone)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		name  string
+		label string // The read-only label, none when empty
+		want  bool
+	}{
+		{name: "no label"},
+		{name: "read-only", label: "true", want: true},
+		{name: "writable", label: "false"},
+		{name: "invalid label", label: "archive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := minioContainer("minio1", "10.0.0.1")
+			if tt.label != "" {
+				c.Config.Labels[ReadOnlyLabel] = tt.label
+			}
+			if got := getServiceMetadataFromContainer(c, false).ReadOnly; got != tt.want {
+				t.Errorf("ReadOnly = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenForDockerEventsRetriesTheInitialRefresh(t *testing.T) {
+	r := registry.NewRegistry(hashring.New())
+	docker := &fakeDocker{listErrs: []error{errors.New("daemon starting"), errors.New("daemon starting")}}
+	docker.setContainers(minioContainer("minio1", "10.0.0.1"))
+	registrar := NewRegistrar(docker, r, Options{NamePrefix: "minio"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(done)
+		registrar.ListenForDockerEvents(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !registrar.Reconciled() {
+		if time.Now().After(deadline) {
+			t.Fatal("the initial refresh wasn't retried until it succeeded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The retries waited 500ms then 1s
+	if elapsed := time.Since(start); elapsed < retryDelay*3 {
+		t.Errorf("refreshed after %v, want the retries backed off", elapsed)
+	}
+	if got := addresses(r); len(got) != 1 || got[0] != "10.0.0.1" {
+		t.Errorf("instances = %v, want [10.0.0.1]", got)
+	}
+}
+
+func TestListenForDockerEventsCancelledDuringTheInitialRefresh(t *testing.T) {
+	docker := &fakeDocker{listErrs: []error{errors.New("daemon down")}}
+	registrar := NewRegistrar(docker, registry.NewRegistry(hashring.New()), Options{NamePrefix: "minio"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), retryDelay/2)
+	defer cancel()
+	registrar.ListenForDockerEvents(ctx)
+
+	docker.mu.Lock()
+	defer docker.mu.Unlock()
+	if docker.eventCalls != 0 || registrar.Reconciled() {
+		t.Errorf("listened %d times, reconciled: %v, want the listener stopped before the refresh succeeded", docker.eventCalls, registrar.Reconciled())
+	}
+}
+
+func TestAddressChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		cordoned bool
+	}{
+		{name: "uncordoned"},
+		{name: "cordoned", cordoned: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := registry.NewRegistry(hashring.New())
+			docker := &fakeDocker{}
+			docker.setContainers(minioContainer("minio1", "10.0.0.1"), minioContainer("minio2", "10.0.0.2"))
+			registrar := NewRegistrar(docker, r, Options{NamePrefix: "minio"})
+			if err := registrar.Refresh(context.Background()); err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+			var registeredAt time.Time
+			for _, service := range r.GetAllServices() {
+				if service.Address() == "10.0.0.1" {
+					registeredAt = service.RegisteredAt
+				}
+			}
+			if err := registrar.SetCordoned(context.Background(), tt.cordoned); err != nil {
+				t.Fatalf("SetCordoned() error = %v", err)
+			}
+
+			// Docker reassigned the IP of the running minio1 container
+			docker.setContainers(minioContainer("minio1", "10.0.0.9"), minioContainer("minio2", "10.0.0.2"))
+			if err := registrar.Refresh(context.Background()); err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+			if got := addresses(r); len(got) != 2 || got[0] != "10.0.0.2" || got[1] != "10.0.0.9" {
+				t.Fatalf("instances = %v, want minio1 moved to [10.0.0.2 10.0.0.9]", got)
+			}
+			for _, service := range r.GetAllServices() {
+				if service.Address() == "10.0.0.9" && (service.ContainerID != "id-minio1" || !service.RegisteredAt.Equal(registeredAt)) {
+					t.Errorf("moved instance = %+v, want the container and the registration time of minio1", service)
+				}
+			}
+		})
+	}
+}
+
+func TestAddressChangeKeepsDraining(t *testing.T) {
+	r := registry.NewRegistry(hashring.New())
+	docker := &fakeDocker{}
+	docker.setContainers(minioContainer("minio1", "10.0.0.1"), minioContainer("minio2", "10.0.0.2"))
+	registrar := NewRegistrar(docker, r, Options{NamePrefix: "minio"})
+	if err := registrar.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if err := r.SetDraining("minio1", true); err != nil {
+		t.Fatalf("SetDraining() error = %v", err)
+	}
+
+	// Docker reassigned the IP of the draining minio1 container
+	docker.setContainers(minioContainer("minio1", "10.0.0.9"), minioContainer("minio2", "10.0.0.2"))
+	if err := registrar.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if !r.IsDraining("10.0.0.9") {
+		t.Error("the moved instance isn't draining anymore")
+	}
+	if r.IsDraining("10.0.0.1") || r.IsDraining("10.0.0.2") {
+		t.Error("the draining state was left on the old address or set on another instance")
+	}
+}
+
+func TestSkippedInstanceReasons(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *types.ContainerJSON)
+		want   string
+	}{
+		{
+			name: "empty access key",
+			modify: func(c *types.ContainerJSON) {
+				c.Config.Env = []string{MinioAccessKeyVarName + "=", MinioSecretKeyVarName + "=minio123"}
+			},
+			want: MinioAccessKeyVarName + " is empty",
+		},
+		{
+			name:   "missing secret key",
+			modify: func(c *types.ContainerJSON) { c.Config.Env = []string{MinioAccessKeyVarName + "=minio"} },
+			want:   MinioSecretKeyVarName + " is missing",
+		},
+		{
+			name:   "no address",
+			modify: func(c *types.ContainerJSON) { c.NetworkSettings.Networks = nil },
+			want:   "no IP address or hostname",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defer log.SetDefault(log.Default())
+			log.SetDefault(log.New(log.NewTextHandler(&logs, &log.HandlerOptions{Level: log.LevelDebug})))
+
+			c := minioContainer("minio1", "10.0.0.1")
+			tt.modify(&c)
+			docker := &fakeDocker{}
+			docker.setContainers(c)
+			r := registry.NewRegistry(hashring.New())
+			if err := NewRegistrar(docker, r, Options{NamePrefix: "minio"}).Refresh(context.Background()); err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+
+			if r.Count() != 0 {
+				t.Errorf("Count() = %d, want the container skipped", r.Count())
+			}
+			if !strings.Contains(logs.String(), `msg="Skipping instance"`) || !strings.Contains(logs.String(), tt.want) {
+				t.Errorf("logs = %s, want the container skipped because %s", &logs, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package registry
+
+import (
+	log "log/slog"
+	"slices"
+)
+
+// ConsistencyReport lists the divergences between the registered services and the ring found by CheckConsistency
+type ConsistencyReport struct {
+	// Added are the addresses of the registered services missing from the ring, which were added to it
+	Added []string `json:"added"`
+	// Removed are the addresses on the ring without a registered service, which were removed from it
+	Removed []string `json:"removed"`
+}
+
+// CheckConsistency compares the registered services with the nodes of the ring, and repairs any divergence
+// They are changed together, so a divergence means an add or a remove partially failed
+// The ring replaced by an ongoing migration is repaired too, its divergences are reported along with the current ring's
+func (r *Registry) CheckConsistency() ConsistencyReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := ConsistencyReport{Added: []string{}, Removed: []string{}}
+	for _, hash := range []Hasher{r.hash, r.previous} {
+		if hash != nil {
+			r.repairHash(hash, &report)
+		}
+	}
+
+	slices.Sort(report.Added)
+	report.Added = slices.Compact(report.Added)
+	slices.Sort(report.Removed)
+	report.Removed = slices.Compact(report.Removed)
+	if len(report.Added) > 0 || len(report.Removed) > 0 {
+		log.Warn("Repaired the ring out of sync with the registered services", "added", report.Added, "removed", report.Removed)
+	}
+
+	return report
+}
+
+func (r *Registry) repairHash(hash Hasher, report *ConsistencyReport) {
+	nodes := make(map[string]struct{})
+	for _, virtualNode := range hash.Ring() {
+		nodes[virtualNode.Node] = struct{}{}
+	}
+
+	r.Range(func(service ServiceMetadata) bool {
+		if _, ok := nodes[service.Address()]; !ok {
+			addToHash(hash, service)
+			report.Added = append(report.Added, service.Address())
+		}
+		delete(nodes, service.Address())
+		return true
+	})
+
+	// The nodes left aren't registered
+	for address := range nodes {
+		hash.Remove(address)
+		report.Removed = append(report.Removed, address)
+	}
+}
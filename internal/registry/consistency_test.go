@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+)
+
+// ringNodes returns the sorted nodes of the ring
+func ringNodes(ring []hashring.VirtualNode) []string {
+	var nodes []string
+	for _, virtualNode := range ring {
+		nodes = append(nodes, virtualNode.Node)
+	}
+	slices.Sort(nodes)
+	return slices.Compact(nodes)
+}
+
+func TestCheckConsistency(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	if report := r.CheckConsistency(); len(report.Added) != 0 || len(report.Removed) != 0 {
+		t.Fatalf("CheckConsistency() = %+v on a ring in sync, want no divergence", report)
+	}
+
+	// A remove that failed after the ring was changed, and one that failed before
+	r.hash.Remove("10.0.0.2")
+	r.hash.Add("10.0.0.9")
+
+	report := r.CheckConsistency()
+	if !slices.Equal(report.Added, []string{"10.0.0.2"}) || !slices.Equal(report.Removed, []string{"10.0.0.9"}) {
+		t.Errorf("CheckConsistency() = %+v, want 10.0.0.2 added and 10.0.0.9 removed", report)
+	}
+	if nodes, want := ringNodes(r.Ring()), []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !slices.Equal(nodes, want) {
+		t.Errorf("ring nodes = %v after the check, want %v", nodes, want)
+	}
+	if report = r.CheckConsistency(); len(report.Added) != 0 || len(report.Removed) != 0 {
+		t.Errorf("CheckConsistency() = %+v after the repair, want no divergence", report)
+	}
+}
+
+func TestCheckConsistencyDuringAMigration(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	r.MigrateRing(hashring.New())
+	if r.previous == nil {
+		t.Fatal("MigrateRing() didn't keep the previous ring")
+	}
+
+	r.previous.Remove("10.0.0.1")
+	report := r.CheckConsistency()
+	if !slices.Equal(report.Added, []string{"10.0.0.1"}) || len(report.Removed) != 0 {
+		t.Errorf("CheckConsistency() = %+v, want 10.0.0.1 added back to the previous ring", report)
+	}
+	if nodes := ringNodes(r.previous.Ring()); !slices.Contains(nodes, "10.0.0.1") {
+		t.Errorf("previous ring nodes = %v, want 10.0.0.1 repaired", nodes)
+	}
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"fmt"
+	log "log/slog"
+	"strings"
+)
+
+// ServiceNotFoundError is returned when no registered service has the given name
+type ServiceNotFoundError struct {
+	Name string
+}
+
+// Error returns the error message
+func (s ServiceNotFoundError) Error() string {
+	return fmt.Sprintf("service %s not found", s.Name)
+}
+
+// SetDraining marks the service with the given container name as draining, or restores it
+// A draining service keeps serving reads, but it isn't matched for new writes anymore
+func (r *Registry) SetDraining(name string, draining bool) error {
+	name = strings.TrimPrefix(name, "/")
+	var (
+		address string
+		found   bool
+	)
+	r.Range(func(service ServiceMetadata) bool {
+		if strings.TrimPrefix(service.Name, "/") == name {
+			address, found = service.Address(), true
+		}
+		return !found
+	})
+	if !found {
+		return ServiceNotFoundError{Name: name}
+	}
+
+	r.drainMu.Lock()
+	defer r.drainMu.Unlock()
+	if draining {
+		r.draining[address] = struct{}{}
+	} else {
+		delete(r.draining, address)
+	}
+
+	log.Info("Updated the draining state", "instance", address, "draining", draining)
+	return nil
+}
+
+// IsDraining reports whether the service with the given address is draining
+func (r *Registry) IsDraining(address string) bool {
+	r.drainMu.RLock()
+	defer r.drainMu.RUnlock()
+	_, ok := r.draining[address]
+	return ok
+}
+
+// writeExcludedSet returns the addresses of the services excluded from new writes, the draining and read-only ones
+func (r *Registry) writeExcludedSet() map[string]struct{} {
+	excluded := r.drainingSet()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for address := range r.readOnly {
+		excluded[address] = struct{}{}
+	}
+	return excluded
+}
+
+// drainingSet returns a copy of the addresses of the draining services
+func (r *Registry) drainingSet() map[string]struct{} {
+	r.drainMu.RLock()
+	defer r.drainMu.RUnlock()
+
+	draining := make(map[string]struct{}, len(r.draining))
+	for address := range r.draining {
+		draining[address] = struct{}{}
+	}
+	return draining
+}
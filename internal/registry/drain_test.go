@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+)
+
+func TestSetDraining(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	ring, err := r.MatchServices("key", 3)
+	if err != nil {
+		t.Fatalf("MatchServices() error = %v", err)
+	}
+	owner := ring[0]
+
+	if err = r.SetDraining("/"+owner.Name, true); err != nil {
+		t.Fatalf("SetDraining() error = %v", err)
+	}
+	if !r.IsDraining(owner.Address()) {
+		t.Fatal("IsDraining() = false, want the drained owner draining")
+	}
+
+	// The writes go to the successors, while the reads still include the draining owner
+	writable, err := r.MatchWritableServices("key", 2)
+	if err != nil {
+		t.Fatalf("MatchWritableServices() error = %v", err)
+	}
+	if got, want := serviceAddresses(writable), serviceAddresses(ring[1:]); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchWritableServices() = %v, want the successors %v", got, want)
+	}
+	readable, err := r.MatchServices("key", 2)
+	if err != nil {
+		t.Fatalf("MatchServices() error = %v", err)
+	}
+	if got, want := serviceAddresses(readable), serviceAddresses(ring); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchServices() = %v, want the draining owner on top of 2 others %v", got, want)
+	}
+
+	if err = r.SetDraining(owner.Name, false); err != nil {
+		t.Fatalf("SetDraining() error = %v", err)
+	}
+	if writable, _ = r.MatchWritableServices("key", 2); writable[0].Address() != owner.Address() {
+		t.Errorf("MatchWritableServices() = %v after undraining, want the owner first", serviceAddresses(writable))
+	}
+}
+
+func TestSetDrainingUnknownService(t *testing.T) {
+	r := newTestRegistry(t, 1)
+	var notFoundErr ServiceNotFoundError
+	if err := r.SetDraining("minio9", true); !errors.As(err, &notFoundErr) {
+		t.Errorf("SetDraining() error = %v, want a ServiceNotFoundError", err)
+	}
+}
+
+func TestDeregisterClearsDraining(t *testing.T) {
+	r := newTestRegistry(t, 2)
+	if err := r.SetDraining("minio1", true); err != nil {
+		t.Fatalf("SetDraining() error = %v", err)
+	}
+
+	// A service registered again at the same address isn't draining anymore
+	r.DeregisterService("10.0.0.1")
+	r.RegisterService(ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1"})
+	if r.IsDraining("10.0.0.1") {
+		t.Error("IsDraining() = true, want the draining state cleared by the deregistration")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	ring, err := r.MatchServices("key", 3)
+	if err != nil {
+		t.Fatalf("MatchServices() error = %v", err)
+	}
+	owner := ring[0]
+	owner.ReadOnly = true
+	r.RegisterService(owner)
+
+	// Like a draining service, the read-only owner is skipped by the writes and still matched by the reads
+	writable, err := r.MatchWritableServices("key", 2)
+	if err != nil {
+		t.Fatalf("MatchWritableServices() error = %v", err)
+	}
+	if got, want := serviceAddresses(writable), serviceAddresses(ring[1:]); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchWritableServices() = %v, want the successors %v", got, want)
+	}
+	readable, err := r.MatchServices("key", 2)
+	if err != nil {
+		t.Fatalf("MatchServices() error = %v", err)
+	}
+	if got, want := serviceAddresses(readable), serviceAddresses(ring); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchServices() = %v, want the read-only owner on top of 2 others %v", got, want)
+	}
+
+	owner.ReadOnly = false
+	r.RegisterService(owner)
+	if writable, _ = r.MatchWritableServices("key", 2); writable[0].Address() != owner.Address() {
+		t.Errorf("MatchWritableServices() = %v once writable again, want the owner first", serviceAddresses(writable))
+	}
+}
+
+func TestEveryServiceReadOnly(t *testing.T) {
+	r := NewRegistry(hashring.New())
+	r.RegisterService(ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1", ReadOnly: true})
+	if _, err := r.MatchWritableServices("key", 1); err == nil {
+		t.Error("MatchWritableServices() error = nil, want no writable service")
+	}
+	if _, err := r.MatchServices("key", 1); err != nil {
+		t.Errorf("MatchServices() error = %v, want the read-only service matched", err)
+	}
+}
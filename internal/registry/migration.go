@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"fmt"
+	log "log/slog"
+)
+
+// MigrateRing replaces the consistent hash like RebuildRing, but keeps the replaced hash until FinishMigration
+// The reads fall back to the services matched by the replaced hash, where the objects are until they are moved
+// When a migration is already ongoing, the hash it replaced is kept, since the objects not moved yet are placed by it
+func (r *Registry) MigrateRing(hash Hasher) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.previous == nil {
+		r.previous = r.hash
+	}
+	log.Info("Migrating the ring, the reads fall back to the previous ring until the migration is finished")
+	return r.rebuildRing(hash)
+}
+
+// FinishMigration drops the hash replaced by the ongoing ring migration, once the objects were moved
+// It reports whether a migration was ongoing
+func (r *Registry) FinishMigration() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.previous == nil {
+		return false
+	}
+
+	r.previous = nil
+	log.Info("Finished the ring migration, the previous ring is dropped")
+	return true
+}
+
+// Migrating reports whether a ring migration is ongoing
+func (r *Registry) Migrating() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.previous != nil
+}
+
+// MatchPreviousServices matches up to n distinct services for a given key with the hash replaced by the ongoing migration
+// The keys are matched on the ring only, since the pins are the same before and after the migration
+// It returns no service when no migration is ongoing
+func (r *Registry) MatchPreviousServices(key string, n int) ([]ServiceMetadata, error) {
+	r.mu.RLock()
+	previous := r.previous
+	r.mu.RUnlock()
+	if previous == nil {
+		return nil, nil
+	}
+
+	serviceAddresses := previous.GetN(key, n)
+	services := make([]ServiceMetadata, 0, len(serviceAddresses))
+	for _, serviceAddress := range serviceAddresses {
+		address, ok := serviceAddress.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected service address %v of type %T in the hash", serviceAddress, serviceAddress)
+		}
+
+		service, ok := r.instances.Get(address)
+		if !ok {
+			return nil, fmt.Errorf("could not find service with address %s", address)
+		}
+		services = append(services, service)
+	}
+
+	return services, nil
+}
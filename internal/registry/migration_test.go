@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+)
+
+func TestMigrateRing(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	if previous, err := r.MatchPreviousServices("key", 2); err != nil || previous != nil {
+		t.Fatalf("MatchPreviousServices() = %v, %v without a migration, want none", previous, err)
+	}
+
+	owners := make(map[string][]string)
+	for i := range 20 {
+		key := fmt.Sprint("key", i)
+		services, err := r.MatchServices(key, 2)
+		if err != nil {
+			t.Fatalf("MatchServices() error = %v", err)
+		}
+		owners[key] = serviceAddresses(services)
+	}
+
+	if remapped := r.MigrateRing(hashring.NewCustom(300, nil)); remapped <= 0 {
+		t.Fatalf("MigrateRing() remapped %v of the key space, want some", remapped)
+	}
+	// A second migration keeps the ring the objects not moved yet are placed by
+	r.MigrateRing(hashring.NewCustom(500, nil))
+	if !r.Migrating() {
+		t.Fatal("Migrating() = false, want a migration ongoing")
+	}
+	for key, want := range owners {
+		previous, err := r.MatchPreviousServices(key, 2)
+		if err != nil {
+			t.Fatalf("MatchPreviousServices() error = %v", err)
+		}
+		if got := serviceAddresses(previous); !slices.Equal(got, want) {
+			t.Errorf("MatchPreviousServices(%s) = %v, want the owners before the migration %v", key, got, want)
+		}
+	}
+
+	// The services registered during the migration are on both rings
+	r.RegisterService(ServiceMetadata{Name: "minio4", IPAddress: "10.0.0.4"})
+	onPrevious := false
+	for i := range 100 {
+		previous, err := r.MatchPreviousServices(fmt.Sprint("key", i), 1)
+		if err != nil {
+			t.Fatalf("MatchPreviousServices() error = %v", err)
+		}
+		onPrevious = onPrevious || previous[0].Address() == "10.0.0.4"
+	}
+	if !onPrevious {
+		t.Error("the service registered during the migration isn't on the previous ring")
+	}
+
+	if !r.FinishMigration() || r.Migrating() {
+		t.Fatal("FinishMigration() didn't finish the ongoing migration")
+	}
+	if previous, _ := r.MatchPreviousServices("key", 2); previous != nil {
+		t.Errorf("MatchPreviousServices() = %v after the migration, want none", previous)
+	}
+	if r.FinishMigration() {
+		t.Error("FinishMigration() = true, want false without a migration")
+	}
+}
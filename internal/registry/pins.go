@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"fmt"
+	log "log/slog"
+	"path"
+	"strings"
+)
+
+// Pin overrides the consistent hash for the keys matching Pattern, which are matched to the service at Address
+// Pattern is either an exact key or a path.Match pattern, such as "reports-*"
+type Pin struct {
+	Pattern string
+	Address string
+}
+
+// ParsePins parses a comma separated list of pattern=address pins
+func ParsePins(value string) ([]Pin, error) {
+	var pins []Pin
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		pattern, address, ok := strings.Cut(field, "=")
+		pattern, address = strings.TrimSpace(pattern), strings.TrimSpace(address)
+		if !ok || pattern == "" || address == "" {
+			return nil, fmt.Errorf("invalid pin %q, expected pattern=address", field)
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pin pattern %q: %w", pattern, err)
+		}
+
+		pins = append(pins, Pin{Pattern: pattern, Address: address})
+	}
+
+	return pins, nil
+}
+
+// SetPins replaces the pin table consulted before the consistent hash
+// Exact keys take precedence over patterns, and patterns are tried in order
+func (r *Registry) SetPins(pins []Pin) {
+	exact := make(map[string]string)
+	var patterns []Pin
+	for _, pin := range pins {
+		if strings.ContainsAny(pin.Pattern, `*?[\`) {
+			patterns = append(patterns, pin)
+			continue
+		}
+
+		if _, ok := exact[pin.Pattern]; !ok {
+			exact[pin.Pattern] = pin.Address
+		}
+	}
+
+	r.pinsMu.Lock()
+	defer r.pinsMu.Unlock()
+	r.exactPins, r.patternPins = exact, patterns
+}
+
+// pinned returns the registered service the key is pinned to
+// A key pinned to an unregistered service falls back to the consistent hash
+func (r *Registry) pinned(key string) (ServiceMetadata, bool) {
+	r.pinsMu.RLock()
+	address, ok := r.exactPins[key]
+	if !ok {
+		for _, pin := range r.patternPins {
+			if matched, _ := path.Match(pin.Pattern, key); matched {
+				address, ok = pin.Address, true
+				break
+			}
+		}
+	}
+	r.pinsMu.RUnlock()
+
+	if !ok {
+		return ServiceMetadata{}, false
+	}
+
+	service, ok := r.instances.Get(address)
+	if !ok {
+		log.Debug("Pinned service is not registered, using the ring", "key", key, "instance", address)
+		return ServiceMetadata{}, false
+	}
+
+	return service, true
+}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePins(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []Pin
+		wantErr bool
+	}{
+		{name: "empty", value: ""},
+		{
+			name:  "pins",
+			value: " reports-* = 10.0.0.1 ,invoice=10.0.0.2,",
+			want:  []Pin{{Pattern: "reports-*", Address: "10.0.0.1"}, {Pattern: "invoice", Address: "10.0.0.2"}},
+		},
+		{name: "missing address", value: "invoice=", wantErr: true},
+		{name: "missing separator", value: "invoice", wantErr: true},
+		{name: "invalid pattern", value: "reports-[=10.0.0.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pins, err := ParsePins(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePins() error = %v, want an error: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(pins, tt.want) {
+				t.Errorf("ParsePins() = %v, want %v", pins, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchServicePinned(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	r.SetPins([]Pin{
+		{Pattern: "reports-*", Address: "10.0.0.1"},
+		{Pattern: "reports-2024", Address: "10.0.0.2"},
+		{Pattern: "reports-*", Address: "10.0.0.3"}, // Shadowed by the first pattern
+		{Pattern: "orphan", Address: "10.0.0.9"},
+	})
+
+	tests := []struct {
+		key  string
+		want string // The address of the matched service, the one of the ring when empty
+	}{
+		{key: "reports-2023", want: "10.0.0.1"},
+		{key: "reports-2024", want: "10.0.0.2"},
+		{key: "invoice"},
+		{key: "orphan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				address, _ := r.hasher().Get(tt.key)
+				want = address.(string)
+			}
+
+			service, err := r.MatchService(tt.key)
+			if err != nil {
+				t.Fatalf("MatchService() error = %v", err)
+			}
+			if service.Address() != want {
+				t.Errorf("MatchService() = %s, want %s", service.Address(), want)
+			}
+		})
+	}
+}
+
+func TestMatchServicesPinned(t *testing.T) {
+	r := newTestRegistry(t, 4)
+	ring, err := r.MatchServices("reports-2023", 4)
+	if err != nil {
+		t.Fatalf("MatchServices() error = %v", err)
+	}
+	// The key is pinned to its last ring successor, which is matched first and not again among the successors
+	pinned := ring[3].Address()
+	r.SetPins([]Pin{{Pattern: "reports-*", Address: pinned}})
+
+	services, err := r.MatchServices("reports-2023", 3)
+	if err != nil {
+		t.Fatalf("MatchServices() error = %v", err)
+	}
+	want := []string{pinned, ring[0].Address(), ring[1].Address()}
+	if got := serviceAddresses(services); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchServices() = %v, want %v", got, want)
+	}
+
+	// Unpinning the key restores the ring placement
+	r.SetPins(nil)
+	if service, _ := r.MatchService("reports-2023"); service.Address() != ring[0].Address() {
+		t.Errorf("MatchService() = %s after unpinning, want the ring owner %s", service.Address(), ring[0].Address())
+	}
+}
+
+func serviceAddresses(services []ServiceMetadata) []string {
+	addresses := make([]string, 0, len(services))
+	for _, service := range services {
+		addresses = append(addresses, service.Address())
+	}
+	return addresses
+}
@@ -3,67 +3,369 @@ package registry
 import (
 	"fmt"
 	log "log/slog"
+	"sync"
+	"time"
 
+	"github.com/dariusigna/object-storage/internal/hashring"
 	cmap "github.com/orcaman/concurrent-map/v2"
-	"github.com/zeromicro/go-zero/core/hash"
 )
 
 // ServiceMetadata represents the metadata of Minio service
 type ServiceMetadata struct {
-	Name      string
-	IPAddress string
-	AccessKey string
-	SecretKey string
+	Name        string
+	ContainerID string // Optional, the docker container of the service, which outlives a change of its address
+	IPAddress   string
+	Hostname    string // Optional, it takes precedence over the IP address when set
+	AccessKey   string
+	SecretKey   string
+	Weight      int    // From 1 to hashring.TopWeight, the share of virtual nodes of the service, zero means the top weight
+	Zone        string // Optional, the zone of the service, which the reads prefer when it is the gateway's zone
+	// RegisteredAt is when the service was first registered, it is kept when the service is registered again
+	RegisteredAt time.Time
+	// ReadOnly services, e.g. archival ones, serve reads but aren't matched for new writes
+	ReadOnly bool
+	// CapacityWeight is the weight derived from the free space of the service, zero if unset, it overrides Weight on the ring
+	// It is set with SetCapacityWeights, and kept when the service is registered again
+	CapacityWeight int
+}
+
+// Address returns the host the service is reached at, which is also its key in the registry
+func (s ServiceMetadata) Address() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+
+	return s.IPAddress
+}
+
+// EffectiveWeight returns the weight of the service on the ring, an unset or out of range weight being the top weight
+// The capacity weight takes precedence over the static one when set
+func (s ServiceMetadata) EffectiveWeight() int {
+	weight := s.Weight
+	if s.CapacityWeight > 0 {
+		weight = s.CapacityWeight
+	}
+
+	if weight <= 0 || weight >= hashring.TopWeight {
+		return hashring.TopWeight
+	}
+
+	return weight
+}
+
+// Hasher is the consistent hash used to match keys to services
+type Hasher interface {
+	Add(node any)
+	AddWithWeight(node any, weight int)
+	Remove(node any)
+	Get(v any) (any, bool)
+	GetN(v any, n int) []any
+	Ring() []hashring.VirtualNode
+	Params() hashring.Params
 }
 
 // Registry is a service registry
 type Registry struct {
-	hash      *hash.ConsistentHash                        // The hash and instances can be combined into a single data structure in production
+	mu        sync.RWMutex                                // Serializes the changes of the hash and instances, so they stay in sync
+	hash      Hasher                                      // The hash and instances can be combined into a single data structure in production
+	previous  Hasher                                      // The hash replaced by an ongoing ring migration, nil otherwise
 	instances cmap.ConcurrentMap[string, ServiceMetadata] // This can be database in production, and we can also use a cache
+	readOnly  map[string]struct{}                         // Addresses of the read-only services, guarded by mu
+
+	hooksMu         sync.RWMutex
+	deregisterHooks []func(address string)
+
+	drainMu  sync.RWMutex
+	draining map[string]struct{} // Addresses of the services excluded from new writes
+
+	pinsMu      sync.RWMutex
+	exactPins   map[string]string // Pinned keys to service addresses
+	patternPins []Pin
 }
 
 // NewRegistry creates a new registry
-func NewRegistry(hash *hash.ConsistentHash) *Registry {
-	return &Registry{hash: hash, instances: cmap.New[ServiceMetadata]()}
+func NewRegistry(hash Hasher) *Registry {
+	return &Registry{
+		hash:      hash,
+		instances: cmap.New[ServiceMetadata](),
+		readOnly:  make(map[string]struct{}),
+		draining:  make(map[string]struct{}),
+	}
 }
 
 // RegisterService registers a service
+// It is idempotent, registering an already registered address updates its metadata in place
+// The ring entries of the service are only rebuilt when its weight changed
 func (r *Registry) RegisterService(service ServiceMetadata) {
-	log.Debug("Registering", "instance", service.IPAddress)
-	r.instances.Set(service.IPAddress, service)
-	r.hash.Add(service.IPAddress)
+	log.Debug("Registering", "instance", service.Address())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing, registered := r.instances.Get(service.Address())
+	if registered {
+		service.RegisteredAt, service.CapacityWeight = existing.RegisteredAt, existing.CapacityWeight
+	} else if service.RegisteredAt.IsZero() {
+		service.RegisteredAt = time.Now()
+	}
+	if service.ReadOnly {
+		r.readOnly[service.Address()] = struct{}{}
+	} else {
+		delete(r.readOnly, service.Address())
+	}
+	// The instance is stored before being added to the hash, so a matched address can always be resolved
+	r.instances.Set(service.Address(), service)
+	if registered && existing.EffectiveWeight() == service.EffectiveWeight() {
+		return
+	}
+
+	addToHash(r.hash, service)
+	if r.previous != nil {
+		addToHash(r.previous, service)
+	}
 }
 
-// DeregisterService deregisters a service
-func (r *Registry) DeregisterService(ipAddress string) {
-	log.Debug("Deregistering", "instance", ipAddress)
-	r.instances.Remove(ipAddress)
-	r.hash.Remove(ipAddress)
+// DeregisterService deregisters a service by its address
+func (r *Registry) DeregisterService(address string) {
+	log.Debug("Deregistering", "instance", address)
+	r.mu.Lock()
+	// The address is removed from the hash first, so it is no longer matched once its instance is gone
+	r.hash.Remove(address)
+	if r.previous != nil {
+		r.previous.Remove(address)
+	}
+	r.instances.Remove(address)
+	delete(r.readOnly, address)
+	r.mu.Unlock()
+
+	r.drainMu.Lock()
+	delete(r.draining, address)
+	r.drainMu.Unlock()
+
+	r.hooksMu.RLock()
+	defer r.hooksMu.RUnlock()
+	for _, hook := range r.deregisterHooks {
+		hook(address)
+	}
+}
+
+// OnDeregister registers fn to be called with the address of every deregistered service
+func (r *Registry) OnDeregister(fn func(address string)) {
+	r.hooksMu.Lock()
+	defer r.hooksMu.Unlock()
+	r.deregisterHooks = append(r.deregisterHooks, fn)
 }
 
 // MatchService matches a service for a given key
 // It returns an error if the service is not found
 // for the given key it returns the service metadata if the service is found in the registry
-// The key is used for finding the service in the consistent hash store
+// The key is used for finding the service in the consistent hash store, unless it is pinned to a service
 func (r *Registry) MatchService(key string) (ServiceMetadata, error) {
-	serviceIP, ok := r.hash.Get(key)
+	if service, ok := r.pinned(key); ok {
+		return service, nil
+	}
+
+	serviceAddress, ok := r.hasher().Get(key)
 	if !ok {
 		return ServiceMetadata{}, fmt.Errorf("could not match service for key %s", key)
 	}
 
-	service, ok := r.instances.Get(serviceIP.(string))
+	address, ok := serviceAddress.(string)
+	if !ok {
+		return ServiceMetadata{}, fmt.Errorf("unexpected service address %v of type %T in the hash", serviceAddress, serviceAddress)
+	}
+
+	service, ok := r.instances.Get(address)
 	if !ok {
-		return ServiceMetadata{}, fmt.Errorf("could not find service with IP %s", service)
+		return ServiceMetadata{}, fmt.Errorf("could not find service with address %s", address)
 	}
 	return service, nil
 }
 
+// MatchServices matches up to n distinct services for a given key
+// The first service is the one returned by MatchService, the others are its successors on the ring
+// A pinned key gets its pinned service first, followed by the ring successors of the key
+// Draining and read-only services are matched on top of the n services, since the objects written meanwhile are on their successors
+// It returns an error if no service is found for the key
+func (r *Registry) MatchServices(key string, n int) ([]ServiceMetadata, error) {
+	return r.matchServices(key, n, false)
+}
+
+// MatchWritableServices matches up to n distinct services for writing a given key
+// It is like MatchServices, but the draining and read-only services are skipped
+func (r *Registry) MatchWritableServices(key string, n int) ([]ServiceMetadata, error) {
+	return r.matchServices(key, n, true)
+}
+
+func (r *Registry) matchServices(key string, n int, writable bool) ([]ServiceMetadata, error) {
+	excluded := r.writeExcludedSet()
+	pinnedService, pinned := r.pinned(key)
+	if _, ok := excluded[pinnedService.Address()]; pinned && ok && writable {
+		pinned = false
+	}
+
+	// Extra services are asked from the ring, in case it includes the pinned or excluded ones
+	serviceAddresses := r.hasher().GetN(key, n+1+len(excluded))
+	if len(serviceAddresses) == 0 && !pinned {
+		return nil, fmt.Errorf("could not match service for key %s", key)
+	}
+
+	var (
+		services = make([]ServiceMetadata, 0, n)
+		counted  int // The services counting towards n, which excludes the draining and read-only ones
+	)
+	add := func(service ServiceMetadata) {
+		services = append(services, service)
+		if _, ok := excluded[service.Address()]; !ok {
+			counted++
+		}
+	}
+
+	if pinned {
+		add(pinnedService)
+	}
+	for _, serviceAddress := range serviceAddresses {
+		if counted == n {
+			break
+		}
+
+		address, ok := serviceAddress.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected service address %v of type %T in the hash", serviceAddress, serviceAddress)
+		}
+
+		if pinned && address == pinnedService.Address() {
+			continue
+		}
+
+		if _, ok := excluded[address]; ok && writable {
+			continue
+		}
+
+		service, ok := r.instances.Get(address)
+		if !ok {
+			return nil, fmt.Errorf("could not find service with address %s", address)
+		}
+		add(service)
+	}
+
+	if len(services) == 0 {
+		return nil, fmt.Errorf("could not match a writable service for key %s, all of them are draining or read-only", key)
+	}
+
+	return services, nil
+}
+
 // GetAllServices returns all the services in the registry
 func (r *Registry) GetAllServices() []ServiceMetadata {
-	var services []ServiceMetadata
-	for v := range r.instances.IterBuffered() {
-		services = append(services, v.Val)
-	}
+	services := make([]ServiceMetadata, 0, r.Count())
+	r.Range(func(service ServiceMetadata) bool {
+		services = append(services, service)
+		return true
+	})
 
 	return services
 }
+
+// Count returns the number of services in the registry
+func (r *Registry) Count() int {
+	return r.instances.Count()
+}
+
+// Range calls fn for each service in the registry, until fn returns false
+// Unlike GetAllServices, it doesn't copy the services, so fn must not call back into the registry
+func (r *Registry) Range(fn func(service ServiceMetadata) bool) {
+	stopped := false
+	r.instances.IterCb(func(_ string, service ServiceMetadata) {
+		if stopped {
+			return
+		}
+		stopped = !fn(service)
+	})
+}
+
+// Ring returns the virtual nodes of the consistent hash ordered by their position
+func (r *Registry) Ring() []hashring.VirtualNode {
+	return r.hasher().Ring()
+}
+
+// HashParams returns the parameters of the consistent hash the services are matched with
+func (r *Registry) HashParams() hashring.Params {
+	return r.hasher().Params()
+}
+
+// Imbalance returns the ratio between the key space owned by the most and the least loaded services
+func (r *Registry) Imbalance() float64 {
+	return hashring.Imbalance(r.Ring())
+}
+
+// RebuildRing replaces the consistent hash with the given one, e.g. built with a different number of virtual nodes
+// The registered services are preserved, and the fraction of the key space that moved to another service is returned
+// An ongoing ring migration is finished, the previous hash is no longer matched
+func (r *Registry) RebuildRing(hash Hasher) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.previous = nil
+	return r.rebuildRing(hash)
+}
+
+func (r *Registry) rebuildRing(hash Hasher) float64 {
+	count := 0
+	r.Range(func(service ServiceMetadata) bool {
+		addToHash(hash, service)
+		count++
+		return true
+	})
+
+	remapped := hashring.Remapped(r.hash.Ring(), hash.Ring())
+	r.hash = hash
+	log.Info("Rebuilt the ring", "instances", count, "remapped_fraction", remapped)
+	return remapped
+}
+
+func addToHash(hash Hasher, service ServiceMetadata) {
+	if weight := service.EffectiveWeight(); weight < hashring.TopWeight {
+		hash.AddWithWeight(service.Address(), weight)
+		return
+	}
+
+	hash.Add(service.Address())
+}
+
+// SetCapacityWeights sets the weights derived from the free space of the services with the given addresses
+// The keys moved by the new weights are still stored on their former owners, so the weights are applied on the given
+// empty hash, which replaces the current one like MigrateRing does: the reads fall back to the replaced hash until
+// FinishMigration. The unknown addresses are skipped, and the ring is left as is when no weight on the ring changed
+// It returns the fraction of the key space that moved to another service
+func (r *Registry) SetCapacityWeights(weights map[string]int, hash Hasher) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := false
+	for address, weight := range weights {
+		service, ok := r.instances.Get(address)
+		if !ok {
+			continue
+		}
+
+		previous := service.EffectiveWeight()
+		service.CapacityWeight = weight
+		r.instances.Set(address, service)
+		changed = changed || service.EffectiveWeight() != previous
+	}
+	if !changed {
+		return 0
+	}
+
+	// The hash replaced by an ongoing migration is kept, since the objects not moved yet are placed by it
+	if r.previous == nil {
+		r.previous = r.hash
+	}
+	log.Info("Re-weighting the ring, the reads fall back to the previous ring until the migration is finished")
+	return r.rebuildRing(hash)
+}
+
+func (r *Registry) hasher() Hasher {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.hash
+}
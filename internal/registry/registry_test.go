@@ -0,0 +1,310 @@
+package registry
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dariusigna/object-storage/internal/hashring"
+	"github.com/dariusigna/object-storage/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestRegistry(t *testing.T, n int) *Registry {
+	t.Helper()
+	r := NewRegistry(hashring.New())
+	for i := 1; i <= n; i++ {
+		r.RegisterService(ServiceMetadata{Name: fmt.Sprintf("minio%d", i), IPAddress: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	return r
+}
+
+func TestSetCapacityWeightsMigratesTheRing(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	before := r.Ring()
+
+	remapped := r.SetCapacityWeights(map[string]int{"10.0.0.1": 10, "unknown": 50}, hashring.New())
+	if remapped <= 0 {
+		t.Fatalf("SetCapacityWeights() remapped %v of the key space, want some", remapped)
+	}
+	if !r.Migrating() {
+		t.Fatal("the re-weighting didn't start a migration")
+	}
+
+	// The replaced ring is kept as is, so the keys moved away are still found on their former owners
+	if got := hashring.Remapped(before, r.previous.Ring()); got != 0 {
+		t.Errorf("the previous ring was changed, %v of the key space remapped", got)
+	}
+	service, _ := r.instances.Get("10.0.0.1")
+	if service.CapacityWeight != 10 {
+		t.Errorf("CapacityWeight = %d, want 10", service.CapacityWeight)
+	}
+	if load := hashring.Load(r.Ring()); load["10.0.0.1"] >= load["10.0.0.2"] {
+		t.Errorf("load of the re-weighted instance %v isn't below the one of the others %v", load["10.0.0.1"], load["10.0.0.2"])
+	}
+}
+
+func TestSetCapacityWeightsKeepsTheMigratedRing(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	original := r.Ring()
+
+	r.SetCapacityWeights(map[string]int{"10.0.0.1": 10}, hashring.New())
+	r.SetCapacityWeights(map[string]int{"10.0.0.2": 20}, hashring.New())
+
+	// The objects not moved yet are still placed by the ring before the first re-weighting
+	if got := hashring.Remapped(original, r.previous.Ring()); got != 0 {
+		t.Errorf("the ring replaced first wasn't kept, %v of the key space remapped", got)
+	}
+}
+
+func TestSetCapacityWeightsUnchanged(t *testing.T) {
+	r := newTestRegistry(t, 2)
+	hash := r.hasher()
+
+	if remapped := r.SetCapacityWeights(map[string]int{"10.0.0.1": hashring.TopWeight}, hashring.New()); remapped != 0 {
+		t.Errorf("SetCapacityWeights() remapped %v, want 0 when the ring weights didn't change", remapped)
+	}
+	if r.Migrating() || r.hasher() != hash {
+		t.Error("the ring was replaced although no ring weight changed")
+	}
+}
+
+// BenchmarkConcurrentMatchAndRegister matches keys while services register and deregister, run it with -race
+func BenchmarkConcurrentMatchAndRegister(b *testing.B) {
+	r := NewRegistry(hashring.New())
+	for i := 1; i <= 5; i++ {
+		r.RegisterService(ServiceMetadata{Name: fmt.Sprintf("minio%d", i), IPAddress: fmt.Sprintf("10.0.0.%d", i)})
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			key := fmt.Sprintf("object-%d", i)
+			switch i % 10 {
+			case 0:
+				// A flapping service, the stable ones keep every key matched
+				r.RegisterService(ServiceMetadata{Name: "flapping", IPAddress: "10.0.1.1", Weight: 1 + i%hashring.TopWeight})
+			case 1:
+				r.DeregisterService("10.0.1.1")
+			default:
+				if _, err := r.MatchService(key); err != nil {
+					b.Errorf("MatchService() error = %v", err)
+				}
+				if _, err := r.MatchServices(key, 3); err != nil {
+					b.Errorf("MatchServices() error = %v", err)
+				}
+			}
+		}
+	})
+}
+
+func TestMatchOnASingleServiceRing(t *testing.T) {
+	r := newTestRegistry(t, 1)
+	for i := range 100 {
+		key := fmt.Sprint("key", i)
+		if service, err := r.MatchService(key); err != nil || service.Address() != "10.0.0.1" {
+			t.Fatalf("MatchService(%s) = %v, %v, want the single service", key, service.Address(), err)
+		}
+		// Asking for more services than registered returns the ones available
+		services, err := r.MatchServices(key, 3)
+		if err != nil || len(services) != 1 || services[0].Address() != "10.0.0.1" {
+			t.Fatalf("MatchServices(%s, 3) = %v, %v, want the single service", key, serviceAddresses(services), err)
+		}
+	}
+
+	r.RegisterService(ServiceMetadata{Name: "minio2", IPAddress: "10.0.0.2"})
+	if services, err := r.MatchWritableServices("key", 3); err != nil || len(services) != 2 {
+		t.Errorf("MatchWritableServices() = %v, %v, want the 2 services", serviceAddresses(services), err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	if got := r.Count(); got != 3 {
+		t.Errorf("Count() = %d, want 3", got)
+	}
+
+	// Registering an instance again updates it rather than counting it twice
+	r.RegisterService(ServiceMetadata{Name: "renamed", IPAddress: "10.0.0.1"})
+	r.DeregisterService("10.0.0.2")
+	if got := r.Count(); got != 2 {
+		t.Errorf("Count() = %d, want 2", got)
+	}
+	if got := len(r.GetAllServices()); got != r.Count() {
+		t.Errorf("GetAllServices() returned %d services, want Count() %d", got, r.Count())
+	}
+}
+
+func TestRegisterServiceConcurrently(t *testing.T) {
+	r := newTestRegistry(t, 1)
+	want := len(r.Ring())
+
+	var wg sync.WaitGroup
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				r.RegisterService(ServiceMetadata{Name: fmt.Sprintf("minio-%d", i), IPAddress: "10.0.0.2", Zone: "a"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if r.Count() != 2 {
+		t.Errorf("Count() = %d, want the instance registered once", r.Count())
+	}
+	// The ring holds the entries of each instance once
+	if got := len(r.Ring()); got != 2*want {
+		t.Errorf("the ring has %d entries, want %d", got, 2*want)
+	}
+
+	// The metadata is updated in place, and a new weight rebuilds the entries of the instance
+	r.RegisterService(ServiceMetadata{Name: "minio2", IPAddress: "10.0.0.2", Zone: "b", Weight: hashring.TopWeight / 2})
+	if service, _ := r.instances.Get("10.0.0.2"); service.Name != "minio2" || service.Zone != "b" {
+		t.Errorf("service = %+v, want its metadata updated", service)
+	}
+	if got := hashring.Load(r.Ring()); got["10.0.0.2"] >= got["10.0.0.1"] {
+		t.Errorf("load = %v, want the instance registered at half the weight below the other", got)
+	}
+}
+
+func TestRegisteredAt(t *testing.T) {
+	r := newTestRegistry(t, 1)
+	registered, _ := r.instances.Get("10.0.0.1")
+	if registered.RegisteredAt.IsZero() || time.Since(registered.RegisteredAt) > time.Minute {
+		t.Fatalf("RegisteredAt = %v, want the registration time", registered.RegisteredAt)
+	}
+
+	// A refresh that doesn't change the instance keeps its registration time
+	time.Sleep(10 * time.Millisecond)
+	r.RegisterService(ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1"})
+	if refreshed, _ := r.instances.Get("10.0.0.1"); !refreshed.RegisteredAt.Equal(registered.RegisteredAt) {
+		t.Errorf("RegisteredAt = %v after a refresh, want %v kept", refreshed.RegisteredAt, registered.RegisteredAt)
+	}
+
+	// An instance that flapped is registered anew
+	r.DeregisterService("10.0.0.1")
+	r.RegisterService(ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1"})
+	if flapped, _ := r.instances.Get("10.0.0.1"); !flapped.RegisteredAt.After(registered.RegisteredAt) {
+		t.Errorf("RegisteredAt = %v after flapping, want after %v", flapped.RegisteredAt, registered.RegisteredAt)
+	}
+}
+
+func TestRange(t *testing.T) {
+	r := newTestRegistry(t, 5)
+
+	seen := make(map[string]int)
+	r.Range(func(service ServiceMetadata) bool {
+		seen[service.Address()]++
+		return true
+	})
+	if len(seen) != 5 {
+		t.Errorf("Range() visited %v, want each of the 5 services", seen)
+	}
+	for address, visits := range seen {
+		if visits != 1 {
+			t.Errorf("Range() visited %s %d times, want once", address, visits)
+		}
+	}
+
+	visits := 0
+	r.Range(func(service ServiceMetadata) bool {
+		visits++
+		return visits < 2
+	})
+	if visits != 2 {
+		t.Errorf("Range() visited %d services after being stopped at the second, want 2", visits)
+	}
+}
+
+func TestRebuildRing(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	r.SetCapacityWeights(map[string]int{"10.0.0.1": 10}, hashring.New())
+
+	remapped := r.RebuildRing(hashring.NewCustom(200, nil))
+	if remapped <= 0 || remapped >= 1 {
+		t.Errorf("RebuildRing() remapped %v of the key space, want a fraction of it", remapped)
+	}
+	if r.Migrating() {
+		t.Error("the rebuild didn't finish the ongoing migration")
+	}
+	if got := r.HashParams().VirtualNodes; got != 200 {
+		t.Errorf("VirtualNodes = %d, want 200", got)
+	}
+
+	// Every instance is kept on the new ring, with its weight
+	if r.Count() != 3 {
+		t.Errorf("Count() = %d, want the 3 instances kept", r.Count())
+	}
+	load := hashring.Load(r.Ring())
+	for i := 1; i <= 3; i++ {
+		address := fmt.Sprintf("10.0.0.%d", i)
+		if load[address] == 0 {
+			t.Errorf("instance %s isn't on the rebuilt ring", address)
+		}
+	}
+	if load["10.0.0.1"] >= load["10.0.0.2"] {
+		t.Errorf("load of the re-weighted instance %v isn't below the one of the others %v", load["10.0.0.1"], load["10.0.0.2"])
+	}
+	if _, err := r.MatchService("key"); err != nil {
+		t.Errorf("MatchService() error = %v", err)
+	}
+}
+
+func TestImbalanceMetric(t *testing.T) {
+	r := newTestRegistry(t, 3)
+	metrics.SetRingImbalanceSource(r.Imbalance)
+	t.Cleanup(func() { metrics.SetRingImbalanceSource(func() float64 { return 0 }) })
+
+	balanced := testutil.ToFloat64(metrics.RingImbalance)
+	if balanced != r.Imbalance() || balanced < 1 {
+		t.Fatalf("imbalance metric = %v, want the one of the ring %v", balanced, r.Imbalance())
+	}
+
+	r.SetCapacityWeights(map[string]int{"10.0.0.1": 10}, hashring.New())
+	if skewed := testutil.ToFloat64(metrics.RingImbalance); skewed <= 2*balanced {
+		t.Errorf("imbalance metric = %v after skewing the weights, want well above the balanced %v", skewed, balanced)
+	}
+}
+
+// intHasher is a consistent hash whose lookups return the nodes as their index instead of their address
+type intHasher struct {
+	*hashring.ConsistentHash
+}
+
+func (h intHasher) Get(v any) (any, bool) {
+	if _, ok := h.ConsistentHash.Get(v); !ok {
+		return nil, false
+	}
+	return 1, true
+}
+
+func (h intHasher) GetN(v any, n int) []any {
+	nodes := h.ConsistentHash.GetN(v, n)
+	for i := range nodes {
+		nodes[i] = i
+	}
+	return nodes
+}
+
+func TestMatchNonStringAddress(t *testing.T) {
+	r := NewRegistry(intHasher{hashring.New()})
+	r.RegisterService(ServiceMetadata{Name: "minio1", IPAddress: "10.0.0.1"})
+	r.RegisterService(ServiceMetadata{Name: "minio2", IPAddress: "10.0.0.2"})
+
+	if _, err := r.MatchService("key"); err == nil {
+		t.Error("MatchService() error = nil, want the unexpected address reported")
+	}
+	if _, err := r.MatchServices("key", 2); err == nil {
+		t.Error("MatchServices() error = nil, want the unexpected address reported")
+	}
+	if _, err := r.MatchWritableServices("key", 2); err == nil {
+		t.Error("MatchWritableServices() error = nil, want the unexpected address reported")
+	}
+
+	r.MigrateRing(intHasher{hashring.New()})
+	if _, err := r.MatchPreviousServices("key", 2); err == nil {
+		t.Error("MatchPreviousServices() error = nil, want the unexpected address reported")
+	}
+}
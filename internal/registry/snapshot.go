@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// snapshot is the persisted state of the registry, which warms it up at startup before docker is reconciled
+type snapshot struct {
+	Services []ServiceMetadata `json:"services"`
+}
+
+// SaveSnapshot writes the registered services to the file at path, replacing it atomically
+// The file holds the credentials of the services, so it is only readable by its owner
+func (r *Registry) SaveSnapshot(path string) error {
+	services := r.GetAllServices()
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Address() < services[j].Address()
+	})
+
+	data, err := json.Marshal(snapshot{Services: services})
+	if err != nil {
+		return fmt.Errorf("failed to encode the registry snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("failed to create the registry snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write the registry snapshot: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write the registry snapshot: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace the registry snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// LoadSnapshot registers the services saved by SaveSnapshot to the file at path, and returns how many
+// The services keep their registration time and capacity weight, a missing file loads none
+func (r *Registry) LoadSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read the registry snapshot: %w", err)
+	}
+
+	var s snapshot
+	if err = json.Unmarshal(data, &s); err != nil {
+		return 0, fmt.Errorf("failed to decode the registry snapshot: %w", err)
+	}
+
+	for _, service := range s.Services {
+		r.RegisterService(service)
+	}
+
+	return len(s.Services), nil
+}
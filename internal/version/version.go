@@ -0,0 +1,22 @@
+package version
+
+import "runtime"
+
+// Version and Commit are injected at build time with
+// -ldflags "-X github.com/dariusigna/object-storage/internal/version.Version=... -X github.com/dariusigna/object-storage/internal/version.Commit=..."
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the info of the running build
+func Get() Info {
+	return Info{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+}
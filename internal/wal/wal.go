@@ -0,0 +1,254 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	log "log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	entryExt = ".json"
+	dataExt  = ".data"
+	// tmpPrefix is the prefix of the files being written, which a crash can leave behind
+	tmpPrefix = ".tmp-"
+)
+
+// Entry is a replicated write in progress
+type Entry struct {
+	ID          string            `json:"id"`
+	Bucket      string            `json:"bucket"`
+	Object      string            `json:"object"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Created     time.Time         `json:"created"`
+	// Generation orders the writes of the log, a write of an object supersedes its writes of a lower generation
+	Generation uint64 `json:"generation"`
+}
+
+// Key identifies the object written by the entry
+func (e Entry) Key() string {
+	return e.Bucket + "/" + e.Object
+}
+
+// Supersedes reports whether the entry is a later write of the same object as other
+func (e Entry) Supersedes(other Entry) bool {
+	return e.Key() == other.Key() && e.Generation > other.Generation
+}
+
+// Log is a write-ahead log recording the replicated writes in progress in a directory
+// Each entry is stored as a JSON file next to a file holding the object data
+type Log struct {
+	dir string
+
+	mu         sync.Mutex
+	generation uint64             // The generation of the last entry begun
+	pending    map[string][]Entry // The entries not committed yet, by object
+}
+
+// Open opens the write-ahead log in dir, creating the directory if needed
+// The generations of the new entries follow the ones of the entries pending from a previous run
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create write-ahead log directory: %w", err)
+	}
+
+	l := &Log{dir: dir, pending: make(map[string][]Entry)}
+	l.removeOrphans()
+	entries, err := l.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		l.generation = max(l.generation, entry.Generation)
+		l.pending[entry.Key()] = append(l.pending[entry.Key()], entry)
+	}
+	return l, nil
+}
+
+// Begin records a write of the size bytes of data before it is replicated
+// The ID, creation time and generation of the entry are set by Begin
+func (l *Log) Begin(entry Entry, data io.ReaderAt, size int64) (Entry, error) {
+	l.mu.Lock()
+	l.generation++
+	entry.ID, entry.Created, entry.Generation = uuid.NewString(), time.Now(), l.generation
+	l.mu.Unlock()
+
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to encode write-ahead log entry: %w", err)
+	}
+
+	// The data is written first, so an entry always has its data
+	if err = writeFile(l.path(entry.ID, dataExt), io.NewSectionReader(data, 0, size)); err != nil {
+		return Entry{}, err
+	}
+
+	if err = writeFile(l.path(entry.ID, entryExt), bytes.NewReader(encoded)); err != nil {
+		os.Remove(l.path(entry.ID, dataExt))
+		return Entry{}, err
+	}
+
+	l.mu.Lock()
+	l.pending[entry.Key()] = append(l.pending[entry.Key()], entry)
+	l.mu.Unlock()
+	return entry, nil
+}
+
+// Commit removes the entry once the write reached all its replicas
+// The entries of the object it supersedes are removed too, so a recovery never replays them over the committed write
+func (l *Log) Commit(entry Entry) error {
+	l.mu.Lock()
+	var superseded []Entry
+	remaining := l.pending[entry.Key()][:0]
+	for _, pending := range l.pending[entry.Key()] {
+		switch {
+		case pending.ID == entry.ID:
+		case entry.Supersedes(pending):
+			superseded = append(superseded, pending)
+		default:
+			remaining = append(remaining, pending)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(l.pending, entry.Key())
+	} else {
+		l.pending[entry.Key()] = remaining
+	}
+	l.mu.Unlock()
+
+	for _, pending := range superseded {
+		if err := l.remove(pending); err != nil {
+			return err
+		}
+	}
+
+	return l.remove(entry)
+}
+
+// remove deletes the files of the entry
+func (l *Log) remove(entry Entry) error {
+	if err := os.Remove(l.path(entry.ID, entryExt)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove write-ahead log entry: %w", err)
+	}
+
+	if err := os.Remove(l.path(entry.ID, dataExt)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove write-ahead log data: %w", err)
+	}
+
+	return nil
+}
+
+// Pending returns the entries that were not committed, oldest first
+func (l *Log) Pending() ([]Entry, error) {
+	paths, err := filepath.Glob(filepath.Join(l.dir, "*"+entryExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list write-ahead log entries: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(paths))
+	for _, path := range paths {
+		encoded, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read write-ahead log entry: %w", err)
+		}
+
+		var entry Entry
+		if err = json.Unmarshal(encoded, &entry); err != nil {
+			log.Error("Skipping corrupted write-ahead log entry", "path", path, "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Generation != entries[j].Generation {
+			return entries[i].Generation < entries[j].Generation
+		}
+		return entries[i].Created.Before(entries[j].Created)
+	})
+	return entries, nil
+}
+
+// Data opens the object data of the entry and returns its size
+func (l *Log) Data(entry Entry) (*os.File, int64, error) {
+	file, err := os.Open(l.path(entry.ID, dataExt))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open write-ahead log data: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to stat write-ahead log data: %w", err)
+	}
+
+	return file, info.Size(), nil
+}
+
+func (l *Log) path(id, ext string) string {
+	return filepath.Join(l.dir, id+ext)
+}
+
+// removeOrphans removes the data files left without an entry, and the partially written files, by a crash in Begin or Commit
+func (l *Log) removeOrphans() {
+	paths, err := filepath.Glob(filepath.Join(l.dir, "*"+dataExt))
+	if err != nil {
+		return
+	}
+
+	for _, path := range paths {
+		if _, err = os.Stat(strings.TrimSuffix(path, dataExt) + entryExt); os.IsNotExist(err) {
+			os.Remove(path)
+		}
+	}
+
+	tmpPaths, err := filepath.Glob(filepath.Join(l.dir, tmpPrefix+"*"))
+	if err != nil {
+		return
+	}
+
+	for _, path := range tmpPaths {
+		os.Remove(path)
+	}
+}
+
+// writeFile writes the file atomically, so a crash never leaves it partially written
+func writeFile(path string, data io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), tmpPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create write-ahead log file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write write-ahead log file: %w", err)
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync write-ahead log file: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close write-ahead log file: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename write-ahead log file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package wal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func begin(t *testing.T, l *Log, bucket, object, data string) Entry {
+	t.Helper()
+	entry, err := l.Begin(Entry{Bucket: bucket, Object: object}, bytes.NewReader([]byte(data)), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	return entry
+}
+
+func pendingIDs(t *testing.T, l *Log) []string {
+	t.Helper()
+	entries, err := l.Pending()
+	if err != nil {
+		t.Fatalf("Pending() error = %v", err)
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+func TestPendingAndData(t *testing.T) {
+	l, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	first := begin(t, l, "bucket", "a", "first")
+	second := begin(t, l, "bucket", "b", "second")
+	if ids := pendingIDs(t, l); len(ids) != 2 || ids[0] != first.ID || ids[1] != second.ID {
+		t.Fatalf("Pending() = %v, want [%s %s]", ids, first.ID, second.ID)
+	}
+
+	file, size, err := l.Data(second)
+	if err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	defer file.Close()
+
+	data, _ := io.ReadAll(file)
+	if string(data) != "second" || size != int64(len(data)) {
+		t.Errorf("Data() = %q of size %d, want %q", data, size, "second")
+	}
+
+	if err = l.Commit(first); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if ids := pendingIDs(t, l); len(ids) != 1 || ids[0] != second.ID {
+		t.Errorf("Pending() after commit = %v, want [%s]", ids, second.ID)
+	}
+}
+
+func TestCommitDropsSupersededEntries(t *testing.T) {
+	l, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	stale := begin(t, l, "bucket", "id", "stale")
+	other := begin(t, l, "bucket", "other", "other")
+	latest := begin(t, l, "bucket", "id", "latest")
+	if !latest.Supersedes(stale) || stale.Supersedes(latest) || latest.Supersedes(other) {
+		t.Fatalf("Supersedes() doesn't order the writes of the same object by generation")
+	}
+
+	// The stale write failed on a replica and was kept, the latest one reached all of them
+	if err = l.Commit(latest); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if ids := pendingIDs(t, l); len(ids) != 1 || ids[0] != other.ID {
+		t.Errorf("Pending() = %v, want only the entry of the other object %s", ids, other.ID)
+	}
+	if _, err = os.Stat(l.path(stale.ID, dataExt)); !os.IsNotExist(err) {
+		t.Errorf("the data of the superseded entry was kept")
+	}
+}
+
+func TestCommitKeepsLaterEntries(t *testing.T) {
+	l, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	earlier := begin(t, l, "bucket", "id", "earlier")
+	later := begin(t, l, "bucket", "id", "later")
+	if err = l.Commit(earlier); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if ids := pendingIDs(t, l); len(ids) != 1 || ids[0] != later.ID {
+		t.Errorf("Pending() = %v, want the later entry %s", ids, later.ID)
+	}
+}
+
+func TestOpenResumesGenerations(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	pending := begin(t, l, "bucket", "id", "pending")
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	next := begin(t, reopened, "bucket", "id", "next")
+	if !next.Supersedes(pending) {
+		t.Errorf("generation %d after reopening doesn't follow the pending generation %d", next.Generation, pending.Generation)
+	}
+
+	// Committing the new write drops the entry pending from the previous run
+	if err = reopened.Commit(next); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if ids := pendingIDs(t, reopened); len(ids) != 0 {
+		t.Errorf("Pending() = %v, want none", ids)
+	}
+}
+
+func TestOpenRemovesOrphans(t *testing.T) {
+	dir := t.TempDir()
+	orphans := []string{"orphan" + dataExt, tmpPrefix + "123"}
+	for _, name := range orphans {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("partial"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	kept := begin(t, l, "bucket", "id", "kept")
+	if _, err = Open(dir); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	for _, name := range orphans {
+		if _, err = os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("orphan %s was not removed", name)
+		}
+	}
+	if _, err = os.Stat(l.path(kept.ID, dataExt)); err != nil {
+		t.Errorf("the data of a pending entry was removed: %v", err)
+	}
+}